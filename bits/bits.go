@@ -6,21 +6,13 @@ func SetBit(bits *uint32, pos int, value bool) {
 	if value {
 		bit = 1
 	}
-	bit <<= pos
-	*bits |= bit
-	*bits &= bit
+	*bits &^= 1 << pos
+	*bits |= bit << pos
 }
 
 // ShiftRightSigned shifts bits to the right and preserves the sign
 func ShiftRightSigned(bits *uint32, amount int) {
-
-	*bits >>= amount
-	if !GetBit(*bits, 32-amount) {
-		return
-	}
-	signExtension := ^(uint32(0)) << amount
-
-	*bits |= signExtension
+	*bits = uint32(int32(*bits) >> amount)
 }
 
 // GetBit returns if a bit at a position is set or clear.
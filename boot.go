@@ -0,0 +1,236 @@
+package casper
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+
+	"github.com/damilolarandolph/casper/cart"
+	"github.com/damilolarandolph/casper/firmware"
+	"github.com/damilolarandolph/casper/power"
+)
+
+// Addresses of the state the BIOS and firmware leave in main RAM.
+const (
+	bootHeaderAddress = 0x027ffe00
+	bootInfoAddress   = 0x027ff800
+	bootInfoMirror    = 0x027ffc00
+	bootUserSettings  = 0x027ffc80
+)
+
+// Stack pointers for system, IRQ and supervisor mode set up by the BIOS.
+const (
+	arm9SystemStack     = 0x03002f7c
+	arm9IRQStack        = 0x03003f80
+	arm9SupervisorStack = 0x03003fc0
+	arm7SystemStack     = 0x0380fd80
+	arm7IRQStack        = 0x0380ff80
+	arm7SupervisorStack = 0x0380ffc0
+)
+
+// The BIOS stubs installed when no BIOS is loaded. Their IRQ vectors save
+// the scratch registers and call the handler the game stored at the end of
+// the Arm7 WRAM or the Arm9 DTCM, like the real BIOSes do. They have no SWI
+// handlers, the CPUs carry out the BIOS functions themselves instead.
+var (
+	arm7Stub = map[uint32]uint32{
+		0x18: 0xea000000, // b 0x20
+		0x20: 0xe92d500f, // stmfd sp!, {r0-r3, r12, lr}
+		0x24: 0xe3a00301, // mov r0, #0x04000000
+		0x28: 0xe28fe000, // add lr, pc, #0
+		0x2c: 0xe510f004, // ldr pc, [r0, #-4]
+		0x30: 0xe8bd500f, // ldmfd sp!, {r0-r3, r12, lr}
+		0x34: 0xe25ef004, // subs pc, lr, #4
+	}
+	arm9Stub = map[uint32]uint32{
+		0x18: 0xea000000, // b 0x20
+		0x20: 0xe92d500f, // stmfd sp!, {r0-r3, r12, lr}
+		0x24: 0xee190f11, // mrc p15, 0, r0, c9, c1, 0
+		0x28: 0xe1a00620, // mov r0, r0, lsr #12
+		0x2c: 0xe1a00600, // mov r0, r0, lsl #12
+		0x30: 0xe2800901, // add r0, r0, #0x4000
+		0x34: 0xe28fe000, // add lr, pc, #0
+		0x38: 0xe510f004, // ldr pc, [r0, #-4]
+		0x3c: 0xe8bd500f, // ldmfd sp!, {r0-r3, r12, lr}
+		0x40: 0xe25ef004, // subs pc, lr, #4
+	}
+)
+
+// ErrNoROM is returned when booting without a ROM loaded.
+var ErrNoROM = errors.New("casper: no ROM loaded")
+
+// ErrBadBIOS is returned when a BIOS dump has the wrong size.
+var ErrBadBIOS = errors.New("casper: BIOS dump has the wrong size")
+
+// ErrBadFirmware is returned when neither copy of the user settings in the
+// firmware is valid.
+var ErrBadFirmware = errors.New("casper: firmware has no valid user settings")
+
+// ErrBadBinary is returned when a binary lies outside the ROM.
+var ErrBadBinary = errors.New("casper: binary outside of ROM")
+
+// LoadROM inserts the game card with the given ROM image.
+func (system *System) LoadROM(rom []byte) error {
+	header, err := cart.ParseHeader(rom)
+	if err != nil {
+		return err
+	}
+	system.Header = header
+	system.Memory.ROM = rom
+
+	card := cart.NewCard(rom, header)
+	if system.arm7BIOSLoaded {
+		card.SetKey1Table(system.Memory.BIOS)
+	}
+	system.Slot.Insert(card)
+	return nil
+}
+
+// LoadSave backs the card's backup chip with the save file at path, which
+// is created the first time the game saves.
+func (system *System) LoadSave(path string) error {
+	card := system.Slot.Card()
+	if card == nil {
+		return ErrNoROM
+	}
+	return card.Backup().Load(path)
+}
+
+// FlushSave writes any unsaved changes to the backup chip to the save file.
+func (system *System) FlushSave() error {
+	card := system.Slot.Card()
+	if card == nil {
+		return nil
+	}
+	return card.Backup().Flush()
+}
+
+// LoadFirmware loads the firmware dump at path. If there is no file there
+// the generated firmware is kept, and written there once it changes.
+func (system *System) LoadFirmware(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		system.Firmware.SetPath(path)
+		return nil
+	}
+	if err := system.Firmware.Load(path); err != nil {
+		return err
+	}
+	user := firmware.UserSettings(system.Firmware.Data())
+	if user == nil {
+		return ErrBadFirmware
+	}
+	system.Touchscreen.SetCalibration(firmware.ParseCalibration(user))
+	return nil
+}
+
+// Flush writes any unsaved changes to the backup chip and the firmware to
+// their files.
+func (system *System) Flush() error {
+	if err := system.FlushSave(); err != nil {
+		return err
+	}
+	return system.Firmware.Flush()
+}
+
+// LoadArm7BIOS loads a dump of the Arm7 BIOS, which also provides the KEY1
+// table needed to talk to encrypted game cards.
+func (system *System) LoadArm7BIOS(bios []byte) error {
+	if len(bios) != len(system.Memory.BIOS) {
+		return ErrBadBIOS
+	}
+	copy(system.Memory.BIOS, bios)
+	system.arm7BIOSLoaded = true
+	if card := system.Slot.Card(); card != nil {
+		card.SetKey1Table(system.Memory.BIOS)
+	}
+	return nil
+}
+
+// DirectBoot starts the loaded game without running the BIOS or firmware.
+// The binaries are copied to their load addresses and the hardware is put
+// in the state the boot process leaves it in.
+func (system *System) DirectBoot() error {
+	header := system.Header
+	if header == nil {
+		return ErrNoROM
+	}
+	rom := system.Memory.ROM
+
+	// The Arm7 gets all the shared WRAM.
+	system.Memory.SetWramControl(3)
+
+	if err := copyBinary(system.Arm9Bus, rom, header.Arm9); err != nil {
+		return err
+	}
+	if err := copyBinary(system.Arm7Bus, rom, header.Arm7); err != nil {
+		return err
+	}
+
+	bus := system.Arm9Bus
+	for offset, value := range header.Bytes() {
+		bus.WriteData8(bootHeaderAddress+uint32(offset), uint32(value))
+	}
+	for _, base := range []uint32{bootInfoAddress, bootInfoMirror} {
+		bus.WriteData32(base, header.ChipID())
+		bus.WriteData32(base+4, header.ChipID())
+		bus.WriteData16(base+8, uint32(header.HeaderCRC))
+		bus.WriteData16(base+0xa, uint32(header.SecureAreaCRC))
+	}
+	user := firmware.UserSettings(system.Firmware.Data())
+	if user == nil {
+		return ErrBadFirmware
+	}
+	for offset, value := range user {
+		bus.WriteData8(bootUserSettings+uint32(offset), uint32(value))
+	}
+	bus.WriteData16(0x027ff850, 0x5835)
+	bus.WriteData16(0x027ffc10, 0x5835)
+	bus.WriteData16(0x027ffc30, 0xffff)
+	// Marks a boot from the game card.
+	bus.WriteData16(0x027ffc40, 0x0001)
+
+	system.Slot.DirectBoot(header.NormalCardControl)
+	// The firmware powers up both engines and the 3D hardware, with engine
+	// A on the top screen.
+	system.Power.SetPOWCNT1(power.LCDs | power.EngineA | power.EngineB | power.Render3D | power.Geometry3D | power.DisplaySwap)
+
+	installStub(system.Memory.BIOS, arm7Stub)
+	installStub(system.Memory.Arm9BIOS, arm9Stub)
+	// There is never an Arm9 BIOS image.
+	system.Arm7.SetBIOSEmulation(!system.arm7BIOSLoaded)
+	system.Arm9.SetBIOSEmulation(true)
+
+	system.Arm9.DirectBoot(header.Arm9.EntryPoint, arm9SystemStack, arm9IRQStack, arm9SupervisorStack)
+	system.Arm7.DirectBoot(header.Arm7.EntryPoint, arm7SystemStack, arm7IRQStack, arm7SupervisorStack)
+
+	// Setting up isn't charged to the CPUs.
+	system.Arm9Bus.DrainCycles()
+	system.Arm7Bus.DrainCycles()
+	return nil
+}
+
+// copyBinary copies a CPU binary from the ROM through the CPU's bus.
+func copyBinary(bus interface {
+	WriteData8(address uint32, val uint32)
+}, rom []byte, binary cart.Binary) error {
+	end := uint64(binary.ROMOffset) + uint64(binary.Size)
+	if end > uint64(len(rom)) {
+		return ErrBadBinary
+	}
+	for offset := uint32(0); offset < binary.Size; offset++ {
+		bus.WriteData8(binary.RAMAddress+offset, uint32(rom[binary.ROMOffset+offset]))
+	}
+	return nil
+}
+
+// installStub writes stub into bios unless a BIOS image has been loaded.
+func installStub(bios []uint8, stub map[uint32]uint32) {
+	for _, value := range bios {
+		if value != 0 {
+			return
+		}
+	}
+	for offset, word := range stub {
+		binary.LittleEndian.PutUint32(bios[offset:], word)
+	}
+}
@@ -0,0 +1,118 @@
+package casper
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/damilolarandolph/casper/cart"
+	"github.com/damilolarandolph/casper/cpu"
+)
+
+const (
+	testArm9Entry = 0x02000000
+	testArm7Entry = 0x037f8000
+	testResults   = 0x02100000
+)
+
+// testROM returns a ROM image whose Arm9 and Arm7 binaries are the given
+// ARM programs.
+func testROM(arm9 []uint32, arm7 []uint32) []byte {
+	rom := make([]byte, 0x2000)
+	copy(rom, "CASPERTEST")
+	copy(rom[0xc:], "TEST")
+	le := binary.LittleEndian
+	binaries := []struct {
+		header  int
+		offset  uint32
+		entry   uint32
+		program []uint32
+	}{
+		{0x20, 0x1000, testArm9Entry, arm9},
+		{0x30, 0x1800, testArm7Entry, arm7},
+	}
+	for _, binary := range binaries {
+		le.PutUint32(rom[binary.header:], binary.offset)
+		le.PutUint32(rom[binary.header+4:], binary.entry)
+		le.PutUint32(rom[binary.header+8:], binary.entry)
+		le.PutUint32(rom[binary.header+12:], 0x100)
+		for index, opcode := range binary.program {
+			le.PutUint32(rom[binary.offset+uint32(index*4):], opcode)
+		}
+	}
+	le.PutUint16(rom[0x15e:], cart.CRC16(0xffff, rom[:0x15e]))
+	return rom
+}
+
+func bootTestROM(t *testing.T, arm9 []uint32, arm7 []uint32) *System {
+	system := NewSystem()
+	if err := system.LoadROM(testROM(arm9, arm7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := system.DirectBoot(); err != nil {
+		t.Fatal(err)
+	}
+	return system
+}
+
+func TestDirectBootSoftwareInterrupts(t *testing.T) {
+	arm9 := []uint32{
+		0xe3a02402, // mov r2, #0x02000000
+		0xe2822601, // add r2, r2, #0x100000
+		0xe3a00010, // mov r0, #16
+		0xef030000, // swi 0x030000 (WaitByLoop)
+		0xe3a01001, // mov r1, #1
+		0xe5821000, // str r1, [r2]
+		0xeafffffe, // b .
+	}
+	arm7 := []uint32{
+		0xe3a00402, // mov r0, #0x02000000
+		0xe2800601, // add r0, r0, #0x100000
+		0xef060000, // swi 0x060000 (Halt)
+		0xe3a01001, // mov r1, #1
+		0xe5801004, // str r1, [r0, #4]
+		0xeafffffe, // b .
+	}
+	system := bootTestROM(t, arm9, arm7)
+	system.RunFor(4000)
+
+	if value := system.Arm9Bus.ReadData32(testResults); value != 1 {
+		t.Error("Arm9 didn't return from the SWI")
+	}
+	if !system.Arm7.Halted() {
+		t.Fatal("Halt didn't halt the Arm7")
+	}
+	if value := system.Arm9Bus.ReadData32(testResults + 4); value != 0 {
+		t.Fatal("Arm7 ran past Halt")
+	}
+
+	// An enabled interrupt wakes the Arm7, which returns from the SWI.
+	system.Arm7Bus.WriteData32(0x04000210, 1)
+	system.Arm7Irq.Request(0)
+	system.RunFor(4000)
+	if value := system.Arm9Bus.ReadData32(testResults + 4); value != 1 {
+		t.Error("Arm7 didn't return from Halt")
+	}
+}
+
+func TestUnemulatedSoftwareInterrupt(t *testing.T) {
+	arm9 := []uint32{
+		0xef000000, // swi 0x000000 (SoftReset)
+		0xeafffffe, // b .
+	}
+	arm7 := []uint32{0xeafffffe} // b .
+	system := bootTestROM(t, arm9, arm7)
+	system.RunFor(100)
+	err, ok := system.Err().(*cpu.UnimplementedSWIError)
+	if !ok || err.Number != 0 || err.Address != testArm9Entry {
+		t.Errorf("err = %v, want SoftReset at the entry point", system.Err())
+	}
+}
+
+func TestArm7GBASlotIsEmpty(t *testing.T) {
+	system := bootTestROM(t, []uint32{0xeafffffe}, []uint32{0xeafffffe})
+	for _, address := range []uint32{0x08000000, 0x080000c0, 0x09fffffc} {
+		if value := system.Arm7Bus.ReadData32(address); value != 0 {
+			t.Errorf("GBA slot reads %#x at %#x", value, address)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package cart
+
+import (
+	"os"
+
+	"github.com/damilolarandolph/casper/flash"
+)
+
+// SaveType is the kind of backup chip on a card.
+type SaveType int
+
+// Backup chip kinds. The type of a card whose chip isn't known yet is
+// detected from the first read the game makes. Its size can't be told
+// from the accesses, so a detected chip starts at the smallest size of
+// its type and grows to cover the highest address the game uses.
+const (
+	SaveUnknown SaveType = iota
+	SaveNone
+	SaveEEPROM512
+	SaveEEPROM
+	SaveFRAM
+	SaveFlash
+)
+
+// SaveConfig describes the backup chip of a card.
+type SaveConfig struct {
+	Type SaveType
+	Size int
+}
+
+// SaveDatabase overrides the save type detection for games whose first
+// accesses are misleading, keyed by game code. Only the chips listed here
+// have a known size.
+var SaveDatabase = map[string]SaveConfig{
+	"ASME": {SaveEEPROM512, 0x200},
+	"A2DE": {SaveEEPROM, 0x2000},
+	"AMCE": {SaveFlash, 0x40000},
+	"ADAE": {SaveFlash, 0x80000},
+	"APAE": {SaveFlash, 0x80000},
+}
+
+// EEPROM and FRAM commands, flash chips have those of the flash package.
+// The 512 byte EEPROM uses bit 3 of the read and write commands as the
+// ninth address bit.
+const (
+	backupWriteStatus  = 0x01
+	backupWrite        = 0x02
+	backupRead         = 0x03
+	backupWriteDisable = 0x04
+	backupReadStatus   = 0x05
+	backupWriteEnable  = 0x06
+	backupWriteHigh    = 0x0a
+	backupReadHigh     = 0x0b
+)
+
+// Bits of the status register.
+const (
+	statusWriteEnable uint8 = 1 << 1
+	statusWriteMask   uint8 = 0x8c
+)
+
+const (
+	// The smallest and largest chips of the types addressed with two and
+	// three bytes, the sizes detected chips start at and grow up to.
+	eepromMinSize = 0x2000
+	eepromMaxSize = 0x10000
+	flashMinSize  = 0x40000
+	flashMaxSize  = 0x1000000
+)
+
+// Backup is the SPI backup chip of a card, which keeps the game's saves.
+// Its contents are kept in a save file in the raw format, a plain dump of
+// the chip.
+type Backup struct {
+	config SaveConfig
+	image  flash.Image
+	// flash drives the chip when it is a flash chip.
+	flash *flash.Chip
+	// fixed is set when the size of the chip is known, otherwise it grows
+	// with the addresses the game uses.
+	fixed bool
+
+	status      uint8
+	command     uint8
+	position    int
+	address     uint32
+	transferred int
+
+	// The selections made before the type is detected are kept with the
+	// status they started from and replayed once it is known.
+	selection     []uint8
+	pending       [][]uint8
+	pendingStatus uint8
+}
+
+// NewBackup constructs the backup chip for the game with the given code,
+// taking its type from the database when it is listed there.
+func NewBackup(gameCode string) *Backup {
+	backup := &Backup{}
+	backup.flash = flash.NewChip(&backup.image)
+	backup.flash.Resize = backup.grow
+	if config, ok := SaveDatabase[gameCode]; ok {
+		backup.SetConfig(config)
+	}
+	return backup
+}
+
+// configForSize guesses the chip from the size of a save.
+func configForSize(size int) SaveConfig {
+	switch {
+	case size == 0:
+		return SaveConfig{SaveUnknown, 0}
+	case size <= 0x200:
+		return SaveConfig{SaveEEPROM512, 0x200}
+	case size == 0x8000:
+		return SaveConfig{SaveFRAM, size}
+	case size <= 0x10000:
+		return SaveConfig{SaveEEPROM, size}
+	}
+	return SaveConfig{SaveFlash, size}
+}
+
+func (backup *Backup) setConfig(config SaveConfig) {
+	backup.config = config
+	if len(backup.image.Data) != config.Size {
+		data := make([]byte, config.Size)
+		for index := range data {
+			data[index] = 0xff
+		}
+		copy(data, backup.image.Data)
+		backup.image.Data = data
+	}
+}
+
+// Config returns the type and size of the chip.
+func (backup *Backup) Config() SaveConfig {
+	return backup.config
+}
+
+// Data returns the contents of the chip.
+func (backup *Backup) Data() []byte {
+	return backup.image.Data
+}
+
+// SetConfig overrides the type and size of the chip.
+func (backup *Backup) SetConfig(config SaveConfig) {
+	backup.fixed = true
+	backup.setConfig(config)
+}
+
+// Load reads the save file at path and keeps using it for the chip. A
+// missing file starts a blank save. Unless the chip's type is already
+// known it is taken from the size of the file, which the chip can still
+// grow past.
+func (backup *Backup) Load(path string) error {
+	backup.image.Path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	backup.image.Data = data
+	if backup.config.Type == SaveUnknown {
+		backup.config = configForSize(len(data))
+	}
+	backup.setConfig(backup.config)
+	return nil
+}
+
+// Flush writes the chip to its save file if it has changed.
+func (backup *Backup) Flush() error {
+	return backup.image.Flush()
+}
+
+// Dirty reports whether the chip has changed since it was last flushed.
+func (backup *Backup) Dirty() bool {
+	return backup.image.Dirty
+}
+
+func (backup *Backup) addressBytes() int {
+	if backup.config.Type == SaveEEPROM512 {
+		return 1
+	}
+	return 2
+}
+
+func (backup *Backup) pageSize() uint32 {
+	switch backup.config.Type {
+	case SaveEEPROM512:
+		return 0x10
+	// Only EEPROMs of a known size wrap writes within their pages, the
+	// others might be FRAM, which has none.
+	case SaveEEPROM:
+		if !backup.fixed {
+			return uint32(backup.config.Size)
+		}
+		if backup.config.Size <= 0x2000 {
+			return 0x20
+		}
+		return 0x80
+	}
+	return uint32(backup.config.Size)
+}
+
+// transfer exchanges a byte with the chip while it is selected. The first
+// byte of each selection is the command.
+func (backup *Backup) transfer(value uint8) uint8 {
+	if backup.config.Type == SaveFlash {
+		return backup.flash.Transfer(value)
+	}
+	position := backup.position
+	backup.position++
+	if backup.config.Type == SaveUnknown {
+		if position == 0 && len(backup.pending) == 0 {
+			backup.pendingStatus = backup.status
+		}
+		backup.selection = append(backup.selection, value)
+	}
+	if position == 0 {
+		backup.command = value
+		backup.address = 0
+		switch value {
+		case backupWriteEnable:
+			backup.status |= statusWriteEnable
+		case backupWriteDisable:
+			backup.status &^= statusWriteEnable
+		}
+		return 0xff
+	}
+
+	switch backup.config.Type {
+	case SaveNone:
+		return 0xff
+	case SaveUnknown:
+		return backup.detect(value)
+	}
+	return backup.eepromCommand(position, value)
+}
+
+// detect counts the bytes of the first read while the chip's type isn't
+// known. Games read whole blocks whose size is a multiple of four, so the
+// remainder gives the number of address bytes. The chip is blank until
+// then, so the reads return erased bytes.
+func (backup *Backup) detect(value uint8) uint8 {
+	switch backup.command {
+	case backupReadStatus:
+		return backup.status
+	case backupRead:
+		backup.transferred++
+	}
+	return 0xff
+}
+
+// detected sets the type found by the first read and replays the
+// selections made before it.
+func (backup *Backup) detected() {
+	switch backup.transferred & 3 {
+	case 1:
+		backup.setConfig(SaveConfig{SaveEEPROM512, 0x200})
+	case 2:
+		backup.setConfig(SaveConfig{SaveEEPROM, eepromMinSize})
+	case 3:
+		backup.setConfig(SaveConfig{SaveFlash, flashMinSize})
+	}
+	backup.transferred = 0
+	if backup.config.Type == SaveUnknown {
+		return
+	}
+
+	pending := backup.pending
+	backup.pending = nil
+	backup.status = backup.pendingStatus
+	for _, selection := range pending {
+		for _, value := range selection {
+			backup.transfer(value)
+		}
+		backup.release()
+	}
+}
+
+// grow enlarges a chip of unknown size to the next power of two that
+// covers address.
+func (backup *Backup) grow(address uint32) {
+	var maxSize int
+	switch backup.config.Type {
+	case SaveEEPROM:
+		maxSize = eepromMaxSize
+	case SaveFlash:
+		maxSize = flashMaxSize
+	default:
+		return
+	}
+	size := len(backup.image.Data)
+	if backup.fixed || int(address) < size {
+		return
+	}
+	for size <= int(address) && size < maxSize {
+		size *= 2
+	}
+	backup.setConfig(SaveConfig{backup.config.Type, size})
+}
+
+// addressByte shifts in the address bytes that follow the command and
+// reports whether the address is complete.
+func (backup *Backup) addressByte(position int, value uint8) bool {
+	if position > backup.addressBytes() {
+		return true
+	}
+	backup.address = backup.address<<8 | uint32(value)
+	if position == backup.addressBytes() {
+		backup.grow(backup.address)
+		backup.address %= uint32(len(backup.image.Data))
+	}
+	return false
+}
+
+func (backup *Backup) readByte() uint8 {
+	value := backup.image.Data[backup.address]
+	backup.address = (backup.address + 1) % uint32(len(backup.image.Data))
+	return value
+}
+
+// writeByte stores a byte, wrapping within the current page.
+func (backup *Backup) writeByte(value uint8) {
+	if backup.status&statusWriteEnable == 0 {
+		return
+	}
+	backup.image.Data[backup.address] = value
+	page := backup.pageSize()
+	backup.address = backup.address&^(page-1) | (backup.address+1)&(page-1)
+	backup.image.Dirty = true
+}
+
+func (backup *Backup) eepromCommand(position int, value uint8) uint8 {
+	command := backup.command
+	if backup.config.Type == SaveEEPROM512 {
+		if position == 1 && command&0x08 != 0 {
+			backup.address = 1
+		}
+		command &^= 0x08
+	}
+	switch command {
+	case backupReadStatus:
+		return backup.readStatus()
+	case backupWriteStatus:
+		if position == 1 {
+			backup.writeStatus(value)
+		}
+	case backupRead:
+		if backup.addressByte(position, value) {
+			return backup.readByte()
+		}
+	case backupWrite:
+		if backup.addressByte(position, value) {
+			backup.writeByte(value)
+		}
+	}
+	return 0xff
+}
+
+func (backup *Backup) readStatus() uint8 {
+	// Unused bits of the 512 byte EEPROM read as set.
+	if backup.config.Type == SaveEEPROM512 {
+		return backup.status | 0xf0
+	}
+	return backup.status
+}
+
+func (backup *Backup) writeStatus(value uint8) {
+	if backup.status&statusWriteEnable == 0 {
+		return
+	}
+	backup.status = backup.status&^statusWriteMask | value&statusWriteMask
+}
+
+// release ends the selection of the chip, which completes writes and
+// erases and disables writing again.
+func (backup *Backup) release() {
+	if backup.config.Type == SaveFlash {
+		backup.flash.Release()
+		return
+	}
+	command := backup.command
+	complete := backup.position > 0
+	backup.position = 0
+	if !complete {
+		return
+	}
+	if backup.config.Type == SaveUnknown {
+		selection := backup.selection
+		backup.selection = nil
+		if command == backupRead {
+			backup.detected()
+		} else {
+			backup.pending = append(backup.pending, selection)
+		}
+		return
+	}
+	if command&^0x08 == backupWrite || command == backupWriteStatus {
+		backup.status &^= statusWriteEnable
+	}
+}
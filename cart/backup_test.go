@@ -0,0 +1,215 @@
+package cart
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/damilolarandolph/casper/flash"
+)
+
+// spi selects the chip, exchanges bytes with it and releases it.
+func spi(backup *Backup, bytes ...uint8) []uint8 {
+	var response []uint8
+	for _, value := range bytes {
+		response = append(response, backup.transfer(value))
+	}
+	backup.release()
+	return response
+}
+
+func TestBackupDetection(t *testing.T) {
+	tests := []struct {
+		name   string
+		read   []uint8
+		config SaveConfig
+	}{
+		{"eeprom 512", []uint8{backupRead, 0, 0, 0, 0, 0}, SaveConfig{SaveEEPROM512, 0x200}},
+		{"eeprom", []uint8{backupRead, 0, 0, 0, 0, 0, 0}, SaveConfig{SaveEEPROM, eepromMinSize}},
+		{"flash", []uint8{backupRead, 0, 0, 0, 0, 0, 0, 0}, SaveConfig{SaveFlash, flashMinSize}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backup := NewBackup("XXXX")
+			response := spi(backup, test.read...)
+			for _, value := range response {
+				if value != 0xff {
+					t.Errorf("blank chip read %#x", value)
+				}
+			}
+			if backup.Config() != test.config || len(backup.Data()) != test.config.Size {
+				t.Errorf("config = %+v, want %+v", backup.Config(), test.config)
+			}
+		})
+	}
+}
+
+func TestBackupWritesBeforeDetection(t *testing.T) {
+	backup := NewBackup("XXXX")
+	spi(backup, backupWriteEnable)
+	spi(backup, backupWrite, 0x00, 0x10, 0xaa, 0xbb)
+	spi(backup, backupRead, 0, 0, 0, 0, 0, 0)
+	if backup.Config().Type != SaveEEPROM {
+		t.Fatalf("type = %v", backup.Config().Type)
+	}
+	if backup.Data()[0x10] != 0xaa || backup.Data()[0x11] != 0xbb {
+		t.Errorf("write before detection lost, data = %x", backup.Data()[0x10:0x12])
+	}
+	if status := spi(backup, backupReadStatus, 0)[1]; status&statusWriteEnable != 0 {
+		t.Error("write enable latch left set")
+	}
+}
+
+func TestBackupGrowsWithAddresses(t *testing.T) {
+	backup := NewBackup("XXXX")
+	spi(backup, backupRead, 0, 0, 0, 0, 0, 0)
+	spi(backup, backupWriteEnable)
+	spi(backup, backupWrite, 0x7f, 0xfe, 1, 2)
+	if size := backup.Config().Size; size != 0x8000 || len(backup.Data()) != size {
+		t.Fatalf("size = %#x, want 0x8000", size)
+	}
+	if response := spi(backup, backupRead, 0x7f, 0xfe, 0, 0); response[3] != 1 || response[4] != 2 {
+		t.Errorf("read back %x", response[3:])
+	}
+	// The grown chip may be FRAM, so writes don't wrap at EEPROM pages.
+	spi(backup, backupWriteEnable)
+	spi(backup, backupWrite, 0x01, 0x7e, 1, 2, 3, 4)
+	if backup.Data()[0x180] != 3 {
+		t.Errorf("write wrapped, data = %x", backup.Data()[0x17e:0x182])
+	}
+}
+
+func TestBackupKnownEEPROM(t *testing.T) {
+	backup := NewBackup("XXXX")
+	backup.SetConfig(SaveConfig{SaveEEPROM, 0x2000})
+	spi(backup, backupWriteEnable)
+	if status := spi(backup, backupReadStatus, 0)[1]; status != statusWriteEnable {
+		t.Errorf("status = %#x", status)
+	}
+	// Writes wrap within the 32 byte pages.
+	spi(backup, backupWrite, 0x00, 0x1e, 1, 2, 3, 4)
+	if backup.Data()[0x00] != 3 || backup.Data()[0x01] != 4 {
+		t.Errorf("page = %x", backup.Data()[:0x20])
+	}
+	// Addresses past the end mirror.
+	if response := spi(backup, backupRead, 0x20, 0x1e, 0); response[3] != 1 {
+		t.Errorf("mirror read %#x", response[3])
+	}
+	spi(backup, backupWrite, 0x00, 0x00, 9)
+	if backup.Data()[0] != 3 {
+		t.Error("written without the write enable latch")
+	}
+	if backup.Config().Size != 0x2000 {
+		t.Errorf("known size changed to %#x", backup.Config().Size)
+	}
+}
+
+func TestBackupEEPROM512HighHalf(t *testing.T) {
+	backup := NewBackup("XXXX")
+	backup.SetConfig(SaveConfig{SaveEEPROM512, 0x200})
+	spi(backup, backupWriteEnable)
+	spi(backup, backupWriteHigh, 0x10, 7)
+	if backup.Data()[0x110] != 7 {
+		t.Error("write to the high half missed")
+	}
+	if value := spi(backup, backupReadHigh, 0x10, 0)[2]; value != 7 {
+		t.Errorf("read %#x", value)
+	}
+	if status := spi(backup, backupReadStatus, 0)[1]; status != 0xf0 {
+		t.Errorf("status = %#x", status)
+	}
+}
+
+func TestBackupFlash(t *testing.T) {
+	backup := NewBackup("ADAE")
+	if id := spi(backup, flash.CommandReadID, 0, 0, 0); id[1] != 0x20 || id[3] != 0x13 {
+		t.Errorf("JEDEC ID = %x", id[1:])
+	}
+	spi(backup, flash.CommandWriteEnable)
+	spi(backup, flash.CommandPageWrite, 0x00, 0x01, 0xff, 0x12, 0x34)
+	// Page program can only clear bits.
+	spi(backup, flash.CommandWriteEnable)
+	spi(backup, flash.CommandPageProgram, 0x00, 0x01, 0x00, 0x0f)
+	if value := spi(backup, flash.CommandRead, 0x00, 0x01, 0x00, 0)[4]; value != 0x04 {
+		t.Errorf("programmed byte = %#x", value)
+	}
+	// Fast read has a dummy byte and writes wrap within the page.
+	if response := spi(backup, flash.CommandFastRead, 0x00, 0x01, 0xff, 0, 0, 0); response[5] != 0x12 || response[6] != 0xff {
+		t.Errorf("fast read = %x", response[5:])
+	}
+	spi(backup, flash.CommandWriteEnable)
+	spi(backup, flash.CommandPageErase, 0x00, 0x01, 0x10)
+	for _, value := range backup.Data()[0x100:0x200] {
+		if value != 0xff {
+			t.Fatal("page not erased")
+		}
+	}
+}
+
+func TestBackupSaveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.sav")
+
+	backup := NewBackup("XXXX")
+	if err := backup.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	spi(backup, backupRead, 0, 0, 0, 0, 0, 0)
+	spi(backup, backupWriteEnable)
+	spi(backup, backupWrite, 0x00, 0x00, 0x5a)
+	if err := backup.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != eepromMinSize {
+		t.Fatalf("save file = %v, %v", info, err)
+	}
+
+	// The next run takes the size from the file and still grows past it.
+	reloaded := NewBackup("XXXX")
+	if err := reloaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Config() != (SaveConfig{SaveEEPROM, eepromMinSize}) || reloaded.Data()[0] != 0x5a {
+		t.Fatalf("config = %+v", reloaded.Config())
+	}
+	spi(reloaded, backupWriteEnable)
+	spi(reloaded, backupWrite, 0xff, 0xff, 1)
+	if reloaded.Config().Size != eepromMaxSize || reloaded.Data()[0] != 0x5a {
+		t.Errorf("config = %+v", reloaded.Config())
+	}
+}
+
+func TestSlotBackupSPI(t *testing.T) {
+	test := newSlotTest(t)
+	backup := test.slot.card.Backup()
+	backup.SetConfig(SaveConfig{SaveEEPROM, 0x2000})
+	send := func(value uint8, hold bool) uint8 {
+		control := auxControlEnable | auxControlSPI
+		if hold {
+			control |= auxControlHold
+		}
+		test.registry.Write16(auxControlAddress, control)
+		test.registry.Write16(auxDataAddress, uint16(value))
+		if test.registry.Read16(auxControlAddress)&auxControlBusy == 0 {
+			t.Fatal("SPI not busy after a write")
+		}
+		test.scheduler.Advance(test.scheduler.Now() + auxBytePeriod)
+		if test.registry.Read16(auxControlAddress)&auxControlBusy != 0 {
+			t.Fatal("SPI still busy")
+		}
+		return uint8(test.registry.Read16(auxDataAddress))
+	}
+	send(backupWriteEnable, false)
+	send(backupWrite, true)
+	send(0, true)
+	send(5, true)
+	send(0x42, false)
+	send(backupRead, true)
+	send(0, true)
+	send(5, true)
+	if value := send(0, false); value != 0x42 {
+		t.Errorf("read back %#x", value)
+	}
+	if !backup.Dirty() {
+		t.Error("write didn't dirty the save")
+	}
+}
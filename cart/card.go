@@ -0,0 +1,227 @@
+package cart
+
+import "encoding/binary"
+
+// cardMode is the command set the card is accepting.
+type cardMode int
+
+const (
+	modeUnencrypted cardMode = iota
+	modeKey1
+	modeData
+)
+
+const (
+	secureAreaStart = 0x4000
+	secureAreaEnd   = 0x8000
+	secureAreaSize  = 0x800
+	dataBlockSize   = 0x200
+)
+
+// The first words of a secure area that has been decrypted and then had
+// its identifier destroyed, as in most dumps.
+const destroyedSecureArea = 0xe7ffdeff
+
+// Card is a DS game card. It answers the commands sent over the card bus
+// with a stream of bytes.
+type Card struct {
+	rom      []byte
+	header   *Header
+	gameCode uint32
+	bios     []byte
+	backup   *Backup
+
+	mode       cardMode
+	key1       *key1
+	key2       key2
+	key2Active bool
+
+	response func(index uint32) uint8
+	position uint32
+}
+
+// NewCard constructs a card for the given ROM.
+func NewCard(rom []byte, header *Header) *Card {
+	return &Card{
+		rom:      rom,
+		header:   header,
+		gameCode: binary.LittleEndian.Uint32(rom[0x00c:]),
+		response: noResponse,
+		backup:   NewBackup(header.GameCode),
+	}
+}
+
+// Backup returns the card's backup chip.
+func (card *Card) Backup() *Backup {
+	return card.backup
+}
+
+// SetKey1Table provides the Arm7 BIOS the KEY1 table is taken from. Without
+// it the card can't decrypt KEY1 commands, so it can't be booted through
+// the BIOS. A secure area that has been decrypted in the dump is encrypted
+// again so the BIOS finds it the way it is stored on real cards.
+func (card *Card) SetKey1Table(bios []byte) {
+	if len(bios) < Key1TableOffset+Key1TableSize {
+		return
+	}
+	card.bios = bios
+	card.encryptSecureArea()
+}
+
+func (card *Card) encryptSecureArea() {
+	if card.header.Arm9.ROMOffset >= secureAreaEnd || len(card.rom) < secureAreaEnd {
+		return
+	}
+	area := card.rom[secureAreaStart : secureAreaStart+secureAreaSize]
+	if binary.LittleEndian.Uint32(area) != destroyedSecureArea ||
+		binary.LittleEndian.Uint32(area[4:]) != destroyedSecureArea {
+		return
+	}
+	// Work on a copy so the decrypted dump stays available.
+	card.rom = append([]byte(nil), card.rom...)
+	area = card.rom[secureAreaStart : secureAreaStart+secureAreaSize]
+	copy(area, "encryObj")
+	key := newKey1(card.bios, card.gameCode, 3, 8)
+	for offset := 0; offset < secureAreaSize; offset += 8 {
+		encryptBlock(key, area[offset:])
+	}
+	encryptBlock(newKey1(card.bios, card.gameCode, 2, 8), area)
+}
+
+func encryptBlock(key *key1, block []byte) {
+	data := [2]uint32{
+		binary.LittleEndian.Uint32(block),
+		binary.LittleEndian.Uint32(block[4:]),
+	}
+	key.encrypt(&data)
+	binary.LittleEndian.PutUint32(block, data[0])
+	binary.LittleEndian.PutUint32(block[4:], data[1])
+}
+
+// EnterDataMode skips the KEY1 handshake and leaves the card accepting
+// main data commands, as it is after the BIOS has booted a game. The KEY2
+// seeds are the ones the host uses.
+func (card *Card) EnterDataMode(seed0 uint64, seed1 uint64) {
+	card.mode = modeData
+	card.key2.seed(seed0, seed1)
+	card.key2Active = true
+}
+
+// ChipID returns the ID the card answers the chip ID commands with.
+func (card *Card) ChipID() uint32 {
+	return card.header.ChipID()
+}
+
+func noResponse(index uint32) uint8 {
+	return 0xff
+}
+
+// romByte returns a byte of the ROM, mirrored across the chip's capacity.
+func (card *Card) romByte(address uint32) uint8 {
+	address &= card.header.Capacity() - 1
+	if address >= uint32(len(card.rom)) {
+		return 0xff
+	}
+	return card.rom[address]
+}
+
+func (card *Card) chipIDResponse(index uint32) uint8 {
+	return uint8(card.ChipID() >> ((index & 3) * 8))
+}
+
+// command receives the eight command bytes sent by the host.
+func (card *Card) command(cmd [8]uint8) {
+	card.position = 0
+	card.response = noResponse
+
+	switch card.mode {
+	case modeUnencrypted:
+		card.unencryptedCommand(cmd)
+	case modeKey1:
+		card.key1Command(cmd)
+	case modeData:
+		if card.key2Active {
+			for index := range cmd {
+				cmd[index] = card.key2.process(cmd[index])
+			}
+		}
+		card.dataCommand(cmd)
+	}
+}
+
+func (card *Card) unencryptedCommand(cmd [8]uint8) {
+	switch cmd[0] {
+	// Get header, the first 4KB repeat.
+	case 0x00:
+		card.response = func(index uint32) uint8 {
+			return card.romByte(index & 0xfff)
+		}
+	// Get chip ID.
+	case 0x90:
+		card.response = card.chipIDResponse
+	// Activate KEY1 encryption.
+	case 0x3c:
+		if card.bios != nil {
+			card.mode = modeKey1
+			card.key1 = newKey1(card.bios, card.gameCode, 2, 8)
+		}
+	}
+}
+
+func (card *Card) key1Command(cmd [8]uint8) {
+	// The command is sent as a big endian 64 bit value.
+	data := [2]uint32{
+		binary.BigEndian.Uint32(cmd[4:]),
+		binary.BigEndian.Uint32(cmd[:4]),
+	}
+	card.key1.decrypt(&data)
+	value := uint64(data[1])<<32 | uint64(data[0])
+
+	switch value >> 60 {
+	// Activate KEY2 encryption, seeded from the mmmnnn parameter.
+	case 0x4:
+		seed0 := ((value>>20)&0xffffff)<<15 + 0x6000 + key2SeedBytes[card.header.SeedSelect&7]
+		card.key2.seed(seed0, key2Seed1)
+		card.key2Active = true
+	// Get chip ID.
+	case 0x1:
+		card.response = card.chipIDResponse
+	// Get a 4KB block of the secure area.
+	case 0x2:
+		base := uint32((value>>44)&0xffff) * 0x1000
+		card.response = func(index uint32) uint8 {
+			return card.romByte(base + index&0xfff)
+		}
+	// Enter main data mode.
+	case 0xa:
+		card.mode = modeData
+	}
+}
+
+func (card *Card) dataCommand(cmd [8]uint8) {
+	switch cmd[0] {
+	// Read data, in 512 byte blocks that wrap within a 4KB page. Reads from
+	// the secure area are redirected past it.
+	case 0xb7:
+		address := binary.BigEndian.Uint32(cmd[1:5])
+		if address < secureAreaEnd {
+			address = secureAreaEnd + address&(dataBlockSize-1)
+		}
+		card.response = func(index uint32) uint8 {
+			return card.romByte(address&^0xfff | (address+index)&0xfff)
+		}
+	// Get chip ID.
+	case 0xb8:
+		card.response = card.chipIDResponse
+	}
+}
+
+// readByte returns the next byte of the response to the last command.
+func (card *Card) readByte() uint8 {
+	value := card.response(card.position)
+	card.position++
+	if card.key2Active {
+		value = card.key2.process(value)
+	}
+	return value
+}
@@ -0,0 +1,157 @@
+// Package cart implements DS game cards: the ROM header, the card
+// protocol and the backup memory on the card.
+package cart
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// HeaderSize is the size of the part of the header that is loaded into
+// main RAM on boot.
+const HeaderSize = 0x170
+
+// Binary describes where one of the CPU binaries is stored in the ROM and
+// where it is loaded to.
+type Binary struct {
+	ROMOffset  uint32
+	EntryPoint uint32
+	RAMAddress uint32
+	Size       uint32
+}
+
+// Header is the header at the start of every DS ROM.
+type Header struct {
+	Title             string
+	GameCode          string
+	MakerCode         string
+	UnitCode          uint8
+	SeedSelect        uint8
+	DeviceCapacity    uint8
+	ROMVersion        uint8
+	Arm9              Binary
+	Arm7              Binary
+	FNTOffset         uint32
+	FNTSize           uint32
+	FATOffset         uint32
+	FATSize           uint32
+	Arm9Overlay       uint32
+	Arm9OverlaySize   uint32
+	Arm7Overlay       uint32
+	Arm7OverlaySize   uint32
+	NormalCardControl uint32
+	Key1CardControl   uint32
+	IconTitleOffset   uint32
+	SecureAreaCRC     uint16
+	SecureAreaDelay   uint16
+	ROMSize           uint32
+	HeaderSize        uint32
+	LogoCRC           uint16
+	HeaderCRC         uint16
+
+	raw []byte
+}
+
+// ErrShortROM is returned for a ROM too small to hold a header.
+var ErrShortROM = errors.New("cart: ROM is smaller than its header")
+
+// ErrHeaderCRC is returned when the header checksum doesn't match.
+var ErrHeaderCRC = errors.New("cart: header CRC mismatch")
+
+// ParseHeader parses the header at the start of rom.
+func ParseHeader(rom []byte) (*Header, error) {
+	if len(rom) < HeaderSize {
+		return nil, ErrShortROM
+	}
+	word := func(offset int) uint32 {
+		return binary.LittleEndian.Uint32(rom[offset:])
+	}
+	half := func(offset int) uint16 {
+		return binary.LittleEndian.Uint16(rom[offset:])
+	}
+	binaryAt := func(offset int) Binary {
+		return Binary{
+			ROMOffset:  word(offset),
+			EntryPoint: word(offset + 4),
+			RAMAddress: word(offset + 8),
+			Size:       word(offset + 12),
+		}
+	}
+
+	header := &Header{
+		Title:             strings.TrimRight(string(rom[0x000:0x00c]), "\x00"),
+		GameCode:          strings.TrimRight(string(rom[0x00c:0x010]), "\x00"),
+		MakerCode:         strings.TrimRight(string(rom[0x010:0x012]), "\x00"),
+		UnitCode:          rom[0x012],
+		SeedSelect:        rom[0x013],
+		DeviceCapacity:    rom[0x014],
+		ROMVersion:        rom[0x01e],
+		Arm9:              binaryAt(0x020),
+		Arm7:              binaryAt(0x030),
+		FNTOffset:         word(0x040),
+		FNTSize:           word(0x044),
+		FATOffset:         word(0x048),
+		FATSize:           word(0x04c),
+		Arm9Overlay:       word(0x050),
+		Arm9OverlaySize:   word(0x054),
+		Arm7Overlay:       word(0x058),
+		Arm7OverlaySize:   word(0x05c),
+		NormalCardControl: word(0x060),
+		Key1CardControl:   word(0x064),
+		IconTitleOffset:   word(0x068),
+		SecureAreaCRC:     half(0x06c),
+		SecureAreaDelay:   half(0x06e),
+		ROMSize:           word(0x080),
+		HeaderSize:        word(0x084),
+		LogoCRC:           half(0x15c),
+		HeaderCRC:         half(0x15e),
+		raw:               rom[:HeaderSize],
+	}
+
+	if CRC16(0xffff, rom[:0x15e]) != header.HeaderCRC {
+		return header, ErrHeaderCRC
+	}
+	return header, nil
+}
+
+// Bytes returns the raw header as it is loaded into main RAM.
+func (header *Header) Bytes() []byte {
+	return header.raw
+}
+
+// Capacity returns the size of the card's ROM chip in bytes.
+func (header *Header) Capacity() uint32 {
+	return 0x20000 << header.DeviceCapacity
+}
+
+// ChipID returns the ID the card reports to the chip ID command, which is
+// derived from its capacity.
+func (header *Header) ChipID() uint32 {
+	id := uint32(0xc2)
+	capacity := header.Capacity()
+	switch {
+	case capacity < 1<<20:
+		// No real cards are this small.
+	case capacity <= 128<<20:
+		id |= ((capacity >> 20) - 1) << 8
+	default:
+		id |= (0x100 - (capacity >> 28)) << 8
+	}
+	return id
+}
+
+// CRC16 computes the CRC-16 used by the DS BIOS and headers over data.
+func CRC16(crc uint16, data []byte) uint16 {
+	for _, value := range data {
+		crc ^= uint16(value)
+		for bit := 0; bit < 8; bit++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,75 @@
+package cart
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		name string
+		crc  uint16
+		data []byte
+		want uint16
+	}{
+		// The CRC-16/MODBUS check value.
+		{"check", 0xffff, []byte("123456789"), 0x4b37},
+		{"empty", 0xffff, nil, 0xffff},
+		{"zero seed", 0, []byte("123456789"), 0xbb3d},
+	}
+	for _, test := range tests {
+		if got := CRC16(test.crc, test.data); got != test.want {
+			t.Errorf("%s: CRC16 = %#x, want %#x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	rom := make([]byte, 0x1000)
+	copy(rom, "CASPER TEST\x00TEST01")
+	rom[0x14] = 2
+	binary.LittleEndian.PutUint32(rom[0x20:], 0x4000)
+	binary.LittleEndian.PutUint32(rom[0x24:], 0x02000800)
+	binary.LittleEndian.PutUint32(rom[0x3c:], 0x1234)
+	binary.LittleEndian.PutUint16(rom[0x15e:], CRC16(0xffff, rom[:0x15e]))
+
+	header, err := ParseHeader(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Title != "CASPER TEST" || header.GameCode != "TEST" || header.MakerCode != "01" {
+		t.Errorf("title, game code, maker = %q, %q, %q", header.Title, header.GameCode, header.MakerCode)
+	}
+	if header.Arm9.ROMOffset != 0x4000 || header.Arm9.EntryPoint != 0x02000800 || header.Arm7.Size != 0x1234 {
+		t.Errorf("binaries = %+v, %+v", header.Arm9, header.Arm7)
+	}
+	if header.Capacity() != 0x80000 {
+		t.Errorf("capacity = %#x", header.Capacity())
+	}
+
+	rom[0] ^= 1
+	if _, err := ParseHeader(rom); err != ErrHeaderCRC {
+		t.Errorf("corrupt header gave %v", err)
+	}
+	if _, err := ParseHeader(rom[:0x100]); err != ErrShortROM {
+		t.Errorf("short ROM gave %v", err)
+	}
+}
+
+func TestChipID(t *testing.T) {
+	tests := []struct {
+		capacity uint8
+		id       uint32
+	}{
+		{3, 0x0c2},
+		{7, 0x0fc2},
+		{10, 0x7fc2},
+		{12, 0xfec2},
+	}
+	for _, test := range tests {
+		header := &Header{DeviceCapacity: test.capacity}
+		if id := header.ChipID(); id != test.id {
+			t.Errorf("capacity %d: ChipID = %#x, want %#x", test.capacity, id, test.id)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package cart
+
+import "encoding/binary"
+
+// Key1TableOffset and Key1TableSize locate the KEY1 Blowfish table in the
+// Arm7 BIOS.
+const (
+	Key1TableOffset = 0x30
+	Key1TableSize   = 0x1048
+)
+
+// key1 is the Blowfish variant used by the KEY1 card command encryption
+// and the secure area.
+type key1 struct {
+	keys    [Key1TableSize / 4]uint32
+	keycode [3]uint32
+}
+
+// newKey1 initialises KEY1 from the table in the Arm7 BIOS for the given
+// game code. Level 2 is used for card commands and level 3 for the
+// secure area, modulo is the keycode length in bytes.
+func newKey1(bios []byte, gameCode uint32, level int, modulo int) *key1 {
+	key := &key1{}
+	table := bios[Key1TableOffset : Key1TableOffset+Key1TableSize]
+	for index := range key.keys {
+		key.keys[index] = binary.LittleEndian.Uint32(table[index*4:])
+	}
+	key.keycode = [3]uint32{gameCode, gameCode / 2, gameCode * 2}
+	if level >= 1 {
+		key.applyKeycode(modulo)
+	}
+	if level >= 2 {
+		key.applyKeycode(modulo)
+	}
+	key.keycode[1] *= 2
+	key.keycode[2] /= 2
+	if level >= 3 {
+		key.applyKeycode(modulo)
+	}
+	return key
+}
+
+func bswap(value uint32) uint32 {
+	return value>>24 | (value>>8)&0xff00 | (value<<8)&0xff0000 | value<<24
+}
+
+func (key *key1) applyKeycode(modulo int) {
+	pair := [2]uint32{key.keycode[1], key.keycode[2]}
+	key.encrypt(&pair)
+	key.keycode[1], key.keycode[2] = pair[0], pair[1]
+	pair = [2]uint32{key.keycode[0], key.keycode[1]}
+	key.encrypt(&pair)
+	key.keycode[0], key.keycode[1] = pair[0], pair[1]
+
+	for index := 0; index < 0x12; index++ {
+		key.keys[index] ^= bswap(key.keycode[(index*4%modulo)/4])
+	}
+
+	var scratch [2]uint32
+	for index := 0; index < 0x412; index += 2 {
+		key.encrypt(&scratch)
+		key.keys[index] = scratch[1]
+		key.keys[index+1] = scratch[0]
+	}
+}
+
+func (key *key1) round(value uint32) uint32 {
+	result := key.keys[0x012+(value>>24)]
+	result += key.keys[0x112+(value>>16)&0xff]
+	result ^= key.keys[0x212+(value>>8)&0xff]
+	result += key.keys[0x312+value&0xff]
+	return result
+}
+
+func (key *key1) encrypt(data *[2]uint32) {
+	y, x := data[0], data[1]
+	for index := 0; index < 0x10; index++ {
+		z := key.keys[index] ^ x
+		x = y ^ key.round(z)
+		y = z
+	}
+	data[0] = x ^ key.keys[0x10]
+	data[1] = y ^ key.keys[0x11]
+}
+
+func (key *key1) decrypt(data *[2]uint32) {
+	y, x := data[0], data[1]
+	for index := 0x11; index >= 0x02; index-- {
+		z := key.keys[index] ^ x
+		x = y ^ key.round(z)
+		y = z
+	}
+	data[0] = x ^ key.keys[0x01]
+	data[1] = y ^ key.keys[0x00]
+}
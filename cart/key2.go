@@ -0,0 +1,39 @@
+package cart
+
+const key2Mask = 0x7fffffffff
+
+// key2 is the pair of 39 bit LFSRs whose output is XORed with card
+// commands and data once KEY2 is active. The host and the card each keep
+// their own copy and stay in step by encrypting the same bytes.
+type key2 struct {
+	x uint64
+	y uint64
+}
+
+// reverse39 reverses the order of the low 39 bits of value.
+func reverse39(value uint64) uint64 {
+	var result uint64
+	for bit := 0; bit < 39; bit++ {
+		result = result<<1 | (value>>uint(bit))&1
+	}
+	return result
+}
+
+func (key *key2) seed(seed0 uint64, seed1 uint64) {
+	key.x = reverse39(seed0 & key2Mask)
+	key.y = reverse39(seed1 & key2Mask)
+}
+
+func (key *key2) process(value uint8) uint8 {
+	key.x = (((key.x >> 5) ^ (key.x >> 17) ^ (key.x >> 18) ^ (key.x >> 31)) & 0xff) + (key.x << 8)
+	key.y = (((key.y >> 5) ^ (key.y >> 23) ^ (key.y >> 18) ^ (key.y >> 31)) & 0xff) + (key.y << 8)
+	key.x &= key2Mask
+	key.y &= key2Mask
+	return value ^ uint8(key.x) ^ uint8(key.y)
+}
+
+// The KEY2 seed selected by the low bits of header byte 0x13.
+var key2SeedBytes = [8]uint64{0xe8, 0x4d, 0x5a, 0xb1, 0x17, 0x8f, 0x99, 0xd5}
+
+// Seed1 is constant for every game.
+const key2Seed1 = 0x5c879b9b05
@@ -0,0 +1,93 @@
+package cart
+
+import "testing"
+
+func TestKey1ZeroTableSwapsHalves(t *testing.T) {
+	// With a zero table every round only swaps the halves, sixteen of them
+	// cancel out and the final swap is left.
+	key := newKey1(make([]byte, 0x4000), 0, 0, 8)
+	data := [2]uint32{0x01234567, 0x89abcdef}
+	key.encrypt(&data)
+	if data != [2]uint32{0x89abcdef, 0x01234567} {
+		t.Errorf("encrypt = %#x", data)
+	}
+}
+
+func TestKey1RoundTrip(t *testing.T) {
+	bios := testBIOS()
+	for level := 1; level <= 3; level++ {
+		key := newKey1(bios, 0x44434241, level, 8)
+		plain := [2]uint32{0xdeadbeef, 0x12345678}
+		data := plain
+		key.encrypt(&data)
+		if data == plain {
+			t.Errorf("level %d: encrypt left the data unchanged", level)
+		}
+		key.decrypt(&data)
+		if data != plain {
+			t.Errorf("level %d: decrypt(encrypt(%#x)) = %#x", level, plain, data)
+		}
+	}
+
+	// The keycode depends on the game code.
+	first := [2]uint32{1, 2}
+	second := first
+	newKey1(bios, 0x44434241, 2, 8).encrypt(&first)
+	newKey1(bios, 0x44434242, 2, 8).encrypt(&second)
+	if first == second {
+		t.Error("different game codes gave the same key")
+	}
+}
+
+func TestReverse39(t *testing.T) {
+	tests := []struct{ value, reversed uint64 }{
+		{0, 0},
+		{1, 1 << 38},
+		{1 << 38, 1},
+		{0x7fffffffff, 0x7fffffffff},
+		{0x5c879b9b05, 0x506cecf09d},
+	}
+	for _, test := range tests {
+		if got := reverse39(test.value); got != test.reversed {
+			t.Errorf("reverse39(%#x) = %#x, want %#x", test.value, got, test.reversed)
+		}
+	}
+}
+
+func TestKey2Stream(t *testing.T) {
+	tests := []struct {
+		name         string
+		seed0, seed1 uint64
+		stream       []uint8
+	}{
+		// The only set bit of X is shifted down into the low byte by the
+		// taps at 31 and then 5.
+		{"x only", 1, 0, []uint8{0x80, 0x04, 0x00}},
+		{"y only", 0, 1, []uint8{0x80, 0x04, 0x00}},
+		// Both registers produce the same bytes, which cancel out.
+		{"both", 1, 1, []uint8{0x00, 0x00, 0x00}},
+		{"zero", 0, 0, []uint8{0x00, 0x00, 0x00}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var key key2
+			key.seed(test.seed0, test.seed1)
+			for index, want := range test.stream {
+				if got := key.process(0); got != want {
+					t.Errorf("byte %d = %#x, want %#x", index, got, want)
+				}
+			}
+		})
+	}
+
+	// Both ends of the card bus decrypt each other's bytes by staying in
+	// step.
+	var host, card key2
+	host.seed(0x58c56de0e8, key2Seed1)
+	card.seed(0x58c56de0e8, key2Seed1)
+	for value := 0; value < 0x100; value++ {
+		if got := card.process(host.process(uint8(value))); got != uint8(value) {
+			t.Fatalf("byte %#x came back as %#x", value, got)
+		}
+	}
+}
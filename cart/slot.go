@@ -0,0 +1,455 @@
+package cart
+
+import (
+	"github.com/damilolarandolph/casper/dma"
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const (
+	memoryControlAddress = 0x04000204
+	auxControlAddress    = 0x040001a0
+	auxDataAddress       = 0x040001a2
+	romControlAddress    = 0x040001a4
+	commandAddress       = 0x040001a8
+	seed0LowAddress      = 0x040001b0
+	seed1LowAddress      = 0x040001b4
+	seed0HighAddress     = 0x040001b8
+	seed1HighAddress     = 0x040001ba
+	dataAddress          = 0x04100010
+)
+
+// Bits of EXMEMCNT.
+const (
+	memoryControlArm7Slot uint16 = 1 << 11
+	memoryControlFixed    uint16 = 1 << 13
+)
+
+// Bits of AUXSPICNT.
+const (
+	auxControlBaudRate uint16 = 0x3
+	auxControlHold     uint16 = 1 << 6
+	auxControlBusy     uint16 = 1 << 7
+	auxControlSPI      uint16 = 1 << 13
+	auxControlIRQ      uint16 = 1 << 14
+	auxControlEnable   uint16 = 1 << 15
+)
+
+// Bits of ROMCTRL.
+const (
+	romControlGap1       uint32 = 0x1fff
+	romControlDataKey2   uint32 = 1 << 13
+	romControlApplySeed  uint32 = 1 << 15
+	romControlCmdKey2    uint32 = 1 << 22
+	romControlDataReady  uint32 = 1 << 23
+	romControlSlowClock  uint32 = 1 << 27
+	romControlRelease    uint32 = 1 << 29
+	romControlBusy       uint32 = 1 << 31
+	romControlBlockShift        = 24
+	romControlWriteMask  uint32 = 0x7f7fffff
+)
+
+// Master cycles per byte at each card transfer clock rate, the 33MHz bus
+// runs at half the master clock.
+const (
+	fastBytePeriod = 5 * 2
+	slowBytePeriod = 8 * 2
+)
+
+// Master cycles per byte sent to the backup chip at the fastest SPI clock
+// of 4MHz. Each step of the baud rate halves the clock.
+const auxBytePeriod = 64 * 2
+
+// saveFlushDelay is how long after the last write to the backup chip the
+// save file is written, about a second in master cycles.
+const saveFlushDelay = 67027964
+
+// The KEY2 seeds the host and the card share after a direct boot. Any pair
+// works as long as both sides use it.
+const (
+	bootSeed0 = 0x58c56de0e8
+	bootSeed1 = 0x5c879b9b05
+)
+
+// Slot is the game card slot and the host side of the card bus. Only one
+// CPU at a time can access it, chosen by EXMEMCNT on the Arm9.
+type Slot struct {
+	card      *Card
+	scheduler *scheduler.Scheduler
+	irqs      [2]*irq.Controller
+	dmas      [2]*dma.Controller
+
+	memoryControl     uint16
+	arm7MemoryControl uint16
+	auxControl        uint16
+	romControl        uint32
+	command           [8]uint8
+	seed0             uint64
+	seed1             uint64
+
+	key2      key2
+	data      uint32
+	remaining uint32
+	event     *scheduler.Event
+
+	auxData    uint8
+	auxEvent   *scheduler.Event
+	flushEvent *scheduler.Event
+}
+
+// The index of each CPU in the per CPU state of the slot.
+const (
+	arm7 = iota
+	arm9
+)
+
+// NewSlot constructs an empty game card slot. Transfer complete interrupts
+// and card DMA are delivered to the CPU that owns the slot.
+func NewSlot(sched *scheduler.Scheduler, arm7Interrupts *irq.Controller, arm9Interrupts *irq.Controller, arm7DMA *dma.Controller, arm9DMA *dma.Controller) *Slot {
+	return &Slot{
+		scheduler:     sched,
+		irqs:          [2]*irq.Controller{arm7Interrupts, arm9Interrupts},
+		dmas:          [2]*dma.Controller{arm7DMA, arm9DMA},
+		memoryControl: memoryControlFixed,
+	}
+}
+
+// Insert puts card into the slot, or empties it when card is nil.
+func (slot *Slot) Insert(card *Card) {
+	slot.card = card
+}
+
+// Card returns the card in the slot.
+func (slot *Slot) Card() *Card {
+	return slot.card
+}
+
+// DirectBoot leaves the slot and the card in main data mode with KEY2
+// seeded, as the BIOS does before starting a game. romControl is the
+// ROMCTRL setting for normal commands from the header.
+func (slot *Slot) DirectBoot(romControl uint32) {
+	slot.seed0 = bootSeed0
+	slot.seed1 = bootSeed1
+	slot.key2.seed(slot.seed0, slot.seed1)
+	slot.auxControl = auxControlEnable
+	slot.romControl = romControl&romControlWriteMask&^(romControlApplySeed|romControlBusy) | romControlRelease
+	if slot.card != nil {
+		slot.card.EnterDataMode(slot.seed0, slot.seed1)
+	}
+}
+
+// MapArm7 registers the slot registers and EXMEMSTAT with the Arm7 I/O
+// registry.
+func (slot *Slot) MapArm7(registry *mmio.Registry) {
+	registry.MapRegister(memoryControlAddress, 2, &mmio.Register{
+		ReadMask:  0xffff,
+		WriteMask: 0x7f,
+		OnRead: func() uint32 {
+			return uint32(slot.memoryControl&^0x7f | slot.arm7MemoryControl)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			slot.arm7MemoryControl = uint16(value & 0x7f)
+		},
+	})
+	slot.mapRegisters(registry, arm7)
+}
+
+// MapArm9 registers the slot registers and EXMEMCNT with the Arm9 I/O
+// registry.
+func (slot *Slot) MapArm9(registry *mmio.Registry) {
+	registry.MapRegister(memoryControlAddress, 2, &mmio.Register{
+		ReadMask:  0xffff,
+		WriteMask: 0xc8ff,
+		OnRead: func() uint32 {
+			return uint32(slot.memoryControl)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			slot.memoryControl = uint16(value) | memoryControlFixed
+		},
+	})
+	slot.mapRegisters(registry, arm9)
+}
+
+func (slot *Slot) owner() int {
+	if slot.memoryControl&memoryControlArm7Slot != 0 {
+		return arm7
+	}
+	return arm9
+}
+
+// The registers read as zero and ignore writes from the CPU that doesn't
+// own the slot.
+func (slot *Slot) mapRegisters(registry *mmio.Registry, cpu int) {
+	owned := func() bool {
+		return slot.owner() == cpu
+	}
+	registry.MapRegister(auxControlAddress, 2, &mmio.Register{
+		ReadMask:  0xe0c3,
+		WriteMask: 0xe043,
+		OnRead: func() uint32 {
+			if !owned() {
+				return 0
+			}
+			return uint32(slot.auxControl)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			if owned() {
+				busy := slot.auxControl & auxControlBusy
+				slot.auxControl = uint16(value)&^auxControlBusy | busy
+			}
+		},
+	})
+	registry.MapRegister(auxDataAddress, 2, &mmio.Register{
+		ReadMask:  0xff,
+		WriteMask: 0xff,
+		OnRead: func() uint32 {
+			if !owned() {
+				return 0
+			}
+			return uint32(slot.auxData)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			if owned() && mask != 0 {
+				slot.writeAuxData(uint8(value))
+			}
+		},
+	})
+	registry.MapRegister(romControlAddress, 4, &mmio.Register{
+		ReadMask:  0xffffffff,
+		WriteMask: 0xffffffff,
+		OnRead: func() uint32 {
+			if !owned() {
+				return 0
+			}
+			return slot.romControl
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			if owned() {
+				slot.writeROMControl(value, mask)
+			}
+		},
+	})
+	registry.Map(commandAddress, commandAddress+7, &mmio.Handler{
+		Read8: func(address uint32) uint8 {
+			if !owned() {
+				return 0
+			}
+			return slot.command[address-commandAddress]
+		},
+		Write8: func(address uint32, value uint8) {
+			if owned() {
+				slot.command[address-commandAddress] = value
+			}
+		},
+	})
+	registry.Map(seed0LowAddress, seed1HighAddress+1, &mmio.Handler{
+		Write8: func(address uint32, value uint8) {
+			if owned() {
+				slot.writeSeed(address, value)
+			}
+		},
+	})
+	readData := func(address uint32, size uint32) uint32 {
+		if !owned() {
+			return 0
+		}
+		return slot.readDataLanes(address, size)
+	}
+	registry.Map(dataAddress, dataAddress+3, &mmio.Handler{
+		Read8: func(address uint32) uint8 {
+			return uint8(readData(address, 1))
+		},
+		Read16: func(address uint32) uint16 {
+			return uint16(readData(address, 2))
+		},
+		Read32: func(address uint32) uint32 {
+			return readData(address, 4)
+		},
+	})
+}
+
+// writeSeed sets a byte of the KEY2 seed registers. The high registers
+// hold the top 7 bits of each 39 bit seed.
+func (slot *Slot) writeSeed(address uint32, value uint8) {
+	seed := &slot.seed0
+	shift := uint(0)
+	switch {
+	case address < seed1LowAddress:
+		shift = uint(address-seed0LowAddress) * 8
+	case address < seed0HighAddress:
+		seed = &slot.seed1
+		shift = uint(address-seed1LowAddress) * 8
+	case address < seed1HighAddress:
+		shift = 32 + uint(address-seed0HighAddress)*8
+	default:
+		seed = &slot.seed1
+		shift = 32 + uint(address-seed1HighAddress)*8
+	}
+	*seed = (*seed &^ (0xff << shift)) | uint64(value)<<shift
+	*seed &= key2Mask
+}
+
+func (slot *Slot) writeROMControl(value uint32, mask uint32) {
+	// The release reset bit can't be cleared once set.
+	sticky := slot.romControl & romControlRelease
+	writable := mask & romControlWriteMask
+	slot.romControl = (slot.romControl &^ writable) | (value & writable) | sticky
+
+	if mask&value&romControlApplySeed != 0 {
+		slot.key2.seed(slot.seed0, slot.seed1)
+		slot.romControl &^= romControlApplySeed
+	}
+	if mask&value&romControlBusy != 0 {
+		slot.startTransfer()
+	}
+}
+
+func (slot *Slot) bytePeriod() uint64 {
+	if slot.romControl&romControlSlowClock != 0 {
+		return slowBytePeriod
+	}
+	return fastBytePeriod
+}
+
+// blockSize returns the number of bytes the transfer reads from the card.
+func (slot *Slot) blockSize() uint32 {
+	size := (slot.romControl >> romControlBlockShift) & 7
+	switch size {
+	case 0:
+		return 0
+	case 7:
+		return 4
+	}
+	return 0x100 << size
+}
+
+// startTransfer sends the command to the card. The first word arrives
+// after the command and the gap, each following one after it has been
+// read and the next four bytes clocked in.
+func (slot *Slot) startTransfer() {
+	if slot.auxControl&auxControlEnable == 0 {
+		return
+	}
+	slot.romControl |= romControlBusy
+	command := slot.command
+	if slot.romControl&romControlCmdKey2 != 0 {
+		for index := range command {
+			command[index] = slot.key2.process(command[index])
+		}
+	}
+	if slot.card != nil {
+		slot.card.command(command)
+	}
+
+	slot.remaining = slot.blockSize()
+	slot.scheduler.Cancel(slot.event)
+	gap := uint64(slot.romControl & romControlGap1)
+	delay := (8 + gap) * slot.bytePeriod()
+	if slot.remaining == 0 {
+		slot.event = slot.scheduler.Schedule(delay, slot.finishTransfer)
+		return
+	}
+	slot.event = slot.scheduler.Schedule(delay+4*slot.bytePeriod(), slot.wordReady)
+}
+
+func (slot *Slot) readByte() uint8 {
+	value := uint8(0xff)
+	if slot.card != nil {
+		value = slot.card.readByte()
+	}
+	if slot.romControl&romControlDataKey2 != 0 {
+		value = slot.key2.process(value)
+	}
+	return value
+}
+
+func (slot *Slot) wordReady() {
+	slot.event = nil
+	slot.data = 0
+	for index := uint(0); index < 4; index++ {
+		slot.data |= uint32(slot.readByte()) << (index * 8)
+	}
+	slot.remaining -= 4
+	slot.romControl |= romControlDataReady
+	slot.dmas[slot.owner()].Trigger(dma.Cartridge)
+}
+
+// readDataLanes reads bytes of the word the card last sent. The transfer
+// only continues once the last byte of the word has been read, so games
+// can read the port a byte or halfword at a time.
+func (slot *Slot) readDataLanes(address uint32, size uint32) uint32 {
+	shift := (address - dataAddress) * 8
+	if address+size < dataAddress+4 {
+		return slot.data >> shift
+	}
+	return slot.readData() >> shift
+}
+
+// readData returns the word the card last sent and lets the transfer
+// continue.
+func (slot *Slot) readData() uint32 {
+	if slot.romControl&romControlDataReady == 0 {
+		return slot.data
+	}
+	slot.romControl &^= romControlDataReady
+	if slot.remaining == 0 {
+		slot.finishTransfer()
+	} else {
+		slot.event = slot.scheduler.Schedule(4*slot.bytePeriod(), slot.wordReady)
+	}
+	return slot.data
+}
+
+func (slot *Slot) finishTransfer() {
+	slot.event = nil
+	slot.romControl &^= romControlBusy
+	if slot.auxControl&auxControlIRQ != 0 {
+		slot.irqs[slot.owner()].Request(irq.CardTransferComplete)
+	}
+}
+
+// writeAuxData sends a byte to the backup chip. The chip is deselected
+// after the byte unless the chip select is held.
+func (slot *Slot) writeAuxData(value uint8) {
+	if slot.auxControl&auxControlEnable == 0 || slot.auxControl&auxControlSPI == 0 {
+		return
+	}
+	slot.auxData = 0xff
+	backup := slot.backup()
+	if backup != nil {
+		slot.auxData = backup.transfer(value)
+		if slot.auxControl&auxControlHold == 0 {
+			backup.release()
+			slot.scheduleFlush(backup)
+		}
+	}
+
+	slot.auxControl |= auxControlBusy
+	slot.scheduler.Cancel(slot.auxEvent)
+	period := uint64(auxBytePeriod) << (slot.auxControl & auxControlBaudRate)
+	slot.auxEvent = slot.scheduler.Schedule(period, func() {
+		slot.auxEvent = nil
+		slot.auxControl &^= auxControlBusy
+	})
+}
+
+func (slot *Slot) backup() *Backup {
+	if slot.card == nil {
+		return nil
+	}
+	return slot.card.Backup()
+}
+
+// scheduleFlush writes the save file once the game has stopped writing to
+// the chip for a while. Errors are kept for the next explicit flush.
+func (slot *Slot) scheduleFlush(backup *Backup) {
+	if !backup.Dirty() {
+		return
+	}
+	slot.scheduler.Cancel(slot.flushEvent)
+	slot.flushEvent = slot.scheduler.Schedule(saveFlushDelay, func() {
+		slot.flushEvent = nil
+		backup.Flush()
+	})
+}
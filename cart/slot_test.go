@@ -0,0 +1,222 @@
+package cart
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/damilolarandolph/casper/dma"
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const testGameCode = "ABCD"
+
+type nullBus struct{}
+
+func (nullBus) ReadData16(uint32) uint32   { return 0 }
+func (nullBus) ReadData32(uint32) uint32   { return 0 }
+func (nullBus) WriteData16(uint32, uint32) {}
+func (nullBus) WriteData32(uint32, uint32) {}
+func (nullBus) SetSequencial(bool)         {}
+
+type slotTest struct {
+	slot       *Slot
+	registry   *mmio.Registry
+	scheduler  *scheduler.Scheduler
+	interrupts *irq.Controller
+	rom        []byte
+	bios       []byte
+}
+
+// testBIOS returns a fake Arm7 BIOS, whose KEY1 table is random.
+func testBIOS() []byte {
+	bios := make([]byte, 0x4000)
+	rand.New(rand.NewSource(1)).Read(bios)
+	return bios
+}
+
+func newSlotTest(t *testing.T) *slotTest {
+	rom := make([]byte, 0x20000)
+	rand.New(rand.NewSource(2)).Read(rom)
+	copy(rom, "TESTGAME\x00\x00\x00\x00"+testGameCode)
+	rom[0x13] = 3
+	rom[0x14] = 0
+	binary.LittleEndian.PutUint32(rom[0x20:], 0x4000)
+	// The secure area starts with the unencrypted identifier.
+	binary.LittleEndian.PutUint32(rom[0x4000:], 0xe7ffdeff)
+	binary.LittleEndian.PutUint32(rom[0x4004:], 0xe7ffdeff)
+	binary.LittleEndian.PutUint16(rom[0x15e:], CRC16(0xffff, rom[:0x15e]))
+	header, err := ParseHeader(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := &slotTest{
+		registry:   mmio.NewRegistry(),
+		scheduler:  scheduler.New(),
+		interrupts: irq.NewController(),
+		rom:        rom,
+		bios:       testBIOS(),
+	}
+	arm7Interrupts := irq.NewController()
+	test.slot = NewSlot(test.scheduler, arm7Interrupts, test.interrupts,
+		dma.NewArm7(nullBus{}, arm7Interrupts), dma.NewArm9(nullBus{}, test.interrupts))
+	test.interrupts.Map(test.registry)
+	test.slot.MapArm9(test.registry)
+	card := NewCard(rom, header)
+	card.SetKey1Table(test.bios)
+	test.slot.Insert(card)
+	test.registry.Write16(auxControlAddress, uint16(auxControlEnable|auxControlIRQ))
+	return test
+}
+
+// waitData runs the scheduler until the card has sent a word.
+func (test *slotTest) waitData() {
+	for test.registry.Read32(romControlAddress)&romControlDataReady == 0 {
+		next, _ := test.scheduler.NextEvent()
+		test.scheduler.Advance(next)
+	}
+}
+
+// transfer sends a command and reads length bytes of its response a word
+// at a time.
+func (test *slotTest) transfer(command [8]byte, control uint32, length int) []byte {
+	for index, value := range command {
+		test.registry.Write8(commandAddress+uint32(index), value)
+	}
+	test.registry.Write32(romControlAddress, control|romControlBusy)
+	var response []byte
+	for len(response) < length {
+		test.waitData()
+		word := test.registry.Read32(dataAddress)
+		response = append(response, byte(word), byte(word>>8), byte(word>>16), byte(word>>24))
+	}
+	// Commands without a response still take their time.
+	test.scheduler.Advance(test.scheduler.Now() + 1000)
+	return response
+}
+
+// expect checks got against the start of want.
+func (test *slotTest) expect(t *testing.T, name string, got []byte, want []byte) {
+	t.Helper()
+	for index := range got {
+		if got[index] != want[index] {
+			t.Fatalf("%s differs at byte %#x: %#x, want %#x", name, index, got[index], want[index])
+		}
+	}
+}
+
+const (
+	blocks1     = 1 << romControlBlockShift
+	blocks4     = 4 << romControlBlockShift
+	blocksChip  = 7 << romControlBlockShift
+	key2Control = romControlDataKey2 | romControlCmdKey2
+)
+
+func TestCardProtocol(t *testing.T) {
+	test := newSlotTest(t)
+	test.registry.Write32(0x04000210, 1<<irq.CardTransferComplete)
+
+	header := test.transfer([8]byte{0x00}, blocks1, 0x200)
+	test.expect(t, "header", header, test.rom[:0x200])
+	if test.registry.Read32(romControlAddress)&romControlBusy != 0 {
+		t.Error("transfer still busy")
+	}
+	if !test.interrupts.Asserted() {
+		t.Error("no transfer complete interrupt")
+	}
+	chipID := test.transfer([8]byte{0x90}, blocksChip, 4)
+	if binary.LittleEndian.Uint32(chipID) != test.slot.card.ChipID() {
+		t.Errorf("chip ID = %x", chipID)
+	}
+
+	// Enter KEY1 mode and send the KEY1 commands encrypted.
+	test.transfer([8]byte{0x3c}, 0, 0)
+	key := newKey1(test.bios, binary.LittleEndian.Uint32([]byte(testGameCode)), 2, 8)
+	encrypt := func(command uint64) (encrypted [8]byte) {
+		data := [2]uint32{uint32(command), uint32(command >> 32)}
+		key.encrypt(&data)
+		binary.BigEndian.PutUint32(encrypted[:], data[1])
+		binary.BigEndian.PutUint32(encrypted[4:], data[0])
+		return
+	}
+	mmmnnn := uint64(0x123456)
+	test.transfer(encrypt(0x4<<60|mmmnnn<<20), 0, 0)
+	seed0 := mmmnnn<<15 + 0x6000 + key2SeedBytes[3]
+	test.registry.Write32(seed0LowAddress, uint32(seed0))
+	test.registry.Write16(seed0HighAddress, uint16(seed0>>32))
+	test.registry.Write32(seed1LowAddress, uint32(key2Seed1&0xffffffff))
+	test.registry.Write16(seed1HighAddress, uint16(uint64(key2Seed1)>>32))
+	test.registry.Write32(romControlAddress, romControlApplySeed)
+
+	chipID = test.transfer(encrypt(0x1<<60), blocksChip|romControlDataKey2, 4)
+	if binary.LittleEndian.Uint32(chipID) != test.slot.card.ChipID() {
+		t.Errorf("KEY1 chip ID = %x", chipID)
+	}
+	secure := test.transfer(encrypt(0x2<<60|4<<44), blocks4|romControlDataKey2, 0x1000)
+	test.expect(t, "secure area", secure, test.slot.card.rom[0x4000:])
+	if test.rom[0x4000] != 0xff {
+		t.Error("encrypting the secure area modified the ROM image")
+	}
+	test.transfer(encrypt(0xa<<60), 0, 0)
+
+	// Data mode reads wrap at 4KB boundaries and redirect the secure area.
+	data := test.transfer([8]byte{0xb7, 0, 0, 0x90, 0}, blocks1|key2Control, 0x200)
+	test.expect(t, "data", data, test.rom[0x9000:])
+	data = test.transfer([8]byte{0xb7, 0, 0, 0xf0, 0x80}, blocks1|key2Control, 0x200)
+	for index := range data {
+		if data[index] != test.rom[0xf000+(0x80+index)&0xfff] {
+			t.Fatalf("wrapped read differs at byte %#x", index)
+		}
+	}
+	data = test.transfer([8]byte{0xb7, 0, 0, 0x10, 0}, blocks1|key2Control, 0x200)
+	test.expect(t, "redirected secure area", data, test.rom[0x8000:])
+}
+
+func TestDirectBootDataMode(t *testing.T) {
+	test := newSlotTest(t)
+	test.slot.DirectBoot(key2Control)
+	chipID := test.transfer([8]byte{0xb8}, blocksChip|key2Control, 4)
+	if binary.LittleEndian.Uint32(chipID) != test.slot.card.ChipID() {
+		t.Errorf("chip ID = %x", chipID)
+	}
+}
+
+// The data port only moves to the next word once its last byte has been
+// read, whatever the width of the reads.
+func TestDataPortNarrowReads(t *testing.T) {
+	tests := []struct {
+		name string
+		read func(registry *mmio.Registry) []byte
+	}{
+		{"bytes", func(registry *mmio.Registry) []byte {
+			var word []byte
+			for lane := uint32(0); lane < 4; lane++ {
+				word = append(word, registry.Read8(dataAddress+lane))
+			}
+			return word
+		}},
+		{"halfwords", func(registry *mmio.Registry) []byte {
+			low := registry.Read16(dataAddress)
+			high := registry.Read16(dataAddress + 2)
+			return []byte{byte(low), byte(low >> 8), byte(high), byte(high >> 8)}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slot := newSlotTest(t)
+			for index, value := range [8]byte{0x00} {
+				slot.registry.Write8(commandAddress+uint32(index), value)
+			}
+			slot.registry.Write32(romControlAddress, blocks1|romControlBusy)
+			var header []byte
+			for len(header) < 0x200 {
+				slot.waitData()
+				header = append(header, test.read(slot.registry)...)
+			}
+			slot.expect(t, "header", header, slot.rom)
+		})
+	}
+}
@@ -1 +1,182 @@
+// Package casper wires the components of a Nintendo DS together into a
+// system that can be run.
 package casper
+
+import (
+	"github.com/damilolarandolph/casper/cart"
+	"github.com/damilolarandolph/casper/cpu"
+	"github.com/damilolarandolph/casper/display"
+	"github.com/damilolarandolph/casper/dma"
+	"github.com/damilolarandolph/casper/firmware"
+	"github.com/damilolarandolph/casper/gpu"
+	"github.com/damilolarandolph/casper/ipc"
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/keypad"
+	"github.com/damilolarandolph/casper/mathunit"
+	"github.com/damilolarandolph/casper/memory"
+	"github.com/damilolarandolph/casper/power"
+	"github.com/damilolarandolph/casper/rtc"
+	"github.com/damilolarandolph/casper/scheduler"
+	"github.com/damilolarandolph/casper/spi"
+	"github.com/damilolarandolph/casper/timer"
+	"github.com/damilolarandolph/casper/touchscreen"
+	"github.com/damilolarandolph/casper/vram"
+)
+
+// The Arm9 runs at the master clock rate and the Arm7 at half of it.
+const (
+	arm9ClockMultiple = 1
+	arm7ClockMultiple = 2
+)
+
+// maxSlice is the longest the CPUs run ahead of each other and of the
+// scheduler, in master cycles.
+const maxSlice = 64
+
+// System is a Nintendo DS, made up of both CPUs, their buses and the
+// devices attached to them, all driven by a common scheduler.
+type System struct {
+	Memory    *memory.InternalMemory
+	Scheduler *scheduler.Scheduler
+	IPC       *ipc.IPC
+	Header    *cart.Header
+	Slot      *cart.Slot
+	Keypad    *keypad.Keypad
+	Power     *power.Power
+
+	Arm7        *cpu.Arm7
+	Arm7Bus     *cpu.Arm7Bus
+	Arm7Irq     *irq.Controller
+	Arm7Timers  *timer.Timers
+	Arm7DMA     *dma.Controller
+	SPI         *spi.Bus
+	Firmware    *firmware.Flash
+	Touchscreen *touchscreen.Touchscreen
+	RTC         *rtc.RTC
+
+	Arm9       *cpu.Arm9
+	Arm9Bus    *cpu.Arm9Bus
+	Arm9Irq    *irq.Controller
+	Arm9Timers *timer.Timers
+	Arm9DMA    *dma.Controller
+	MathUnit   *mathunit.MathUnit
+	VRAM       *vram.Controller
+	GPU        *gpu.GPU
+	Display    *display.Display
+
+	arm7BIOSLoaded bool
+}
+
+// NewSystem constructs a system with both CPUs at their reset state.
+func NewSystem() *System {
+	system := &System{
+		Memory:    memory.NewInternalMemory(),
+		Scheduler: scheduler.New(),
+		Arm7:      cpu.NewArm7(arm7ClockMultiple),
+		Arm7Irq:   irq.NewController(),
+		Arm9:      cpu.NewArm9(arm9ClockMultiple),
+		Arm9Irq:   irq.NewController(),
+	}
+
+	system.Arm7Bus = cpu.NewArm7Bus(system.Memory)
+	system.Arm7.SetBus(system.Arm7Bus)
+	system.Arm7Irq.Map(system.Arm7Bus.IO())
+	system.Arm7.SetInterruptLine(system.Arm7Irq)
+	system.Arm7Timers = timer.New(system.Scheduler, system.Arm7Irq)
+	system.Arm7Timers.Map(system.Arm7Bus.IO())
+	system.Arm7DMA = dma.NewArm7(system.Arm7Bus, system.Arm7Irq)
+	system.Arm7DMA.Map(system.Arm7Bus.IO())
+	system.SPI = spi.New(system.Scheduler, system.Arm7Irq)
+	system.SPI.Map(system.Arm7Bus.IO())
+	copy(system.Memory.Firmware, firmware.Generate(firmware.DefaultSettings()))
+	system.Firmware = firmware.NewFlash(system.Memory.Firmware)
+	system.SPI.Attach(spi.Firmware, system.Firmware)
+	system.Touchscreen = touchscreen.New(firmware.DefaultSettings().Calibration)
+	system.SPI.Attach(spi.Touchscreen, system.Touchscreen)
+	system.RTC = rtc.New(system.Scheduler, system.Arm7Irq)
+	system.RTC.Map(system.Arm7Bus.IO())
+
+	system.Arm9Bus = cpu.NewArm9Bus(system.Memory)
+	system.Arm9.SetBus(system.Arm9Bus)
+	system.Arm9Irq.Map(system.Arm9Bus.IO())
+	system.Arm9.SetInterruptLine(system.Arm9Irq)
+	system.Arm9Timers = timer.New(system.Scheduler, system.Arm9Irq)
+	system.Arm9Timers.Map(system.Arm9Bus.IO())
+	system.Arm9DMA = dma.NewArm9(system.Arm9Bus, system.Arm9Irq)
+	system.Arm9DMA.Map(system.Arm9Bus.IO())
+	system.MathUnit = mathunit.New(system.Scheduler)
+	system.MathUnit.Map(system.Arm9Bus.IO())
+
+	system.Power = power.New(system.Arm7)
+	system.Power.MapArm7(system.Arm7Bus.IO())
+	system.Power.MapArm9(system.Arm9Bus.IO())
+	system.SPI.Attach(spi.PowerManagement, system.Power)
+
+	system.VRAM = vram.New(system.Memory.Vram)
+	system.VRAM.MapArm7(system.Arm7Bus.IO())
+	system.VRAM.MapArm9(system.Arm9Bus.IO())
+	system.Arm7Bus.SetVRAM(system.VRAM)
+	system.Arm9Bus.SetVRAM(system.VRAM)
+	system.GPU = gpu.New(system.Memory, system.Power, system.VRAM)
+	system.GPU.Map(system.Arm9Bus.IO())
+	system.Display = display.New(system.Scheduler, system.Arm7Irq, system.Arm9Irq, system.Arm7DMA, system.Arm9DMA, system.GPU)
+	system.Display.MapArm7(system.Arm7Bus.IO())
+	system.Display.MapArm9(system.Arm9Bus.IO())
+
+	system.Keypad = keypad.New(system.Scheduler, system.Arm7Irq, system.Arm9Irq, system.Touchscreen)
+	system.Keypad.MapArm7(system.Arm7Bus.IO())
+	system.Keypad.MapArm9(system.Arm9Bus.IO())
+
+	system.IPC = ipc.New(system.Arm7Irq, system.Arm9Irq)
+	system.IPC.MapArm7(system.Arm7Bus.IO())
+	system.IPC.MapArm9(system.Arm9Bus.IO())
+
+	system.Slot = cart.NewSlot(system.Scheduler, system.Arm7Irq, system.Arm9Irq, system.Arm7DMA, system.Arm9DMA)
+	system.Slot.MapArm7(system.Arm7Bus.IO())
+	system.Slot.MapArm9(system.Arm9Bus.IO())
+
+	return system
+}
+
+// RunFor runs the system for the given number of master cycles. The CPUs
+// run in slices that end at the next scheduled event, so devices fire at
+// the cycle they were scheduled for. While a CPU runs the scheduler follows
+// its time, and an event it schedules before the end of the slice cuts the
+// slice short.
+func (system *System) RunFor(cycles uint64) {
+	target := system.Scheduler.Now() + cycles
+	for system.Scheduler.Now() < target {
+		sliceEnd := system.Scheduler.Now() + maxSlice
+		if sliceEnd > target {
+			sliceEnd = target
+		}
+		deadline := func() uint64 {
+			if next, ok := system.Scheduler.NextEvent(); ok && next < sliceEnd {
+				return next
+			}
+			return sliceEnd
+		}
+		system.Scheduler.SetClock(system.Arm9)
+		system.Arm9.RunUntil(deadline)
+		system.Scheduler.SetClock(system.Arm7)
+		system.Arm7.RunUntil(deadline)
+		system.Scheduler.SetClock(nil)
+		system.Scheduler.Advance(deadline())
+	}
+}
+
+// Err returns the error that stopped one of the CPUs, such as the game
+// calling a BIOS function that isn't emulated.
+func (system *System) Err() error {
+	if err := system.Arm9.Err(); err != nil {
+		return err
+	}
+	return system.Arm7.Err()
+}
+
+// Run runs the system forever.
+func (system *System) Run() {
+	for {
+		system.RunFor(maxSlice)
+	}
+}
@@ -1,11 +1,99 @@
 package main
 
-import "github.com/damilolarandolph/casper/cpu"
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/damilolarandolph/casper"
+)
+
+var (
+	arm7BIOSPath = flag.String("bios7", "", "path to an Arm7 BIOS dump")
+	savePath     = flag.String("save", "", "path to the save file, next to the ROM by default")
+	firmwarePath = flag.String("firmware", "", "path to a firmware dump, one is generated if it doesn't exist")
+)
 
 func main() {
-	cpu := cpu.NewArm7(2)
-	cpu.Tick()
-	cpu.Run()
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: casper [-bios7 biosnds7.bin] <rom.nds>")
+		os.Exit(2)
+	}
+
+	system := casper.NewSystem()
+	if *arm7BIOSPath != "" {
+		bios, err := os.ReadFile(*arm7BIOSPath)
+		if err != nil {
+			fail(err)
+		}
+		if err := system.LoadArm7BIOS(bios); err != nil {
+			fail(err)
+		}
+	}
+
+	if *firmwarePath != "" {
+		if err := system.LoadFirmware(*firmwarePath); err != nil {
+			fail(err)
+		}
+	}
+
+	rom, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	if err := system.LoadROM(rom); err != nil {
+		fail(err)
+	}
+	if *savePath == "" {
+		*savePath = strings.TrimSuffix(flag.Arg(0), filepath.Ext(flag.Arg(0))) + ".sav"
+	}
+	if err := system.LoadSave(*savePath); err != nil {
+		fail(err)
+	}
+	if err := system.DirectBoot(); err != nil {
+		fail(err)
+	}
+
+	// The emulator runs until it is interrupted, the game turns the
+	// console off or it does something that isn't emulated. The save and
+	// firmware are written out before exiting.
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	for !system.Power.PoweredOff() && system.Err() == nil {
+		select {
+		case <-interrupted:
+			exit(system)
+		default:
+			system.RunFor(runSlice)
+		}
+	}
+	if err := system.Err(); err != nil {
+		if flushErr := system.Flush(); flushErr != nil {
+			fmt.Fprintln(os.Stderr, flushErr)
+		}
+		fail(err)
+	}
+	exit(system)
+}
+
+func exit(system *casper.System) {
+	if err := system.Flush(); err != nil {
+		fail(err)
+	}
+	os.Exit(0)
+}
+
+// runSlice is how long the system runs between checks for an interrupt,
+// in master cycles.
+const runSlice = 1 << 16
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
 }
 
 func init() {
@@ -100,7 +100,7 @@ func rri(cpu ArmCPU) (uint32, bool) {
 		carryOut := rotateRightExtend(&operand, cpu.isFlag(carry))
 		return operand, carryOut
 	}
-	carryOut := arthShiftRight(&operand, shiftAmount, cpu.isFlag(carry))
+	carryOut := rotateRight(&operand, shiftAmount, cpu.isFlag(carry))
 	return operand, carryOut
 }
 
@@ -130,12 +130,32 @@ func isNegative(val uint32) bool {
 	return bits.GetBit(val, 31)
 }
 
-func didOverflow(val1 uint32, val2 uint32) bool {
-	return val2 > (0xffffffff - val1)
+// addWithCarry adds two operands and a carry in the way the ALU does,
+// returning the result along with the carry and signed overflow flags.
+// Subtraction is performed by adding the inverted second operand.
+func addWithCarry(lhs uint32, rhs uint32, carryIn bool) (uint32, bool, bool) {
+	var carryBit uint64
+	if carryIn {
+		carryBit = 1
+	}
+	wide := uint64(lhs) + uint64(rhs) + carryBit
+	result := uint32(wide)
+	carryOut := (wide >> 32) != 0
+	overflowOut := isNegative((lhs ^ result) & (rhs ^ result))
+	return result, carryOut, overflowOut
 }
 
-func didUnderflow(val1 uint32, val2 uint32) bool {
-	return val2 > val1
+func setArithmeticFlags(cpu ArmCPU, result uint32, carryOut bool, overflowOut bool) {
+	cpu.setFlag(negative, isNegative(result))
+	cpu.setFlag(zero, result == 0)
+	cpu.setFlag(carry, carryOut)
+	cpu.setFlag(overflow, overflowOut)
+}
+
+func setLogicalFlags(cpu ArmCPU, result uint32, carryOut bool) {
+	cpu.setFlag(negative, isNegative(result))
+	cpu.setFlag(zero, result == 0)
+	cpu.setFlag(carry, carryOut)
 }
 
 func didSignOverflow(val1 uint32, val2 uint32) bool {
@@ -172,7 +192,7 @@ func add(addressingMode arthAddrMode, cpu ArmCPU) {
 	firstOpReg, destinationReg, setConditions := parseDataInstr(cpu.currentInstruction())
 	secondOp, _ := addressingMode(cpu)
 	firstOp := cpu.readReg(firstOpReg)
-	result := firstOp + secondOp
+	result, carryOut, overflowOut := addWithCarry(firstOp, secondOp, false)
 	cpu.setReg(destinationReg, result)
 
 	if !setConditions {
@@ -184,10 +204,7 @@ func add(addressingMode arthAddrMode, cpu ArmCPU) {
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(carry, didOverflow(firstOp, secondOp))
-	cpu.setFlag(overflow, didSignOverflow(firstOp, secondOp))
-	cpu.setFlag(zero, result == 0)
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
 }
 
 func addC(addressingMode arthAddrMode, cpu ArmCPU) {
@@ -197,10 +214,7 @@ func addC(addressingMode arthAddrMode, cpu ArmCPU) {
 	firstOpReg, destinationReg, setConditions := parseDataInstr(cpu.currentInstruction())
 	secondOp, _ := addressingMode(cpu)
 	firstOp := cpu.readReg(firstOpReg)
-	if cpu.isFlag(carry) {
-		secondOp++
-	}
-	result := firstOp + secondOp
+	result, carryOut, overflowOut := addWithCarry(firstOp, secondOp, cpu.isFlag(carry))
 	cpu.setReg(destinationReg, result)
 
 	if !setConditions {
@@ -212,10 +226,7 @@ func addC(addressingMode arthAddrMode, cpu ArmCPU) {
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(carry, didOverflow(firstOp, secondOp))
-	cpu.setFlag(overflow, didSignOverflow(firstOp, secondOp))
-	cpu.setFlag(zero, result == 0)
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
 }
 
 // AND Instructions
@@ -236,9 +247,7 @@ func and(addressingMode arthAddrMode, cpu ArmCPU) {
 		cpu.setCpsr(cpu.readSpsr())
 		return
 	}
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, carryOut)
+	setLogicalFlags(cpu, result, carryOut)
 }
 
 // BIC Instruction
@@ -262,10 +271,7 @@ func bic(addressingMode arthAddrMode, cpu ArmCPU) {
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, carryOut)
-
+	setLogicalFlags(cpu, result, carryOut)
 }
 
 // Compare Negative (CMN)
@@ -277,13 +283,9 @@ func cmn(addressingMode arthAddrMode, cpu ArmCPU) {
 	firstOpReg, _, _ := parseDataInstr(cpu.currentInstruction())
 	firstOp := cpu.readReg(firstOpReg)
 	secondOp, _ := addressingMode(cpu)
-	result := firstOp + secondOp
-
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, didOverflow(firstOp, secondOp))
-	cpu.setFlag(overflow, didSignOverflow(firstOp, secondOp))
+	result, carryOut, overflowOut := addWithCarry(firstOp, secondOp, false)
 
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
 }
 
 // Compare (CMP)
@@ -295,12 +297,9 @@ func cmp(addressingMode arthAddrMode, cpu ArmCPU) {
 	firstOpReg, _, _ := parseDataInstr(cpu.currentInstruction())
 	firstOp := cpu.readReg(firstOpReg)
 	secondOp, _ := addressingMode(cpu)
-	result := firstOp - secondOp
+	result, carryOut, overflowOut := addWithCarry(firstOp, ^secondOp, true)
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, !didUnderflow(firstOp, secondOp))
-	cpu.setFlag(overflow, didSignOverflow(firstOp, ^secondOp))
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
 }
 
 // Exclusive Or (EOR)
@@ -323,10 +322,7 @@ func eor(addressingMode arthAddrMode, cpu ArmCPU) {
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, carryOut)
-
+	setLogicalFlags(cpu, result, carryOut)
 }
 
 // Move (MOV)
@@ -348,10 +344,7 @@ func mov(addressingMode arthAddrMode, cpu ArmCPU) {
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, carryOut)
-
+	setLogicalFlags(cpu, result, carryOut)
 }
 
 // Move Negative (MVN)
@@ -373,10 +366,7 @@ func mvn(addressingMode arthAddrMode, cpu ArmCPU) {
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, carryOut)
-
+	setLogicalFlags(cpu, result, carryOut)
 }
 
 // Logical OR (ORR)
@@ -399,10 +389,7 @@ func orr(addressingMode arthAddrMode, cpu ArmCPU) {
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, carryOut)
-
+	setLogicalFlags(cpu, result, carryOut)
 }
 
 // Reverse Subtract (RSB)
@@ -410,26 +397,22 @@ func rsb(addressingMode arthAddrMode, cpu ArmCPU) {
 	if !testCondition(cpu) {
 		return
 	}
-	firstOpReg, destReg, setConditions := parseDataInstr(cpu.currentInstruction())
-	firstOp := cpu.readReg(firstOpReg)
+	firstOpReg, destinationReg, setConditions := parseDataInstr(cpu.currentInstruction())
 	secondOp, _ := addressingMode(cpu)
-	result := secondOp - firstOp
-	cpu.setReg(destReg, result)
+	firstOp := cpu.readReg(firstOpReg)
+	result, carryOut, overflowOut := addWithCarry(secondOp, ^firstOp, true)
+	cpu.setReg(destinationReg, result)
 
 	if !setConditions {
 		return
 	}
 
-	if destReg == rPc {
+	if destinationReg == rPc {
 		cpu.setCpsr(cpu.readSpsr())
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, !didUnderflow(secondOp, firstOp))
-	cpu.setFlag(overflow, didOverflow(secondOp, ^firstOp))
-
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
 }
 
 // Reverse Subtract with Carry (RSC)
@@ -437,29 +420,22 @@ func rsc(addressingMode arthAddrMode, cpu ArmCPU) {
 	if !testCondition(cpu) {
 		return
 	}
-	firstOpReg, destReg, setConditions := parseDataInstr(cpu.currentInstruction())
-	firstOp := cpu.readReg(firstOpReg)
-	if !cpu.isFlag(carry) {
-		firstOp++
-	}
+	firstOpReg, destinationReg, setConditions := parseDataInstr(cpu.currentInstruction())
 	secondOp, _ := addressingMode(cpu)
-	result := secondOp - firstOp
-	cpu.setReg(destReg, result)
+	firstOp := cpu.readReg(firstOpReg)
+	result, carryOut, overflowOut := addWithCarry(secondOp, ^firstOp, cpu.isFlag(carry))
+	cpu.setReg(destinationReg, result)
 
 	if !setConditions {
 		return
 	}
 
-	if destReg == rPc {
+	if destinationReg == rPc {
 		cpu.setCpsr(cpu.readSpsr())
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, !didUnderflow(secondOp, firstOp))
-	cpu.setFlag(overflow, didOverflow(secondOp, ^firstOp))
-
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
 }
 
 // Subtract with Carry (SBC)
@@ -467,29 +443,22 @@ func sbc(addressingMode arthAddrMode, cpu ArmCPU) {
 	if !testCondition(cpu) {
 		return
 	}
-	firstOpReg, destReg, setConditions := parseDataInstr(cpu.currentInstruction())
-	firstOp := cpu.readReg(firstOpReg)
+	firstOpReg, destinationReg, setConditions := parseDataInstr(cpu.currentInstruction())
 	secondOp, _ := addressingMode(cpu)
-	if !cpu.isFlag(carry) {
-		secondOp++
-	}
-	result := firstOp - secondOp
-	cpu.setReg(destReg, result)
+	firstOp := cpu.readReg(firstOpReg)
+	result, carryOut, overflowOut := addWithCarry(firstOp, ^secondOp, cpu.isFlag(carry))
+	cpu.setReg(destinationReg, result)
 
 	if !setConditions {
 		return
 	}
 
-	if destReg == rPc {
+	if destinationReg == rPc {
 		cpu.setCpsr(cpu.readSpsr())
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, !didUnderflow(firstOp, secondOp))
-	cpu.setFlag(overflow, didOverflow(firstOp, ^secondOp))
-
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
 }
 
 // Subtract (SUB)
@@ -497,26 +466,22 @@ func sub(addressingMode arthAddrMode, cpu ArmCPU) {
 	if !testCondition(cpu) {
 		return
 	}
-	firstOpReg, destReg, setConditions := parseDataInstr(cpu.currentInstruction())
-	firstOp := cpu.readReg(firstOpReg)
+	firstOpReg, destinationReg, setConditions := parseDataInstr(cpu.currentInstruction())
 	secondOp, _ := addressingMode(cpu)
-	result := firstOp - secondOp
-	cpu.setReg(destReg, result)
+	firstOp := cpu.readReg(firstOpReg)
+	result, carryOut, overflowOut := addWithCarry(firstOp, ^secondOp, true)
+	cpu.setReg(destinationReg, result)
 
 	if !setConditions {
 		return
 	}
 
-	if destReg == rPc {
+	if destinationReg == rPc {
 		cpu.setCpsr(cpu.readSpsr())
 		return
 	}
 
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, !didUnderflow(firstOp, secondOp))
-	cpu.setFlag(overflow, didOverflow(firstOp, ^secondOp))
-
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
 }
 
 // Test Equivalence (TEQ)
@@ -524,16 +489,12 @@ func teq(addressingMode arthAddrMode, cpu ArmCPU) {
 	if !testCondition(cpu) {
 		return
 	}
-	firstOpReg, destReg, _ := parseDataInstr(cpu.currentInstruction())
+	firstOpReg, _, _ := parseDataInstr(cpu.currentInstruction())
 	firstOp := cpu.readReg(firstOpReg)
 	secondOp, carryOut := addressingMode(cpu)
 	result := firstOp ^ secondOp
-	cpu.setReg(destReg, result)
-
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, carryOut)
 
+	setLogicalFlags(cpu, result, carryOut)
 }
 
 // Test (TST)
@@ -542,16 +503,12 @@ func tst(addressingMode arthAddrMode, cpu ArmCPU) {
 		return
 	}
 
-	firstOpReg, destReg, _ := parseDataInstr(cpu.currentInstruction())
+	firstOpReg, _, _ := parseDataInstr(cpu.currentInstruction())
 	firstOp := cpu.readReg(firstOpReg)
 	secondOp, carryOut := addressingMode(cpu)
 	result := firstOp & secondOp
-	cpu.setReg(destReg, result)
-
-	cpu.setFlag(negative, isNegative(result))
-	cpu.setFlag(zero, result == 0)
-	cpu.setFlag(carry, carryOut)
 
+	setLogicalFlags(cpu, result, carryOut)
 }
 
 func logicalShiftLeft(value *uint32, amount int, currentCarry bool) bool {
@@ -631,7 +588,7 @@ func rotateRight(value *uint32, amount int, currentCarry bool) bool {
 
 	carryOut := bits.GetBit(*value, amount-1)
 	dup := *value
-	dup <<= 31 - amount
+	dup <<= 32 - amount
 	*value >>= amount
 	*value |= dup
 	return carryOut
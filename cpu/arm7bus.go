@@ -0,0 +1,122 @@
+package cpu
+
+import (
+	"github.com/damilolarandolph/casper/memory"
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+// Arm7Bus implements an Arm7 system bus
+type Arm7Bus struct {
+	memoryBus
+}
+
+// Wait states for each region of the Arm7 memory map in the order
+// non-sequencial word, sequencial word, non-sequencial halfword and
+// sequencial halfword. The rows are main RAM, BIOS/WRAM/IO, VRAM,
+// GBA ROM and GBA RAM.
+var arm7Timings = [][]int{
+	{9, 2, 8, 1},
+	{1, 1, 1, 1},
+	{2, 2, 1, 1},
+	{16, 12, 10, 6},
+	{40, 40, 10, 10},
+}
+
+// NewArm7Bus constructs a new Arm7 system bus backed by the given memory.
+func NewArm7Bus(memory *memory.InternalMemory) *Arm7Bus {
+	bus := &Arm7Bus{
+		memoryBus: memoryBus{
+			memory:      memory,
+			io:          mmio.NewRegistry(),
+			dataTimings: arm7Timings,
+			codeTimings: arm7Timings,
+		},
+	}
+	bus.decoder = bus
+	// WRAMSTAT
+	bus.io.MapRegister(0x04000241, 1, &mmio.Register{
+		ReadMask: 0x3,
+		OnRead: func() uint32 {
+			return uint32(memory.WramControl())
+		},
+	})
+	return bus
+}
+
+func (bus *Arm7Bus) getMemRegionParams(address uint32) (memRegion []uint8, translatedAddr uint32) {
+
+	//ARM7 BIOS
+	if address >= 0x0 && address < 0x02000000 {
+		memRegion = bus.memory.BIOS
+		translatedAddr = address
+		bus.wait(bus.getTiming(1))
+		return
+	}
+	// MAIN RAM
+	if address < 0x03000000 {
+		memRegion = bus.memory.MainRAM
+		translatedAddr = address - 0x02000000
+		bus.wait(bus.getTiming(0))
+		return
+	}
+	// Shared WRAM
+	if address < 0x03800000 {
+		memRegion = bus.memory.Arm7SharedWram()
+		translatedAddr = address - 0x03000000
+		bus.wait(bus.getTiming(1))
+		return
+	}
+	// WRAM
+	if address < 0x04000000 {
+		memRegion = bus.memory.Arm7Wram
+		translatedAddr = address - 0x03800000
+		bus.wait(bus.getTiming(1))
+		return
+	}
+	// I/O
+	if address < 0x04800000 {
+		memRegion = nil
+		translatedAddr = address - 0x04000000
+		bus.wait(bus.getTiming(1))
+		return
+	}
+	// WIFI RAM
+	if address < 0x04808000 {
+		memRegion = bus.memory.WifiRAM
+		translatedAddr = address - 0x04800000
+		bus.wait(bus.getTiming(1))
+		return
+	}
+
+	// WIFI I/O
+	if address < 0x06000000 {
+		memRegion = nil
+		translatedAddr = address - 0x04808000
+		bus.wait(bus.getTiming(1))
+		return
+	}
+
+	//VRAM
+	if address < 0x08000000 {
+		if bus.vram != nil {
+			memRegion, translatedAddr = bus.vramRegion(bus.vram.Arm7Page(address))
+		}
+		bus.wait(bus.getTiming(2))
+		return
+	}
+
+	//GBA ROM, the slot is empty.
+	if address < 0x0A000000 {
+		memRegion = nil
+		translatedAddr = address - 0x08000000
+		bus.wait(bus.getTiming(3))
+		return
+	}
+
+	//GBA RAM
+	memRegion = nil
+	translatedAddr = address - 0x0A000000
+	bus.wait(bus.getTiming(4))
+	return
+
+}
@@ -0,0 +1,41 @@
+package cpu
+
+// Arm9 represents an ARM946E-S CPU, which implements the ARMv5TE
+// architecture and runs the DS game logic.
+type Arm9 struct {
+	armCore
+	cp15 *cp15
+}
+
+// NewArm9 constructs a new Arm9 CPU. clockMultiple is the number of
+// master clock cycles each CPU cycle takes.
+func NewArm9(clockMultiple int) *Arm9 {
+	cpu := &Arm9{
+		armCore: newArmCore(V5, clockMultiple),
+	}
+	cpu.biosCalls = arm9BIOSCalls
+	cpu.cp15 = newCP15(&cpu.armCore)
+	cpu.SetCoprocessor(15, cpu.cp15)
+	cpu.Reset()
+	return cpu
+}
+
+// SetBus connects the CPU to the bus it fetches code and data from.
+// The tightly coupled memories are remapped on the bus by CP15 if it
+// supports them.
+func (cpu *Arm9) SetBus(bus SystemBus) {
+	cpu.armCore.SetBus(bus)
+	if tcm, ok := bus.(TCMController); ok {
+		cpu.cp15.setTCMController(tcm)
+	}
+}
+
+// DirectBoot sets up CP15 and the registers the way the BIOS leaves them
+// when it starts a game at entry. The DTCM is mapped at 0x03000000 and
+// the ITCM covers the first 32MB.
+func (cpu *Arm9) DirectBoot(entry uint32, systemStack uint32, irqStack uint32, supervisorStack uint32) {
+	cpu.cp15.Write(0, 9, 1, 0, 0x0300000a)
+	cpu.cp15.Write(0, 9, 1, 1, 0x00000020)
+	cpu.cp15.Write(0, 1, 0, 0, 0x0005707d)
+	cpu.armCore.DirectBoot(entry, systemStack, irqStack, supervisorStack)
+}
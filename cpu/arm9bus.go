@@ -0,0 +1,182 @@
+package cpu
+
+import (
+	"github.com/damilolarandolph/casper/memory"
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+// Arm9Bus implements the Arm9 system bus, including the tightly coupled
+// memories that are private to the Arm9.
+type Arm9Bus struct {
+	memoryBus
+	itcmSize     uint32
+	itcmEnabled  bool
+	itcmLoadMode bool
+	dtcmBase     uint32
+	dtcmSize     uint32
+	dtcmEnabled  bool
+	dtcmLoadMode bool
+}
+
+// Wait states for each region of the Arm9 memory map in Arm9 cycles,
+// in the order non-sequencial word, sequencial word, non-sequencial
+// halfword and sequencial halfword. The rows are main RAM,
+// BIOS/WRAM/IO, palette/VRAM/OAM, GBA ROM, GBA RAM and the TCMs.
+var arm9Timings = [][]int{
+	{20, 4, 18, 2},
+	{2, 2, 2, 2},
+	{4, 4, 2, 2},
+	{36, 24, 20, 12},
+	{80, 80, 20, 20},
+	{1, 1, 1, 1},
+}
+
+const (
+	paletteSize  = 0x800
+	arm9BIOSBase = 0xffff0000
+)
+
+// NewArm9Bus constructs a new Arm9 system bus backed by the given memory.
+func NewArm9Bus(memory *memory.InternalMemory) *Arm9Bus {
+	bus := &Arm9Bus{
+		memoryBus: memoryBus{
+			memory:      memory,
+			io:          mmio.NewRegistry(),
+			dataTimings: arm9Timings,
+			codeTimings: arm9Timings,
+		},
+	}
+	bus.decoder = bus
+	// WRAMCNT
+	bus.io.MapRegister(0x04000247, 1, &mmio.Register{
+		ReadMask:  0x3,
+		WriteMask: 0x3,
+		OnRead: func() uint32 {
+			return uint32(memory.WramControl())
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			memory.SetWramControl(uint8(value))
+		},
+	})
+	return bus
+}
+
+// SetITCM maps the instruction TCM from address zero with the given
+// virtual size. The physical memory is mirrored across it.
+func (bus *Arm9Bus) SetITCM(size uint32, enabled bool, loadMode bool) {
+	bus.itcmSize = size
+	bus.itcmEnabled = enabled
+	bus.itcmLoadMode = loadMode
+}
+
+// SetDTCM maps the data TCM at base with the given virtual size. The
+// physical memory is mirrored across it.
+func (bus *Arm9Bus) SetDTCM(base uint32, size uint32, enabled bool, loadMode bool) {
+	bus.dtcmBase = base
+	bus.dtcmSize = size
+	bus.dtcmEnabled = enabled
+	bus.dtcmLoadMode = loadMode
+}
+
+func (bus *Arm9Bus) inITCM(address uint32) bool {
+	if !bus.itcmEnabled || (bus.itcmLoadMode && !bus.isWrite) {
+		return false
+	}
+	return address < bus.itcmSize
+}
+
+// The DTCM can't be used for code fetches.
+func (bus *Arm9Bus) inDTCM(address uint32) bool {
+	if !bus.dtcmEnabled || bus.isOpcode || (bus.dtcmLoadMode && !bus.isWrite) {
+		return false
+	}
+	return address >= bus.dtcmBase && address-bus.dtcmBase < bus.dtcmSize
+}
+
+func (bus *Arm9Bus) getMemRegionParams(address uint32) (memRegion []uint8, translatedAddr uint32) {
+
+	// ITCM
+	if bus.inITCM(address) {
+		memRegion = bus.memory.ITCM
+		translatedAddr = address
+		bus.wait(bus.getTiming(5))
+		return
+	}
+
+	// DTCM
+	if bus.inDTCM(address) {
+		memRegion = bus.memory.DTCM
+		translatedAddr = address - bus.dtcmBase
+		bus.wait(bus.getTiming(5))
+		return
+	}
+
+	// The 2D engine memories ignore byte writes from the Arm9.
+	isByteWrite := bus.isWrite && bus.accessType == byteFetch
+
+	switch address >> 24 {
+	// MAIN RAM
+	case 0x02:
+		memRegion = bus.memory.MainRAM
+		translatedAddr = address - 0x02000000
+		bus.wait(bus.getTiming(0))
+
+	// Shared WRAM, open bus when the Arm7 owns all of it.
+	case 0x03:
+		memRegion = bus.memory.Arm9SharedWram()
+		translatedAddr = address - 0x03000000
+		bus.wait(bus.getTiming(1))
+
+	// I/O
+	case 0x04:
+		translatedAddr = address - 0x04000000
+		bus.wait(bus.getTiming(1))
+
+	// Palettes
+	case 0x05:
+		if !isByteWrite {
+			memRegion = bus.memory.OamPal[:paletteSize]
+		}
+		translatedAddr = address - 0x05000000
+		bus.wait(bus.getTiming(2))
+
+	// VRAM
+	case 0x06:
+		if !isByteWrite && bus.vram != nil {
+			memRegion, translatedAddr = bus.vramRegion(bus.vram.Arm9Page(address))
+		}
+		bus.wait(bus.getTiming(2))
+
+	// OAM
+	case 0x07:
+		if !isByteWrite {
+			memRegion = bus.memory.OamPal[paletteSize:]
+		}
+		translatedAddr = address - 0x07000000
+		bus.wait(bus.getTiming(2))
+
+	// GBA ROM, the slot is empty.
+	case 0x08, 0x09:
+		translatedAddr = address - 0x08000000
+		bus.wait(bus.getTiming(3))
+
+	// GBA RAM
+	case 0x0a:
+		translatedAddr = address - 0x0a000000
+		bus.wait(bus.getTiming(4))
+
+	// ARM9 BIOS
+	case 0xff:
+		if address >= arm9BIOSBase {
+			memRegion = bus.memory.Arm9BIOS
+		}
+		translatedAddr = address - arm9BIOSBase
+		bus.wait(bus.getTiming(1))
+
+	default:
+		translatedAddr = address
+		bus.wait(bus.getTiming(1))
+	}
+
+	return
+}
@@ -0,0 +1,499 @@
+package cpu
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/damilolarandolph/casper/bits"
+	"github.com/damilolarandolph/casper/cart"
+)
+
+// biosCall carries out a BIOS function in place of the BIOS image.
+type biosCall func(cpu *armCore)
+
+// The BIOS functions of each CPU by SWI number. The ones that read their
+// data through callbacks read it straight from memory instead, which is
+// all those callbacks do for data already in memory.
+var (
+	arm7BIOSCalls = map[uint32]biosCall{
+		0x03: waitByLoop,
+		0x04: intrWait,
+		0x05: vblankIntrWait,
+		0x06: halt,
+		// Sleep is a halt with the clocks stopped, which makes no
+		// difference here.
+		0x07: halt,
+		0x08: soundBias,
+		0x09: div,
+		0x0b: cpuSet,
+		0x0c: cpuFastSet,
+		0x0d: sqrt,
+		0x0e: getCRC16,
+		0x0f: isDebugger,
+		0x10: bitUnPack,
+		0x11: lz77Write8,
+		0x12: lz77Write16,
+		0x13: huffman,
+		0x14: runLengthWrite8,
+		0x15: runLengthWrite16,
+		0x1a: getSineTable,
+		0x1b: getPitchTable,
+		0x1c: getVolumeTable,
+		0x1f: customHalt,
+	}
+	arm9BIOSCalls = map[uint32]biosCall{
+		0x03: waitByLoop,
+		0x04: intrWait,
+		0x05: vblankIntrWait,
+		0x06: halt,
+		0x09: div,
+		0x0b: cpuSet,
+		0x0c: cpuFastSet,
+		0x0d: sqrt,
+		0x0e: getCRC16,
+		0x0f: isDebugger,
+		0x10: bitUnPack,
+		0x11: lz77Write8,
+		0x12: lz77Write16,
+		0x13: huffman,
+		0x14: runLengthWrite8,
+		0x15: runLengthWrite16,
+		0x16: diff8Write8,
+		0x18: diff16,
+	}
+)
+
+// Addresses the BIOS functions use.
+const (
+	imeAddress       = 0x04000208
+	haltControl      = 0x04000301
+	soundBiasAddress = 0x04000504
+	// The Arm7 interrupt check flags are at the end of its WRAM, the Arm9
+	// ones at the end of the DTCM.
+	arm7IRQCheck = 0x0380fff8
+	arm9IRQCheck = 0x3ff8
+)
+
+// UnimplementedSWIError is the error a CPU stops with when a game calls a
+// BIOS function that isn't emulated.
+type UnimplementedSWIError struct {
+	Number  uint32
+	Address uint32
+}
+
+func (err *UnimplementedSWIError) Error() string {
+	return fmt.Sprintf("cpu: BIOS function %#02x called at %#08x isn't emulated", err.Number, err.Address)
+}
+
+// SetBIOSEmulation makes the CPU carry out the BIOS functions games call
+// with SWI itself instead of taking the exception, for when there is no
+// BIOS image to run them.
+func (cpu *armCore) SetBIOSEmulation(enabled bool) {
+	cpu.emulateBIOS = enabled
+}
+
+// Err returns the error that stopped the CPU, if any.
+func (cpu *armCore) Err() error {
+	return cpu.err
+}
+
+// softwareInterrupt calls the BIOS function the executing SWI numbers,
+// through the exception or in place of the BIOS. The number is in bits 16
+// to 23 of an ARM SWI and in the low byte of a Thumb one.
+func (cpu *armCore) softwareInterrupt() {
+	if !cpu.emulateBIOS {
+		cpu.raiseException(SwiEx)
+		return
+	}
+	number := bits.GetBits(cpu.instruction, 23, 16)
+	if cpu.isFlag(thumbMode) {
+		number = cpu.instruction & 0xff
+	}
+	call, ok := cpu.biosCalls[number]
+	if !ok {
+		cpu.err = &UnimplementedSWIError{Number: number, Address: cpu.instructionAddr}
+		return
+	}
+	call(cpu)
+}
+
+// waitByLoop spends r0 iterations of a four cycle loop.
+func waitByLoop(cpu *armCore) {
+	count := int32(cpu.readReg(r0))
+	if count < 1 {
+		count = 1
+	}
+	cpu.timestamp += uint64(count) * 4 * uint64(cpu.clockMultiple)
+	cpu.setReg(r0, 0)
+}
+
+func intrWait(cpu *armCore) {
+	cpu.waitForInterrupts(cpu.readReg(r0) != 0, cpu.readReg(r1))
+}
+
+func vblankIntrWait(cpu *armCore) {
+	cpu.setReg(r0, 1)
+	cpu.setReg(r1, 1)
+	cpu.waitForInterrupts(true, 1)
+}
+
+// waitForInterrupts halts until one of the interrupts in mask is flagged
+// in the interrupt check flags, which the game's handler sets, and clears
+// it. Old flags are discarded first when discard is set. Each time the CPU
+// wakes the SWI runs again, so the game's handler runs in between.
+func (cpu *armCore) waitForInterrupts(discard bool, mask uint32) {
+	cpu.bus.WriteData32(imeAddress, 1)
+	address := cpu.irqCheckAddress()
+	flags := cpu.bus.ReadData32(address)
+	if discard && !cpu.waitingForInterrupts {
+		flags &^= mask
+		cpu.bus.WriteData32(address, flags)
+	} else if flags&mask != 0 {
+		cpu.bus.WriteData32(address, flags&^mask)
+		cpu.waitingForInterrupts = false
+		return
+	}
+	cpu.waitingForInterrupts = true
+	cpu.halted = true
+	cpu.setPc(cpu.instructionAddr)
+}
+
+// irqCheckAddress returns the address of the interrupt check flags, which
+// follow the DTCM on a CPU with one.
+func (cpu *armCore) irqCheckAddress() uint32 {
+	if cp15 := cpu.coprocessor(systemControl); cp15 != nil {
+		return cp15.Read(0, 9, 1, 0)&^0xfff + arm9IRQCheck
+	}
+	return arm7IRQCheck
+}
+
+func halt(cpu *armCore) {
+	cpu.Halt()
+}
+
+// customHalt writes r2 to HALTCNT.
+func customHalt(cpu *armCore) {
+	cpu.bus.WriteData8(haltControl, cpu.readReg(r2)&0xff)
+}
+
+// soundBias sets the sound bias level in one go rather than moving it a
+// step at a time, to 0x200 if r0 is set and 0 otherwise.
+func soundBias(cpu *armCore) {
+	var level uint32
+	if cpu.readReg(r0) != 0 {
+		level = 0x200
+	}
+	cpu.bus.WriteData16(soundBiasAddress, level)
+}
+
+// div divides r0 by r1 as signed numbers, leaving the quotient in r0, the
+// remainder in r1 and the absolute quotient in r3. The BIOS hangs on a
+// division by zero, the result is that of the math unit instead.
+func div(cpu *armCore) {
+	numerator := int32(cpu.readReg(r0))
+	denominator := int32(cpu.readReg(r1))
+	quotient, remainder := int32(-1), numerator
+	if denominator != 0 {
+		quotient, remainder = numerator/denominator, numerator%denominator
+	} else if numerator < 0 {
+		quotient = 1
+	}
+	absolute := quotient
+	if absolute < 0 {
+		absolute = -absolute
+	}
+	cpu.setReg(r0, uint32(quotient))
+	cpu.setReg(r1, uint32(remainder))
+	cpu.setReg(r3, uint32(absolute))
+}
+
+// sqrt leaves the integer square root of r0 in r0.
+func sqrt(cpu *armCore) {
+	value := uint64(cpu.readReg(r0))
+	root := uint64(math.Sqrt(float64(value)))
+	for root*root > value {
+		root--
+	}
+	for (root+1)*(root+1) <= value {
+		root++
+	}
+	cpu.setReg(r0, uint32(root))
+}
+
+// cpuSet copies or, with bit 24 of r2 set, fills r2 bits 0 to 20 units
+// from r0 to r1. The units are words with bit 26 set and halfwords
+// otherwise.
+func cpuSet(cpu *armCore) {
+	source, dest, control := cpu.readReg(r0), cpu.readReg(r1), cpu.readReg(r2)
+	fill := control&(1<<24) != 0
+	if control&(1<<26) != 0 {
+		cpu.copyWords(source&^3, dest&^3, control&0x1fffff, fill)
+		return
+	}
+	source &^= 1
+	dest &^= 1
+	for count := control & 0x1fffff; count > 0; count-- {
+		cpu.bus.WriteData16(dest, cpu.bus.ReadData16(source))
+		dest += 2
+		if !fill {
+			source += 2
+		}
+	}
+}
+
+// cpuFastSet is cpuSet for words only, with the count rounded up to a
+// multiple of eight.
+func cpuFastSet(cpu *armCore) {
+	control := cpu.readReg(r2)
+	count := (control&0x1fffff + 7) &^ 7
+	cpu.copyWords(cpu.readReg(r0)&^3, cpu.readReg(r1)&^3, count, control&(1<<24) != 0)
+}
+
+func (cpu *armCore) copyWords(source uint32, dest uint32, count uint32, fill bool) {
+	for ; count > 0; count-- {
+		cpu.bus.WriteData32(dest, cpu.bus.ReadData32(source))
+		dest += 4
+		if !fill {
+			source += 4
+		}
+	}
+}
+
+// getCRC16 leaves the CRC-16 of the r2 bytes at r1 in r0, starting from
+// the CRC in r0.
+func getCRC16(cpu *armCore) {
+	address, length := cpu.readReg(r1), cpu.readReg(r2)
+	data := make([]byte, length)
+	for offset := range data {
+		data[offset] = uint8(cpu.bus.ReadData8(address + uint32(offset)))
+	}
+	cpu.setReg(r0, uint32(cart.CRC16(uint16(cpu.readReg(r0)), data)))
+}
+
+func isDebugger(cpu *armCore) {
+	cpu.setReg(r0, 0)
+}
+
+// bitUnPack widens the units of the data at r0 to the size given by the
+// info at r2 and stores them at r1, adding an offset to each unit that is
+// not zero or, with bit 31 of the offset set, to every unit.
+func bitUnPack(cpu *armCore) {
+	source, dest, info := cpu.readReg(r0), cpu.readReg(r1), cpu.readReg(r2)
+	length := cpu.bus.ReadData16(info)
+	sourceWidth := cpu.bus.ReadData8(info + 2)
+	destWidth := cpu.bus.ReadData8(info + 3)
+	offset := cpu.bus.ReadData32(info + 4)
+	zeroes := offset&(1<<31) != 0
+	offset &^= 1 << 31
+	if sourceWidth == 0 || 8%sourceWidth != 0 || destWidth == 0 || 32%destWidth != 0 {
+		return
+	}
+
+	var out, outBits uint32
+	for index := uint32(0); index < length; index++ {
+		value := cpu.bus.ReadData8(source + index)
+		for bit := uint32(0); bit < 8; bit += sourceWidth {
+			unit := value >> bit & (1<<sourceWidth - 1)
+			if unit != 0 || zeroes {
+				unit += offset
+			}
+			out |= unit << outBits
+			outBits += destWidth
+			if outBits == 32 {
+				cpu.bus.WriteData32(dest, out)
+				dest += 4
+				out, outBits = 0, 0
+			}
+		}
+	}
+}
+
+func lz77Write8(cpu *armCore) {
+	cpu.store(cpu.readReg(r1), cpu.lz77(cpu.readReg(r0)), 8)
+}
+
+func lz77Write16(cpu *armCore) {
+	cpu.store(cpu.readReg(r1), cpu.lz77(cpu.readReg(r0)), 16)
+}
+
+func runLengthWrite8(cpu *armCore) {
+	cpu.store(cpu.readReg(r1), cpu.runLength(cpu.readReg(r0)), 8)
+}
+
+func runLengthWrite16(cpu *armCore) {
+	cpu.store(cpu.readReg(r1), cpu.runLength(cpu.readReg(r0)), 16)
+}
+
+// lz77 decompresses the LZ77 data at source. Each flag byte says which of
+// the eight blocks after it, from the top bit, are copies of earlier data
+// instead of single bytes.
+func (cpu *armCore) lz77(source uint32) []byte {
+	size := int(cpu.bus.ReadData32(source) >> 8)
+	source += 4
+	data := make([]byte, 0, size)
+	next := func() uint8 {
+		value := uint8(cpu.bus.ReadData8(source))
+		source++
+		return value
+	}
+	for len(data) < size {
+		flags := next()
+		for block := 0; block < 8 && len(data) < size; block++ {
+			if flags&(0x80>>block) == 0 {
+				data = append(data, next())
+				continue
+			}
+			first, second := next(), next()
+			length := int(first>>4) + 3
+			distance := int(first&0xf)<<8 | int(second) + 1
+			for ; length > 0 && len(data) < size; length-- {
+				var value uint8
+				if distance <= len(data) {
+					value = data[len(data)-distance]
+				}
+				data = append(data, value)
+			}
+		}
+	}
+	return data
+}
+
+// runLength decompresses the run length encoded data at source, made of
+// runs of one repeated byte and of bytes stored as they are.
+func (cpu *armCore) runLength(source uint32) []byte {
+	size := int(cpu.bus.ReadData32(source) >> 8)
+	source += 4
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		flag := uint8(cpu.bus.ReadData8(source))
+		source++
+		if flag&0x80 != 0 {
+			value := uint8(cpu.bus.ReadData8(source))
+			source++
+			for length := int(flag&0x7f) + 3; length > 0 && len(data) < size; length-- {
+				data = append(data, value)
+			}
+			continue
+		}
+		for length := int(flag&0x7f) + 1; length > 0 && len(data) < size; length-- {
+			data = append(data, uint8(cpu.bus.ReadData8(source)))
+			source++
+		}
+	}
+	return data
+}
+
+// huffman decompresses the Huffman coded data at r0 to r1 a word at a
+// time. The tree follows the header, each node giving the offset of its
+// pair of children and whether they are data. The bitstream after it is
+// read a word at a time from the top bit.
+func huffman(cpu *armCore) {
+	source, dest := cpu.readReg(r0), cpu.readReg(r1)
+	header := cpu.bus.ReadData32(source)
+	unitBits := header & 0xf
+	size := header >> 8
+	if unitBits == 0 || 32%unitBits != 0 {
+		return
+	}
+	tree := source + 4
+	stream := tree + (cpu.bus.ReadData8(tree)+1)*2
+	root := tree + 1
+
+	node := root
+	var out, outBits, written uint32
+	for written < size {
+		word := cpu.bus.ReadData32(stream)
+		stream += 4
+		for bit := 31; bit >= 0 && written < size; bit-- {
+			child := word >> uint(bit) & 1
+			value := cpu.bus.ReadData8(node)
+			node = node&^1 + (value&0x3f)*2 + 2 + child
+			if value&(0x80>>child) == 0 {
+				continue
+			}
+			out |= (cpu.bus.ReadData8(node) & (1<<unitBits - 1)) << outBits
+			outBits += unitBits
+			node = root
+			if outBits == 32 {
+				cpu.bus.WriteData32(dest, out)
+				dest += 4
+				written += 4
+				out, outBits = 0, 0
+			}
+		}
+	}
+}
+
+// diff8Write8 undoes the byte differences filter on the data at r0.
+func diff8Write8(cpu *armCore) {
+	source, dest := cpu.readReg(r0), cpu.readReg(r1)
+	size := cpu.bus.ReadData32(source) >> 8
+	var value uint32
+	for offset := uint32(0); offset < size; offset++ {
+		value = (value + cpu.bus.ReadData8(source+4+offset)) & 0xff
+		cpu.bus.WriteData8(dest+offset, value)
+	}
+}
+
+// diff16 undoes the halfword differences filter on the data at r0.
+func diff16(cpu *armCore) {
+	source, dest := cpu.readReg(r0), cpu.readReg(r1)
+	size := cpu.bus.ReadData32(source) >> 8
+	var value uint32
+	for offset := uint32(0); offset+1 < size; offset += 2 {
+		value = (value + cpu.bus.ReadData16(source+4+offset)) & 0xffff
+		cpu.bus.WriteData16(dest+offset, value)
+	}
+}
+
+// store writes decompressed data to address a byte or, for VRAM, which
+// ignores byte writes, a halfword at a time.
+func (cpu *armCore) store(address uint32, data []byte, width int) {
+	if width == 8 {
+		for offset, value := range data {
+			cpu.bus.WriteData8(address+uint32(offset), uint32(value))
+		}
+		return
+	}
+	for offset := 0; offset < len(data); offset += 2 {
+		value := uint32(data[offset])
+		if offset+1 < len(data) {
+			value |= uint32(data[offset+1]) << 8
+		}
+		cpu.bus.WriteData16(address+uint32(offset), value)
+	}
+}
+
+// The sound tables of the Arm7 BIOS are computed from the curves they
+// follow rather than read from an image.
+
+// getSineTable leaves sin(r0 * 90 / 64 degrees) * 0x8000 in r0.
+func getSineTable(cpu *armCore) {
+	index := float64(cpu.readReg(r0) & 0x3f)
+	cpu.setReg(r0, uint32(math.Round(math.Sin(index*math.Pi/128)*0x8000)))
+}
+
+// getPitchTable leaves the fraction of 2^(r0 / 768) in r0, scaled by
+// 0x10000.
+func getPitchTable(cpu *armCore) {
+	index := float64(cpu.readReg(r0) % 0x300)
+	cpu.setReg(r0, uint32(math.Round((math.Pow(2, index/0x300)-1)*0x10000)))
+}
+
+// getVolumeTable leaves the channel volume for r0 tenths of a decibel
+// above -72.3 in r0. Quieter volumes are scaled up to suit the divider the
+// sound driver pairs them with, 16 below -24dB, 4 below -12dB and 2 below
+// -6dB.
+func getVolumeTable(cpu *armCore) {
+	decibels := (float64(cpu.readReg(r0)%724) - 723) / 10
+	scale := 1.0
+	switch {
+	case decibels < -24:
+		scale = 16
+	case decibels < -12:
+		scale = 4
+	case decibels < -6:
+		scale = 2
+	}
+	cpu.setReg(r0, uint32(math.Round(math.Pow(10, decibels/20)*127*scale)))
+}
@@ -0,0 +1,205 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/cart"
+)
+
+// Where the BIOS tests keep their data in the test memory.
+const (
+	testSource = 0x1000
+	testDest   = 0x2000
+)
+
+// callBIOS runs the Arm7 BIOS function number on an emulating core with
+// r0 onwards set to arguments.
+func callBIOS(bus *testBus, number uint32, arguments ...uint32) *armCore {
+	core := newArmCore(V4, 1)
+	bus.WriteData32(testEntry, 0xef000000|number<<16)
+	core.SetBus(bus)
+	core.DirectBoot(testEntry, testSystemStack, testIrqStack, testSvcStack)
+	core.biosCalls = arm7BIOSCalls
+	core.SetBIOSEmulation(true)
+	for index, value := range arguments {
+		core.setReg(reg(index), value)
+	}
+	core.Step()
+	return &core
+}
+
+func TestBIOSDiv(t *testing.T) {
+	tests := []struct {
+		numerator, denominator int32
+		quotient, remainder    int32
+		absolute               uint32
+	}{
+		{7, 2, 3, 1, 3},
+		{-7, 2, -3, -1, 3},
+		{7, -2, -3, 1, 3},
+		{5, 0, -1, 5, 1},
+		{-5, 0, 1, -5, 1},
+	}
+	for _, test := range tests {
+		core := callBIOS(&testBus{}, 0x09, uint32(test.numerator), uint32(test.denominator))
+		quotient, remainder := int32(core.readReg(r0)), int32(core.readReg(r1))
+		if quotient != test.quotient || remainder != test.remainder || core.readReg(r3) != test.absolute {
+			t.Errorf("%d / %d = %d, %d, %d, want %d, %d, %d", test.numerator, test.denominator,
+				quotient, remainder, core.readReg(r3), test.quotient, test.remainder, test.absolute)
+		}
+	}
+}
+
+func TestBIOSSqrt(t *testing.T) {
+	tests := []struct {
+		value, root uint32
+	}{
+		{0, 0},
+		{15, 3},
+		{16, 4},
+		{0xfffe0001, 0xffff},
+		{0xffffffff, 0xffff},
+	}
+	for _, test := range tests {
+		if root := callBIOS(&testBus{}, 0x0d, test.value).readReg(r0); root != test.root {
+			t.Errorf("sqrt(%#x) = %#x, want %#x", test.value, root, test.root)
+		}
+	}
+}
+
+func TestBIOSCpuSet(t *testing.T) {
+	bus := &testBus{}
+	for offset := uint32(0); offset < 0x40; offset++ {
+		bus.WriteData8(testSource+offset, offset+1)
+	}
+
+	// Three halfwords copied.
+	callBIOS(bus, 0x0b, testSource, testDest, 3)
+	if value := bus.ReadData32(testDest + 4); value != 0x0605 {
+		t.Errorf("copied halfwords = %#x, want 0x0605", value)
+	}
+	// Two words filled.
+	callBIOS(bus, 0x0b, testSource, testDest+0x10, 1<<26|1<<24|2)
+	if bus.ReadData32(testDest+0x14) != 0x04030201 || bus.ReadData32(testDest+0x18) != 0 {
+		t.Error("word fill wrong")
+	}
+	// CpuFastSet rounds up to eight words.
+	callBIOS(bus, 0x0c, testSource, testDest+0x20, 1)
+	if bus.ReadData32(testDest+0x3c) != 0x201f1e1d || bus.ReadData32(testDest+0x40) != 0 {
+		t.Error("CpuFastSet didn't copy eight words")
+	}
+}
+
+func TestBIOSDecompression(t *testing.T) {
+	tests := []struct {
+		name   string
+		number uint32
+		data   []uint8
+		want   string
+	}{
+		{"LZ77", 0x11, []uint8{0x10, 8, 0, 0, 0x20, 'A', 'B', 0x30, 0x01}, "ABABABAB"},
+		{"LZ77 16 bit", 0x12, []uint8{0x10, 8, 0, 0, 0x20, 'A', 'B', 0x30, 0x01}, "ABABABAB"},
+		{"run length", 0x14, []uint8{0x30, 6, 0, 0, 0x82, 'A', 0x00, 'B'}, "AAAAAB"},
+		{"run length 16 bit", 0x15, []uint8{0x30, 6, 0, 0, 0x82, 'A', 0x00, 'B'}, "AAAAAB"},
+		// A tree with A on the zero branch and B on the one branch.
+		{"Huffman", 0x13, []uint8{0x28, 4, 0, 0, 1, 0xc0, 'A', 'B', 0, 0, 0, 0x60}, "ABBA"},
+	}
+	for _, test := range tests {
+		bus := &testBus{}
+		for offset, value := range test.data {
+			bus.WriteData8(testSource+uint32(offset), uint32(value))
+		}
+		callBIOS(bus, test.number, testSource, testDest)
+		out := string(bus.memory[testDest : testDest+len(test.want)])
+		if out != test.want {
+			t.Errorf("%s = %q, want %q", test.name, out, test.want)
+		}
+	}
+}
+
+func TestBIOSGetCRC16(t *testing.T) {
+	bus := &testBus{}
+	data := []byte("casper")
+	copy(bus.memory[testSource:], data)
+	core := callBIOS(bus, 0x0e, 0xffff, testSource, uint32(len(data)))
+	if crc := core.readReg(r0); crc != uint32(cart.CRC16(0xffff, data)) {
+		t.Errorf("CRC = %#x, want %#x", crc, cart.CRC16(0xffff, data))
+	}
+}
+
+func TestBIOSSoundTables(t *testing.T) {
+	tests := []struct {
+		name   string
+		number uint32
+		index  uint32
+		value  uint32
+	}{
+		{"sine 0", 0x1a, 0, 0},
+		{"sine 45 degrees", 0x1a, 0x20, 0x5a82},
+		{"pitch 0", 0x1b, 0, 0},
+		{"pitch last", 0x1b, 0x2ff, 0xff8a},
+		{"volume 0dB", 0x1c, 0x2d3, 0x7f},
+		{"volume silent", 0x1c, 0, 0},
+	}
+	for _, test := range tests {
+		if value := callBIOS(&testBus{}, test.number, test.index).readReg(r0); value != test.value {
+			t.Errorf("%s = %#x, want %#x", test.name, value, test.value)
+		}
+	}
+}
+
+func TestBIOSIntrWait(t *testing.T) {
+	bus := &testBus{}
+	// An old VBlank flag is discarded.
+	bus.WriteData32(arm7IRQCheck, 1)
+	core := callBIOS(bus, 0x04, 1, 1)
+	line := &testInterruptLine{}
+	core.SetInterruptLine(line)
+	if !core.Halted() || bus.ReadData32(arm7IRQCheck) != 0 {
+		t.Fatalf("halted = %v, flags = %#x", core.Halted(), bus.ReadData32(arm7IRQCheck))
+	}
+	if bus.ReadData32(imeAddress) != 1 {
+		t.Error("IME not set")
+	}
+
+	// Waking without the flag set waits again.
+	line.pending = true
+	core.Step()
+	if !core.Halted() {
+		t.Fatal("returned without the interrupt flagged")
+	}
+
+	// The game's handler flags the interrupt.
+	bus.WriteData32(arm7IRQCheck, 1|4)
+	core.Step()
+	if core.Halted() || bus.ReadData32(arm7IRQCheck) != 4 {
+		t.Errorf("halted = %v, flags = %#x", core.Halted(), bus.ReadData32(arm7IRQCheck))
+	}
+	if next := core.nextExecuteAddress(); next != testEntry+4 {
+		t.Errorf("next instruction at %#x, want the one after the SWI", next)
+	}
+}
+
+func TestBIOSThumbNumber(t *testing.T) {
+	core, _ := newThumbTestCore(V4, 0xdf06) // swi 6 (Halt)
+	core.biosCalls = arm7BIOSCalls
+	core.SetBIOSEmulation(true)
+	core.Step()
+	if !core.Halted() {
+		t.Error("Thumb Halt didn't halt")
+	}
+}
+
+func TestBIOSUnimplementedCall(t *testing.T) {
+	core := callBIOS(&testBus{}, 0x00)
+	err, ok := core.Err().(*UnimplementedSWIError)
+	if !ok || err.Number != 0 || err.Address != testEntry {
+		t.Fatalf("err = %v", core.Err())
+	}
+	// The CPU stays stopped.
+	timestamp := core.Timestamp()
+	core.Step()
+	if core.Timestamp() != timestamp {
+		t.Error("stopped CPU ran")
+	}
+}
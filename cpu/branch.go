@@ -2,6 +2,14 @@ package cpu
 
 import "github.com/damilolarandolph/casper/bits"
 
+// branchOffset sign extends the 24 bit word offset of a branch
+// instruction into a byte offset.
+func branchOffset(instruction uint32) uint32 {
+	offset := bits.GetBits(instruction, 23, 0) << 8
+	bits.ShiftRightSigned(&offset, 6)
+	return offset
+}
+
 // Branch or Branch with Link Instruction.
 // Linking the return address to R14 is determined by
 // the withLink parameter.
@@ -13,9 +21,7 @@ func branch(withLink bool, cpu ArmCPU) {
 		blxImm(cpu)
 		return
 	}
-	branchAddress := bits.GetBits(cpu.currentInstruction(), 23, 0)
-	bits.ShiftRightSigned(&branchAddress, 12)
-	branchAddress <<= 2
+	branchAddress := branchOffset(cpu.currentInstruction())
 	if withLink {
 		cpu.setReg(r14, cpu.nextInstruction())
 	}
@@ -25,10 +31,9 @@ func branch(withLink bool, cpu ArmCPU) {
 
 // Branch Link Exchange instruction with immediate operand.
 func blxImm(cpu ArmCPU) {
-	branchAddress := bits.GetBits(cpu.currentInstruction(), 23, 0)
-	bits.ShiftRightSigned(&branchAddress, 12)
-	branchAddress <<= 2
+	branchAddress := branchOffset(cpu.currentInstruction())
 	bits.SetBit(&branchAddress, 1, bits.GetBit(cpu.currentInstruction(), 24))
+	cpu.setReg(r14, cpu.nextInstruction())
 	cpu.setFlag(thumbMode, true)
 	var newPc uint32 = uint32(int32(cpu.readPc()) + int32(branchAddress))
 	cpu.setPc(newPc)
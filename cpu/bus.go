@@ -1,6 +1,9 @@
 package cpu
 
-import "github.com/damilolarandolph/casper/memory"
+import (
+	"github.com/damilolarandolph/casper/memory"
+	"github.com/damilolarandolph/casper/mmio"
+)
 
 type fetchType int
 
@@ -37,116 +40,145 @@ type CodeBus interface {
 type SystemBus interface {
 	DataBus
 	CodeBus
+	// DrainCycles returns the CPU cycles spent on accesses since the
+	// last call.
+	DrainCycles() int
+}
+
+// regionDecoder translates an address into the memory region backing
+// it. It is implemented by each CPU's bus according to its memory map.
+type regionDecoder interface {
+	getMemRegionParams(address uint32) (memRegion []uint8, translatedAddr uint32)
 }
 
-// Arm7Bus implements an Arm7 system bus
-type Arm7Bus struct {
-	arm7        ArmCPU
-	arm9        ArmCPU
+// VRAM is video memory as the VRAM bank controller maps it for each CPU.
+// A page is every bank mapped at an address, more than one where banks
+// overlap, and the offset of the address in them.
+type VRAM interface {
+	Arm7Page(address uint32) ([][]uint8, uint32)
+	Arm9Page(address uint32) ([][]uint8, uint32)
+}
+
+// memoryBus implements the accesses and timings shared by the
+// Arm7 and Arm9 system buses.
+type memoryBus struct {
 	memory      *memory.InternalMemory
+	io          *mmio.Registry
+	vram        VRAM
+	decoder     regionDecoder
 	isOpcode    bool
+	isWrite     bool
 	accessType  fetchType
 	sequencial  bool
 	dataTimings [][]int
 	codeTimings [][]int
+	cycles      int
+	// overlapping holds the other regions backing the last decoded
+	// address where VRAM banks are mapped over each other. Reads combine
+	// all of them and writes go to each.
+	overlapping [][]uint8
 }
 
 /* ReadCode8 performs an 8 bit opcode fetch.
-func (bus *Arm7Bus) ReadCode8(address uint32) uint32 {
+func (bus *memoryBus) ReadCode8(address uint32) uint32 {
 	bus.isOpcode = true
 	bus.accessType = byteFetch
 	return bus.read(address)
 }*/
 
 // ReadCode16 performs a 16 bit opcode fetch.
-func (bus *Arm7Bus) ReadCode16(address uint32) uint32 {
+func (bus *memoryBus) ReadCode16(address uint32) uint32 {
 	bus.isOpcode = true
 	bus.accessType = halfWordFetch
 	return bus.read(address)
 }
 
 // ReadCode32 performs a 32 bit opcode fetch.
-func (bus *Arm7Bus) ReadCode32(address uint32) uint32 {
+func (bus *memoryBus) ReadCode32(address uint32) uint32 {
 	bus.isOpcode = true
 	bus.accessType = wordFetch
 	return bus.read(address)
 }
 
 // ReadData8 performs an 8 bit data fetch.
-func (bus *Arm7Bus) ReadData8(address uint32) uint32 {
+func (bus *memoryBus) ReadData8(address uint32) uint32 {
 	bus.isOpcode = false
 	bus.accessType = byteFetch
 	return bus.read(address)
 }
 
 // ReadData16 performs a 16 bit data fetch.
-func (bus *Arm7Bus) ReadData16(address uint32) uint32 {
+func (bus *memoryBus) ReadData16(address uint32) uint32 {
 	bus.isOpcode = false
 	bus.accessType = halfWordFetch
 	return bus.read(address)
 }
 
 // ReadData32 performs a 32 bit data fetch.
-func (bus *Arm7Bus) ReadData32(address uint32) uint32 {
+func (bus *memoryBus) ReadData32(address uint32) uint32 {
 	bus.isOpcode = false
 	bus.accessType = wordFetch
 	return bus.read(address)
 }
 
 /*
-func (bus *Arm7Bus) WriteCode8(address uint32, val uint32) {
+func (bus *memoryBus) WriteCode8(address uint32, val uint32) {
 	bus.isOpcode = true
 	bus.accessType = byteFetch
 	bus.write(address, val)
 }
 
-func (bus *Arm7Bus) WriteCode16(address uint32, val uint32) {
+func (bus *memoryBus) WriteCode16(address uint32, val uint32) {
 	bus.isOpcode = true
 	bus.accessType = halfWordFetch
 	bus.write(address, val)
 }
 
-func (bus *Arm7Bus) WriteCode32(address uint32, val uint32) {
+func (bus *memoryBus) WriteCode32(address uint32, val uint32) {
 	bus.isOpcode = true
 	bus.accessType = wordFetch
 	bus.write(address, val)
 } */
 
 // WriteData8 performs an 8 bit write.
-func (bus *Arm7Bus) WriteData8(address uint32, val uint32) {
+func (bus *memoryBus) WriteData8(address uint32, val uint32) {
 	bus.isOpcode = false
+	bus.isWrite = true
 	bus.accessType = byteFetch
 	bus.write(address, val)
 }
 
 // WriteData16 performs a 16 bit write.
-func (bus *Arm7Bus) WriteData16(address uint32, val uint32) {
+func (bus *memoryBus) WriteData16(address uint32, val uint32) {
 	bus.isOpcode = false
+	bus.isWrite = true
 	bus.accessType = halfWordFetch
 	bus.write(address, val)
 }
 
 // WriteData32 performs a 32 bit write.
-func (bus *Arm7Bus) WriteData32(address uint32, val uint32) {
+func (bus *memoryBus) WriteData32(address uint32, val uint32) {
 	bus.isOpcode = false
+	bus.isWrite = true
 	bus.accessType = wordFetch
 	bus.write(address, val)
 }
 
 // SetSequencial set the next memory access an non-sequencial.
-func (bus *Arm7Bus) SetSequencial(val bool) {
+func (bus *memoryBus) SetSequencial(val bool) {
 	bus.sequencial = val
 }
 
-func (bus *Arm7Bus) read(address uint32) uint32 {
-	if address >= 04000000 && address < 0x04800000 {
+func (bus *memoryBus) read(address uint32) uint32 {
+	bus.isWrite = false
+	if address >= 0x04000000 && address < 0x04800000 {
 		return bus.ioReadBytes(address)
 	}
 	return bus.memReadBytes(address)
 }
 
-func (bus *Arm7Bus) write(address uint32, val uint32) {
-	if address >= 04000000 && address < 0x04800000 {
+func (bus *memoryBus) write(address uint32, val uint32) {
+	if address >= 0x04000000 && address < 0x04800000 {
 		bus.ioWriteBytes(address, val)
 		return
 	}
@@ -154,7 +186,7 @@ func (bus *Arm7Bus) write(address uint32, val uint32) {
 	return
 }
 
-func (bus *Arm7Bus) getTiming(row int) int {
+func (bus *memoryBus) getTiming(row int) int {
 
 	var col int
 
@@ -180,93 +212,35 @@ func (bus *Arm7Bus) getTiming(row int) int {
 
 }
 
-func (bus *Arm7Bus) wait(amount int) {
-	for ; amount > 0; amount-- {
-		bus.arm7.WaitForTick()
-	}
+func (bus *memoryBus) wait(amount int) {
+	bus.cycles += amount
 }
 
-func (bus *Arm7Bus) getMemRegionParams(address uint32) (memRegion []uint8, translatedAddr uint32) {
-
-	//ARM7 BIOS
-	if address >= 0x0 && address < 0x02000000 {
-		memRegion = bus.memory.BIOS
-		translatedAddr = address
-		bus.wait(bus.getTiming(1))
-		return
-	}
-	// MAIN RAM
-	if address < 0x03000000 {
-		memRegion = bus.memory.MainRAM
-		translatedAddr = address - 0x02000000
-		bus.wait(bus.getTiming(0))
-		return
-	}
-	// Shared WRAM
-	if address < 0x03800000 {
-		memRegion = bus.memory.Wram
-		translatedAddr = address - 0x03000000
-		bus.wait(bus.getTiming(1))
-		return
-	}
-	// WRAM
-	if address < 0x04000000 {
-		memRegion = bus.memory.Wram
-		translatedAddr = address - 0x03800000
-		bus.wait(bus.getTiming(1))
-		return
-	}
-	// I/O
-	if address < 0x04800000 {
-		memRegion = nil
-		translatedAddr = address - 0x04000000
-		bus.wait(bus.getTiming(1))
-		return
-	}
-	// WIFI RAM
-	if address < 0x04808000 {
-		memRegion = bus.memory.WifiRAM
-		translatedAddr = address - 0x04800000
-		bus.wait(bus.getTiming(1))
-		return
-	}
-
-	// WIFI I/O
-	if address < 0x06000000 {
-		memRegion = nil
-		translatedAddr = address - 0x04808000
-		bus.wait(bus.getTiming(1))
-		return
-	}
+// DrainCycles returns the CPU cycles spent on accesses since the last
+// call.
+func (bus *memoryBus) DrainCycles() int {
+	cycles := bus.cycles
+	bus.cycles = 0
+	return cycles
+}
 
-	//VRAM
-	if address < 0x08000000 {
-		memRegion = bus.memory.Vram
-		translatedAddr = address - 0x06000000
-		bus.wait(bus.getTiming(2))
-		return
+func (bus *memoryBus) memReadBytes(address uint32) uint32 {
+	bus.overlapping = nil
+	memRegion, tranlatedAddress := bus.decoder.getMemRegionParams(address)
+	if len(memRegion) == 0 {
+		return 0
 	}
-
-	//GBA ROM
-	if address < 0x0A000000 {
-		memRegion = bus.memory.ROM
-		translatedAddr = address - 0x08000000
-		bus.wait(bus.getTiming(3))
-		return
+	value := bus.readRegion(memRegion, tranlatedAddress)
+	for _, region := range bus.overlapping {
+		value |= bus.readRegion(region, tranlatedAddress)
 	}
-
-	//GBA RAM
-	memRegion = nil
-	translatedAddr = address - 0x0A000000
-	bus.wait(bus.getTiming(4))
-	return
-
+	return value
 }
 
-func (bus *Arm7Bus) memReadBytes(address uint32) uint32 {
+func (bus *memoryBus) readRegion(memRegion []uint8, tranlatedAddress uint32) uint32 {
 
 	var value uint32
-	memRegion, tranlatedAddress := bus.getMemRegionParams(address)
+	tranlatedAddress = mirror(memRegion, tranlatedAddress&^uint32(bus.accessType))
 	var endAddress = tranlatedAddress + uint32(bus.accessType)
 
 	for ; endAddress >= tranlatedAddress; endAddress-- {
@@ -281,21 +255,71 @@ func (bus *Arm7Bus) memReadBytes(address uint32) uint32 {
 
 }
 
-func (bus *Arm7Bus) memWriteBytes(address uint32, val uint32) {
-	var value uint32
-	memRegion, tranlatedAddress := bus.getMemRegionParams(address)
+func (bus *memoryBus) memWriteBytes(address uint32, val uint32) {
+	bus.overlapping = nil
+	memRegion, tranlatedAddress := bus.decoder.getMemRegionParams(address)
+	if len(memRegion) == 0 {
+		return
+	}
+	bus.writeRegion(memRegion, tranlatedAddress, val)
+	for _, region := range bus.overlapping {
+		bus.writeRegion(region, tranlatedAddress, val)
+	}
+}
+
+func (bus *memoryBus) writeRegion(memRegion []uint8, tranlatedAddress uint32, val uint32) {
+	tranlatedAddress = mirror(memRegion, tranlatedAddress&^uint32(bus.accessType))
 	endAddress := tranlatedAddress + uint32(bus.accessType)
-	for ; endAddress >= tranlatedAddress; endAddress-- {
-		memRegion[endAddress] = uint8(value)
-		value >>= 8
-		if endAddress == 0 {
-			break
-		}
+	for ; tranlatedAddress <= endAddress; tranlatedAddress++ {
+		memRegion[tranlatedAddress] = uint8(val)
+		val >>= 8
 	}
 }
 
-func (bus *Arm7Bus) ioWriteBytes(address uint32, val uint32) {}
+// SetVRAM connects the bus to the VRAM bank controller. VRAM is unmapped
+// until it is set.
+func (bus *memoryBus) SetVRAM(vram VRAM) {
+	bus.vram = vram
+}
+
+// vramRegion returns the first bank of a VRAM page as the region backing
+// the address, keeping the others as overlapping regions.
+func (bus *memoryBus) vramRegion(banks [][]uint8, offset uint32) ([]uint8, uint32) {
+	if len(banks) == 0 {
+		return nil, 0
+	}
+	bus.overlapping = banks[1:]
+	return banks[0], offset
+}
+
+// mirror wraps an address so that it repeats across the whole
+// region it was decoded from.
+func mirror(memRegion []uint8, address uint32) uint32 {
+	return address % uint32(len(memRegion))
+}
 
-func (bus *Arm7Bus) ioReadBytes(address uint32) uint32 {
-	return 0
+// IO returns the registry of the I/O registers seen by the bus's CPU.
+func (bus *memoryBus) IO() *mmio.Registry {
+	return bus.io
+}
+
+func (bus *memoryBus) ioWriteBytes(address uint32, val uint32) {
+	switch bus.accessType {
+	case byteFetch:
+		bus.io.Write8(address, uint8(val))
+	case halfWordFetch:
+		bus.io.Write16(address, uint16(val))
+	default:
+		bus.io.Write32(address, val)
+	}
+}
+
+func (bus *memoryBus) ioReadBytes(address uint32) uint32 {
+	switch bus.accessType {
+	case byteFetch:
+		return uint32(bus.io.Read8(address))
+	case halfWordFetch:
+		return uint32(bus.io.Read16(address))
+	}
+	return bus.io.Read32(address)
 }
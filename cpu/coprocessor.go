@@ -0,0 +1,99 @@
+package cpu
+
+import "github.com/damilolarandolph/casper/bits"
+
+// Coprocessor describes a coprocessor attached to an ARM core.
+// Registers are addressed the same way the MCR, MRC and CDP
+// instructions encode them.
+type Coprocessor interface {
+	// Read handles an MRC transfer from the coprocessor.
+	Read(opcode1 uint32, crn uint32, crm uint32, opcode2 uint32) uint32
+	// Write handles an MCR transfer to the coprocessor.
+	Write(opcode1 uint32, crn uint32, crm uint32, opcode2 uint32, value uint32)
+	// Operation handles a CDP instruction.
+	Operation(opcode1 uint32, crd uint32, crn uint32, crm uint32, opcode2 uint32)
+}
+
+func parseCoprocessorInstr(instruction uint32) (uint32, uint32, uint32, uint32) {
+	crn := bits.GetBits(instruction, 19, 16)
+	crm := bits.GetBits(instruction, 3, 0)
+	opcode2 := bits.GetBits(instruction, 7, 5)
+	number := bits.GetBits(instruction, 11, 8)
+	return number, crn, crm, opcode2
+}
+
+// systemControl is the number of the system control coprocessor.
+const systemControl = 15
+
+// accessibleCoprocessor returns the coprocessor an instruction addresses,
+// or nil when there is none attached or it is the system control
+// coprocessor and the CPU is in user mode.
+func accessibleCoprocessor(cpu ArmCPU, number uint32) Coprocessor {
+	if number == systemControl && cpu.mode() == user {
+		return nil
+	}
+	return cpu.coprocessor(int(number))
+}
+
+// Move to coprocessor from ARM register (MCR).
+func mcr(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	number, crn, crm, opcode2 := parseCoprocessorInstr(instruction)
+	opcode1 := bits.GetBits(instruction, 23, 21)
+	rd := reg(bits.GetBits(instruction, 15, 12))
+	coprocessor := accessibleCoprocessor(cpu, number)
+	if coprocessor == nil {
+		undefinedInstruction(cpu)
+		return
+	}
+	coprocessor.Write(opcode1, crn, crm, opcode2, cpu.readReg(rd))
+}
+
+// Move to ARM register from coprocessor (MRC).
+// A destination of r15 sets the condition flags instead.
+func mrc(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	number, crn, crm, opcode2 := parseCoprocessorInstr(instruction)
+	opcode1 := bits.GetBits(instruction, 23, 21)
+	rd := reg(bits.GetBits(instruction, 15, 12))
+	coprocessor := accessibleCoprocessor(cpu, number)
+	if coprocessor == nil {
+		undefinedInstruction(cpu)
+		return
+	}
+	value := coprocessor.Read(opcode1, crn, crm, opcode2)
+	if rd == rPc {
+		cpu.setCpsr((cpu.readCpsr() & 0x0fffffff) | (value & 0xf0000000))
+		return
+	}
+	cpu.setReg(rd, value)
+}
+
+// Coprocessor data processing (CDP).
+func cdp(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	number, crn, crm, opcode2 := parseCoprocessorInstr(instruction)
+	opcode1 := bits.GetBits(instruction, 23, 20)
+	crd := bits.GetBits(instruction, 15, 12)
+	coprocessor := accessibleCoprocessor(cpu, number)
+	if coprocessor == nil {
+		undefinedInstruction(cpu)
+		return
+	}
+	coprocessor.Operation(opcode1, crd, crn, crm, opcode2)
+}
+
+func emitCoprocessorDataOpcode(row int, col int) bool {
+	armInstructions[row][col] = cdp
+	return true
+}
+
+func emitCoprocessorRegOpcode(row int, col int) bool {
+	opcodeFrag := (uint32(row) << 20) | (uint32(col) << 4)
+	if bits.GetBit(opcodeFrag, 20) {
+		armInstructions[row][col] = mrc
+	} else {
+		armInstructions[row][col] = mcr
+	}
+	return true
+}
@@ -0,0 +1,320 @@
+package cpu
+
+import "github.com/damilolarandolph/casper/bits"
+
+// armCore holds the register file, pipeline and execution loop shared
+// by the Arm7 and Arm9.
+type armCore struct {
+	architecture    Architecture
+	registers       [37]uint32
+	bankedRegisters []reg
+	instruction     uint32
+	instructionAddr uint32
+	instructionSize uint32
+	pipeline        [2]uint32
+	pipelineFlushed bool
+	currentSpsr     reg
+	currentMode     cpuMode
+	irqHigh         bool
+	timestamp       uint64
+	clockMultiple   int
+	bus             SystemBus
+	coprocessors    [16]Coprocessor
+	interrupts      InterruptLine
+	halted          bool
+	// The BIOS functions carried out in place of a BIOS image, when
+	// emulateBIOS is set.
+	biosCalls            map[uint32]biosCall
+	emulateBIOS          bool
+	waitingForInterrupts bool
+	// err is set when the CPU has stopped on something it can't emulate.
+	err error
+}
+
+func newArmCore(architecture Architecture, clockMultiple int) armCore {
+	core := armCore{
+		architecture:    architecture,
+		registers:       [37]uint32{},
+		bankedRegisters: bankedRegMap[user],
+		currentMode:     user,
+		currentSpsr:     rCpsr,
+		clockMultiple:   clockMultiple,
+		pipelineFlushed: true,
+	}
+	core.registers[rCpsr] = uint32(user)
+	return core
+}
+
+// SetBus connects the CPU to the bus it fetches code and data from.
+func (cpu *armCore) SetBus(bus SystemBus) {
+	cpu.bus = bus
+}
+
+// SetInterruptLine connects the IRQ input of the CPU.
+func (cpu *armCore) SetInterruptLine(line InterruptLine) {
+	cpu.interrupts = line
+}
+
+// SetCoprocessor attaches a coprocessor to the CPU under the given number.
+func (cpu *armCore) SetCoprocessor(number int, coprocessor Coprocessor) {
+	cpu.coprocessors[number] = coprocessor
+}
+
+func (cpu *armCore) coprocessor(number int) Coprocessor {
+	return cpu.coprocessors[number]
+}
+
+// Halt stops instruction execution until an enabled interrupt is
+// requested.
+func (cpu *armCore) Halt() {
+	cpu.halted = true
+}
+
+// Halted reports whether the CPU is waiting for an interrupt.
+func (cpu *armCore) Halted() bool {
+	return cpu.halted
+}
+
+// Bus returns the bus used by the CPU for data accesses.
+func (cpu *armCore) Bus() DataBus {
+	return cpu.bus
+}
+
+// Architecture returns the ARM architecture version implemented by the CPU.
+func (cpu *armCore) Architecture() Architecture {
+	return cpu.architecture
+}
+
+func (cpu *armCore) readReg(register reg) uint32 {
+	if register == rPc {
+		return cpu.readPc()
+	}
+
+	if register < r8 {
+		return cpu.registers[register]
+	}
+
+	return cpu.registers[cpu.bankedRegisters[register-8]]
+}
+
+func (cpu *armCore) setReg(register reg, value uint32) {
+	if register == rPc {
+		cpu.setPc(value)
+		return
+	}
+
+	if register < r8 {
+		cpu.registers[register] = value
+		return
+	}
+
+	cpu.registers[cpu.bankedRegisters[register-8]] = value
+}
+
+// readPc returns the value of r15 as seen by the executing instruction,
+// which is two instructions ahead of it because of the pipeline.
+func (cpu *armCore) readPc() uint32 {
+	return cpu.registers[rPc]
+}
+
+// setPc writes r15 and flushes the pipeline so execution resumes at
+// the new address.
+func (cpu *armCore) setPc(value uint32) {
+	cpu.registers[rPc] = value
+	cpu.pipelineFlushed = true
+}
+
+func (cpu *armCore) readCpsr() uint32 {
+	return cpu.registers[rCpsr]
+}
+
+// setCpsr writes the CPSR, switching register banks if the mode changes.
+func (cpu *armCore) setCpsr(value uint32) {
+	cpu.registers[rCpsr] = value
+	mode := cpuMode(value & 0x1f)
+	if mode == cpu.currentMode {
+		return
+	}
+	cpu.currentMode = mode
+	if banked, ok := bankedRegMap[mode]; ok {
+		cpu.bankedRegisters = banked
+	}
+	// User and system mode have no SPSR, accesses to it use the CPSR.
+	cpu.currentSpsr = rCpsr
+	if spsr, ok := bankedSpsrMap[mode]; ok {
+		cpu.currentSpsr = spsr
+	}
+}
+
+func (cpu *armCore) readSpsr() uint32 {
+	return cpu.registers[cpu.currentSpsr]
+}
+
+func (cpu *armCore) setSpsr(value uint32) {
+	if cpu.currentSpsr == rCpsr {
+		return
+	}
+	cpu.registers[cpu.currentSpsr] = value
+}
+
+// DirectBoot sets up the registers the BIOS leaves behind when it starts
+// a game at entry, using the given stack pointers for system, IRQ and
+// supervisor mode.
+func (cpu *armCore) DirectBoot(entry uint32, systemStack uint32, irqStack uint32, supervisorStack uint32) {
+	for register := r0; register < rPc; register++ {
+		cpu.registers[register] = 0
+	}
+	cpu.setMode(irq)
+	cpu.setReg(r13, irqStack)
+	cpu.setMode(supervisor)
+	cpu.setReg(r13, supervisorStack)
+	// System mode with IRQs and FIQs disabled.
+	cpu.setCpsr(0xdf)
+	cpu.setReg(r13, systemStack)
+	cpu.setReg(r12, entry)
+	cpu.setReg(r14, entry)
+	cpu.halted = false
+	cpu.setPc(entry)
+}
+
+// Reset takes the reset exception, which starts execution from the reset
+// vector in supervisor mode with interrupts disabled.
+func (cpu *armCore) Reset() {
+	cpu.enterException(ResetEx, 0)
+}
+
+// currentInstruction returns the opcode being executed.
+func (cpu *armCore) currentInstruction() uint32 {
+	return cpu.instruction
+}
+
+// nextInstruction returns the address of the instruction following the
+// one being executed, which is the return address for linking branches.
+func (cpu *armCore) nextInstruction() uint32 {
+	return cpu.instructionAddr + cpu.instructionSize
+}
+
+// Timestamp returns the time the CPU has run up to in master cycles.
+func (cpu *armCore) Timestamp() uint64 {
+	return cpu.timestamp
+}
+
+// RunUntil executes instructions until the CPU has run up to the master
+// cycle deadline returns. The deadline is checked after every instruction,
+// so what the CPU does can bring it forward. A halted or stopped CPU idles
+// until then.
+func (cpu *armCore) RunUntil(deadline func() uint64) {
+	for cpu.timestamp < deadline() {
+		cpu.Step()
+		if cpu.halted || cpu.err != nil {
+			if target := deadline(); target > cpu.timestamp {
+				cpu.timestamp = target
+			}
+		}
+	}
+}
+
+// Step fetches, decodes and executes a single instruction.
+func (cpu *armCore) Step() {
+	if cpu.err != nil {
+		return
+	}
+	cpu.checkInterrupts()
+	if cpu.halted {
+		return
+	}
+
+	if cpu.pipelineFlushed {
+		cpu.refillPipeline()
+	}
+
+	// r15 already points two instructions ahead of the one being executed,
+	// so the fetch stage reads from it directly.
+	cpu.instruction = cpu.pipeline[0]
+	cpu.pipeline[0] = cpu.pipeline[1]
+	cpu.bus.SetSequencial(true)
+
+	if cpu.isFlag(thumbMode) {
+		cpu.instructionSize = 2
+		cpu.instructionAddr = cpu.registers[rPc] - 4
+		cpu.pipeline[1] = cpu.bus.ReadCode16(cpu.registers[rPc])
+		cpu.executeThumb()
+	} else {
+		cpu.instructionSize = 4
+		cpu.instructionAddr = cpu.registers[rPc] - 8
+		cpu.pipeline[1] = cpu.bus.ReadCode32(cpu.registers[rPc])
+		cpu.execute()
+	}
+
+	if !cpu.pipelineFlushed {
+		cpu.registers[rPc] += cpu.instructionSize
+	}
+
+	// Every instruction takes at least a cycle, the rest of its time is
+	// spent waiting on the bus.
+	cycles := cpu.bus.DrainCycles()
+	if cycles < 1 {
+		cycles = 1
+	}
+	cpu.timestamp += uint64(cycles * cpu.clockMultiple)
+}
+
+// refillPipeline fetches the two instructions following a write to r15
+// and leaves r15 pointing past them.
+func (cpu *armCore) refillPipeline() {
+	cpu.bus.SetSequencial(false)
+	if cpu.isFlag(thumbMode) {
+		pc := cpu.registers[rPc] &^ 1
+		cpu.pipeline[0] = cpu.bus.ReadCode16(pc)
+		cpu.bus.SetSequencial(true)
+		cpu.pipeline[1] = cpu.bus.ReadCode16(pc + 2)
+		cpu.registers[rPc] = pc + 4
+	} else {
+		pc := cpu.registers[rPc] &^ 3
+		cpu.pipeline[0] = cpu.bus.ReadCode32(pc)
+		cpu.bus.SetSequencial(true)
+		cpu.pipeline[1] = cpu.bus.ReadCode32(pc + 4)
+		cpu.registers[rPc] = pc + 8
+	}
+	cpu.pipelineFlushed = false
+}
+
+func (cpu *armCore) execute() {
+	instruction := cpu.instruction
+	condition := bits.GetBits(instruction, 31, 28)
+
+	// The NV condition space only encodes BLX with an immediate operand
+	// and, on ARMv5, PLD on the cores we emulate. Everything else there
+	// is undefined.
+	if condition == 0xf {
+		switch {
+		case bits.GetBits(instruction, 27, 25) == 0x5:
+		case instruction&pldMask == pldBits && cpu.architecture >= V5:
+			// PLD is only a hint, there is no cache to preload.
+			return
+		default:
+			undefinedInstruction(cpu)
+			return
+		}
+	} else if !conditions[condition](cpu) {
+		return
+	}
+
+	row := bits.GetBits(instruction, 27, 20)
+	col := bits.GetBits(instruction, 7, 4)
+	handler := armInstructions[row][col]
+	if handler == nil {
+		undefinedInstruction(cpu)
+		return
+	}
+	handler(cpu)
+}
+
+func (cpu *armCore) executeThumb() {
+	handler := thumbInstructions[cpu.instruction>>6]
+	if handler == nil {
+		undefinedInstruction(cpu)
+		return
+	}
+	handler(cpu)
+}
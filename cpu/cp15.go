@@ -0,0 +1,233 @@
+package cpu
+
+import "github.com/damilolarandolph/casper/bits"
+
+// TCMController describes a bus whose tightly coupled memories can be
+// remapped by the system control coprocessor. In load mode a TCM only
+// accepts writes, reads go to the memory behind it.
+type TCMController interface {
+	SetITCM(size uint32, enabled bool, loadMode bool)
+	SetDTCM(base uint32, size uint32, enabled bool, loadMode bool)
+}
+
+const (
+	cp15MainID    uint32 = 0x41059461
+	cp15CacheType uint32 = 0x0f0d2112
+	cp15TCMSize   uint32 = 0x00140180
+)
+
+// Bits of the CP15 control register.
+const (
+	controlMPUEnable    = 0
+	controlDCache       = 2
+	controlICache       = 12
+	controlHighVectors  = 13
+	controlDTCMEnable   = 16
+	controlDTCMLoadMode = 17
+	controlITCMEnable   = 18
+	controlITCMLoadMode = 19
+
+	// Bits 3 - 6 always read as one.
+	controlFixedBits uint32 = 0x00000078
+	controlWriteMask uint32 = 0x000ff085
+	controlReset     uint32 = controlFixedBits | (1 << controlHighVectors)
+)
+
+// cp15 is the ARM946E-S system control coprocessor. It configures the
+// protection unit, the caches, the tightly coupled memories and the
+// exception vector base of the Arm9.
+type cp15 struct {
+	cpu     *armCore
+	tcm     TCMController
+	control uint32
+
+	dataCachable      uint32
+	codeCachable      uint32
+	dataBufferable    uint32
+	dataPermissions   uint32
+	codePermissions   uint32
+	protectionRegions [8]uint32
+	dataLockdown      uint32
+	codeLockdown      uint32
+	dtcmRegion        uint32
+	itcmRegion        uint32
+	processID         uint32
+}
+
+func newCP15(cpu *armCore) *cp15 {
+	cp := &cp15{
+		cpu: cpu,
+	}
+	cp.setControl(controlReset)
+	return cp
+}
+
+// setTCMController connects the bus the TCMs are mapped on and applies
+// the current configuration to it.
+func (cp *cp15) setTCMController(tcm TCMController) {
+	cp.tcm = tcm
+	cp.updateTCM()
+}
+
+func (cp *cp15) setControl(value uint32) {
+	cp.control = (value & controlWriteMask) | controlFixedBits
+	cp.cpu.irqHigh = bits.GetBit(cp.control, controlHighVectors)
+	cp.updateTCM()
+}
+
+// tcmSize decodes the virtual size field of a TCM region register.
+func tcmSize(region uint32) uint32 {
+	return 512 << bits.GetBits(region, 5, 1)
+}
+
+func (cp *cp15) updateTCM() {
+	if cp.tcm == nil {
+		return
+	}
+	// The ITCM base is fixed at address zero on the DS.
+	cp.tcm.SetITCM(
+		tcmSize(cp.itcmRegion),
+		bits.GetBit(cp.control, controlITCMEnable),
+		bits.GetBit(cp.control, controlITCMLoadMode),
+	)
+	cp.tcm.SetDTCM(
+		cp.dtcmRegion&0xfffff000,
+		tcmSize(cp.dtcmRegion),
+		bits.GetBit(cp.control, controlDTCMEnable),
+		bits.GetBit(cp.control, controlDTCMLoadMode),
+	)
+}
+
+// simplePermissions and extendedPermissions convert between the two bit
+// per region and four bit per region access permission formats.
+func simplePermissions(extended uint32) uint32 {
+	var simple uint32
+	for region := 0; region < 8; region++ {
+		simple |= ((extended >> (region * 4)) & 0x3) << (region * 2)
+	}
+	return simple
+}
+
+func extendedPermissions(simple uint32) uint32 {
+	var extended uint32
+	for region := 0; region < 8; region++ {
+		extended |= ((simple >> (region * 2)) & 0x3) << (region * 4)
+	}
+	return extended
+}
+
+func (cp *cp15) Read(opcode1 uint32, crn uint32, crm uint32, opcode2 uint32) uint32 {
+	if opcode1 != 0 {
+		return 0
+	}
+
+	switch crn {
+	case 0:
+		switch opcode2 {
+		case 1:
+			return cp15CacheType
+		case 2:
+			return cp15TCMSize
+		default:
+			return cp15MainID
+		}
+	case 1:
+		return cp.control
+	case 2:
+		if opcode2 == 1 {
+			return cp.codeCachable
+		}
+		return cp.dataCachable
+	case 3:
+		return cp.dataBufferable
+	case 5:
+		switch opcode2 {
+		case 0:
+			return simplePermissions(cp.dataPermissions)
+		case 1:
+			return simplePermissions(cp.codePermissions)
+		case 2:
+			return cp.dataPermissions
+		case 3:
+			return cp.codePermissions
+		}
+	case 6:
+		return cp.protectionRegions[crm&0x7]
+	case 9:
+		if crm == 0 {
+			if opcode2 == 1 {
+				return cp.codeLockdown
+			}
+			return cp.dataLockdown
+		}
+		if crm == 1 {
+			if opcode2 == 1 {
+				return cp.itcmRegion
+			}
+			return cp.dtcmRegion
+		}
+	case 13:
+		return cp.processID
+	}
+	return 0
+}
+
+func (cp *cp15) Write(opcode1 uint32, crn uint32, crm uint32, opcode2 uint32, value uint32) {
+	if opcode1 != 0 {
+		return
+	}
+
+	switch crn {
+	case 1:
+		cp.setControl(value)
+	case 2:
+		if opcode2 == 1 {
+			cp.codeCachable = value & 0xff
+		} else {
+			cp.dataCachable = value & 0xff
+		}
+	case 3:
+		cp.dataBufferable = value & 0xff
+	case 5:
+		switch opcode2 {
+		case 0:
+			cp.dataPermissions = extendedPermissions(value)
+		case 1:
+			cp.codePermissions = extendedPermissions(value)
+		case 2:
+			cp.dataPermissions = value
+		case 3:
+			cp.codePermissions = value
+		}
+	case 6:
+		cp.protectionRegions[crm&0x7] = value & 0xfffff03f
+	case 7:
+		// Cache maintenance has no visible effect since caches aren't
+		// emulated, apart from the wait for interrupt operations.
+		if (crm == 0 && opcode2 == 4) || (crm == 8 && opcode2 == 2) {
+			cp.cpu.Halt()
+		}
+	case 9:
+		if crm == 0 {
+			if opcode2 == 1 {
+				cp.codeLockdown = value
+			} else {
+				cp.dataLockdown = value
+			}
+		}
+		if crm == 1 {
+			if opcode2 == 1 {
+				cp.itcmRegion = value & 0xfffff03e
+			} else {
+				cp.dtcmRegion = value & 0xfffff03e
+			}
+			cp.updateTCM()
+		}
+	case 13:
+		cp.processID = value
+	}
+}
+
+// Operation is a no-op since CP15 defines no CDP operations.
+func (cp *cp15) Operation(opcode1 uint32, crd uint32, crn uint32, crm uint32, opcode2 uint32) {
+}
@@ -64,10 +64,10 @@ var bankedSpsrMap = map[cpuMode]reg{
 type Architecture int
 
 const (
-	// V5 is version 5
-	V5 Architecture = iota
 	// V4 is version 4
-	V4
+	V4 Architecture = iota
+	// V5 is version 5
+	V5
 )
 
 // ArmCPU describes a generic Arm CPU
@@ -88,8 +88,11 @@ type ArmCPU interface {
 	Architecture() Architecture
 	mode() cpuMode
 	setMode(mode cpuMode)
-	WaitForTick()
-	Tick()
-	Run()
+	raiseException(ex exception)
+	softwareInterrupt()
+	coprocessor(number int) Coprocessor
+	RunUntil(deadline func() uint64)
+	Timestamp() uint64
+	Reset()
 	Bus() DataBus
 }
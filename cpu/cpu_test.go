@@ -0,0 +1,111 @@
+package cpu
+
+import "testing"
+
+const (
+	testEntry       = 0x100
+	testMemorySize  = 0x10000
+	testSystemStack = 0x8000
+	testIrqStack    = 0x7f00
+	testSvcStack    = 0x7e00
+)
+
+// testBus is flat memory without wait states, which is enough to run
+// short programs on a core.
+type testBus struct {
+	memory [testMemorySize]uint8
+}
+
+func (bus *testBus) read(address uint32, size uint32) uint32 {
+	var value uint32
+	for index := size; index > 0; index-- {
+		value = value<<8 | uint32(bus.memory[(address+index-1)%testMemorySize])
+	}
+	return value
+}
+
+func (bus *testBus) write(address uint32, size uint32, value uint32) {
+	for index := uint32(0); index < size; index++ {
+		bus.memory[(address+index)%testMemorySize] = uint8(value >> (index * 8))
+	}
+}
+
+func (bus *testBus) ReadData8(address uint32) uint32  { return bus.read(address, 1) }
+func (bus *testBus) ReadData16(address uint32) uint32 { return bus.read(address&^1, 2) }
+func (bus *testBus) ReadData32(address uint32) uint32 { return bus.read(address&^3, 4) }
+func (bus *testBus) ReadCode16(address uint32) uint32 { return bus.read(address&^1, 2) }
+func (bus *testBus) ReadCode32(address uint32) uint32 { return bus.read(address&^3, 4) }
+
+func (bus *testBus) WriteData8(address uint32, value uint32)  { bus.write(address, 1, value) }
+func (bus *testBus) WriteData16(address uint32, value uint32) { bus.write(address&^1, 2, value) }
+func (bus *testBus) WriteData32(address uint32, value uint32) { bus.write(address&^3, 4, value) }
+
+func (bus *testBus) SetSequencial(val bool) {}
+func (bus *testBus) DrainCycles() int       { return 1 }
+
+// newTestCore returns a core of the given architecture in system mode
+// about to execute the ARM opcodes at testEntry.
+func newTestCore(architecture Architecture, program ...uint32) (*armCore, *testBus) {
+	core := newArmCore(architecture, 1)
+	bus := &testBus{}
+	for index, opcode := range program {
+		bus.WriteData32(testEntry+uint32(index*4), opcode)
+	}
+	core.SetBus(bus)
+	core.DirectBoot(testEntry, testSystemStack, testIrqStack, testSvcStack)
+	return &core, bus
+}
+
+// newThumbTestCore returns a core about to execute the Thumb opcodes at
+// testEntry.
+func newThumbTestCore(architecture Architecture, program ...uint16) (*armCore, *testBus) {
+	core, bus := newTestCore(architecture)
+	for index, opcode := range program {
+		bus.WriteData16(testEntry+uint32(index*2), uint32(opcode))
+	}
+	core.setFlag(thumbMode, true)
+	core.setPc(testEntry)
+	return core, bus
+}
+
+// flags returns the condition flags as a string of the set ones, like
+// "NZ".
+func flags(core *armCore) string {
+	var set string
+	for _, flag := range []struct {
+		bit  flag
+		name string
+	}{{negative, "N"}, {zero, "Z"}, {carry, "C"}, {overflow, "V"}} {
+		if core.isFlag(flag.bit) {
+			set += flag.name
+		}
+	}
+	return set
+}
+
+func TestTestBusIsLittleEndian(t *testing.T) {
+	bus := &testBus{}
+	bus.WriteData32(0x10, 0x12345678)
+	if value := bus.ReadData8(0x10); value != 0x78 {
+		t.Errorf("ReadData8 = %#x, want 0x78", value)
+	}
+	if value := bus.ReadData16(0x12); value != 0x1234 {
+		t.Errorf("ReadData16 = %#x, want 0x1234", value)
+	}
+}
+
+func TestRunUntilDeadlineMovesForward(t *testing.T) {
+	nop := uint32(0xe1a00000)
+	core, _ := newTestCore(V4, nop, nop, nop, nop, nop, nop, nop, nop)
+	deadline := uint64(8)
+	core.RunUntil(func() uint64 {
+		// Something done on the second instruction brings the deadline in.
+		if core.Timestamp() == 2 {
+			deadline = 3
+		}
+		return deadline
+	})
+	if timestamp := core.Timestamp(); timestamp != 3 {
+		t.Errorf("timestamp = %d, want the earlier deadline 3", timestamp)
+	}
+}
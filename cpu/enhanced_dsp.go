@@ -10,15 +10,23 @@ const (
 )
 
 func signedSat(lhs int32, rhs int32, upperLimit int32, lowerLimit int32) (int32, bool) {
-	result := lhs + rhs
+	result := int64(lhs) + int64(rhs)
 
-	if (lhs < 0 && rhs > 0) && result >= 0 {
+	if result < int64(lowerLimit) {
 		return lowerLimit, true
-	} else if (lhs > 0 && rhs > 0) && result <= 0 {
+	} else if result > int64(upperLimit) {
 		return upperLimit, true
 	}
 
-	return result, false
+	return int32(result), false
+}
+
+// setSaturated sets the sticky Q flag when saturation occured. It is
+// only ever cleared by writing to the CPSR.
+func setSaturated(cpu ArmCPU, didSat bool) {
+	if didSat {
+		cpu.setFlag(overflowSat, true)
+	}
 }
 
 /*
@@ -33,20 +41,20 @@ func qadd(cpu ArmCPU) {
 	rnVal := int32(cpu.readReg(rn))
 	rmVal := int32(cpu.readReg(rm))
 	result, didSat := signedSat(rmVal, rnVal, _MaxInt, _MinInt)
-	cpu.setFlag(overflowSat, didSat)
+	setSaturated(cpu, didSat)
 	cpu.setReg(rd, uint32(result))
 }
 
 func signedSatM(lhs int32, rhs int32, upperLimit int32, lowerLimit int32) (int32, bool) {
-	result := lhs - rhs
+	result := int64(lhs) - int64(rhs)
 
-	if rhs > 0 && result < lhs {
-		return upperLimit, true
-	} else if rhs < 0 && result > lhs {
+	if result < int64(lowerLimit) {
 		return lowerLimit, true
+	} else if result > int64(upperLimit) {
+		return upperLimit, true
 	}
 
-	return result, false
+	return int32(result), false
 }
 
 /*
@@ -64,7 +72,7 @@ func qdadd(cpu ArmCPU) {
 	rmVal := int32(cpu.readReg(rm))
 	result, didSat := signedSat(rnVal, rnVal, _MaxInt, _MinInt)
 	accResult, accDidSat := signedSat(rmVal, result, _MaxInt, _MinInt)
-	cpu.setFlag(overflowSat, didSat || accDidSat)
+	setSaturated(cpu, didSat || accDidSat)
 	cpu.setReg(rd, uint32(accResult))
 }
 
@@ -85,7 +93,7 @@ func qdsub(cpu ArmCPU) {
 	rmVal := int32(cpu.readReg(rm))
 	result, didSat := signedSat(rnVal, rnVal, _MaxInt, _MinInt)
 	accResult, accDidSat := signedSatM(rmVal, result, _MaxInt, _MinInt)
-	cpu.setFlag(overflowSat, didSat || accDidSat)
+	setSaturated(cpu, didSat || accDidSat)
 	cpu.setReg(rd, uint32(accResult))
 }
 
@@ -96,7 +104,7 @@ func qsub(cpu ArmCPU) {
 	rnVal := int32(cpu.readReg(rn))
 	rmVal := int32(cpu.readReg(rm))
 	result, didSat := signedSatM(rmVal, rnVal, _MaxInt, _MinInt)
-	cpu.setFlag(overflowSat, didSat)
+	setSaturated(cpu, didSat)
 	cpu.setReg(rd, uint32(result))
 }
 
@@ -132,9 +140,7 @@ func smlaXY(cpu ArmCPU) {
 
 	result := (int32(rmVal) * int32(rsVal)) + int32(rnVal)
 	cpu.setReg(rd, uint32(result))
-	if didSignOverflow(uint32(int32(rmVal)*int32(rsVal)), rnVal) {
-		cpu.setFlag(overflowSat, true)
-	}
+	setSaturated(cpu, didSignOverflow(uint32(int32(rmVal)*int32(rsVal)), rnVal))
 }
 
 func smlalXY(cpu ArmCPU) {
@@ -169,7 +175,7 @@ func smlalXY(cpu ArmCPU) {
 		bits.ShiftRightSigned(&operand2, 16)
 	}
 
-	rdLowHi := (int64(rdVal) << 32 & int64(rnVal)) + (int64(int32(operand1)) * int64(int32(operand2)))
+	rdLowHi := int64(uint64(rdVal)<<32|uint64(rnVal)) + (int64(int32(operand1)) * int64(int32(operand2)))
 	cpu.setReg(rd, uint32(rdLowHi>>32))
 	cpu.setReg(rn, uint32(rdLowHi))
 }
@@ -195,10 +201,10 @@ func smlawY(cpu ArmCPU) {
 		operand2 <<= 16
 		bits.ShiftRightSigned(&operand2, 16)
 	}
-	prod48 := ((int64(rmVal) * int64(operand2)) >> 16) & 0xffffffff
+	prod48 := (int64(int32(rmVal)) * int64(int32(operand2))) >> 16
 	result := int32(prod48) + int32(rnVal)
 	cpu.setReg(rd, uint32(result))
-	cpu.setFlag(overflowSat, didSignOverflow(uint32(prod48), uint32(rnVal)))
+	setSaturated(cpu, didSignOverflow(uint32(prod48), uint32(rnVal)))
 }
 
 func smulXY(cpu ArmCPU) {
@@ -252,28 +258,38 @@ func smulwY(cpu ArmCPU) {
 		operand2 <<= 16
 		bits.ShiftRightSigned(&operand2, 16)
 	}
-	prod48 := ((int64(rmVal) * int64(operand2)) >> 16)
+	prod48 := (int64(int32(rmVal)) * int64(int32(operand2))) >> 16
 	result := uint32(prod48)
 	cpu.setReg(rd, result)
 }
 
+// evenRegisterPair wraps LDRD and STRD, whose register pair has to start
+// at an even register. An odd one is unpredictable on the ARM946E-S and
+// raises undefined here, before the base register is written back.
+func evenRegisterPair(handler instructionHandler) instructionHandler {
+	return func(cpu ArmCPU) {
+		if bits.GetBit(cpu.currentInstruction(), 12) {
+			undefinedInstruction(cpu)
+			return
+		}
+		handler(cpu)
+	}
+}
+
 func ldrd(cpu ArmCPU, address uint32) {
 	instruction := cpu.currentInstruction()
 	rd := reg(bits.GetBits(instruction, 15, 12))
-
-	if (rd%2) == 0 && (address&7) == 0 && rd != r14 {
-		cpu.setReg(rd, cpu.Bus().ReadData32(address))
-		cpu.setReg(rd+1, cpu.Bus().ReadData32(address))
-	}
-
+	cpu.Bus().SetSequencial(false)
+	cpu.setReg(rd, cpu.Bus().ReadData32(address))
+	cpu.Bus().SetSequencial(true)
+	cpu.setReg(rd+1, cpu.Bus().ReadData32(address+4))
 }
 
 func strd(cpu ArmCPU, address uint32) {
 	instruction := cpu.currentInstruction()
 	rd := reg(bits.GetBits(instruction, 15, 12))
-
-	if (rd%2) == 0 && (address&7) == 0 && rd != r14 {
-		cpu.Bus().WriteData32(address, cpu.readReg(rd))
-		cpu.Bus().WriteData32(address+4, cpu.readReg(rd+1))
-	}
+	cpu.Bus().SetSequencial(false)
+	cpu.Bus().WriteData32(address, cpu.readReg(rd))
+	cpu.Bus().SetSequencial(true)
+	cpu.Bus().WriteData32(address+4, cpu.readReg(rd+1))
 }
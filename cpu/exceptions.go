@@ -12,6 +12,12 @@ type exception struct {
 	mode          cpuMode
 	normalAddress uint32
 	highAddress   uint32
+	// returnOffset is added to the address of the instruction the
+	// exception was taken on to form the return address saved in LR.
+	returnOffset uint32
+	// returnsToNext exceptions save the address of the instruction after
+	// the one that raised them instead, in either instruction set.
+	returnsToNext bool
 }
 
 func (e exception) equals(to comparable) bool {
@@ -32,6 +38,7 @@ var (
 		mode:          undefined,
 		normalAddress: 0x00000004,
 		highAddress:   0xFFFF0004,
+		returnsToNext: true,
 	}
 
 	// SwiEx is a Software Exception
@@ -39,6 +46,7 @@ var (
 		mode:          supervisor,
 		normalAddress: 0x00000008,
 		highAddress:   0xFFFF0008,
+		returnsToNext: true,
 	}
 
 	// PrefetchAbtEx is a Prefetch Abort Exception
@@ -46,6 +54,7 @@ var (
 		mode:          abort,
 		normalAddress: 0x0000000C,
 		highAddress:   0xFFFF000C,
+		returnOffset:  4,
 	}
 
 	// DataAbtEx is a Data Abort Exception
@@ -53,6 +62,7 @@ var (
 		mode:          abort,
 		normalAddress: 0x00000010,
 		highAddress:   0xFFFF0010,
+		returnOffset:  8,
 	}
 
 	// IrqEx is an Interrupt Exception
@@ -60,6 +70,7 @@ var (
 		mode:          irq,
 		normalAddress: 0x00000018,
 		highAddress:   0xFFFF0018,
+		returnOffset:  4,
 	}
 
 	// FiqEx is a Fast Interrupt Exception
@@ -67,28 +78,79 @@ var (
 		mode:          fiq,
 		normalAddress: 0x0000001C,
 		highAddress:   0xFFFF001C,
+		returnOffset:  4,
 	}
 )
 
-func (cpu *Arm7) highIrqVectors() bool {
-	return cpu.irqHigh
+// InterruptLine is the IRQ input of a CPU, usually driven by an
+// interrupt controller.
+type InterruptLine interface {
+	// Asserted reports whether an enabled interrupt has been requested,
+	// which wakes a halted CPU.
+	Asserted() bool
+	// Pending reports whether the CPU should take an IRQ exception.
+	Pending() bool
 }
 
-func (cpu *Arm7) requestInterrupt(ex exception) {
+// checkInterrupts runs at instruction boundaries and enters IRQ mode when
+// the interrupt line is pending and the CPSR doesn't mask IRQs.
+func (cpu *armCore) checkInterrupts() {
+	if cpu.interrupts == nil {
+		return
+	}
+	if cpu.halted && cpu.interrupts.Asserted() {
+		cpu.halted = false
+	}
+	if cpu.interrupts.Pending() {
+		cpu.requestInterrupt(IrqEx)
+	}
+}
 
+func (cpu *armCore) highIrqVectors() bool {
+	return cpu.irqHigh
+}
+
+// requestInterrupt takes an IRQ or FIQ at the current instruction
+// boundary unless the CPSR masks it.
+func (cpu *armCore) requestInterrupt(ex exception) {
 	if compare(ex, FiqEx) && cpu.isFlag(fiqDisable) {
 		return
 	}
 
-	if cpu.isFlag(irqDisable) {
+	if compare(ex, IrqEx) && cpu.isFlag(irqDisable) {
 		return
 	}
-	cpu.setMode(supervisor)
-	cpu.setFlag(thumbMode, true)
+
+	cpu.enterException(ex, cpu.nextExecuteAddress())
+}
+
+// raiseException takes an exception caused by the executing instruction.
+func (cpu *armCore) raiseException(ex exception) {
+	cpu.enterException(ex, cpu.instructionAddr)
+}
+
+// enterException switches to the exception's mode, saving the CPSR in its
+// SPSR and the return address for the instruction at address in its LR,
+// then jumps to the exception vector in ARM state.
+func (cpu *armCore) enterException(ex exception, address uint32) {
+	returnAddress := address + ex.returnOffset
+	if ex.returnsToNext {
+		returnAddress = address + cpu.instructionSize
+	}
+
+	cpsr := cpu.readCpsr()
+	cpu.setMode(ex.mode)
+	cpu.setSpsr(cpsr)
+	if !compare(ex, ResetEx) {
+		cpu.setReg(r14, returnAddress)
+	}
+
+	cpu.setFlag(thumbMode, false)
 	if compare(ex, ResetEx) || compare(ex, FiqEx) {
 		cpu.setFlag(fiqDisable, true)
 	}
 	cpu.setFlag(irqDisable, true)
+	cpu.halted = false
 
 	if cpu.irqHigh {
 		cpu.setPc(ex.highAddress)
@@ -97,3 +159,34 @@ func (cpu *Arm7) requestInterrupt(ex exception) {
 
 	cpu.setPc(ex.normalAddress)
 }
+
+// nextExecuteAddress returns the address of the instruction that will be
+// executed next, which interrupts return to.
+func (cpu *armCore) nextExecuteAddress() uint32 {
+	if cpu.pipelineFlushed {
+		return cpu.registers[rPc]
+	}
+	if cpu.isFlag(thumbMode) {
+		return cpu.registers[rPc] - 4
+	}
+	return cpu.registers[rPc] - 8
+}
+
+// Software interrupt (SWI).
+func swi(cpu ArmCPU) {
+	cpu.softwareInterrupt()
+}
+
+// Breakpoint (BKPT), which raises a prefetch abort.
+func bkpt(cpu ArmCPU) {
+	cpu.raiseException(PrefetchAbtEx)
+}
+
+func undefinedInstruction(cpu ArmCPU) {
+	cpu.raiseException(UndInstrEx)
+}
+
+func emitUndefinedOpcode(row int, col int) bool {
+	armInstructions[row][col] = undefinedInstruction
+	return true
+}
@@ -0,0 +1,104 @@
+package cpu
+
+import "testing"
+
+type testInterruptLine struct {
+	pending bool
+}
+
+func (line *testInterruptLine) Asserted() bool { return line.pending }
+func (line *testInterruptLine) Pending() bool  { return line.pending }
+
+func TestExceptionEntry(t *testing.T) {
+	tests := []struct {
+		name         string
+		architecture Architecture
+		opcode       uint32
+		mode         cpuMode
+		vector       uint32
+		lr           uint32
+	}{
+		{"swi", V4, 0xef000000, supervisor, 0x08, testEntry + 4},
+		{"undefined", V4, 0xe7f000f0, undefined, 0x04, testEntry + 4},
+		{"bkpt", V5, 0xe1200070, abort, 0x0c, testEntry + 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, _ := newTestCore(test.architecture, test.opcode)
+			cpsr := core.readCpsr()
+			core.Step()
+			if core.mode() != test.mode {
+				t.Errorf("mode = %#x, want %#x", core.mode(), test.mode)
+			}
+			if got := core.registers[rPc]; got != test.vector {
+				t.Errorf("pc = %#x, want %#x", got, test.vector)
+			}
+			if got := core.readReg(r14); got != test.lr {
+				t.Errorf("lr = %#x, want %#x", got, test.lr)
+			}
+			if got := core.readSpsr(); got != cpsr {
+				t.Errorf("spsr = %#x, want %#x", got, cpsr)
+			}
+			if !core.isFlag(irqDisable) {
+				t.Error("IRQs left enabled")
+			}
+		})
+	}
+}
+
+func TestInterruptReturnAddress(t *testing.T) {
+	// mov r0, r0; mov r0, r0
+	core, _ := newTestCore(V4, 0xe1a00000, 0xe1a00000)
+	line := &testInterruptLine{}
+	core.SetInterruptLine(line)
+	core.setFlag(irqDisable, false)
+	core.Step()
+
+	line.pending = true
+	core.Step()
+	if core.mode() != irq {
+		t.Fatalf("mode = %#x, want irq", core.mode())
+	}
+	// subs pc, lr, #4 returns to the instruction that was about to run.
+	if got := core.readReg(r14); got != testEntry+4+4 {
+		t.Errorf("lr = %#x, want %#x", got, testEntry+8)
+	}
+	// The IRQ is taken at the start of the step, which then runs the
+	// vector.
+	if got := core.instructionAddr; got != 0x18 {
+		t.Errorf("executed %#x, want the vector at 0x18", got)
+	}
+}
+
+func TestReturnFromSoftwareInterrupt(t *testing.T) {
+	core, bus := newTestCore(V4, 0xef000000)
+	// movs pc, lr
+	bus.WriteData32(0x08, 0xe1b0f00e)
+	cpsr := core.readCpsr()
+	core.Step()
+	core.Step()
+	if core.readCpsr() != cpsr {
+		t.Errorf("cpsr = %#x, want %#x", core.readCpsr(), cpsr)
+	}
+	if got := core.registers[rPc]; got != testEntry+4 {
+		t.Errorf("pc = %#x, want %#x", got, testEntry+4)
+	}
+}
+
+func TestHaltWakesOnInterrupt(t *testing.T) {
+	core, _ := newTestCore(V4, 0xe1a00000)
+	line := &testInterruptLine{}
+	core.SetInterruptLine(line)
+	core.Halt()
+	core.RunUntil(func() uint64 { return 10 })
+	if !core.Halted() {
+		t.Fatal("halted core woke without an interrupt")
+	}
+
+	// IRQs are masked, so the core resumes without taking the exception.
+	line.pending = true
+	core.Step()
+	if core.Halted() || core.mode() != system {
+		t.Errorf("halted = %v, mode = %#x", core.Halted(), core.mode())
+	}
+}
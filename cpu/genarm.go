@@ -1,9 +1,6 @@
 package cpu
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/damilolarandolph/casper/bits"
 )
 
@@ -31,32 +28,15 @@ type MaskTarget struct {
 }
 
 func (gen *Generator) start() {
-	foundLog, _ := os.Create("foundlog.txt")
-	notFoundLog, _ := os.Create("notfoundlog.txt")
-	defer foundLog.Close()
-	defer notFoundLog.Close()
-	foundHandlers := 0
 	for row := 0; row <= gen.rows; row++ {
-		var found bool
 		for col := 0; col <= gen.cols; col++ {
-
 			for _, item := range gen.maskTargets {
-				found = item.tryRun(row, col)
-				if found {
-					foundHandlers++
-					fmt.Fprintln(foundLog, "Found handler", item.name, "for row", row, "coloumn", col)
+				if item.tryRun(row, col) {
 					break
 				}
 			}
-
-			if !found {
-
-				fmt.Fprintln(notFoundLog, "No handler for row", row, "column", col)
-			}
 		}
 	}
-
-	fmt.Println("Found: ", foundHandlers, "Didn't find: ", (gen.rows*gen.cols)-foundHandlers)
 }
 
 func (target *MaskTarget) tryRun(row int, col int) bool {
@@ -347,26 +327,16 @@ func emitMSRImmOpcode(row int, col int) bool {
 func emitBranchLinkOpcode(row int, col int) bool {
 
 	opcodeFrag := (uint32(row) << 20) | (uint32(col) << 4)
-	opcode := bits.GetBit(opcodeFrag, 24)
+	withLink := bits.GetBit(opcodeFrag, 24)
+	exchange := v5Only(blxImm)
 
-	if opcode {
-		armInstructions[row][col] = func(cpu ArmCPU) {
-			condition := bits.GetBits(cpu.currentInstruction(), 31, 28)
-			if condition == 0xf {
-				blxImm(cpu)
-				return
-			}
-			branch(false, cpu)
-		}
-	} else {
-		armInstructions[row][col] = func(cpu ArmCPU) {
-			condition := bits.GetBits(cpu.currentInstruction(), 31, 28)
-			if condition == 0xf {
-				blxImm(cpu)
-				return
-			}
-			branch(true, cpu)
+	armInstructions[row][col] = func(cpu ArmCPU) {
+		condition := bits.GetBits(cpu.currentInstruction(), 31, 28)
+		if condition == 0xf {
+			exchange(cpu)
+			return
 		}
+		branch(withLink, cpu)
 	}
 	return true
 }
@@ -380,13 +350,13 @@ func emitBranchExchangeOpcode(row int, col int) bool {
 
 func emitCLZOpcode(row int, col int) bool {
 
-	armInstructions[row][col] = clz
+	armInstructions[row][col] = v5Only(clz)
 
 	return true
 }
 
 func emitBLXRegOpcode(row int, col int) bool {
-	armInstructions[row][col] = blxReg
+	armInstructions[row][col] = v5Only(blxReg)
 	return true
 }
 
@@ -395,13 +365,13 @@ func emitDSPAddSub(row int, col int) bool {
 	opcodeFrag := (uint32(row) << 20) | (uint32(col) << 4)
 	op := bits.GetBits(opcodeFrag, 23, 20)
 	if op == 0 {
-		armInstructions[row][col] = qadd
+		armInstructions[row][col] = v5Only(qadd)
 	} else if op == 2 {
-		armInstructions[row][col] = qsub
+		armInstructions[row][col] = v5Only(qsub)
 	} else if op == 4 {
-		armInstructions[row][col] = qdadd
+		armInstructions[row][col] = v5Only(qdadd)
 	} else if op == 6 {
-		armInstructions[row][col] = qdsub
+		armInstructions[row][col] = v5Only(qdsub)
 	} else {
 		return false
 	}
@@ -414,18 +384,18 @@ func emitDSPMultiplyOpcodes(row int, col int) bool {
 
 	switch op {
 	case 8:
-		armInstructions[row][col] = smlaXY
+		armInstructions[row][col] = v5Only(smlaXY)
 	case 9:
 		if bits.GetBit(opcodeFrag, 5) {
-			armInstructions[row][col] = smulwY
+			armInstructions[row][col] = v5Only(smulwY)
 		} else {
-			armInstructions[row][col] = smlawY
+			armInstructions[row][col] = v5Only(smlawY)
 		}
 
 	case 10:
-		armInstructions[row][col] = smlalXY
+		armInstructions[row][col] = v5Only(smlalXY)
 	case 11:
-		armInstructions[row][col] = smulXY
+		armInstructions[row][col] = v5Only(smulXY)
 	default:
 		return false
 	}
@@ -497,11 +467,11 @@ func emitLdrStrHReg(row int, col int) bool {
 
 	if L {
 		armInstructions[row][col] = func(cpu ArmCPU) {
-			ldrh(cpu, scaledRegOff(cpu, indexingFunc))
+			ldrh(cpu, regOffLdStr(cpu, indexingFunc))
 		}
 	} else {
 		armInstructions[row][col] = func(cpu ArmCPU) {
-			strh(cpu, scaledRegOff(cpu, indexingFunc))
+			strh(cpu, regOffLdStr(cpu, indexingFunc))
 		}
 	}
 
@@ -543,13 +513,13 @@ func emitLdrStrDReg(row int, col int) bool {
 	}
 
 	if !S {
-		armInstructions[row][col] = func(cpu ArmCPU) {
-			ldrd(cpu, scaledRegOff(cpu, indexingFunc))
-		}
+		armInstructions[row][col] = v5Only(evenRegisterPair(func(cpu ArmCPU) {
+			ldrd(cpu, regOffLdStr(cpu, indexingFunc))
+		}))
 	} else {
-		armInstructions[row][col] = func(cpu ArmCPU) {
-			strd(cpu, scaledRegOff(cpu, indexingFunc))
-		}
+		armInstructions[row][col] = v5Only(evenRegisterPair(func(cpu ArmCPU) {
+			strd(cpu, regOffLdStr(cpu, indexingFunc))
+		}))
 	}
 
 	return true
@@ -568,13 +538,13 @@ func emitLdrStrDImm(row int, col int) bool {
 	}
 
 	if !S {
-		armInstructions[row][col] = func(cpu ArmCPU) {
+		armInstructions[row][col] = v5Only(evenRegisterPair(func(cpu ArmCPU) {
 			ldrd(cpu, miscImmOffLdStr(cpu, indexingFunc))
-		}
+		}))
 	} else {
-		armInstructions[row][col] = func(cpu ArmCPU) {
+		armInstructions[row][col] = v5Only(evenRegisterPair(func(cpu ArmCPU) {
 			strd(cpu, miscImmOffLdStr(cpu, indexingFunc))
-		}
+		}))
 	}
 
 	return true
@@ -593,11 +563,11 @@ func emitLdrSBReg(row int, col int) bool {
 	}
 	if H {
 		armInstructions[row][col] = func(cpu ArmCPU) {
-			ldrsh(cpu, scaledRegOff(cpu, indexingFunc))
+			ldrsh(cpu, regOffLdStr(cpu, indexingFunc))
 		}
 	} else {
 		armInstructions[row][col] = func(cpu ArmCPU) {
-			ldrsb(cpu, scaledRegOff(cpu, indexingFunc))
+			ldrsb(cpu, regOffLdStr(cpu, indexingFunc))
 		}
 	}
 
@@ -868,9 +838,11 @@ func emitLdrStrMOpcode(row int, col int) bool {
 	}
 
 	armInstructions[row][col] = func(cpu ArmCPU) {
-		condition := bits.GetBits(cpu.currentInstruction(), 31, 28)
-		if condition == 0xf {
-			fmt.Println("Undefined Instruction NV ldm/stm")
+		instruction := cpu.currentInstruction()
+		// An empty register list is unpredictable, the game is told
+		// through the undefined instruction trap.
+		if bits.GetBits(instruction, 15, 0) == 0 {
+			undefinedInstruction(cpu)
 			return
 		}
 		startAddress, endAddress := addressing(cpu)
@@ -884,7 +856,7 @@ func emitLdrStrMOpcode(row int, col int) bool {
 func init() {
 	gen := NewGenerator(0xff, 0xf)
 	gen.AddMaskTarget(MaskTarget{
-		rowMask: "000xxxx0",
+		rowMask: "000xxxxx",
 		name:    "Data processing immediate shift",
 		colMask: "xxx0",
 		handler: emitDataProcessImmShift,
@@ -936,10 +908,10 @@ func init() {
 
 	gen.AddMaskTarget(MaskTarget{
 		rowMask: "00010010",
-		name:    "Software Interrupt",
+		name:    "Breakpoint",
 		colMask: "0111",
 		handler: func(row int, col int) bool {
-			//TODO implement software interrupts
+			armInstructions[row][col] = v5Only(bkpt)
 			return true
 		},
 	})
@@ -947,7 +919,7 @@ func init() {
 	gen.AddMaskTarget(MaskTarget{
 		name:    "Enchanced DSP Multiplies",
 		rowMask: "00010xx0",
-		colMask: "0111",
+		colMask: "1xx0",
 		handler: emitDSPMultiplyOpcodes,
 	})
 
@@ -1009,7 +981,7 @@ func init() {
 		name:    "Load signed halfword/byte immediate offset",
 		rowMask: "000xx1x1",
 		colMask: "11x1",
-		handler: emitLdrStrDImm,
+		handler: emitLdrSBImm,
 	})
 
 	gen.AddMaskTarget(MaskTarget{
@@ -1023,9 +995,7 @@ func init() {
 		name:    "Undefined Instruction",
 		rowMask: "00110x00",
 		colMask: "xxxx",
-		handler: func(row int, col int) bool {
-			return true
-		},
+		handler: emitUndefinedOpcode,
 	})
 	gen.AddMaskTarget(MaskTarget{
 		name:    "Move immediate to status register",
@@ -1052,23 +1022,14 @@ func init() {
 		name:    "Undefined Instruction",
 		rowMask: "011xxxxx",
 		colMask: "xxx1",
-		handler: func(row int, col int) bool {
-			armInstructions[row][col] = func(cpu ArmCPU) {
-				if bits.GetBits(cpu.currentInstruction(), 31, 28) == 0xf {
-					fmt.Println("Undefined Instruction with NV")
-				}
-			}
-			return true
-		},
+		handler: emitUndefinedOpcode,
 	})
 
 	gen.AddMaskTarget(MaskTarget{
 		name:    "Undefined Instruction",
 		rowMask: "0xxxxxxx",
 		colMask: "xxxx",
-		handler: func(row int, col int) bool {
-			return true
-		},
+		handler: emitUndefinedOpcode,
 	})
 
 	gen.AddMaskTarget(MaskTarget{
@@ -1085,5 +1046,37 @@ func init() {
 		handler: emitBranchLinkOpcode,
 	})
 
+	gen.AddMaskTarget(MaskTarget{
+		name:    "Coprocessor data processing",
+		rowMask: "1110xxxx",
+		colMask: "xxx0",
+		handler: emitCoprocessorDataOpcode,
+	})
+
+	gen.AddMaskTarget(MaskTarget{
+		name:    "Coprocessor register transfers",
+		rowMask: "1110xxxx",
+		colMask: "xxx1",
+		handler: emitCoprocessorRegOpcode,
+	})
+
+	// None of the DS coprocessors support coprocessor loads and stores.
+	gen.AddMaskTarget(MaskTarget{
+		name:    "Coprocessor load/store",
+		rowMask: "110xxxxx",
+		colMask: "xxxx",
+		handler: emitUndefinedOpcode,
+	})
+
+	gen.AddMaskTarget(MaskTarget{
+		name:    "Software Interrupt",
+		rowMask: "1111xxxx",
+		colMask: "xxxx",
+		handler: func(row int, col int) bool {
+			armInstructions[row][col] = swi
+			return true
+		},
+	})
+
 	gen.start()
 }
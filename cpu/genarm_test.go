@@ -0,0 +1,84 @@
+package cpu
+
+import "testing"
+
+// Data processing instructions with S set and a register operand shifted
+// by an immediate used to decode to nothing, leaving the flags untouched.
+func TestDataProcessingImmediateShiftSetsFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		opcode uint32
+		r1, r2 uint32
+		r0     uint32
+		flags  string
+	}{
+		{"cmp less", 0xe1510002, 1, 2, 0, "N"},
+		{"cmp equal", 0xe1510002, 5, 5, 0, "ZC"},
+		{"tst", 0xe1110002, 0xf0, 0x0f, 0, "Z"},
+		{"teq", 0xe1310002, 0x80000000, 0, 0, "N"},
+		{"cmn", 0xe1710002, 0xffffffff, 1, 0, "ZC"},
+		{"movs lsl", 0xe1b00081, 0x80000001, 0, 2, "C"},
+		{"adds overflow", 0xe0910002, 0x7fffffff, 1, 0x80000000, "NV"},
+		{"subs", 0xe0510002, 3, 3, 0, "ZC"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, _ := newTestCore(V4, test.opcode)
+			core.setReg(r1, test.r1)
+			core.setReg(r2, test.r2)
+			core.Step()
+			if got := core.readReg(r0); got != test.r0 {
+				t.Errorf("r0 = %#x, want %#x", got, test.r0)
+			}
+			if got := flags(core); got != test.flags {
+				t.Errorf("flags = %q, want %q", got, test.flags)
+			}
+		})
+	}
+}
+
+func TestEmptyRegisterListIsUndefined(t *testing.T) {
+	// ldmia r0, {}
+	core, _ := newTestCore(V4, 0xe8900000)
+	core.Step()
+	if core.mode() != undefined {
+		t.Errorf("mode = %#x, want undefined", core.mode())
+	}
+}
+
+func TestNeverConditionIsUndefined(t *testing.T) {
+	tests := []struct {
+		name   string
+		arch   Architecture
+		opcode uint32
+	}{
+		{"data processing", V4, 0xf0000000},
+		{"ldmia", V5, 0xf8900001},
+		{"swi", V5, 0xff000000},
+		{"pld on v4", V4, 0xf5d0f000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, _ := newTestCore(test.arch, test.opcode)
+			core.Step()
+			if core.mode() != undefined {
+				t.Fatalf("mode = %#x, want undefined", core.mode())
+			}
+			if got := core.readReg(r14); got != testEntry+4 {
+				t.Errorf("lr = %#x, want %#x", got, testEntry+4)
+			}
+		})
+	}
+}
+
+func TestPreloadIsIgnored(t *testing.T) {
+	// pld [r0, #0x10]
+	core, _ := newTestCore(V5, 0xf5d0f010)
+	core.Step()
+	if core.mode() != system {
+		t.Errorf("mode = %#x, want system", core.mode())
+	}
+	if got := core.instructionAddr; got != testEntry {
+		t.Errorf("executed %#x, want %#x", got, testEntry)
+	}
+}
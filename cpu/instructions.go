@@ -6,6 +6,12 @@ type instructionHandler func(cpu ArmCPU)
 
 var armInstructions = [0xff + 1][0xf + 1]instructionHandler{}
 
+// PLD, 1111 01x1 x101 xxxx 1111, in the NV condition space.
+const (
+	pldMask uint32 = 0xfd70f000
+	pldBits uint32 = 0xf550f000
+)
+
 type condition func(cpu ArmCPU) bool
 
 var conditions = []condition{
@@ -37,7 +43,7 @@ var conditions = []condition{
 	func(cpu ArmCPU) bool { return cpu.isFlag(carry) && !cpu.isFlag(zero) },
 
 	//unsigned lower or same - LS
-	func(cpu ArmCPU) bool { return !cpu.isFlag(carry) && cpu.isFlag(zero) },
+	func(cpu ArmCPU) bool { return !cpu.isFlag(carry) || cpu.isFlag(zero) },
 
 	//signed greater or equal - GE
 	func(cpu ArmCPU) bool { return cpu.isFlag(negative) == cpu.isFlag(overflow) },
@@ -49,7 +55,7 @@ var conditions = []condition{
 	func(cpu ArmCPU) bool { return !cpu.isFlag(zero) && (cpu.isFlag(negative) == cpu.isFlag(overflow)) },
 
 	//signed less or equal - LE
-	func(cpu ArmCPU) bool { return !cpu.isFlag(zero) || (cpu.isFlag(negative) != cpu.isFlag(overflow)) },
+	func(cpu ArmCPU) bool { return cpu.isFlag(zero) || (cpu.isFlag(negative) != cpu.isFlag(overflow)) },
 
 	//always - AL
 	func(cpu ArmCPU) bool { return true },
@@ -66,6 +72,18 @@ func populateInstruction(rows ...int) func(...int) func(instructionHandler) {
 	}
 }
 
+// v5Only wraps a handler for an instruction that was introduced in
+// ARMv5 so that it is undefined on older cores.
+func v5Only(handler instructionHandler) instructionHandler {
+	return func(cpu ArmCPU) {
+		if cpu.Architecture() < V5 {
+			undefinedInstruction(cpu)
+			return
+		}
+		handler(cpu)
+	}
+}
+
 func testCondition(cpu ArmCPU) bool {
 	instruction := cpu.currentInstruction()
 	return conditions[bits.GetBits(instruction, 31, 28)](cpu)
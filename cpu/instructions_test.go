@@ -0,0 +1,143 @@
+package cpu
+
+import "testing"
+
+// v5Opcodes are ARMv5TE instructions the Arm7 doesn't implement.
+var v5Opcodes = []struct {
+	name   string
+	opcode uint32
+}{
+	{"blx register", 0xe12fff31},
+	{"blx immediate", 0xfa000000},
+	{"clz", 0xe16f0f11},
+	{"qadd", 0xe1020051},
+	{"smlabb", 0xe1003281},
+	{"ldrd", 0xe1c020d0},
+	{"strd", 0xe1c020f0},
+	{"bkpt", 0xe1200070},
+}
+
+func TestV5InstructionsAreUndefinedOnV4(t *testing.T) {
+	for _, test := range v5Opcodes {
+		t.Run(test.name, func(t *testing.T) {
+			core, _ := newTestCore(V4, test.opcode)
+			core.Step()
+			if core.mode() != undefined {
+				t.Fatalf("mode = %#x, want undefined", core.mode())
+			}
+			if got := core.readReg(r14); got != testEntry+4 {
+				t.Errorf("lr = %#x, want %#x", got, testEntry+4)
+			}
+		})
+	}
+}
+
+func TestCountLeadingZeros(t *testing.T) {
+	tests := []struct {
+		value uint32
+		count uint32
+	}{
+		{0, 32},
+		{1, 31},
+		{0x00010000, 15},
+		{0x80000000, 0},
+	}
+	for _, test := range tests {
+		// clz r0, r1
+		core, _ := newTestCore(V5, 0xe16f0f11)
+		core.setReg(r1, test.value)
+		core.Step()
+		if got := core.readReg(r0); got != test.count {
+			t.Errorf("clz(%#x) = %d, want %d", test.value, got, test.count)
+		}
+	}
+}
+
+func TestLoadStoreDoubleword(t *testing.T) {
+	// strd r2, [r0]; ldrd r4, [r0]
+	core, bus := newTestCore(V5, 0xe1c020f0, 0xe1c040d0)
+	core.setReg(r0, 0x1000)
+	core.setReg(r2, 0x11111111)
+	core.setReg(r3, 0x22222222)
+	core.Step()
+	core.Step()
+	if got := bus.ReadData32(0x1004); got != 0x22222222 {
+		t.Errorf("second word = %#x, want 0x22222222", got)
+	}
+	if core.readReg(r4) != 0x11111111 || core.readReg(r5) != 0x22222222 {
+		t.Errorf("r4, r5 = %#x, %#x", core.readReg(r4), core.readReg(r5))
+	}
+}
+
+func TestOddDoublewordRegisterIsUndefined(t *testing.T) {
+	tests := []struct {
+		name   string
+		opcode uint32
+	}{
+		// ldrd r3, [r0, #8]!
+		{"ldrd", 0xe1e030d8},
+		// strd r3, [r0, #8]!
+		{"strd", 0xe1e030f8},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, _ := newTestCore(V5, test.opcode)
+			core.setReg(r0, 0x1000)
+			core.Step()
+			if core.mode() != undefined {
+				t.Fatalf("mode = %#x, want undefined", core.mode())
+			}
+			core.setMode(system)
+			if got := core.readReg(r0); got != 0x1000 {
+				t.Errorf("base written back to %#x", got)
+			}
+		})
+	}
+}
+
+// recordingCoprocessor keeps the last value written to it.
+type recordingCoprocessor struct {
+	written uint32
+}
+
+func (cp *recordingCoprocessor) Read(opcode1 uint32, crn uint32, crm uint32, opcode2 uint32) uint32 {
+	return 0x12345678
+}
+
+func (cp *recordingCoprocessor) Write(opcode1 uint32, crn uint32, crm uint32, opcode2 uint32, value uint32) {
+	cp.written = value
+}
+
+func (cp *recordingCoprocessor) Operation(opcode1 uint32, crd uint32, crn uint32, crm uint32, opcode2 uint32) {
+}
+
+func TestSystemControlIsPrivileged(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   cpuMode
+		opcode uint32
+	}{
+		// mcr p15, 0, r0, c1, c0, 0
+		{"mcr", user, 0xee010f10},
+		// mrc p15, 0, r0, c1, c0, 0
+		{"mrc", user, 0xee110f10},
+		{"mcr", system, 0xee010f10},
+		{"mrc", system, 0xee110f10},
+	}
+	for _, test := range tests {
+		cp := &recordingCoprocessor{}
+		core, _ := newTestCore(V5, test.opcode)
+		core.SetCoprocessor(15, cp)
+		core.setMode(test.mode)
+		core.setReg(r0, 0xcafe)
+		core.Step()
+
+		trapped := core.mode() == undefined
+		if trapped != (test.mode == user) {
+			t.Errorf("%s in mode %#x: undefined = %v", test.name, test.mode, trapped)
+		}
+		if trapped && (cp.written != 0 || core.readReg(r0) != 0xcafe) {
+			t.Errorf("%s in user mode reached the coprocessor", test.name)
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package cpu
 
 import (
-	"fmt"
-
 	"github.com/damilolarandolph/casper/bits"
 )
 
@@ -167,18 +165,19 @@ func ldStrMullDecBefore(cpu ArmCPU) (uint32, uint32) {
 	return startAddress, endAddress
 }
 
+// readWordRotated performs a word load the way the ARM does for
+// unaligned addresses, rotating the addressed byte into the low bits.
+func readWordRotated(cpu ArmCPU, address uint32) uint32 {
+	value := cpu.Bus().ReadData32(address)
+	rotateRight(&value, int(address&0x3)*8, false)
+	return value
+}
+
 func ldr(cpu ArmCPU, address uint32) {
-	endBits := address & 0x3
 	instruction := cpu.currentInstruction()
 	rd := reg(bits.GetBits(instruction, 15, 12))
-	var value uint32
 	cpu.Bus().SetSequencial(false)
-	if endBits == 0 {
-		value = cpu.Bus().ReadData32(address)
-	} else {
-		value = cpu.Bus().ReadData32(address)
-		rotateRight(&value, int(endBits*8), false)
-	}
+	value := readWordRotated(cpu, address)
 
 	if rd == rPc {
 		if cpu.Architecture() >= V5 {
@@ -193,19 +192,12 @@ func ldr(cpu ArmCPU, address uint32) {
 
 }
 func ldrt(cpu ArmCPU, address uint32) {
-	endBits := address & 0x3
 	instruction := cpu.currentInstruction()
 	rd := reg(bits.GetBits(instruction, 15, 12))
-	var value uint32
 	cpu.Bus().SetSequencial(false)
 	currentMode := cpu.mode()
 	cpu.setMode(user)
-	if endBits == 0 {
-		value = cpu.Bus().ReadData32(address)
-	} else {
-		value = cpu.Bus().ReadData32(address)
-		rotateRight(&value, int(endBits*8), false)
-	}
+	value := readWordRotated(cpu, address)
 	cpu.setMode(currentMode)
 	cpu.setReg(rd, value)
 
@@ -248,7 +240,7 @@ func ldrsh(cpu ArmCPU, address uint32) {
 	instruction := cpu.currentInstruction()
 	rd := reg(bits.GetBits(instruction, 15, 12))
 	cpu.Bus().SetSequencial(false)
-	data := cpu.Bus().ReadData8(address)
+	data := cpu.Bus().ReadData16(address)
 	data <<= 32 - 16
 	bits.ShiftRightSigned(&data, 32-16)
 	cpu.setReg(rd, data)
@@ -264,10 +256,10 @@ func strt(cpu ArmCPU, address uint32) {
 	instruction := cpu.currentInstruction()
 	rd := reg(bits.GetBits(instruction, 15, 12))
 	currentMode := cpu.mode()
-	cpu.setMode(currentMode)
+	cpu.setMode(user)
 	cpu.Bus().SetSequencial(false)
 	cpu.Bus().WriteData32(address, cpu.readReg(rd))
-	cpu.setMode(user)
+	cpu.setMode(currentMode)
 }
 
 func strb(cpu ArmCPU, address uint32) {
@@ -300,15 +292,13 @@ func ldm(cpu ArmCPU, startAddress uint32, endAddress uint32) {
 
 	cpu.Bus().SetSequencial(false)
 	for a := 0; a < 15; a++ {
-		bitSet := (regList & 0x1) != 0
-		if bitSet {
+		if bits.GetBit(regList, a) {
 			cpu.setReg(reg(a), cpu.Bus().ReadData32(startAddress))
 			startAddress += 4
-			regList >>= 1
+			cpu.Bus().SetSequencial(true)
 		}
-
 	}
-	if (regList >> 14) != 0 {
+	if bits.GetBit(regList, 15) {
 		value := cpu.Bus().ReadData32(startAddress)
 		if cpu.Architecture() >= V5 {
 			cpu.setPc(value & 0xFFFFFFFE)
@@ -318,11 +308,6 @@ func ldm(cpu ArmCPU, startAddress uint32, endAddress uint32) {
 		}
 		startAddress += 4
 	}
-
-	if endAddress != (startAddress - 4) {
-		fmt.Println("LDM Assert failed")
-	}
-
 }
 
 func ldmUser(cpu ArmCPU, startAddress uint32, endAddress uint32) {
@@ -336,13 +321,11 @@ func ldmUser(cpu ArmCPU, startAddress uint32, endAddress uint32) {
 	cpu.setMode(user)
 	cpu.Bus().SetSequencial(false)
 	for a := 0; a < 15; a++ {
-		bitSet := (regList & 0x1) != 0
-		if bitSet {
+		if bits.GetBit(regList, a) {
 			cpu.setReg(reg(a), cpu.Bus().ReadData32(startAddress))
 			startAddress += 4
-			regList >>= 1
+			cpu.Bus().SetSequencial(true)
 		}
-
 	}
 	cpu.setMode(currentMode)
 }
@@ -353,18 +336,18 @@ func ldmSPSR(cpu ArmCPU, startAddress uint32, endAddress uint32) {
 
 	cpu.Bus().SetSequencial(false)
 	for a := 0; a < 15; a++ {
-		bitSet := (regList & 0x1) != 0
-		if bitSet {
+		if bits.GetBit(regList, a) {
 			cpu.setReg(reg(a), cpu.Bus().ReadData32(startAddress))
 			startAddress += 4
-			regList >>= 1
+			cpu.Bus().SetSequencial(true)
 		}
 	}
-	cpu.setCpsr(cpu.readSpsr())
-
 	value := cpu.Bus().ReadData32(startAddress)
 
-	if cpu.Architecture() > V4 && cpu.isFlag(thumbMode) {
+	// The SPSR is restored last so the loads above use the banked
+	// registers of the exception mode.
+	cpu.setCpsr(cpu.readSpsr())
+	if cpu.isFlag(thumbMode) {
 		cpu.setPc(value & 0xFFFFFFFE)
 	} else {
 		cpu.setPc(value & 0xFFFFFFFC)
@@ -377,12 +360,11 @@ func stm(cpu ArmCPU, startAddress uint32, endAddress uint32) {
 	regList := bits.GetBits(instruction, 15, 0)
 
 	cpu.Bus().SetSequencial(false)
-	for a := 0; a < 15; a++ {
-		bitSet := (regList & 0x1) != 0
-		if bitSet {
+	for a := 0; a < 16; a++ {
+		if bits.GetBit(regList, a) {
 			cpu.Bus().WriteData32(startAddress, cpu.readReg(reg(a)))
 			startAddress += 4
-			regList >>= 1
+			cpu.Bus().SetSequencial(true)
 		}
 	}
 }
@@ -394,12 +376,11 @@ func stmUser(cpu ArmCPU, startAddress uint32, endAddress uint32) {
 	regList := bits.GetBits(instruction, 15, 0)
 
 	cpu.Bus().SetSequencial(false)
-	for a := 0; a < 15; a++ {
-		bitSet := (regList & 0x1) != 0
-		if bitSet {
+	for a := 0; a < 16; a++ {
+		if bits.GetBit(regList, a) {
 			cpu.Bus().WriteData32(startAddress, cpu.readReg(reg(a)))
 			startAddress += 4
-			regList >>= 1
+			cpu.Bus().SetSequencial(true)
 		}
 	}
 
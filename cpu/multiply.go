@@ -60,8 +60,8 @@ func smlal(cpu ArmCPU) {
 	rdLoVal := cpu.readReg(rdLo)
 	rsVal := cpu.readReg(rs)
 	rmVal := cpu.readReg(rm)
-	result64 := int64(rmVal) * int64(rsVal)
-	rdComb := (int64(rdHiVal) << 32) & int64(rdLoVal)
+	result64 := int64(int32(rmVal)) * int64(int32(rsVal))
+	rdComb := int64(uint64(rdHiVal)<<32 | uint64(rdLoVal))
 	resultComb := rdComb + result64
 
 	cpu.setReg(rdHi, uint32(resultComb>>32))
@@ -83,7 +83,7 @@ func smull(cpu ArmCPU) {
 	rdHi, rdLo, rs, rm := parseMult(cpu)
 	rsVal := cpu.readReg(rs)
 	rmVal := cpu.readReg(rm)
-	result64 := int64(rmVal) * int64(rsVal)
+	result64 := int64(int32(rmVal)) * int64(int32(rsVal))
 
 	cpu.setReg(rdHi, uint32(result64>>32))
 	cpu.setReg(rdLo, uint32(result64&0xffffffff))
@@ -107,7 +107,7 @@ func umlal(cpu ArmCPU) {
 	rsVal := cpu.readReg(rs)
 	rmVal := cpu.readReg(rm)
 	result64 := uint64(rmVal) * uint64(rsVal)
-	rdComb := (uint64(rdHiVal) << 32) & uint64(rdLoVal)
+	rdComb := uint64(rdHiVal)<<32 | uint64(rdLoVal)
 	resultComb := rdComb + result64
 
 	cpu.setReg(rdHi, uint32(resultComb>>32))
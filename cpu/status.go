@@ -27,12 +27,12 @@ const (
 	system     cpuMode = 0b11111
 )
 
-func (cpu *Arm7) isFlag(fl flag) bool {
+func (cpu *armCore) isFlag(fl flag) bool {
 	result := (cpu.readCpsr() >> fl) & 0x1
 	return result == 1
 }
 
-func (cpu *Arm7) setFlag(fl flag, value bool) {
+func (cpu *armCore) setFlag(fl flag, value bool) {
 
 	var mask uint32 = 1 << fl
 
@@ -45,17 +45,10 @@ func (cpu *Arm7) setFlag(fl flag, value bool) {
 	cpu.setCpsr(cpu.readCpsr() & mask)
 }
 
-func (cpu *Arm7) mode() cpuMode {
+func (cpu *armCore) mode() cpuMode {
 	return cpuMode(cpu.readCpsr() & 0x1f)
 }
 
-func (cpu *Arm7) setMode(mode cpuMode) {
-	var mask uint32
-	mask = (^mask) | uint32(mode)
-	cpu.setCpsr(cpu.readCpsr() & mask)
-	cpu.bankedRegisters = bankedRegMap[mode]
-	val, ok := bankedSpsrMap[mode]
-	if ok {
-		cpu.currentSpsr = val
-	}
+func (cpu *armCore) setMode(mode cpuMode) {
+	cpu.setCpsr((cpu.readCpsr() &^ 0x1f) | uint32(mode))
 }
@@ -18,58 +18,33 @@ func mrs(cpu ArmCPU) {
 	cpu.setReg(rd, cpu.readCpsr())
 }
 
+// msr writes the fields of a status register selected by bits 19-16 of
+// the instruction. Only the flags field of the CPSR can be written in user
+// mode.
 func msr(cpu ArmCPU, addressingMode arthAddrMode) {
 
 	if !testCondition(cpu) {
 		return
 	}
 
-	if cpu.mode() == user {
-		return
-	}
-
-	var result uint32
-	result, _ = addressingMode(cpu)
+	result, _ := addressingMode(cpu)
 	fieldMask := bits.GetBits(cpu.currentInstruction(), 19, 16)
-	var maskStart int
-	var maskEnd int
-
-	if bits.GetBit(fieldMask, 0) {
-		maskStart = 7
-		maskEnd = 0
-	} else if bits.GetBit(fieldMask, 1) {
-		maskStart = 15
-		maskEnd = 8
-	} else if bits.GetBit(fieldMask, 2) {
-		maskStart = 23
-		maskEnd = 16
-	} else if bits.GetBit(fieldMask, 3) {
-		maskStart = 31
-		maskEnd = 24
-	} else {
-		return
+	var mask uint32
+	for field := 0; field < 4; field++ {
+		if bits.GetBit(fieldMask, field) {
+			mask |= 0xff << (field * 8)
+		}
 	}
 
 	isSPSR := bits.GetBit(cpu.currentInstruction(), 22)
 
 	if !isSPSR {
-		current := cpu.readCpsr()
-		newVal := bits.GetBits(result, maskStart, maskEnd)
-		newVal <<= maskStart
-		current |= newVal
-		current &= newVal
-		cpu.setCpsr(current)
-		return
-	}
-
-	if cpu.mode() == system {
+		if cpu.mode() == user {
+			mask &= 0xff000000
+		}
+		cpu.setCpsr((cpu.readCpsr() &^ mask) | (result & mask))
 		return
 	}
 
-	current := cpu.readSpsr()
-	newVal := bits.GetBits(result, maskStart, maskEnd)
-	newVal <<= maskStart
-	current |= newVal
-	current &= newVal
-	cpu.setSpsr(current)
+	cpu.setSpsr((cpu.readSpsr() &^ mask) | (result & mask))
 }
@@ -0,0 +1,533 @@
+package cpu
+
+import "github.com/damilolarandolph/casper/bits"
+
+// thumbInstructions is indexed by bits 15 - 6 of a thumb opcode.
+var thumbInstructions = [0x3ff + 1]instructionHandler{}
+
+func thumbReg(instruction uint32, pos int) reg {
+	return reg(bits.GetBits(instruction, pos+2, pos))
+}
+
+func setNegativeZero(cpu ArmCPU, result uint32) {
+	cpu.setFlag(negative, isNegative(result))
+	cpu.setFlag(zero, result == 0)
+}
+
+// Format 1: move shifted register.
+func thumbShiftImm(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	shift := shiftType(bits.GetBits(instruction, 12, 11))
+	amount := int(bits.GetBits(instruction, 10, 6))
+	rs := thumbReg(instruction, 3)
+	rd := thumbReg(instruction, 0)
+	value := cpu.readReg(rs)
+	var carryOut bool
+	switch shift {
+	case lsl:
+		carryOut = logicalShiftLeft(&value, amount, cpu.isFlag(carry))
+	case lsr:
+		if amount == 0 {
+			amount = 32
+		}
+		carryOut = logicalShiftRight(&value, amount, cpu.isFlag(carry))
+	case asr:
+		if amount == 0 {
+			amount = 32
+		}
+		carryOut = arthShiftRight(&value, amount, cpu.isFlag(carry))
+	}
+	cpu.setReg(rd, value)
+	setLogicalFlags(cpu, value, carryOut)
+}
+
+// Format 2: add/subtract with a register or 3 bit immediate.
+func thumbAddSub(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rs := thumbReg(instruction, 3)
+	rd := thumbReg(instruction, 0)
+	operand := bits.GetBits(instruction, 8, 6)
+	if !bits.GetBit(instruction, 10) {
+		operand = cpu.readReg(reg(operand))
+	}
+	lhs := cpu.readReg(rs)
+	var result uint32
+	var carryOut, overflowOut bool
+	if bits.GetBit(instruction, 9) {
+		result, carryOut, overflowOut = addWithCarry(lhs, ^operand, true)
+	} else {
+		result, carryOut, overflowOut = addWithCarry(lhs, operand, false)
+	}
+	cpu.setReg(rd, result)
+	setArithmeticFlags(cpu, result, carryOut, overflowOut)
+}
+
+// Format 3: move/compare/add/subtract with an 8 bit immediate.
+func thumbImmOp(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rd := thumbReg(instruction, 8)
+	operand := bits.GetBits(instruction, 7, 0)
+	lhs := cpu.readReg(rd)
+	switch bits.GetBits(instruction, 12, 11) {
+	case 0:
+		cpu.setReg(rd, operand)
+		setNegativeZero(cpu, operand)
+	case 1:
+		result, carryOut, overflowOut := addWithCarry(lhs, ^operand, true)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	case 2:
+		result, carryOut, overflowOut := addWithCarry(lhs, operand, false)
+		cpu.setReg(rd, result)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	case 3:
+		result, carryOut, overflowOut := addWithCarry(lhs, ^operand, true)
+		cpu.setReg(rd, result)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	}
+}
+
+const (
+	thumbAnd = iota
+	thumbEor
+	thumbLsl
+	thumbLsr
+	thumbAsr
+	thumbAdc
+	thumbSbc
+	thumbRor
+	thumbTst
+	thumbNeg
+	thumbCmp
+	thumbCmn
+	thumbOrr
+	thumbMul
+	thumbBic
+	thumbMvn
+)
+
+// Format 4: ALU operations between two low registers.
+func thumbAlu(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rs := thumbReg(instruction, 3)
+	rd := thumbReg(instruction, 0)
+	lhs := cpu.readReg(rd)
+	rhs := cpu.readReg(rs)
+	currentCarry := cpu.isFlag(carry)
+
+	switch bits.GetBits(instruction, 9, 6) {
+	case thumbAnd:
+		result := lhs & rhs
+		cpu.setReg(rd, result)
+		setNegativeZero(cpu, result)
+	case thumbEor:
+		result := lhs ^ rhs
+		cpu.setReg(rd, result)
+		setNegativeZero(cpu, result)
+	case thumbLsl:
+		carryOut := logicalShiftLeft(&lhs, int(rhs&0xff), currentCarry)
+		cpu.setReg(rd, lhs)
+		setLogicalFlags(cpu, lhs, carryOut)
+	case thumbLsr:
+		carryOut := logicalShiftRight(&lhs, int(rhs&0xff), currentCarry)
+		cpu.setReg(rd, lhs)
+		setLogicalFlags(cpu, lhs, carryOut)
+	case thumbAsr:
+		carryOut := arthShiftRight(&lhs, int(rhs&0xff), currentCarry)
+		cpu.setReg(rd, lhs)
+		setLogicalFlags(cpu, lhs, carryOut)
+	case thumbAdc:
+		result, carryOut, overflowOut := addWithCarry(lhs, rhs, currentCarry)
+		cpu.setReg(rd, result)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	case thumbSbc:
+		result, carryOut, overflowOut := addWithCarry(lhs, ^rhs, currentCarry)
+		cpu.setReg(rd, result)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	case thumbRor:
+		carryOut := rotateRight(&lhs, int(rhs&0xff), currentCarry)
+		cpu.setReg(rd, lhs)
+		setLogicalFlags(cpu, lhs, carryOut)
+	case thumbTst:
+		setNegativeZero(cpu, lhs&rhs)
+	case thumbNeg:
+		result, carryOut, overflowOut := addWithCarry(0, ^rhs, true)
+		cpu.setReg(rd, result)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	case thumbCmp:
+		result, carryOut, overflowOut := addWithCarry(lhs, ^rhs, true)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	case thumbCmn:
+		result, carryOut, overflowOut := addWithCarry(lhs, rhs, false)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	case thumbOrr:
+		result := lhs | rhs
+		cpu.setReg(rd, result)
+		setNegativeZero(cpu, result)
+	case thumbMul:
+		result := lhs * rhs
+		cpu.setReg(rd, result)
+		setNegativeZero(cpu, result)
+	case thumbBic:
+		result := lhs &^ rhs
+		cpu.setReg(rd, result)
+		setNegativeZero(cpu, result)
+	case thumbMvn:
+		result := ^rhs
+		cpu.setReg(rd, result)
+		setNegativeZero(cpu, result)
+	}
+}
+
+// Format 5: operations on high registers and branch exchange.
+func thumbHiRegOp(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rs := reg(bits.GetBits(instruction, 6, 3))
+	rd := thumbReg(instruction, 0)
+	if bits.GetBit(instruction, 7) {
+		rd += 8
+	}
+	rhs := cpu.readReg(rs)
+
+	switch bits.GetBits(instruction, 9, 8) {
+	case 0:
+		result := cpu.readReg(rd) + rhs
+		if rd == rPc {
+			result &^= 1
+		}
+		cpu.setReg(rd, result)
+	case 1:
+		result, carryOut, overflowOut := addWithCarry(cpu.readReg(rd), ^rhs, true)
+		setArithmeticFlags(cpu, result, carryOut, overflowOut)
+	case 2:
+		if rd == rPc {
+			rhs &^= 1
+		}
+		cpu.setReg(rd, rhs)
+	case 3:
+		if bits.GetBit(instruction, 7) && cpu.Architecture() >= V5 {
+			cpu.setReg(r14, cpu.nextInstruction()|1)
+		}
+		cpu.setFlag(thumbMode, (rhs&0x1) != 0)
+		cpu.setPc(rhs &^ 1)
+	}
+}
+
+// Format 6: load relative to the word aligned PC.
+func thumbLdrPc(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rd := thumbReg(instruction, 8)
+	address := (cpu.readPc() &^ 0x3) + (bits.GetBits(instruction, 7, 0) << 2)
+	cpu.Bus().SetSequencial(false)
+	cpu.setReg(rd, cpu.Bus().ReadData32(address))
+}
+
+func thumbRegOffsetAddress(instruction uint32, cpu ArmCPU) uint32 {
+	ro := thumbReg(instruction, 6)
+	rb := thumbReg(instruction, 3)
+	return cpu.readReg(rb) + cpu.readReg(ro)
+}
+
+// Format 7: load/store word or byte with a register offset.
+func thumbLdrStrReg(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rd := thumbReg(instruction, 0)
+	address := thumbRegOffsetAddress(instruction, cpu)
+	cpu.Bus().SetSequencial(false)
+
+	switch bits.GetBits(instruction, 11, 10) {
+	case 0:
+		cpu.Bus().WriteData32(address, cpu.readReg(rd))
+	case 1:
+		cpu.Bus().WriteData8(address, cpu.readReg(rd))
+	case 2:
+		cpu.setReg(rd, readWordRotated(cpu, address))
+	case 3:
+		cpu.setReg(rd, cpu.Bus().ReadData8(address))
+	}
+}
+
+// Format 8: load/store sign extended byte or halfword.
+func thumbLdrStrSigned(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rd := thumbReg(instruction, 0)
+	address := thumbRegOffsetAddress(instruction, cpu)
+	cpu.Bus().SetSequencial(false)
+
+	switch bits.GetBits(instruction, 11, 10) {
+	case 0:
+		cpu.Bus().WriteData16(address, cpu.readReg(rd))
+	case 1:
+		data := cpu.Bus().ReadData8(address) << 24
+		bits.ShiftRightSigned(&data, 24)
+		cpu.setReg(rd, data)
+	case 2:
+		cpu.setReg(rd, cpu.Bus().ReadData16(address))
+	case 3:
+		data := cpu.Bus().ReadData16(address) << 16
+		bits.ShiftRightSigned(&data, 16)
+		cpu.setReg(rd, data)
+	}
+}
+
+// Format 9: load/store word or byte with a 5 bit immediate offset.
+func thumbLdrStrImm(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rd := thumbReg(instruction, 0)
+	rb := thumbReg(instruction, 3)
+	offset := bits.GetBits(instruction, 10, 6)
+	isByte := bits.GetBit(instruction, 12)
+	if !isByte {
+		offset <<= 2
+	}
+	address := cpu.readReg(rb) + offset
+	cpu.Bus().SetSequencial(false)
+
+	if bits.GetBit(instruction, 11) {
+		if isByte {
+			cpu.setReg(rd, cpu.Bus().ReadData8(address))
+		} else {
+			cpu.setReg(rd, readWordRotated(cpu, address))
+		}
+		return
+	}
+
+	if isByte {
+		cpu.Bus().WriteData8(address, cpu.readReg(rd))
+	} else {
+		cpu.Bus().WriteData32(address, cpu.readReg(rd))
+	}
+}
+
+// Format 10: load/store halfword with a 5 bit immediate offset.
+func thumbLdrStrH(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rd := thumbReg(instruction, 0)
+	rb := thumbReg(instruction, 3)
+	address := cpu.readReg(rb) + (bits.GetBits(instruction, 10, 6) << 1)
+	cpu.Bus().SetSequencial(false)
+
+	if bits.GetBit(instruction, 11) {
+		cpu.setReg(rd, cpu.Bus().ReadData16(address))
+		return
+	}
+	cpu.Bus().WriteData16(address, cpu.readReg(rd))
+}
+
+// Format 11: load/store relative to SP.
+func thumbLdrStrSp(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rd := thumbReg(instruction, 8)
+	address := cpu.readReg(r13) + (bits.GetBits(instruction, 7, 0) << 2)
+	cpu.Bus().SetSequencial(false)
+
+	if bits.GetBit(instruction, 11) {
+		cpu.setReg(rd, readWordRotated(cpu, address))
+		return
+	}
+	cpu.Bus().WriteData32(address, cpu.readReg(rd))
+}
+
+// Format 12: load an address relative to PC or SP.
+func thumbLoadAddress(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rd := thumbReg(instruction, 8)
+	offset := bits.GetBits(instruction, 7, 0) << 2
+	if bits.GetBit(instruction, 11) {
+		cpu.setReg(rd, cpu.readReg(r13)+offset)
+		return
+	}
+	cpu.setReg(rd, (cpu.readPc()&^0x3)+offset)
+}
+
+// Format 13: add a signed offset to SP.
+func thumbAddSp(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	offset := bits.GetBits(instruction, 6, 0) << 2
+	if bits.GetBit(instruction, 7) {
+		cpu.setReg(r13, cpu.readReg(r13)-offset)
+		return
+	}
+	cpu.setReg(r13, cpu.readReg(r13)+offset)
+}
+
+// Format 14: push/pop registers, optionally with LR/PC.
+func thumbPushPop(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	regList := bits.GetBits(instruction, 7, 0)
+	extraReg := bits.GetBit(instruction, 8)
+	sp := cpu.readReg(r13)
+	cpu.Bus().SetSequencial(false)
+
+	if !bits.GetBit(instruction, 11) {
+		count := uint32(bits.NumSetBits(regList))
+		if extraReg {
+			count++
+		}
+		sp -= count * 4
+		cpu.setReg(r13, sp)
+		for a := 0; a < 8; a++ {
+			if bits.GetBit(regList, a) {
+				cpu.Bus().WriteData32(sp, cpu.readReg(reg(a)))
+				sp += 4
+				cpu.Bus().SetSequencial(true)
+			}
+		}
+		if extraReg {
+			cpu.Bus().WriteData32(sp, cpu.readReg(r14))
+		}
+		return
+	}
+
+	for a := 0; a < 8; a++ {
+		if bits.GetBit(regList, a) {
+			cpu.setReg(reg(a), cpu.Bus().ReadData32(sp))
+			sp += 4
+			cpu.Bus().SetSequencial(true)
+		}
+	}
+	if extraReg {
+		value := cpu.Bus().ReadData32(sp)
+		sp += 4
+		if cpu.Architecture() >= V5 {
+			cpu.setFlag(thumbMode, (value&0x1) != 0)
+		}
+		cpu.setPc(value &^ 1)
+	}
+	cpu.setReg(r13, sp)
+}
+
+// Format 15: load/store multiple with base writeback.
+func thumbLdmStm(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	rb := thumbReg(instruction, 8)
+	regList := bits.GetBits(instruction, 7, 0)
+	address := cpu.readReg(rb)
+	cpu.Bus().SetSequencial(false)
+
+	if regList == 0 {
+		// An empty list transfers PC and moves the base by 16 words.
+		if bits.GetBit(instruction, 11) {
+			cpu.setPc(cpu.Bus().ReadData32(address) &^ 1)
+		} else {
+			cpu.Bus().WriteData32(address, cpu.readPc()+2)
+		}
+		cpu.setReg(rb, address+0x40)
+		return
+	}
+
+	writeBack := address + uint32(bits.NumSetBits(regList))*4
+
+	if bits.GetBit(instruction, 11) {
+		for a := 0; a < 8; a++ {
+			if bits.GetBit(regList, a) {
+				cpu.setReg(reg(a), cpu.Bus().ReadData32(address))
+				address += 4
+				cpu.Bus().SetSequencial(true)
+			}
+		}
+		if !bits.GetBit(regList, int(rb)) {
+			cpu.setReg(rb, writeBack)
+		}
+		return
+	}
+
+	for a := 0; a < 8; a++ {
+		if bits.GetBit(regList, a) {
+			cpu.Bus().WriteData32(address, cpu.readReg(reg(a)))
+			address += 4
+			cpu.Bus().SetSequencial(true)
+		}
+	}
+	cpu.setReg(rb, writeBack)
+}
+
+// Format 16: conditional branch.
+func thumbCondBranch(cpu ArmCPU) {
+	instruction := cpu.currentInstruction()
+	if !conditions[bits.GetBits(instruction, 11, 8)](cpu) {
+		return
+	}
+	offset := bits.GetBits(instruction, 7, 0) << 24
+	bits.ShiftRightSigned(&offset, 23)
+	cpu.setPc(cpu.readPc() + offset)
+}
+
+// Format 18: unconditional branch.
+func thumbBranch(cpu ArmCPU) {
+	offset := bits.GetBits(cpu.currentInstruction(), 10, 0) << 21
+	bits.ShiftRightSigned(&offset, 20)
+	cpu.setPc(cpu.readPc() + offset)
+}
+
+// Format 19: first half of a long branch with link, which holds the
+// upper part of the offset in LR for the second half.
+func thumbLongBranchHigh(cpu ArmCPU) {
+	offset := bits.GetBits(cpu.currentInstruction(), 10, 0) << 21
+	bits.ShiftRightSigned(&offset, 9)
+	cpu.setReg(r14, cpu.readPc()+offset)
+}
+
+// Format 19: second half of a long branch with link. BLX switches to
+// ARM state and is only available from ARMv5.
+func thumbLongBranchLow(exchange bool) instructionHandler {
+	return func(cpu ArmCPU) {
+		if exchange && cpu.Architecture() < V5 {
+			undefinedInstruction(cpu)
+			return
+		}
+		offset := bits.GetBits(cpu.currentInstruction(), 10, 0) << 1
+		target := cpu.readReg(r14) + offset
+		cpu.setReg(r14, cpu.nextInstruction()|1)
+		if exchange {
+			cpu.setFlag(thumbMode, false)
+			target &^= 0x3
+		}
+		cpu.setPc(target)
+	}
+}
+
+func init() {
+	targets := []MaskTarget{
+		{name: "Add/subtract", rowMask: "00011xxxxx", handler: emitThumb(thumbAddSub)},
+		{name: "Move shifted register", rowMask: "000xxxxxxx", handler: emitThumb(thumbShiftImm)},
+		{name: "Move/compare/add/subtract immediate", rowMask: "001xxxxxxx", handler: emitThumb(thumbImmOp)},
+		{name: "ALU operations", rowMask: "010000xxxx", handler: emitThumb(thumbAlu)},
+		{name: "Hi register operations/branch exchange", rowMask: "010001xxxx", handler: emitThumb(thumbHiRegOp)},
+		{name: "PC-relative load", rowMask: "01001xxxxx", handler: emitThumb(thumbLdrPc)},
+		{name: "Load/store with register offset", rowMask: "0101xx0xxx", handler: emitThumb(thumbLdrStrReg)},
+		{name: "Load/store sign-extended byte/halfword", rowMask: "0101xx1xxx", handler: emitThumb(thumbLdrStrSigned)},
+		{name: "Load/store with immediate offset", rowMask: "011xxxxxxx", handler: emitThumb(thumbLdrStrImm)},
+		{name: "Load/store halfword", rowMask: "1000xxxxxx", handler: emitThumb(thumbLdrStrH)},
+		{name: "SP-relative load/store", rowMask: "1001xxxxxx", handler: emitThumb(thumbLdrStrSp)},
+		{name: "Load address", rowMask: "1010xxxxxx", handler: emitThumb(thumbLoadAddress)},
+		{name: "Add offset to stack pointer", rowMask: "10110000xx", handler: emitThumb(thumbAddSp)},
+		{name: "Push/pop registers", rowMask: "1011x10xxx", handler: emitThumb(thumbPushPop)},
+		{name: "Multiple load/store", rowMask: "1100xxxxxx", handler: emitThumb(thumbLdmStm)},
+		{name: "Breakpoint", rowMask: "10111110xx", handler: emitThumb(v5Only(bkpt))},
+		{name: "Undefined instruction", rowMask: "11011110xx", handler: emitThumb(undefinedInstruction)},
+		{name: "Software interrupt", rowMask: "11011111xx", handler: emitThumb(swi)},
+		{name: "Conditional branch", rowMask: "1101xxxxxx", handler: emitThumb(thumbCondBranch)},
+		{name: "Unconditional branch", rowMask: "11100xxxxx", handler: emitThumb(thumbBranch)},
+		{name: "Branch link exchange", rowMask: "11101xxxxx", handler: emitThumb(thumbLongBranchLow(true))},
+		{name: "Long branch with link", rowMask: "11110xxxxx", handler: emitThumb(thumbLongBranchHigh)},
+		{name: "Long branch with link", rowMask: "11111xxxxx", handler: emitThumb(thumbLongBranchLow(false))},
+		{name: "Undefined instruction", rowMask: "xxxxxxxxxx", handler: emitThumb(undefinedInstruction)},
+	}
+
+	for row := 0; row <= 0x3ff; row++ {
+		for _, target := range targets {
+			if target.tryRun(row, 0) {
+				break
+			}
+		}
+	}
+}
+
+// emitThumb returns a mask handler that places a thumb instruction
+// handler in the lookup table.
+func emitThumb(handler instructionHandler) MaskHandler {
+	return func(row int, col int) bool {
+		thumbInstructions[row] = handler
+		return true
+	}
+}
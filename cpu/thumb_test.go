@@ -0,0 +1,69 @@
+package cpu
+
+import "testing"
+
+func TestThumbArithmetic(t *testing.T) {
+	// mov r0, #5; add r0, #3; cmp r0, #8
+	core, _ := newThumbTestCore(V4, 0x2005, 0x3003, 0x2808)
+	for step := 0; step < 3; step++ {
+		core.Step()
+	}
+	if got := core.readReg(r0); got != 8 {
+		t.Errorf("r0 = %d, want 8", got)
+	}
+	if got := flags(core); got != "ZC" {
+		t.Errorf("flags = %q, want \"ZC\"", got)
+	}
+}
+
+func TestThumbLongBranchWithLink(t *testing.T) {
+	// bl testEntry+8
+	core, _ := newThumbTestCore(V4, 0xf000, 0xf802)
+	core.Step()
+	core.Step()
+	if got := core.registers[rPc]; got != testEntry+8 {
+		t.Errorf("pc = %#x, want %#x", got, testEntry+8)
+	}
+	if got := core.readReg(r14); got != testEntry+4|1 {
+		t.Errorf("lr = %#x, want %#x", got, testEntry+4|1)
+	}
+	if !core.isFlag(thumbMode) {
+		t.Error("bl left Thumb state")
+	}
+}
+
+func TestThumbExceptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		opcode uint16
+		mode   cpuMode
+		vector uint32
+	}{
+		{"swi", 0xdf05, supervisor, 0x08},
+		{"undefined", 0xde00, undefined, 0x04},
+		{"unallocated", 0xe800, undefined, 0x04},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, _ := newThumbTestCore(V4, test.opcode)
+			core.Step()
+			if core.mode() != test.mode {
+				t.Errorf("mode = %#x, want %#x", core.mode(), test.mode)
+			}
+			if got := core.registers[rPc]; got != test.vector {
+				t.Errorf("pc = %#x, want %#x", got, test.vector)
+			}
+			// Both return to the instruction after the one that raised
+			// them.
+			if got := core.readReg(r14); got != testEntry+2 {
+				t.Errorf("lr = %#x, want %#x", got, testEntry+2)
+			}
+			if core.isFlag(thumbMode) {
+				t.Error("exception taken in Thumb state")
+			}
+			if core.readSpsr()&(1<<uint(thumbMode)) == 0 {
+				t.Error("SPSR lost the Thumb bit")
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Package display implements the timing of the screens, the line counter
+// and display status registers of both CPUs and the interrupts and DMA
+// transfers started by the blanking periods.
+package display
+
+import (
+	"github.com/damilolarandolph/casper/dma"
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const (
+	statusAddress = 0x04000004
+	vcountAddress = 0x04000006
+)
+
+// Frame timing. A dot takes 6 cycles of the 33MHz bus, which runs at half
+// the master clock, so a frame lasts 263 lines of 355 dots, about 59.8Hz.
+const (
+	cyclesPerDot  = 6 * 2
+	dotsPerLine   = 355
+	visibleDots   = 256
+	linesPerFrame = 263
+	visibleLines  = 192
+	lineCycles    = dotsPerLine * cyclesPerDot
+	hblankCycles  = visibleDots * cyclesPerDot
+	// The VBlank flag is already cleared on the last line of the frame.
+	vblankEndLine = linesPerFrame - 1
+)
+
+// Bits of DISPSTAT.
+const (
+	statusVBlank      uint16 = 1 << 0
+	statusHBlank      uint16 = 1 << 1
+	statusVCount      uint16 = 1 << 2
+	statusVBlankIRQ   uint16 = 1 << 3
+	statusHBlankIRQ   uint16 = 1 << 4
+	statusVCountIRQ   uint16 = 1 << 5
+	statusVCountHigh  uint16 = 1 << 7
+	statusVCountShift        = 8
+	statusWriteMask   uint16 = 0xffb8
+	vcountMask        uint16 = 0x1ff
+	vcountSettingLow  uint16 = 0xff
+	// Bit 7 holds bit 8 of the VCount setting.
+	vcountHighShift = 1
+)
+
+// fifoBursts is how many times the main memory display DMA is started for
+// a line, it fills the FIFO in bursts of four words.
+const fifoBursts = visibleDots * 2 / 16
+
+// The index of each CPU in the per CPU state.
+const (
+	arm7 = iota
+	arm9
+)
+
+// Renderer draws the visible lines of both screens.
+type Renderer interface {
+	RenderLine(line int)
+}
+
+// Display steps through the lines of a frame on the scheduler. Each
+// visible line is drawn when its HBlank starts.
+type Display struct {
+	scheduler *scheduler.Scheduler
+	renderer  Renderer
+	statuses  [2]status
+	dmas      [2]*dma.Controller
+
+	line        int
+	hblank      bool
+	nextLine    int
+	lineWritten bool
+	frames      uint64
+	onFrame     func()
+}
+
+// status is the DISPSTAT of one CPU.
+type status struct {
+	value      uint16
+	interrupts *irq.Controller
+}
+
+// New constructs the display timing at the start of the first line and
+// schedules it.
+func New(sched *scheduler.Scheduler, arm7Interrupts *irq.Controller, arm9Interrupts *irq.Controller, arm7DMA *dma.Controller, arm9DMA *dma.Controller, renderer Renderer) *Display {
+	display := &Display{
+		scheduler: sched,
+		renderer:  renderer,
+		dmas:      [2]*dma.Controller{arm7DMA, arm9DMA},
+	}
+	display.statuses[arm7].interrupts = arm7Interrupts
+	display.statuses[arm9].interrupts = arm9Interrupts
+	display.scheduleLine()
+	return display
+}
+
+// MapArm7 registers DISPSTAT and VCOUNT with the Arm7 I/O registry.
+func (display *Display) MapArm7(registry *mmio.Registry) {
+	display.mapRegisters(registry, &display.statuses[arm7])
+}
+
+// MapArm9 registers DISPSTAT and VCOUNT with the Arm9 I/O registry.
+func (display *Display) MapArm9(registry *mmio.Registry) {
+	display.mapRegisters(registry, &display.statuses[arm9])
+}
+
+func (display *Display) mapRegisters(registry *mmio.Registry, stat *status) {
+	registry.MapRegister(statusAddress, 2, &mmio.Register{
+		ReadMask:  0xffff,
+		WriteMask: uint32(statusWriteMask),
+		OnRead: func() uint32 {
+			return uint32(display.readStatus(stat))
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			stat.value = uint16(value) & statusWriteMask
+		},
+	})
+	registry.MapRegister(vcountAddress, 2, &mmio.Register{
+		ReadMask:  uint32(vcountMask),
+		WriteMask: uint32(vcountMask),
+		OnRead: func() uint32 {
+			return uint32(display.line)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			display.writeVCount(int(value))
+		},
+	})
+}
+
+// SetFrameHandler sets a function called at the start of every VBlank,
+// once both screens have been drawn.
+func (display *Display) SetFrameHandler(handler func()) {
+	display.onFrame = handler
+}
+
+// Frames returns the number of frames drawn so far.
+func (display *Display) Frames() uint64 {
+	return display.frames
+}
+
+// VCount returns the current line.
+func (display *Display) VCount() int {
+	return display.line
+}
+
+func (display *Display) readStatus(stat *status) uint16 {
+	value := stat.value
+	if display.inVBlank() {
+		value |= statusVBlank
+	}
+	if display.hblank {
+		value |= statusHBlank
+	}
+	if display.vcountMatches(stat) {
+		value |= statusVCount
+	}
+	return value
+}
+
+// writeVCount sets the line counter, which games use to keep consoles in
+// step. The new value takes effect from the next line.
+func (display *Display) writeVCount(value int) {
+	if value >= linesPerFrame {
+		return
+	}
+	display.nextLine = value
+	display.lineWritten = true
+}
+
+func (display *Display) inVBlank() bool {
+	return display.line >= visibleLines && display.line < vblankEndLine
+}
+
+// vcountMatches reports whether the current line is the one set in
+// DISPSTAT, whose ninth bit is bit 7.
+func (display *Display) vcountMatches(stat *status) bool {
+	setting := int(stat.value>>statusVCountShift&vcountSettingLow) |
+		int(stat.value&statusVCountHigh)<<vcountHighShift
+	return display.line == setting
+}
+
+// scheduleLine schedules the HBlank and the end of the current line.
+func (display *Display) scheduleLine() {
+	display.scheduler.Schedule(hblankCycles, display.startHBlank)
+	display.scheduler.Schedule(lineCycles, display.startLine)
+}
+
+// startLine moves to the next line, which starts the VBlank after the last
+// visible line.
+func (display *Display) startLine() {
+	if display.lineWritten {
+		display.line = display.nextLine
+		display.lineWritten = false
+	} else {
+		display.line = (display.line + 1) % linesPerFrame
+	}
+	display.hblank = false
+
+	switch display.line {
+	case 0:
+		display.dmas[arm9].Trigger(dma.DisplayStart)
+	case visibleLines:
+		display.frames++
+		for index := range display.statuses {
+			stat := &display.statuses[index]
+			if stat.value&statusVBlankIRQ != 0 {
+				stat.interrupts.Request(irq.VBlank)
+			}
+			display.dmas[index].Trigger(dma.VBlank)
+		}
+		if display.onFrame != nil {
+			display.onFrame()
+		}
+	}
+	for index := range display.statuses {
+		stat := &display.statuses[index]
+		if stat.value&statusVCountIRQ != 0 && display.vcountMatches(stat) {
+			stat.interrupts.Request(irq.VCount)
+		}
+	}
+	if display.line < visibleLines {
+		for burst := 0; burst < fifoBursts; burst++ {
+			display.dmas[arm9].Trigger(dma.MainMemoryDisplay)
+		}
+	}
+	display.scheduleLine()
+}
+
+// startHBlank draws a visible line and starts the HBlank, whose DMA only
+// runs on visible lines.
+func (display *Display) startHBlank() {
+	display.hblank = true
+	if display.line < visibleLines {
+		display.renderer.RenderLine(display.line)
+		display.dmas[arm9].Trigger(dma.HBlank)
+	}
+	for index := range display.statuses {
+		stat := &display.statuses[index]
+		if stat.value&statusHBlankIRQ != 0 {
+			stat.interrupts.Request(irq.HBlank)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package display
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/dma"
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const frameCycles = linesPerFrame * lineCycles
+
+// lineRecorder records the lines drawn.
+type lineRecorder struct {
+	lines []int
+}
+
+func (recorder *lineRecorder) RenderLine(line int) {
+	recorder.lines = append(recorder.lines, line)
+}
+
+type displayTest struct {
+	scheduler      *scheduler.Scheduler
+	arm7, arm9     *mmio.Registry
+	arm7Interrupts *irq.Controller
+	arm9Interrupts *irq.Controller
+	renderer       *lineRecorder
+	display        *Display
+}
+
+func newDisplayTest() *displayTest {
+	test := &displayTest{
+		scheduler:      scheduler.New(),
+		arm7:           mmio.NewRegistry(),
+		arm9:           mmio.NewRegistry(),
+		arm7Interrupts: irq.NewController(),
+		arm9Interrupts: irq.NewController(),
+		renderer:       &lineRecorder{},
+	}
+	test.display = New(test.scheduler, test.arm7Interrupts, test.arm9Interrupts,
+		dma.NewArm7(nil, test.arm7Interrupts), dma.NewArm9(nil, test.arm9Interrupts), test.renderer)
+	test.display.MapArm7(test.arm7)
+	test.display.MapArm9(test.arm9)
+	return test
+}
+
+func TestTiming(t *testing.T) {
+	tests := []struct {
+		cycles uint64
+		line   uint16
+		status uint16
+	}{
+		// The VCount setting is line 0.
+		{0, 0, statusVCount},
+		{hblankCycles - 1, 0, statusVCount},
+		{hblankCycles, 0, statusVCount | statusHBlank},
+		{lineCycles, 1, 0},
+		{191*lineCycles + hblankCycles, 191, statusHBlank},
+		{192 * lineCycles, 192, statusVBlank},
+		{192*lineCycles + hblankCycles, 192, statusVBlank | statusHBlank},
+		{261 * lineCycles, 261, statusVBlank},
+		{262 * lineCycles, 262, 0},
+		{frameCycles, 0, statusVCount},
+	}
+	test := newDisplayTest()
+	for _, want := range tests {
+		test.scheduler.Advance(want.cycles)
+		if line := test.arm9.Read16(vcountAddress); line != want.line {
+			t.Errorf("cycle %d: VCOUNT = %d, want %d", want.cycles, line, want.line)
+		}
+		if status := test.arm7.Read16(statusAddress); status != want.status {
+			t.Errorf("cycle %d: DISPSTAT = %#x, want %#x", want.cycles, status, want.status)
+		}
+	}
+}
+
+func TestRenderedLines(t *testing.T) {
+	test := newDisplayTest()
+	frames := 0
+	test.display.SetFrameHandler(func() {
+		frames++
+		if len(test.renderer.lines) != frames*visibleLines {
+			t.Errorf("frame %d: %d lines drawn before VBlank", frames, len(test.renderer.lines))
+		}
+	})
+	test.scheduler.Advance(2 * frameCycles)
+	if frames != 2 || test.display.Frames() != 2 {
+		t.Errorf("frames = %d, %d, want 2", frames, test.display.Frames())
+	}
+	for index, line := range test.renderer.lines {
+		if line != index%visibleLines {
+			t.Fatalf("line %d drawn as %d", index, line)
+		}
+	}
+}
+
+func TestVCountMatch(t *testing.T) {
+	tests := []struct {
+		setting uint16
+		line    int
+	}{
+		{0x0000, 0},
+		{0x0500, 5},
+		{0xc000, 192},
+		// Bit 7 is the ninth bit of the setting.
+		{0x0680, 262},
+	}
+	for _, want := range tests {
+		test := newDisplayTest()
+		test.arm9.Write16(statusAddress, want.setting|statusVCountIRQ)
+		test.scheduler.Advance(uint64(want.line)*lineCycles + 1)
+		if status := test.arm9.Read16(statusAddress); status&statusVCount == 0 {
+			t.Errorf("DISPSTAT %#x on line %d: no VCount match", want.setting, want.line)
+		}
+		requested := test.arm9Interrupts.Flags()&(1<<irq.VCount) != 0
+		// Line 0 of the first frame doesn't start on the scheduler.
+		if requested != (want.line != 0) {
+			t.Errorf("DISPSTAT %#x on line %d: requested = %v", want.setting, want.line, requested)
+		}
+		if status := test.arm7.Read16(statusAddress); status&statusVCount != 0 && want.line != 0 {
+			t.Errorf("Arm7 DISPSTAT %#x matches the Arm9 setting", status)
+		}
+	}
+}
+
+func TestBlankingInterrupts(t *testing.T) {
+	test := newDisplayTest()
+	test.arm7.Write16(statusAddress, statusVBlankIRQ)
+	test.arm9.Write16(statusAddress, statusHBlankIRQ)
+
+	test.scheduler.Advance(hblankCycles)
+	if flags := test.arm9Interrupts.Flags(); flags != 1<<irq.HBlank {
+		t.Errorf("Arm9 IF = %#x, want HBlank", flags)
+	}
+	if flags := test.arm7Interrupts.Flags(); flags != 0 {
+		t.Errorf("Arm7 IF = %#x before VBlank", flags)
+	}
+	test.scheduler.Advance(visibleLines * lineCycles)
+	if flags := test.arm7Interrupts.Flags(); flags != 1<<irq.VBlank {
+		t.Errorf("Arm7 IF = %#x, want VBlank", flags)
+	}
+}
+
+func TestWriteVCount(t *testing.T) {
+	test := newDisplayTest()
+	test.scheduler.Advance(10 * lineCycles)
+	test.arm9.Write16(vcountAddress, 200)
+	if line := test.display.VCount(); line != 10 {
+		t.Errorf("VCOUNT = %d, want the change on the next line", line)
+	}
+	test.scheduler.Advance(11 * lineCycles)
+	if line := test.display.VCount(); line != 200 {
+		t.Errorf("VCOUNT = %d, want 200", line)
+	}
+
+	// Lines past the end of the frame are ignored.
+	test.arm9.Write16(vcountAddress, linesPerFrame)
+	test.scheduler.Advance(12 * lineCycles)
+	if line := test.display.VCount(); line != 201 {
+		t.Errorf("VCOUNT = %d, want 201", line)
+	}
+}
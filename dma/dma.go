@@ -0,0 +1,265 @@
+// Package dma implements the four DMA channels each DS CPU has.
+package dma
+
+import (
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+// StartMode is the event that starts a DMA transfer.
+type StartMode int
+
+// DMA start modes. Which of them a channel can use depends on its CPU.
+const (
+	Immediate StartMode = iota
+	VBlank
+	HBlank
+	DisplayStart
+	MainMemoryDisplay
+	Cartridge
+	GBASlot
+	GXFIFO
+	Wifi
+)
+
+// The start modes selected by the start timing field of DMAxCNT.
+var (
+	arm9StartModes = []StartMode{
+		Immediate, VBlank, HBlank, DisplayStart,
+		MainMemoryDisplay, Cartridge, GBASlot, GXFIFO,
+	}
+	// Mode 3 is the wifi interrupt on channels 0 and 2 and the GBA slot on
+	// channels 1 and 3.
+	arm7StartModes = []StartMode{Immediate, VBlank, Cartridge, Wifi}
+)
+
+// Address control modes of DMAxCNT.
+const (
+	addressIncrement = iota
+	addressDecrement
+	addressFixed
+	addressReload
+)
+
+// Bits of DMAxCNT.
+const (
+	controlRepeat uint32 = 1 << 25
+	controlWord   uint32 = 1 << 26
+	controlIRQ    uint32 = 1 << 30
+	controlEnable uint32 = 1 << 31
+)
+
+const (
+	baseAddress    = 0x040000b0
+	channelSpacing = 12
+	gxFIFOChunk    = 112
+)
+
+// Bus is the part of a CPU bus a DMA channel transfers through, so that
+// transfers are charged the same wait states as the CPU's own accesses.
+type Bus interface {
+	ReadData16(address uint32) uint32
+	ReadData32(address uint32) uint32
+	WriteData16(address uint32, val uint32)
+	WriteData32(address uint32, val uint32)
+	SetSequencial(val bool)
+}
+
+// Controller is the set of DMA channels of one CPU. Lower channels have
+// priority when several start together.
+type Controller struct {
+	bus        Bus
+	interrupts *irq.Controller
+	arm9       bool
+	channels   [4]channel
+}
+
+type channel struct {
+	controller *Controller
+	index      int
+	source     mmio.Register
+	dest       mmio.Register
+	control    mmio.Register
+	countMask  uint32
+
+	// Internal state of a running transfer.
+	currentSource uint32
+	currentDest   uint32
+	remaining     uint32
+}
+
+// NewArm7 constructs the DMA controller of the Arm7.
+func NewArm7(bus Bus, interrupts *irq.Controller) *Controller {
+	controller := &Controller{bus: bus, interrupts: interrupts}
+	for index := range controller.channels {
+		sourceMask := uint32(0x0ffffffe)
+		countMask := uint32(0x3fff)
+		if index == 0 {
+			sourceMask = 0x07fffffe
+		}
+		if index == 3 {
+			countMask = 0xffff
+		}
+		controller.initChannel(index, sourceMask, 0x07fffffe, countMask, 0xf7e00000)
+	}
+	return controller
+}
+
+// NewArm9 constructs the DMA controller of the Arm9.
+func NewArm9(bus Bus, interrupts *irq.Controller) *Controller {
+	controller := &Controller{bus: bus, interrupts: interrupts, arm9: true}
+	for index := range controller.channels {
+		controller.initChannel(index, 0x0ffffffe, 0x0ffffffe, 0x1fffff, 0xffe00000)
+	}
+	return controller
+}
+
+func (controller *Controller) initChannel(index int, sourceMask uint32, destMask uint32, countMask uint32, controlMask uint32) {
+	ch := &controller.channels[index]
+	ch.controller = controller
+	ch.index = index
+	ch.countMask = countMask
+	// Only the control register can be read back.
+	ch.source.WriteMask = sourceMask
+	ch.dest.WriteMask = destMask
+	ch.control.ReadMask = controlMask | countMask
+	ch.control.WriteMask = controlMask | countMask
+	ch.control.OnWrite = func(value uint32, mask uint32) {
+		ch.writeControl(value)
+	}
+}
+
+// Map registers the DMA registers with the I/O registry of the
+// controller's CPU.
+func (controller *Controller) Map(registry *mmio.Registry) {
+	for index := range controller.channels {
+		ch := &controller.channels[index]
+		base := uint32(baseAddress + index*channelSpacing)
+		registry.MapRegister(base, 4, &ch.source)
+		registry.MapRegister(base+4, 4, &ch.dest)
+		registry.MapRegister(base+8, 4, &ch.control)
+	}
+}
+
+// Trigger starts every enabled channel waiting on mode.
+func (controller *Controller) Trigger(mode StartMode) {
+	for index := range controller.channels {
+		ch := &controller.channels[index]
+		if ch.enabled() && ch.startMode() == mode {
+			ch.transfer()
+		}
+	}
+}
+
+func (ch *channel) enabled() bool {
+	return ch.control.Value&controlEnable != 0
+}
+
+func (ch *channel) startMode() StartMode {
+	if ch.controller.arm9 {
+		return arm9StartModes[(ch.control.Value>>27)&0x7]
+	}
+	mode := arm7StartModes[(ch.control.Value>>28)&0x3]
+	if mode == Wifi && ch.index&1 != 0 {
+		return GBASlot
+	}
+	return mode
+}
+
+func (ch *channel) unitSize() uint32 {
+	if ch.control.Value&controlWord != 0 {
+		return 4
+	}
+	return 2
+}
+
+func (ch *channel) sourceControl() uint32 {
+	return (ch.control.Value >> 23) & 0x3
+}
+
+func (ch *channel) destControl() uint32 {
+	return (ch.control.Value >> 21) & 0x3
+}
+
+// count returns the number of units to transfer, where zero means the
+// largest count the channel supports.
+func (ch *channel) count() uint32 {
+	count := ch.control.Value & ch.countMask
+	if count == 0 {
+		return ch.countMask + 1
+	}
+	return count
+}
+
+func (ch *channel) writeControl(value uint32) {
+	if !ch.enabled() {
+		ch.remaining = 0
+		return
+	}
+	if ch.remaining != 0 {
+		return
+	}
+	// The source, destination and count are latched when the channel
+	// is enabled.
+	ch.currentSource = ch.source.Value
+	ch.currentDest = ch.dest.Value
+	ch.remaining = ch.count()
+	if ch.startMode() == Immediate {
+		ch.transfer()
+	}
+}
+
+// step returns how an address changes after each unit for an address
+// control mode.
+func step(mode uint32, unitSize uint32) uint32 {
+	switch mode {
+	case addressDecrement:
+		return -unitSize
+	case addressFixed:
+		return 0
+	}
+	return unitSize
+}
+
+func (ch *channel) transfer() {
+	bus := ch.controller.bus
+	unitSize := ch.unitSize()
+	sourceStep := step(ch.sourceControl(), unitSize)
+	destStep := step(ch.destControl(), unitSize)
+
+	units := ch.remaining
+	// The geometry FIFO is filled 112 words at a time.
+	if ch.startMode() == GXFIFO && units > gxFIFOChunk {
+		units = gxFIFOChunk
+	}
+
+	bus.SetSequencial(false)
+	for ; units > 0; units-- {
+		if unitSize == 4 {
+			bus.WriteData32(ch.currentDest, bus.ReadData32(ch.currentSource))
+		} else {
+			bus.WriteData16(ch.currentDest, bus.ReadData16(ch.currentSource))
+		}
+		bus.SetSequencial(true)
+		ch.currentSource += sourceStep
+		ch.currentDest += destStep
+		ch.remaining--
+	}
+
+	if ch.remaining != 0 {
+		return
+	}
+
+	if ch.control.Value&controlIRQ != 0 {
+		ch.controller.interrupts.Request(irq.DMA0 + irq.Source(ch.index))
+	}
+
+	if ch.control.Value&controlRepeat != 0 && ch.startMode() != Immediate {
+		ch.remaining = ch.count()
+		if ch.destControl() == addressReload {
+			ch.currentDest = ch.dest.Value
+		}
+		return
+	}
+	ch.control.Value &^= controlEnable
+}
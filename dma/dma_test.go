@@ -0,0 +1,184 @@
+package dma
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+// testBus is 4KB of little endian memory, repeated over the address space.
+type testBus struct {
+	memory   [0x1000]uint8
+	accesses int
+}
+
+func (bus *testBus) ReadData16(address uint32) uint32 {
+	address &= 0xffe
+	return uint32(bus.memory[address]) | uint32(bus.memory[address+1])<<8
+}
+
+func (bus *testBus) ReadData32(address uint32) uint32 {
+	return bus.ReadData16(address&^3) | bus.ReadData16(address&^3+2)<<16
+}
+
+func (bus *testBus) WriteData16(address uint32, val uint32) {
+	address &= 0xffe
+	bus.memory[address] = uint8(val)
+	bus.memory[address+1] = uint8(val >> 8)
+	bus.accesses++
+}
+
+func (bus *testBus) WriteData32(address uint32, val uint32) {
+	address &^= 3
+	bus.WriteData16(address, val)
+	bus.WriteData16(address+2, val>>16)
+	bus.accesses--
+}
+
+func (bus *testBus) SetSequencial(val bool) {}
+
+type dmaTest struct {
+	bus        *testBus
+	registry   *mmio.Registry
+	interrupts *irq.Controller
+	controller *Controller
+}
+
+func newDMATest(arm9 bool) *dmaTest {
+	test := &dmaTest{
+		bus:        &testBus{},
+		registry:   mmio.NewRegistry(),
+		interrupts: irq.NewController(),
+	}
+	if arm9 {
+		test.controller = NewArm9(test.bus, test.interrupts)
+	} else {
+		test.controller = NewArm7(test.bus, test.interrupts)
+	}
+	test.controller.Map(test.registry)
+	return test
+}
+
+func (test *dmaTest) start(index int, source uint32, dest uint32, control uint32) {
+	base := uint32(baseAddress + index*channelSpacing)
+	test.registry.Write32(base, source)
+	test.registry.Write32(base+4, dest)
+	test.registry.Write32(base+8, control|controlEnable)
+}
+
+func (test *dmaTest) control(index int) uint32 {
+	return test.registry.Read32(uint32(baseAddress + index*channelSpacing + 8))
+}
+
+func (test *dmaTest) fill(address uint32, count int) {
+	for offset := 0; offset < count; offset++ {
+		test.bus.memory[int(address)+offset] = uint8(offset + 1)
+	}
+}
+
+func TestAddressControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		control uint32
+		source  uint32
+		dest    uint32
+		// want is the destination after copying from 1, 2, 3... at the
+		// source.
+		want []uint8
+	}{
+		{"increment", controlWord | 2, 0x100, 0x200, []uint8{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"halfwords", 3, 0x100, 0x200, []uint8{1, 2, 3, 4, 5, 6, 0, 0}},
+		{"decrement source", addressDecrement<<23 | 2, 0x102, 0x200, []uint8{3, 4, 1, 2, 0, 0, 0, 0}},
+		{"decrement dest", addressDecrement<<21 | 2, 0x100, 0x202, []uint8{3, 4, 1, 2, 0, 0, 0, 0}},
+		{"fixed source", addressFixed<<23 | 3, 0x100, 0x200, []uint8{1, 2, 1, 2, 1, 2, 0, 0}},
+		{"fixed dest", addressFixed<<21 | 3, 0x100, 0x200, []uint8{5, 6, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		dma := newDMATest(true)
+		dma.fill(0x100, 8)
+		dma.start(0, test.source, test.dest, test.control)
+		for index, want := range test.want {
+			if got := dma.bus.memory[0x200+index]; got != want {
+				t.Errorf("%s: byte %d = %d, want %d", test.name, index, got, want)
+			}
+		}
+		if control := dma.control(0); control&controlEnable != 0 {
+			t.Errorf("%s: still enabled after the transfer", test.name)
+		}
+	}
+}
+
+func TestArm7StartModes(t *testing.T) {
+	tests := []struct {
+		index  int
+		timing uint32
+		mode   StartMode
+	}{
+		{0, 0, Immediate},
+		{0, 1, VBlank},
+		{1, 2, Cartridge},
+		{0, 3, Wifi},
+		{2, 3, Wifi},
+		{1, 3, GBASlot},
+		{3, 3, GBASlot},
+	}
+	dma := newDMATest(false)
+	for _, test := range tests {
+		ch := &dma.controller.channels[test.index]
+		ch.control.Value = test.timing << 28
+		if mode := ch.startMode(); mode != test.mode {
+			t.Errorf("channel %d, timing %d: mode = %d, want %d", test.index, test.timing, mode, test.mode)
+		}
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	dma := newDMATest(true)
+	dma.fill(0x100, 8)
+	hblank := uint32(2) << 27
+	dma.start(1, 0x100, 0x200, hblank|controlRepeat|controlIRQ|addressReload<<21|2)
+
+	if dma.bus.accesses != 0 {
+		t.Fatalf("transferred %d units before HBlank", dma.bus.accesses)
+	}
+	dma.controller.Trigger(VBlank)
+	if dma.bus.accesses != 0 {
+		t.Fatalf("transferred %d units on VBlank", dma.bus.accesses)
+	}
+	if flags := dma.interrupts.Flags(); flags != 0 {
+		t.Errorf("IF = %#x before the transfer", flags)
+	}
+
+	for line := 0; line < 2; line++ {
+		dma.controller.Trigger(HBlank)
+	}
+	if dma.bus.accesses != 4 {
+		t.Errorf("transferred %d units, want 4", dma.bus.accesses)
+	}
+	// The destination is reloaded and the source keeps going.
+	for index, want := range []uint8{5, 6, 7, 8, 0, 0, 0, 0} {
+		if got := dma.bus.memory[0x200+index]; got != want {
+			t.Errorf("byte %d = %d, want %d", index, got, want)
+		}
+	}
+	if control := dma.control(1); control&controlEnable == 0 {
+		t.Error("repeating channel disabled")
+	}
+	if flags := dma.interrupts.Flags(); flags != 1<<irq.DMA1 {
+		t.Errorf("IF = %#x, want DMA 1", flags)
+	}
+}
+
+func TestGeometryFIFOChunks(t *testing.T) {
+	dma := newDMATest(true)
+	gxFIFO := uint32(7) << 27
+	dma.start(0, 0x100, 0x400, gxFIFO|controlWord|addressFixed<<21|300)
+
+	for index, want := range []int{112, 224, 300, 300} {
+		dma.controller.Trigger(GXFIFO)
+		if dma.bus.accesses != want {
+			t.Errorf("trigger %d: transferred %d words, want %d", index, dma.bus.accesses, want)
+		}
+	}
+}
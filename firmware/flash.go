@@ -0,0 +1,65 @@
+// Package firmware implements the DS firmware flash on the SPI bus and
+// generates firmware images with the user settings filled in.
+package firmware
+
+import (
+	"os"
+
+	"github.com/damilolarandolph/casper/flash"
+)
+
+// Flash is the firmware flash chip, a 256KB ST M25PE20. It can be backed
+// by a file that changes are written back to.
+type Flash struct {
+	image flash.Image
+	chip  *flash.Chip
+}
+
+// NewFlash constructs the flash chip holding data.
+func NewFlash(data []byte) *Flash {
+	firmware := &Flash{}
+	firmware.image.Data = data
+	firmware.chip = flash.NewChip(&firmware.image)
+	return firmware
+}
+
+// Data returns the contents of the chip.
+func (firmware *Flash) Data() []byte {
+	return firmware.image.Data
+}
+
+// Load reads the firmware dump at path into the chip, keeping the path so
+// changes the firmware makes to its settings are saved.
+func (firmware *Flash) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) != len(firmware.image.Data) {
+		return ErrBadSize
+	}
+	copy(firmware.image.Data, data)
+	firmware.image.Path = path
+	return nil
+}
+
+// SetPath sets the file changes are written to, without reading it.
+func (firmware *Flash) SetPath(path string) {
+	firmware.image.Path = path
+}
+
+// Flush writes the chip to its file if it has changed.
+func (firmware *Flash) Flush() error {
+	return firmware.image.Flush()
+}
+
+// Transfer exchanges a byte with the chip. The first byte after it is
+// selected is the command.
+func (firmware *Flash) Transfer(value uint8) uint8 {
+	return firmware.chip.Transfer(value)
+}
+
+// Release completes writes and erases when the chip is deselected.
+func (firmware *Flash) Release() {
+	firmware.chip.Release()
+}
@@ -0,0 +1,211 @@
+package firmware
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+
+	"github.com/damilolarandolph/casper/cart"
+)
+
+// Size is the size of the firmware flash.
+const Size = 0x40000
+
+// UserSettingsSize is the size of the user settings, which the firmware
+// also copies to main RAM before starting a game.
+const UserSettingsSize = 0x70
+
+const (
+	userSettingsOffset  = 0x3fe00
+	userSettingsSpacing = 0x100
+	accessPointOffset   = 0x3fa00
+	accessPointSize     = 0x100
+	accessPointCount    = 3
+	wifiConfigOffset    = 0x2c
+	wifiConfigSize      = 0x138
+	nicknameLength      = 10
+	messageLength       = 26
+	settingsVersion     = 5
+)
+
+// ErrBadSize is returned when a firmware dump isn't the size of the flash.
+var ErrBadSize = errors.New("firmware: dump has the wrong size")
+
+// Language is the language selected in the user settings.
+type Language uint8
+
+// The languages of the original DS.
+const (
+	Japanese Language = iota
+	English
+	French
+	German
+	Italian
+	Spanish
+)
+
+// Calibration maps touchscreen ADC readings to screen pixels by two
+// reference points, one near each corner of the screen.
+type Calibration struct {
+	ADCX1    uint16
+	ADCY1    uint16
+	ScreenX1 uint8
+	ScreenY1 uint8
+	ADCX2    uint16
+	ADCY2    uint16
+	ScreenX2 uint8
+	ScreenY2 uint8
+}
+
+// Settings are the user settings kept in the firmware.
+type Settings struct {
+	Nickname      string
+	Message       string
+	FavoriteColor uint8
+	BirthMonth    uint8
+	BirthDay      uint8
+	Language      Language
+	Calibration   Calibration
+}
+
+// DefaultSettings returns the settings used when generating a firmware
+// image. The calibration makes ADC readings sixteen times the pixel
+// coordinates.
+func DefaultSettings() Settings {
+	return Settings{
+		Nickname:      "casper",
+		FavoriteColor: 0,
+		BirthMonth:    1,
+		BirthDay:      1,
+		Language:      English,
+		Calibration: Calibration{
+			ADCX1:    0x0200,
+			ADCY1:    0x0200,
+			ScreenX1: 0x20,
+			ScreenY1: 0x20,
+			ADCX2:    0x0e00,
+			ADCY2:    0x0a00,
+			ScreenX2: 0xe0,
+			ScreenY2: 0xa0,
+		},
+	}
+}
+
+// Generate builds a firmware image holding only the header, empty wifi
+// access points and the given user settings. It has no boot code, so it
+// can only be used with direct boot.
+func Generate(settings Settings) []byte {
+	image := make([]byte, Size)
+	le := binary.LittleEndian
+
+	copy(image[0x008:], "MACP")
+	// Console type, an original DS.
+	image[0x01d] = 0xff
+	le.PutUint16(image[0x020:], userSettingsOffset/8)
+
+	le.PutUint16(image[wifiConfigOffset:], wifiConfigSize)
+	// Nintendo's MAC address prefix.
+	copy(image[0x036:], []byte{0x00, 0x09, 0xbf, 0x12, 0x34, 0x56})
+	le.PutUint16(image[0x03c:], 0x3ffe)
+	le.PutUint16(image[0x02a:], cart.CRC16(0, image[wifiConfigOffset:wifiConfigOffset+wifiConfigSize]))
+
+	for index := 0; index < accessPointCount; index++ {
+		accessPoint := image[accessPointOffset+index*accessPointSize:][:accessPointSize]
+		// Not configured.
+		accessPoint[0xe7] = 0xff
+		le.PutUint16(accessPoint[0xfe:], cart.CRC16(0, accessPoint[:0xfe]))
+	}
+
+	user := encodeSettings(settings)
+	for index := 0; index < 2; index++ {
+		copy(image[userSettingsOffset+index*userSettingsSpacing:], user)
+	}
+	// The copy with the higher update counter is the current one.
+	setUpdateCounter(image[userSettingsOffset+userSettingsSpacing:], 1)
+	return image
+}
+
+func encodeSettings(settings Settings) []byte {
+	user := make([]byte, userSettingsSpacing)
+	le := binary.LittleEndian
+
+	le.PutUint16(user[0x00:], settingsVersion)
+	user[0x02] = settings.FavoriteColor
+	user[0x03] = settings.BirthMonth
+	user[0x04] = settings.BirthDay
+	length := putString(user[0x06:], settings.Nickname, nicknameLength)
+	le.PutUint16(user[0x1a:], uint16(length))
+	length = putString(user[0x1c:], settings.Message, messageLength)
+	le.PutUint16(user[0x50:], uint16(length))
+
+	calibration := settings.Calibration
+	le.PutUint16(user[0x58:], calibration.ADCX1)
+	le.PutUint16(user[0x5a:], calibration.ADCY1)
+	user[0x5c] = calibration.ScreenX1
+	user[0x5d] = calibration.ScreenY1
+	le.PutUint16(user[0x5e:], calibration.ADCX2)
+	le.PutUint16(user[0x60:], calibration.ADCY2)
+	user[0x62] = calibration.ScreenX2
+	user[0x63] = calibration.ScreenY2
+
+	// The language with the backlight at full brightness.
+	le.PutUint16(user[0x64:], uint16(settings.Language)&7|3<<4)
+	setUpdateCounter(user, 0)
+	return user
+}
+
+// putString stores s as UTF-16 in at most length characters and returns
+// the number stored.
+func putString(data []byte, s string, length int) int {
+	encoded := utf16.Encode([]rune(s))
+	if len(encoded) > length {
+		encoded = encoded[:length]
+	}
+	for index, char := range encoded {
+		binary.LittleEndian.PutUint16(data[index*2:], char)
+	}
+	return len(encoded)
+}
+
+// setUpdateCounter stores the update counter of a copy of the user
+// settings and updates its CRC.
+func setUpdateCounter(user []byte, counter uint16) {
+	binary.LittleEndian.PutUint16(user[0x70:], counter)
+	binary.LittleEndian.PutUint16(user[0x72:], cart.CRC16(0xffff, user[:UserSettingsSize]))
+}
+
+// UserSettings returns the current copy of the user settings in a
+// firmware image, the valid one with the higher update counter, or nil if
+// neither copy is valid.
+func UserSettings(image []byte) []byte {
+	var current []byte
+	var currentCounter uint16
+	for index := 0; index < 2; index++ {
+		user := image[userSettingsOffset+index*userSettingsSpacing:][:userSettingsSpacing]
+		if binary.LittleEndian.Uint16(user[0x72:]) != cart.CRC16(0xffff, user[:UserSettingsSize]) {
+			continue
+		}
+		// The counter wraps at 0x80.
+		counter := binary.LittleEndian.Uint16(user[0x70:]) & 0x7f
+		if current == nil || (counter-currentCounter)&0x7f == 1 {
+			current = user[:UserSettingsSize]
+			currentCounter = counter
+		}
+	}
+	return current
+}
+
+// ParseCalibration reads the touchscreen calibration from user settings.
+func ParseCalibration(user []byte) Calibration {
+	le := binary.LittleEndian
+	return Calibration{
+		ADCX1:    le.Uint16(user[0x58:]),
+		ADCY1:    le.Uint16(user[0x5a:]),
+		ScreenX1: user[0x5c],
+		ScreenY1: user[0x5d],
+		ADCX2:    le.Uint16(user[0x5e:]),
+		ADCY2:    le.Uint16(user[0x60:]),
+		ScreenX2: user[0x62],
+		ScreenY2: user[0x63],
+	}
+}
@@ -0,0 +1,128 @@
+package firmware
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/damilolarandolph/casper/cart"
+)
+
+func decodeString(data []byte, length int) string {
+	chars := make([]uint16, length)
+	for index := range chars {
+		chars[index] = binary.LittleEndian.Uint16(data[index*2:])
+	}
+	return string(utf16.Decode(chars))
+}
+
+func TestGenerateUserSettings(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Nickname = "Zoë"
+	settings.Message = "hello"
+	settings.FavoriteColor = 7
+	settings.BirthMonth = 12
+	settings.BirthDay = 31
+	settings.Language = German
+	image := Generate(settings)
+	if len(image) != Size {
+		t.Fatalf("image is %#x bytes", len(image))
+	}
+
+	user := UserSettings(image)
+	if len(user) != UserSettingsSize {
+		t.Fatalf("user settings = %d bytes", len(user))
+	}
+	le := binary.LittleEndian
+	if version := le.Uint16(user[0x00:]); version != settingsVersion {
+		t.Errorf("version = %d", version)
+	}
+	if user[0x02] != 7 || user[0x03] != 12 || user[0x04] != 31 {
+		t.Errorf("color and birthday = %x", user[0x02:0x05])
+	}
+	if length := int(le.Uint16(user[0x1a:])); decodeString(user[0x06:], length) != "Zoë" {
+		t.Errorf("nickname = %q", decodeString(user[0x06:], length))
+	}
+	if length := int(le.Uint16(user[0x50:])); decodeString(user[0x1c:], length) != "hello" {
+		t.Errorf("message = %q", decodeString(user[0x1c:], length))
+	}
+	if language := Language(user[0x64] & 7); language != German {
+		t.Errorf("language = %d", language)
+	}
+	if calibration := ParseCalibration(user); calibration != settings.Calibration {
+		t.Errorf("calibration = %+v", calibration)
+	}
+	// The second copy has the higher update counter.
+	if &user[0] != &image[userSettingsOffset+userSettingsSpacing] {
+		t.Error("the first copy was picked")
+	}
+}
+
+func TestGenerateCRCs(t *testing.T) {
+	image := Generate(DefaultSettings())
+	le := binary.LittleEndian
+	if crc := cart.CRC16(0, image[wifiConfigOffset:wifiConfigOffset+wifiConfigSize]); le.Uint16(image[0x2a:]) != crc {
+		t.Errorf("wifi settings CRC = %#x, want %#x", le.Uint16(image[0x2a:]), crc)
+	}
+	for index := 0; index < accessPointCount; index++ {
+		accessPoint := image[accessPointOffset+index*accessPointSize:][:accessPointSize]
+		if crc := cart.CRC16(0, accessPoint[:0xfe]); le.Uint16(accessPoint[0xfe:]) != crc {
+			t.Errorf("access point %d CRC = %#x, want %#x", index, le.Uint16(accessPoint[0xfe:]), crc)
+		}
+	}
+	for index := 0; index < 2; index++ {
+		user := image[userSettingsOffset+index*userSettingsSpacing:]
+		if crc := cart.CRC16(0xffff, user[:UserSettingsSize]); le.Uint16(user[0x72:]) != crc {
+			t.Errorf("user settings %d CRC = %#x, want %#x", index, le.Uint16(user[0x72:]), crc)
+		}
+	}
+}
+
+func TestUserSettingsCopies(t *testing.T) {
+	first := func(image []byte) []byte { return image[userSettingsOffset:] }
+	second := func(image []byte) []byte { return image[userSettingsOffset+userSettingsSpacing:] }
+	tests := []struct {
+		name    string
+		edit    func(image []byte)
+		current func(image []byte) []byte
+	}{
+		{"higher counter", func(image []byte) {}, second},
+		{"corrupt second copy", func(image []byte) { second(image)[0x02] ^= 1 }, first},
+		{"counter wraps", func(image []byte) {
+			setUpdateCounter(first(image), 0)
+			setUpdateCounter(second(image), 0x7f)
+		}, first},
+		{"both corrupt", func(image []byte) {
+			first(image)[0x02] ^= 1
+			second(image)[0x02] ^= 1
+		}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image := Generate(DefaultSettings())
+			test.edit(image)
+			user := UserSettings(image)
+			if test.current == nil {
+				if user != nil {
+					t.Error("picked a corrupt copy")
+				}
+				return
+			}
+			if user == nil || &user[0] != &test.current(image)[0] {
+				t.Error("picked the wrong copy")
+			}
+		})
+	}
+}
+
+func TestLongNicknameIsTruncated(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Nickname = "abcdefghijklmnop"
+	user := UserSettings(Generate(settings))
+	if length := binary.LittleEndian.Uint16(user[0x1a:]); length != nicknameLength {
+		t.Fatalf("nickname length = %d", length)
+	}
+	if nickname := decodeString(user[0x06:], nicknameLength); nickname != "abcdefghij" {
+		t.Errorf("nickname = %q", nickname)
+	}
+}
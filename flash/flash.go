@@ -0,0 +1,217 @@
+// Package flash implements the SPI flash chips the DS keeps its firmware
+// in and that cards use as backup memory.
+package flash
+
+import "os"
+
+// Flash commands.
+const (
+	CommandWriteStatus  = 0x01
+	CommandPageProgram  = 0x02
+	CommandRead         = 0x03
+	CommandWriteDisable = 0x04
+	CommandReadStatus   = 0x05
+	CommandWriteEnable  = 0x06
+	CommandPageWrite    = 0x0a
+	CommandFastRead     = 0x0b
+	CommandReadID       = 0x9f
+	CommandPowerDown    = 0xb9
+	CommandPowerUp      = 0xab
+	CommandSectorErase  = 0xd8
+	CommandPageErase    = 0xdb
+)
+
+// Bits of the status register.
+const (
+	statusWriteEnable uint8 = 1 << 1
+	statusWriteMask   uint8 = 0x8c
+)
+
+const (
+	pageSize   = 0x100
+	sectorSize = 0x10000
+	addressLen = 3
+	// ST Microelectronics, the maker of the firmware flash and of most
+	// backup flash chips.
+	manufacturer = 0x20
+	memoryType   = 0x40
+)
+
+// Image is the contents of a chip, which can be kept in a file that
+// changes are written back to.
+type Image struct {
+	Data  []byte
+	Path  string
+	Dirty bool
+}
+
+// Flush writes the image to its file if it has changed.
+func (image *Image) Flush() error {
+	if !image.Dirty || image.Path == "" {
+		return nil
+	}
+	if err := os.WriteFile(image.Path, image.Data, 0644); err != nil {
+		return err
+	}
+	image.Dirty = false
+	return nil
+}
+
+// Chip is a flash chip on the SPI bus holding an image.
+type Chip struct {
+	image *Image
+	// Resize, when set, is called with every address the chip is given
+	// before it wraps to the size of the image, so an image whose size
+	// isn't known yet can grow to cover it.
+	Resize func(address uint32)
+
+	status      uint8
+	command     uint8
+	position    int
+	address     uint32
+	poweredDown bool
+}
+
+// NewChip constructs a chip holding image.
+func NewChip(image *Image) *Chip {
+	return &Chip{image: image}
+}
+
+// Transfer exchanges a byte with the chip. The first byte after it is
+// selected is the command.
+func (chip *Chip) Transfer(value uint8) uint8 {
+	position := chip.position
+	chip.position++
+	if position == 0 {
+		chip.command = value
+		chip.address = 0
+		switch value {
+		case CommandWriteEnable:
+			chip.status |= statusWriteEnable
+		case CommandWriteDisable:
+			chip.status &^= statusWriteEnable
+		case CommandPowerDown:
+			chip.poweredDown = true
+		case CommandPowerUp:
+			chip.poweredDown = false
+		}
+		return 0xff
+	}
+	if chip.poweredDown {
+		return 0xff
+	}
+
+	switch chip.command {
+	case CommandReadStatus:
+		return chip.status
+	case CommandWriteStatus:
+		if position == 1 && chip.status&statusWriteEnable != 0 {
+			chip.status = chip.status&^statusWriteMask | value&statusWriteMask
+		}
+	case CommandReadID:
+		return chip.id(position - 1)
+	case CommandRead:
+		if chip.addressByte(position, value) {
+			return chip.readByte()
+		}
+	// Fast read is followed by a dummy byte.
+	case CommandFastRead:
+		if chip.addressByte(position, value) && position > addressLen+1 {
+			return chip.readByte()
+		}
+	// Page write replaces the bytes, page program can only clear bits.
+	case CommandPageWrite:
+		if chip.addressByte(position, value) {
+			chip.writeByte(value)
+		}
+	case CommandPageProgram:
+		if chip.addressByte(position, value) {
+			chip.writeByte(chip.image.Data[chip.address] & value)
+		}
+	case CommandPageErase, CommandSectorErase:
+		chip.addressByte(position, value)
+	}
+	return 0xff
+}
+
+// id returns the bytes of the JEDEC ID, the last of which is the log2 of
+// the capacity.
+func (chip *Chip) id(index int) uint8 {
+	switch index {
+	case 0:
+		return manufacturer
+	case 1:
+		return memoryType
+	case 2:
+		size := uint8(0)
+		for 1<<size < len(chip.image.Data) {
+			size++
+		}
+		return size
+	}
+	return 0xff
+}
+
+// addressByte shifts in the address bytes that follow the command and
+// reports whether the address is complete.
+func (chip *Chip) addressByte(position int, value uint8) bool {
+	if position > addressLen {
+		return true
+	}
+	chip.address = chip.address<<8 | uint32(value)
+	if position == addressLen {
+		if chip.Resize != nil {
+			chip.Resize(chip.address)
+		}
+		chip.address %= uint32(len(chip.image.Data))
+	}
+	return false
+}
+
+func (chip *Chip) readByte() uint8 {
+	value := chip.image.Data[chip.address]
+	chip.address = (chip.address + 1) % uint32(len(chip.image.Data))
+	return value
+}
+
+// writeByte stores a byte, wrapping within the current page.
+func (chip *Chip) writeByte(value uint8) {
+	if chip.status&statusWriteEnable == 0 {
+		return
+	}
+	chip.image.Data[chip.address] = value
+	chip.address = chip.address&^(pageSize-1) | (chip.address+1)&(pageSize-1)
+	chip.image.Dirty = true
+}
+
+// Release ends the selection of the chip, which completes writes and
+// erases and disables writing again.
+func (chip *Chip) Release() {
+	command := chip.command
+	complete := chip.position > 0
+	chip.position = 0
+	if !complete || chip.poweredDown {
+		return
+	}
+	switch command {
+	case CommandPageErase:
+		chip.erase(pageSize)
+	case CommandSectorErase:
+		chip.erase(sectorSize)
+	case CommandPageWrite, CommandPageProgram, CommandWriteStatus:
+	default:
+		return
+	}
+	chip.status &^= statusWriteEnable
+}
+
+func (chip *Chip) erase(size uint32) {
+	if chip.status&statusWriteEnable == 0 {
+		return
+	}
+	start := chip.address &^ (size - 1)
+	for offset := uint32(0); offset < size && int(start+offset) < len(chip.image.Data); offset++ {
+		chip.image.Data[start+offset] = 0xff
+	}
+	chip.image.Dirty = true
+}
@@ -0,0 +1,77 @@
+package flash
+
+import "testing"
+
+// spi selects the chip, exchanges bytes with it and releases it.
+func spi(chip *Chip, bytes ...uint8) []uint8 {
+	var response []uint8
+	for _, value := range bytes {
+		response = append(response, chip.Transfer(value))
+	}
+	chip.Release()
+	return response
+}
+
+func TestFlashCommands(t *testing.T) {
+	image := &Image{Data: make([]byte, 0x40000)}
+	data := image.Data
+	data[0x20] = 0xc0
+	data[0x21] = 0x7f
+	chip := NewChip(image)
+
+	if id := spi(chip, CommandReadID, 0, 0, 0); id[1] != 0x20 || id[2] != 0x40 || id[3] != 0x12 {
+		t.Errorf("JEDEC ID = %x", id[1:])
+	}
+	if response := spi(chip, CommandRead, 0, 0, 0x20, 0, 0); response[4] != 0xc0 || response[5] != 0x7f {
+		t.Errorf("read = %x", response[4:])
+	}
+	if response := spi(chip, CommandFastRead, 0, 0, 0x20, 0, 0, 0); response[5] != 0xc0 || response[6] != 0x7f {
+		t.Errorf("fast read = %x", response[5:])
+	}
+
+	spi(chip, CommandPageWrite, 0, 0, 0x00, 0x55)
+	if data[0] != 0 {
+		t.Error("written without the write enable latch")
+	}
+	spi(chip, CommandWriteEnable)
+	if status := spi(chip, CommandReadStatus, 0)[1]; status != statusWriteEnable {
+		t.Errorf("status = %#x", status)
+	}
+	spi(chip, CommandPageWrite, 0, 0, 0xff, 0x55, 0x66)
+	// Writes wrap within the page.
+	if data[0xff] != 0x55 || data[0x00] != 0x66 {
+		t.Errorf("page write = %#x, %#x", data[0xff], data[0x00])
+	}
+	if status := spi(chip, CommandReadStatus, 0)[1]; status != 0 {
+		t.Error("write enable latch left set")
+	}
+
+	spi(chip, CommandPowerDown)
+	if response := spi(chip, CommandRead, 0, 0, 0x20, 0); response[4] != 0xff {
+		t.Error("powered down chip answered")
+	}
+	spi(chip, CommandPowerUp)
+	spi(chip, CommandWriteEnable)
+	spi(chip, CommandPageErase, 0, 0, 0x80)
+	if data[0x20] != 0xff || data[0xff] != 0xff {
+		t.Error("page not erased")
+	}
+}
+
+func TestResize(t *testing.T) {
+	image := &Image{Data: make([]byte, 0x100)}
+	chip := NewChip(image)
+	var addresses []uint32
+	chip.Resize = func(address uint32) {
+		addresses = append(addresses, address)
+		image.Data = make([]byte, 0x1000)
+	}
+	spi(chip, CommandWriteEnable)
+	spi(chip, CommandPageWrite, 0, 0x08, 0x10, 0x42)
+	if len(addresses) != 1 || addresses[0] != 0x810 {
+		t.Errorf("resized for %x, want 810", addresses)
+	}
+	if image.Data[0x810] != 0x42 || !image.Dirty {
+		t.Errorf("byte = %#x, dirty = %v", image.Data[0x810], image.Dirty)
+	}
+}
@@ -0,0 +1,308 @@
+package gpu
+
+// Kinds of background the BG modes give each layer.
+const (
+	bgOff = iota
+	bgText
+	bgAffine
+	bgExtended
+	bgLarge
+	bg3D
+)
+
+// bgModes is the kind of each background in BG modes 0 to 7. Mode 7 is
+// prohibited and shows nothing.
+var bgModes = [8][4]int{
+	{bgText, bgText, bgText, bgText},
+	{bgText, bgText, bgText, bgAffine},
+	{bgText, bgText, bgAffine, bgAffine},
+	{bgText, bgText, bgText, bgExtended},
+	{bgText, bgText, bgAffine, bgExtended},
+	{bgText, bgText, bgExtended, bgExtended},
+	{bg3D, bgOff, bgLarge, bgOff},
+	{bgOff, bgOff, bgOff, bgOff},
+}
+
+// Bits of BGxCNT.
+const (
+	bgPriorityMask uint16 = 0x3
+	bgCharShift           = 2
+	bgDirectColor  uint16 = 1 << 2
+	bgMosaic       uint16 = 1 << 6
+	bgColors256    uint16 = 1 << 7
+	bgScreenShift         = 8
+	// Bit 13 selects extended palette slots 2 and 3 for BG0 and BG1 and
+	// wraps the affine backgrounds around.
+	bgExtSlot    uint16 = 1 << 13
+	bgWraparound uint16 = 1 << 13
+	bgSizeShift         = 14
+	bgBaseMask   uint16 = 0x1f
+	bgCharMask   uint16 = 0xf
+)
+
+// Bits of the 16 bit screen entries.
+const (
+	entryTileMask uint16 = 0x3ff
+	entryHFlip    uint16 = 1 << 10
+	entryVFlip    uint16 = 1 << 11
+	entryPalette         = 12
+)
+
+const (
+	charBlockSize   = 0x4000
+	screenBlockSize = 0x800
+	blockSize       = 0x10000
+	extPaletteSize  = 0x2000
+	tileSize4       = 32
+	tileSize8       = 64
+)
+
+// bitmapSizes are the sizes of the extended bitmap backgrounds.
+var bitmapSizes = [4][2]int{{128, 128}, {256, 256}, {512, 256}, {512, 512}}
+
+// largeSizes are the sizes of the large bitmap of BG mode 6.
+var largeSizes = [4][2]int{{512, 1024}, {1024, 512}, {512, 1024}, {1024, 512}}
+
+// renderBackground draws a line of a background into its line buffer.
+func (engine *Engine) renderBackground(bg int, kind int, line int) {
+	out := &engine.bgLines[bg]
+	for x := range out {
+		out[x] = 0
+	}
+	switch kind {
+	case bgText:
+		engine.renderText(bg, line, out)
+	case bgAffine:
+		engine.renderAffine(bg, out)
+	case bgExtended:
+		engine.renderExtended(bg, out)
+	case bgLarge:
+		engine.renderLarge(bg, out)
+	}
+}
+
+func (engine *Engine) bgControl(bg int) uint16 {
+	return engine.read16(bgControl + bg*2)
+}
+
+// bases returns the start of the tiles and of the screen entries of a
+// tiled background. Engine A moves both by 64KB blocks from DISPCNT.
+func (engine *Engine) bases(control uint16) (charBase uint32, screenBase uint32) {
+	charBase = uint32(control>>bgCharShift&bgCharMask) * charBlockSize
+	screenBase = uint32(control>>bgScreenShift&bgBaseMask) * screenBlockSize
+	if engine.index == EngineA {
+		display := engine.displayControl()
+		charBase += (display >> dispCharBlockShift & 7) * blockSize
+		screenBase += (display >> dispScreenBlockShift & 7) * blockSize
+	}
+	return
+}
+
+// mosaicSize returns the horizontal and vertical mosaic block sizes of the
+// backgrounds or, with the sprites set, of the sprites.
+func (engine *Engine) mosaicSize(sprites bool) (int, int) {
+	value := engine.read16(mosaic)
+	if sprites {
+		value >>= 8
+	}
+	return int(value&0xf) + 1, int(value>>4&0xf) + 1
+}
+
+// renderText draws a line of a text background, made of 32x32 tile screen
+// blocks that wrap around.
+func (engine *Engine) renderText(bg int, line int, out *[Width]uint16) {
+	control := engine.bgControl(bg)
+	hOffset := int(engine.read16(bgHOffset+bg*4) & 0x1ff)
+	vOffset := int(engine.read16(bgVOffset+bg*4) & 0x1ff)
+	width, height := 256, 256
+	if control>>bgSizeShift&1 != 0 {
+		width = 512
+	}
+	if control>>bgSizeShift&2 != 0 {
+		height = 512
+	}
+	mosaicH, mosaicV := 1, 1
+	if control&bgMosaic != 0 {
+		mosaicH, mosaicV = engine.mosaicSize(false)
+	}
+	charBase, screenBase := engine.bases(control)
+	colors256 := control&bgColors256 != 0
+	extPalette := colors256 && engine.displayControl()&dispBGExtPalette != 0
+	slot := bg
+	if bg < 2 && control&bgExtSlot != 0 {
+		slot += 2
+	}
+
+	y := (line - line%mosaicV + vOffset) & (height - 1)
+	for x := range out {
+		px := (x - x%mosaicH + hOffset) & (width - 1)
+		block := uint32(0)
+		if px >= 256 {
+			block++
+		}
+		if y >= 256 {
+			block += uint32(width / 256)
+		}
+		index := uint32((y/8)&31*32 + (px/8)&31)
+		entry := engine.readBG16(screenBase + block*screenBlockSize + index*2)
+		out[x] = engine.tilePixel(entry, px&7, y&7, charBase, colors256, extPalette, slot)
+	}
+}
+
+// tilePixel returns a dot of the tile in a 16 bit screen entry.
+func (engine *Engine) tilePixel(entry uint16, x int, y int, charBase uint32, colors256 bool, extPalette bool, slot int) uint16 {
+	tile := uint32(entry & entryTileMask)
+	if entry&entryHFlip != 0 {
+		x = 7 - x
+	}
+	if entry&entryVFlip != 0 {
+		y = 7 - y
+	}
+	palette := uint32(entry >> entryPalette)
+	if colors256 {
+		index := uint32(engine.readBG8(charBase + tile*tileSize8 + uint32(y*8+x)))
+		if index == 0 {
+			return 0
+		}
+		if extPalette {
+			return engine.bgExtColor(slot, palette*256+index)
+		}
+		return engine.paletteColor(index) | opaque
+	}
+	index := uint32(engine.readBG8(charBase+tile*tileSize4+uint32(y*4+x/2)) >> (uint(x&1) * 4) & 0xf)
+	if index == 0 {
+		return 0
+	}
+	return engine.paletteColor(palette*16+index) | opaque
+}
+
+// renderAffine draws a line of an affine background, a square map of one
+// byte screen entries for 256 color tiles.
+func (engine *Engine) renderAffine(bg int, out *[Width]uint16) {
+	control := engine.bgControl(bg)
+	size := 128 << (control >> bgSizeShift)
+	charBase, screenBase := engine.bases(control)
+	engine.transform(bg, size, size, out, func(x int, y int) uint16 {
+		tile := uint32(engine.readBG8(screenBase + uint32((y/8)*(size/8)+x/8)))
+		index := uint32(engine.readBG8(charBase + tile*tileSize8 + uint32((y&7)*8+x&7)))
+		if index == 0 {
+			return 0
+		}
+		return engine.paletteColor(index) | opaque
+	})
+}
+
+// renderExtended draws a line of an extended background, which is an
+// affine map of 16 bit screen entries, a 256 color bitmap or a direct
+// color bitmap.
+func (engine *Engine) renderExtended(bg int, out *[Width]uint16) {
+	control := engine.bgControl(bg)
+	if control&bgColors256 == 0 {
+		size := 128 << (control >> bgSizeShift)
+		charBase, screenBase := engine.bases(control)
+		extPalette := engine.displayControl()&dispBGExtPalette != 0
+		engine.transform(bg, size, size, out, func(x int, y int) uint16 {
+			entry := engine.readBG16(screenBase + uint32((y/8)*(size/8)+x/8)*2)
+			return engine.tilePixel(entry, x&7, y&7, charBase, true, extPalette, bg)
+		})
+		return
+	}
+
+	size := bitmapSizes[control>>bgSizeShift]
+	base := uint32(control>>bgScreenShift&bgBaseMask) * charBlockSize
+	if control&bgDirectColor != 0 {
+		engine.transform(bg, size[0], size[1], out, func(x int, y int) uint16 {
+			color := engine.readBG16(base + uint32(y*size[0]+x)*2)
+			if color&opaque == 0 {
+				return 0
+			}
+			return color
+		})
+		return
+	}
+	engine.renderBitmap(bg, base, size[0], size[1], out)
+}
+
+// renderLarge draws a line of the 256 color bitmap of BG mode 6, which
+// takes up all 512KB of engine A's background memory.
+func (engine *Engine) renderLarge(bg int, out *[Width]uint16) {
+	size := largeSizes[engine.bgControl(bg)>>bgSizeShift]
+	engine.renderBitmap(bg, 0, size[0], size[1], out)
+}
+
+func (engine *Engine) renderBitmap(bg int, base uint32, width int, height int, out *[Width]uint16) {
+	engine.transform(bg, width, height, out, func(x int, y int) uint16 {
+		index := uint32(engine.readBG8(base + uint32(y*width+x)))
+		if index == 0 {
+			return 0
+		}
+		return engine.paletteColor(index) | opaque
+	})
+}
+
+// transform walks a line of an affine background from its internal
+// reference point, calling sample with every dot that lands inside the
+// background. Dots outside are transparent unless the background wraps.
+func (engine *Engine) transform(bg int, width int, height int, out *[Width]uint16, sample func(x int, y int) uint16) {
+	control := engine.bgControl(bg)
+	set := bg - 2
+	params := bgAffineBase + set*bgAffineStride
+	pa := int32(int16(engine.read16(params)))
+	pc := int32(int16(engine.read16(params + 4)))
+	mosaicH := 1
+	if control&bgMosaic != 0 {
+		mosaicH, _ = engine.mosaicSize(false)
+	}
+	wrap := control&bgWraparound != 0
+
+	for x := range out {
+		dot := int32(x - x%mosaicH)
+		tx := int((engine.refX[set] + pa*dot) >> 8)
+		ty := int((engine.refY[set] + pc*dot) >> 8)
+		if wrap {
+			tx &= width - 1
+			ty &= height - 1
+		} else if tx < 0 || tx >= width || ty < 0 || ty >= height {
+			continue
+		}
+		out[x] = sample(tx, ty)
+	}
+}
+
+// reloadReference copies BGxX and BGxY of an affine background into its
+// internal reference point.
+func (engine *Engine) reloadReference(set int) {
+	params := bgAffineBase + set*bgAffineStride
+	engine.refX[set] = signExtend28(engine.read32(params + 8))
+	engine.refY[set] = signExtend28(engine.read32(params + 12))
+}
+
+// advanceReferences moves the reference points of the affine backgrounds
+// to the next line.
+func (engine *Engine) advanceReferences() {
+	for set := range engine.refX {
+		params := bgAffineBase + set*bgAffineStride
+		engine.refX[set] += int32(int16(engine.read16(params + 2)))
+		engine.refY[set] += int32(int16(engine.read16(params + 6)))
+	}
+}
+
+func signExtend28(value uint32) int32 {
+	return int32(value<<4) >> 4
+}
+
+func (engine *Engine) readBG8(offset uint32) uint8 {
+	return engine.vram.ReadBG(engine.index, offset)
+}
+
+func (engine *Engine) readBG16(offset uint32) uint16 {
+	return uint16(engine.readBG8(offset)) | uint16(engine.readBG8(offset+1))<<8
+}
+
+// bgExtColor returns a color of the extended palette slot of a background.
+func (engine *Engine) bgExtColor(slot int, index uint32) uint16 {
+	offset := uint32(slot)*extPaletteSize + index*2
+	color := uint16(engine.vram.ReadBGExtPalette(engine.index, offset)) |
+		uint16(engine.vram.ReadBGExtPalette(engine.index, offset+1))<<8
+	return color&colorMask | opaque
+}
@@ -0,0 +1,477 @@
+package gpu
+
+import "github.com/damilolarandolph/casper/mmio"
+
+// Offsets of the engine registers from the base of the engine.
+const (
+	displayControl   = 0x00
+	bgControl        = 0x08
+	bgHOffset        = 0x10
+	bgVOffset        = 0x12
+	bgAffineBase     = 0x20
+	bgAffineStride   = 0x10
+	win0H            = 0x40
+	win1H            = 0x42
+	win0V            = 0x44
+	win1V            = 0x46
+	winIn            = 0x48
+	winOut           = 0x4a
+	mosaic           = 0x4c
+	blendControl     = 0x50
+	blendAlpha       = 0x52
+	blendBrightness  = 0x54
+	registerSize     = 0x56
+	masterBrightness = 0x6c
+)
+
+// fifoAddress is the main memory display FIFO of engine A.
+const fifoAddress = 0x04000068
+
+// Bits of DISPCNT.
+const (
+	dispBGMode           uint32 = 0x7
+	dispBG03D            uint32 = 1 << 3
+	dispOBJ1D            uint32 = 1 << 4
+	dispBitmapOBJ256     uint32 = 1 << 5
+	dispBitmapOBJ1D      uint32 = 1 << 6
+	dispForcedBlank      uint32 = 1 << 7
+	dispLayerShift              = 8
+	dispWin0             uint32 = 1 << 13
+	dispWin1             uint32 = 1 << 14
+	dispOBJWin           uint32 = 1 << 15
+	dispModeShift               = 16
+	dispLCDCBankShift           = 18
+	dispOBJBoundShift           = 20
+	dispBitmapOBJBound   uint32 = 1 << 22
+	dispCharBlockShift          = 24
+	dispScreenBlockShift        = 27
+	dispBGExtPalette     uint32 = 1 << 30
+	dispOBJExtPalette    uint32 = 1 << 31
+	// Engine B has no 3D, VRAM or main memory display and no block
+	// offsets for its backgrounds.
+	dispEngineBMask uint32 = 0xc0b1fff7
+)
+
+// Display modes of DISPCNT.
+const (
+	displayOff = iota
+	displayGraphics
+	displayVRAM
+	displayMainMemory
+)
+
+// Layers in the order of their bits in the window and blend registers.
+const (
+	layerBG0 = iota
+	layerBG1
+	layerBG2
+	layerBG3
+	layerOBJ
+	layerBackdrop
+	layerEffects = layerBackdrop
+	layerAll     = 0x3f
+)
+
+// Color effects of BLDCNT.
+const (
+	effectNone = iota
+	effectAlpha
+	effectBrighten
+	effectDarken
+)
+
+// Modes of MASTER_BRIGHT.
+const (
+	brightnessUp   = 1
+	brightnessDown = 2
+)
+
+// opaque marks a drawn pixel in the line buffers, colors only use the low
+// 15 bits.
+const opaque uint16 = 1 << 15
+
+const (
+	white      uint16 = 0x7fff
+	colorMask  uint16 = 0x7fff
+	maxFactor         = 16
+	fifoLength        = 2 * Width
+)
+
+// Engine is one 2D graphics engine, drawing a line at a time.
+type Engine struct {
+	index   int
+	vram    VRAM
+	palette []uint8
+	oam     []uint8
+
+	registers        [registerSize]uint8
+	masterBrightness uint16
+	fifo             []uint16
+
+	// The internal reference points of the affine backgrounds, which are
+	// reloaded from BGxX and BGxY every frame and advanced every line.
+	refX [2]int32
+	refY [2]int32
+
+	bgLines   [4][Width]uint16
+	objLine   [Width]objPixel
+	objWindow [Width]bool
+	line      [Width]uint16
+}
+
+func newEngine(index int, vram VRAM, palette []uint8, oam []uint8) *Engine {
+	return &Engine{
+		index:   index,
+		vram:    vram,
+		palette: palette,
+		oam:     oam,
+	}
+}
+
+func (engine *Engine) mapRegisters(registry *mmio.Registry, base uint32) {
+	handler := &mmio.Handler{
+		Read8: func(address uint32) uint8 {
+			return engine.readRegister(address - base)
+		},
+		Write8: func(address uint32, value uint8) {
+			engine.writeRegister(address-base, value)
+		},
+	}
+	// DISPSTAT and VCOUNT sit between DISPCNT and the background
+	// registers of engine A.
+	registry.Map(base+displayControl, base+displayControl+3, handler)
+	registry.Map(base+bgControl, base+registerSize-1, handler)
+	registry.MapRegister(base+masterBrightness, 2, &mmio.Register{
+		ReadMask:  0xc01f,
+		WriteMask: 0xc01f,
+		OnRead: func() uint32 {
+			return uint32(engine.masterBrightness)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			engine.masterBrightness = uint16(value)
+		},
+	})
+}
+
+// mapFIFO registers the main memory display FIFO, which DMA fills with
+// two pixels per word.
+func (engine *Engine) mapFIFO(registry *mmio.Registry) {
+	registry.Map(fifoAddress, fifoAddress+3, &mmio.Handler{
+		Write32: func(address uint32, value uint32) {
+			if len(engine.fifo) < fifoLength {
+				engine.fifo = append(engine.fifo, uint16(value), uint16(value>>16))
+			}
+		},
+	})
+}
+
+// readRegister reads a byte of the engine registers. Only DISPCNT,
+// BGxCNT, the window controls and the blend controls can be read.
+func (engine *Engine) readRegister(offset uint32) uint8 {
+	switch {
+	case offset < bgControl+8,
+		offset >= winIn && offset < mosaic,
+		offset >= blendControl && offset < blendBrightness:
+		return engine.registers[offset]
+	}
+	return 0
+}
+
+func (engine *Engine) writeRegister(offset uint32, value uint8) {
+	if offset < bgControl && engine.index == EngineB {
+		value &= uint8(dispEngineBMask >> (offset * 8))
+	}
+	engine.registers[offset] = value
+	// Writing a reference point restarts the background from it.
+	if offset >= bgAffineBase {
+		set := (offset - bgAffineBase) / bgAffineStride
+		if set < 2 && (offset-bgAffineBase)%bgAffineStride >= 8 {
+			engine.reloadReference(int(set))
+		}
+	}
+}
+
+func (engine *Engine) read16(offset int) uint16 {
+	return uint16(engine.registers[offset]) | uint16(engine.registers[offset+1])<<8
+}
+
+func (engine *Engine) read32(offset int) uint32 {
+	return uint32(engine.read16(offset)) | uint32(engine.read16(offset+2))<<16
+}
+
+func (engine *Engine) displayControl() uint32 {
+	return engine.read32(displayControl)
+}
+
+// renderLine draws a line into out, or black when the engine is powered
+// off.
+func (engine *Engine) renderLine(line int, out []uint32, powered bool) {
+	if line == 0 {
+		engine.reloadReference(0)
+		engine.reloadReference(1)
+	}
+	if !powered {
+		for x := range out {
+			out[x] = 0
+		}
+		engine.advanceReferences()
+		return
+	}
+
+	control := engine.displayControl()
+	switch control >> dispModeShift & 3 {
+	case displayOff:
+		engine.fill(white)
+	case displayGraphics:
+		if control&dispForcedBlank != 0 {
+			engine.fill(white)
+		} else {
+			engine.renderGraphics(line)
+		}
+	case displayVRAM:
+		bank := int(control >> dispLCDCBankShift & 3)
+		for x := range engine.line {
+			offset := uint32(line*Width+x) * 2
+			engine.line[x] = uint16(engine.vram.ReadLCDC(bank, offset)) |
+				uint16(engine.vram.ReadLCDC(bank, offset+1))<<8
+		}
+	case displayMainMemory:
+		for x := range engine.line {
+			engine.line[x] = 0
+		}
+		copied := copy(engine.line[:], engine.fifo)
+		engine.fifo = engine.fifo[copied:]
+	}
+	engine.advanceReferences()
+
+	for x, color := range engine.line {
+		out[x] = rgb(engine.brighten(color))
+	}
+}
+
+func (engine *Engine) fill(color uint16) {
+	for x := range engine.line {
+		engine.line[x] = color
+	}
+}
+
+// renderGraphics draws the backgrounds and sprites of a line and combines
+// them through the windows and color effects.
+func (engine *Engine) renderGraphics(line int) {
+	control := engine.displayControl()
+	mode := control & dispBGMode
+	for bg := 0; bg < 4; bg++ {
+		kind := bgModes[mode][bg]
+		if engine.index == EngineB && (kind == bgLarge || kind == bg3D) {
+			kind = bgOff
+		}
+		// The 3D engine is not emulated, its layer is transparent.
+		if bg == 0 && control&dispBG03D != 0 {
+			kind = bg3D
+		}
+		if control&(1<<(dispLayerShift+uint(bg))) == 0 {
+			kind = bgOff
+		}
+		engine.renderBackground(bg, kind, line)
+	}
+	engine.renderSprites(line, control&(1<<(dispLayerShift+layerOBJ)) != 0)
+	engine.compose(line)
+}
+
+// layerPixel is a candidate for the final color of a dot.
+type layerPixel struct {
+	layer int
+	color uint16
+}
+
+// compose picks the two topmost visible layers of every dot and applies
+// the color effect between them.
+func (engine *Engine) compose(line int) {
+	var priorities [4]int
+	for bg := range priorities {
+		priorities[bg] = int(engine.read16(bgControl+bg*2) & bgPriorityMask)
+	}
+	backdrop := engine.paletteColor(0)
+	blend := engine.read16(blendControl)
+	effect := int(blend >> 6 & 3)
+	firstTargets := blend & layerAll
+	secondTargets := blend >> 8 & layerAll
+	alpha := engine.read16(blendAlpha)
+	eva := minFactor(int(alpha & 0x1f))
+	evb := minFactor(int(alpha >> 8 & 0x1f))
+	evy := minFactor(int(engine.read16(blendBrightness) & 0x1f))
+	windows := engine.windowLine(line)
+
+	for x := range engine.line {
+		enabled := windows.mask(x, engine.objWindow[x])
+		top := layerPixel{layerBackdrop, backdrop}
+		below := top
+		found := 0
+		push := func(pixel layerPixel) {
+			if found == 0 {
+				top = pixel
+			} else if found == 1 {
+				below = pixel
+			}
+			found++
+		}
+		obj := engine.objLine[x]
+		for priority := 0; priority < 4 && found < 2; priority++ {
+			if obj.color&opaque != 0 && int(obj.priority) == priority && enabled&(1<<layerOBJ) != 0 {
+				push(layerPixel{layerOBJ, obj.color})
+			}
+			for bg := 0; bg < 4; bg++ {
+				color := engine.bgLines[bg][x]
+				if color&opaque != 0 && priorities[bg] == priority && enabled&(1<<uint(bg)) != 0 {
+					push(layerPixel{bg, color})
+				}
+			}
+		}
+
+		color := top.color & colorMask
+		secondTarget := secondTargets&(1<<uint(below.layer)) != 0 && found > 0
+		switch {
+		// Semi-transparent and bitmap sprites blend with whatever is
+		// below them regardless of the effect selected.
+		case top.layer == layerOBJ && (obj.semiTransparent || obj.alpha != 0) && secondTarget:
+			a, b := eva, evb
+			if obj.alpha != 0 {
+				a, b = int(obj.alpha), maxFactor-int(obj.alpha)
+			}
+			color = alphaBlend(color, below.color, a, b)
+		case enabled&(1<<layerEffects) == 0 || firstTargets&(1<<uint(top.layer)) == 0:
+		case effect == effectAlpha:
+			if secondTarget {
+				color = alphaBlend(color, below.color, eva, evb)
+			}
+		case effect == effectBrighten:
+			color = brighten(color, evy)
+		case effect == effectDarken:
+			color = darken(color, evy)
+		}
+		engine.line[x] = color
+	}
+}
+
+// windows is the state of the windows on one line.
+type windows struct {
+	enabled   bool
+	win0      bool
+	win1      bool
+	objWin    bool
+	win0Left  int
+	win0Right int
+	win1Left  int
+	win1Right int
+	win0Mask  uint8
+	win1Mask  uint8
+	outMask   uint8
+	objMask   uint8
+}
+
+func (engine *Engine) windowLine(line int) windows {
+	control := engine.displayControl()
+	in := engine.read16(winIn)
+	out := engine.read16(winOut)
+	state := windows{
+		enabled:  control&(dispWin0|dispWin1|dispOBJWin) != 0,
+		objWin:   control&dispOBJWin != 0,
+		win0Mask: uint8(in & layerAll),
+		win1Mask: uint8(in >> 8 & layerAll),
+		outMask:  uint8(out & layerAll),
+		objMask:  uint8(out >> 8 & layerAll),
+	}
+	vertical0 := engine.read16(win0V)
+	vertical1 := engine.read16(win1V)
+	state.win0 = control&dispWin0 != 0 && inside(line, int(vertical0>>8), int(vertical0&0xff))
+	state.win1 = control&dispWin1 != 0 && inside(line, int(vertical1>>8), int(vertical1&0xff))
+	horizontal0 := engine.read16(win0H)
+	horizontal1 := engine.read16(win1H)
+	state.win0Left, state.win0Right = int(horizontal0>>8), int(horizontal0&0xff)
+	state.win1Left, state.win1Right = int(horizontal1>>8), int(horizontal1&0xff)
+	return state
+}
+
+// mask returns the layers visible at x, from the window of highest
+// priority the dot is inside of.
+func (state *windows) mask(x int, inOBJWindow bool) uint8 {
+	switch {
+	case !state.enabled:
+		return layerAll
+	case state.win0 && inside(x, state.win0Left, state.win0Right):
+		return state.win0Mask
+	case state.win1 && inside(x, state.win1Left, state.win1Right):
+		return state.win1Mask
+	case state.objWin && inOBJWindow:
+		return state.objMask
+	}
+	return state.outMask
+}
+
+// inside reports whether position is in the window edges, which wrap
+// around when the start is past the end.
+func inside(position int, start int, end int) bool {
+	if start <= end {
+		return position >= start && position < end
+	}
+	return position >= start || position < end
+}
+
+// brighten applies MASTER_BRIGHT to a final color.
+func (engine *Engine) brighten(color uint16) uint16 {
+	factor := minFactor(int(engine.masterBrightness & 0x1f))
+	switch engine.masterBrightness >> 14 {
+	case brightnessUp:
+		return brighten(color, factor)
+	case brightnessDown:
+		return darken(color, factor)
+	}
+	return color
+}
+
+func minFactor(factor int) int {
+	if factor > maxFactor {
+		return maxFactor
+	}
+	return factor
+}
+
+// mapColor applies an operation to each component of a color.
+func mapColor(color uint16, operation func(component int) int) uint16 {
+	var result uint16
+	for shift := uint(0); shift < 15; shift += 5 {
+		result |= uint16(operation(int(color>>shift&0x1f))) << shift
+	}
+	return result
+}
+
+func alphaBlend(first uint16, second uint16, eva int, evb int) uint16 {
+	var result uint16
+	for shift := uint(0); shift < 15; shift += 5 {
+		value := (int(first>>shift&0x1f)*eva + int(second>>shift&0x1f)*evb) / maxFactor
+		if value > 0x1f {
+			value = 0x1f
+		}
+		result |= uint16(value) << shift
+	}
+	return result
+}
+
+func brighten(color uint16, evy int) uint16 {
+	return mapColor(color, func(component int) int {
+		return component + (0x1f-component)*evy/maxFactor
+	})
+}
+
+func darken(color uint16, evy int) uint16 {
+	return mapColor(color, func(component int) int {
+		return component - component*evy/maxFactor
+	})
+}
+
+// paletteColor returns a color of the standard palettes, the backgrounds'
+// first and the sprites' after them.
+func (engine *Engine) paletteColor(index uint32) uint16 {
+	offset := index * 2
+	return (uint16(engine.palette[offset]) | uint16(engine.palette[offset+1])<<8) & colorMask
+}
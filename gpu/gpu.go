@@ -0,0 +1,106 @@
+// Package gpu implements the two 2D graphics engines of the Arm9 and the
+// screens they draw to. Engine A is the main engine, which can also show
+// VRAM and main memory directly, engine B is the sub engine.
+package gpu
+
+import (
+	"github.com/damilolarandolph/casper/memory"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/power"
+)
+
+// Size of the screens in dots.
+const (
+	Width  = 256
+	Height = 192
+)
+
+// The engines, as passed to VRAM.
+const (
+	EngineA = iota
+	EngineB
+)
+
+const (
+	engineABase = 0x04000000
+	engineBBase = 0x04001000
+	// Each engine has 1KB of palette and 1KB of OAM in the shared
+	// palette and OAM memory.
+	paletteSize = 0x400
+	oamBase     = 0x800
+	oamSize     = 0x400
+)
+
+// Framebuffer is a screen image, one 0xRRGGBB pixel per dot in rows from
+// the top left.
+type Framebuffer [Width * Height]uint32
+
+// GPU holds the 2D engines and the images they have drawn on both screens.
+type GPU struct {
+	EngineA *Engine
+	EngineB *Engine
+
+	power  *power.Power
+	top    Framebuffer
+	bottom Framebuffer
+}
+
+// New constructs the engines over the palettes and OAM in memory and the
+// given view of VRAM. Which engine drives which screen, and whether it
+// draws at all, is read from POWCNT1 in power.
+func New(mem *memory.InternalMemory, pow *power.Power, vram VRAM) *GPU {
+	return &GPU{
+		EngineA: newEngine(EngineA, vram,
+			mem.OamPal[:paletteSize], mem.OamPal[oamBase:oamBase+oamSize]),
+		EngineB: newEngine(EngineB, vram,
+			mem.OamPal[paletteSize:2*paletteSize], mem.OamPal[oamBase+oamSize:]),
+		power: pow,
+	}
+}
+
+// Map registers the registers of both engines with the Arm9 I/O registry.
+func (gpu *GPU) Map(registry *mmio.Registry) {
+	gpu.EngineA.mapRegisters(registry, engineABase)
+	gpu.EngineA.mapFIFO(registry)
+	gpu.EngineB.mapRegisters(registry, engineBBase)
+}
+
+// RenderLine draws a line of both screens. Engine A draws on the top
+// screen when POWCNT1 selects the display swap, engine B on the other one.
+// A disabled engine leaves its screen black, as do disabled LCDs.
+func (gpu *GPU) RenderLine(line int) {
+	if line < 0 || line >= Height {
+		return
+	}
+	start := line * Width
+	top := gpu.top[start : start+Width]
+	bottom := gpu.bottom[start : start+Width]
+	mainLine, subLine := bottom, top
+	if gpu.power.Enabled(power.DisplaySwap) {
+		mainLine, subLine = top, bottom
+	}
+
+	lcds := gpu.power.Enabled(power.LCDs)
+	gpu.EngineA.renderLine(line, mainLine, lcds && gpu.power.Enabled(power.EngineA))
+	gpu.EngineB.renderLine(line, subLine, lcds && gpu.power.Enabled(power.EngineB))
+}
+
+// Top returns the image on the top screen.
+func (gpu *GPU) Top() *Framebuffer {
+	return &gpu.top
+}
+
+// Bottom returns the image on the bottom screen.
+func (gpu *GPU) Bottom() *Framebuffer {
+	return &gpu.bottom
+}
+
+// rgb converts a 15 bit BGR color to 0xRRGGBB, repeating the top bits of
+// each component into the new low bits so that white stays white.
+func rgb(color uint16) uint32 {
+	expand := func(component uint16) uint32 {
+		component &= 0x1f
+		return uint32(component<<3 | component>>2)
+	}
+	return expand(color)<<16 | expand(color>>5)<<8 | expand(color>>10)
+}
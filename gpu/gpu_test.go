@@ -0,0 +1,259 @@
+package gpu
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/memory"
+	"github.com/damilolarandolph/casper/power"
+)
+
+// testVRAM is 128KB of background memory per engine with nothing else
+// mapped.
+type testVRAM struct {
+	bg [2][0x20000]uint8
+}
+
+func (vram *testVRAM) ReadBG(engine int, offset uint32) uint8 {
+	return vram.bg[engine][offset%uint32(len(vram.bg[engine]))]
+}
+
+func (vram *testVRAM) ReadOBJ(engine int, offset uint32) uint8           { return 0 }
+func (vram *testVRAM) ReadBGExtPalette(engine int, offset uint32) uint8  { return 0 }
+func (vram *testVRAM) ReadOBJExtPalette(engine int, offset uint32) uint8 { return 0 }
+func (vram *testVRAM) ReadLCDC(bank int, offset uint32) uint8            { return 0 }
+
+type testHalter struct{}
+
+func (halter testHalter) Halt() {}
+
+const (
+	red   uint16 = 0x001f
+	green uint16 = 0x03e0
+	blue  uint16 = 0x7c00
+)
+
+func write16(engine *Engine, offset uint32, value uint16) {
+	engine.writeRegister(offset, uint8(value))
+	engine.writeRegister(offset+1, uint8(value>>8))
+}
+
+func write32(engine *Engine, offset uint32, value uint32) {
+	write16(engine, offset, uint16(value))
+	write16(engine, offset+2, uint16(value>>16))
+}
+
+func setColor(palette []uint8, index int, color uint16) {
+	palette[index*2] = uint8(color)
+	palette[index*2+1] = uint8(color >> 8)
+}
+
+func TestRGB(t *testing.T) {
+	tests := []struct {
+		color uint16
+		rgb   uint32
+	}{
+		{0x0000, 0x000000},
+		{0x7fff, 0xffffff},
+		{red, 0xff0000},
+		{green, 0x00ff00},
+		{blue, 0x0000ff},
+		{0x0010, 0x840000},
+		{0x8000, 0x000000},
+	}
+	for _, test := range tests {
+		if rgb := rgb(test.color); rgb != test.rgb {
+			t.Errorf("rgb(%#x) = %#06x, want %#06x", test.color, rgb, test.rgb)
+		}
+	}
+}
+
+func TestColorEffects(t *testing.T) {
+	tests := []struct {
+		name   string
+		effect func() uint16
+		want   uint16
+	}{
+		{"alpha half", func() uint16 { return alphaBlend(red, blue, 8, 8) }, 0x3c0f},
+		{"alpha saturates", func() uint16 { return alphaBlend(0x7fff, 0x7fff, 16, 16) }, 0x7fff},
+		{"alpha first only", func() uint16 { return alphaBlend(green, blue, 16, 0) }, green},
+		{"brighten none", func() uint16 { return brighten(red, 0) }, red},
+		{"brighten full", func() uint16 { return brighten(red, 16) }, 0x7fff},
+		{"brighten half", func() uint16 { return brighten(0, 8) }, 0x3def},
+		{"darken full", func() uint16 { return darken(0x7fff, 16) }, 0},
+		{"darken half", func() uint16 { return darken(0x7fff, 8) }, 0x4210},
+	}
+	for _, test := range tests {
+		if color := test.effect(); color != test.want {
+			t.Errorf("%s = %#x, want %#x", test.name, color, test.want)
+		}
+	}
+}
+
+func TestWindowEdges(t *testing.T) {
+	tests := []struct {
+		position   int
+		start, end int
+		inside     bool
+	}{
+		{10, 10, 20, true},
+		{19, 10, 20, true},
+		{20, 10, 20, false},
+		{9, 10, 20, false},
+		// The edges wrap around when the start is past the end.
+		{250, 200, 10, true},
+		{5, 200, 10, true},
+		{100, 200, 10, false},
+		{15, 15, 15, false},
+	}
+	for _, test := range tests {
+		if inside := inside(test.position, test.start, test.end); inside != test.inside {
+			t.Errorf("inside(%d, %d, %d) = %v, want %v", test.position, test.start, test.end, inside, test.inside)
+		}
+	}
+}
+
+// newTextEngine sets engine A up to show a 16 color text background on BG0
+// with tile 1 at the top left, as is and flipped horizontally next to it.
+// The left half of tile 1 is color 1 and the right half color 2, red and
+// blue, over a green backdrop.
+func newTextEngine() (*Engine, *testVRAM) {
+	vram := &testVRAM{}
+	engine := newEngine(EngineA, vram, make([]uint8, paletteSize), make([]uint8, oamSize))
+	setColor(engine.palette, 0, green)
+	setColor(engine.palette, 1, red)
+	setColor(engine.palette, 2, blue)
+
+	const charBase = 1 * charBlockSize
+	for row := 0; row < 8; row++ {
+		copy(vram.bg[EngineA][charBase+tileSize4+row*4:], []uint8{0x11, 0x11, 0x22, 0x00})
+	}
+	vram.bg[EngineA][0] = 1
+	vram.bg[EngineA][2] = 1
+	vram.bg[EngineA][3] = uint8(entryHFlip >> 8)
+
+	write32(engine, displayControl, displayGraphics<<dispModeShift|1<<dispLayerShift)
+	write16(engine, bgControl, 1<<bgCharShift)
+	return engine, vram
+}
+
+func TestTextBackground(t *testing.T) {
+	engine, _ := newTextEngine()
+	out := make([]uint32, Width)
+	engine.renderLine(0, out, true)
+
+	r, g, b := rgb(red), rgb(green), rgb(blue)
+	want := []uint32{
+		r, r, r, r, b, b, g, g,
+		g, g, b, b, r, r, r, r,
+		g, g,
+	}
+	for x, color := range want {
+		if out[x] != color {
+			t.Errorf("dot %d = %#06x, want %#06x", x, out[x], color)
+		}
+	}
+
+	// Scrolling moves the background, not the backdrop.
+	write16(engine, bgHOffset, 4)
+	engine.renderLine(1, out, true)
+	if out[0] != b || out[2] != g || out[4] != g {
+		t.Errorf("scrolled dots = %#06x %#06x %#06x", out[0], out[2], out[4])
+	}
+}
+
+func TestMasterBrightness(t *testing.T) {
+	tests := []struct {
+		value uint16
+		want  uint32
+	}{
+		{0x0000, rgb(red)},
+		{brightnessUp<<14 | 16, 0xffffff},
+		{brightnessDown<<14 | 16, 0x000000},
+		// Factors past 16 act as 16.
+		{brightnessDown<<14 | 0x1f, 0x000000},
+		{3 << 14, rgb(red)},
+	}
+	for _, test := range tests {
+		engine, _ := newTextEngine()
+		engine.masterBrightness = test.value
+		out := make([]uint32, Width)
+		engine.renderLine(0, out, true)
+		if out[0] != test.want {
+			t.Errorf("MASTER_BRIGHT %#x: dot = %#06x, want %#06x", test.value, out[0], test.want)
+		}
+	}
+}
+
+func TestBlending(t *testing.T) {
+	engine, _ := newTextEngine()
+	// BG0 blended with the backdrop half and half.
+	write16(engine, blendControl, effectAlpha<<6|1<<layerBG0|1<<(8+layerBackdrop))
+	write16(engine, blendAlpha, 8|8<<8)
+	out := make([]uint32, Width)
+	engine.renderLine(0, out, true)
+	if want := rgb(alphaBlend(red, green, 8, 8)); out[0] != want {
+		t.Errorf("blended dot = %#06x, want %#06x", out[0], want)
+	}
+	if want := rgb(green); out[6] != want {
+		t.Errorf("backdrop dot = %#06x, want %#06x", out[6], want)
+	}
+}
+
+func TestWindowMask(t *testing.T) {
+	engine, _ := newTextEngine()
+	// Window 0 covers dots 2 to 5 and hides BG0 there.
+	write32(engine, displayControl, displayGraphics<<dispModeShift|1<<dispLayerShift|dispWin0)
+	write16(engine, win0H, 2<<8|6)
+	write16(engine, win0V, 0<<8|192)
+	write16(engine, winIn, 0)
+	write16(engine, winOut, layerAll)
+	out := make([]uint32, Width)
+	engine.renderLine(0, out, true)
+
+	r, g, b := rgb(red), rgb(green), rgb(blue)
+	want := []uint32{r, r, g, g, g, g, g, g, g, g, b, b}
+	for x, color := range want {
+		if out[x] != color {
+			t.Errorf("dot %d = %#06x, want %#06x", x, out[x], color)
+		}
+	}
+}
+
+func TestEngineBMask(t *testing.T) {
+	engine := newEngine(EngineB, &testVRAM{}, make([]uint8, paletteSize), make([]uint8, oamSize))
+	write32(engine, displayControl, 0xffffffff)
+	if value := engine.displayControl(); value != dispEngineBMask {
+		t.Errorf("engine B DISPCNT = %#x, want %#x", value, dispEngineBMask)
+	}
+}
+
+func TestScreens(t *testing.T) {
+	tests := []struct {
+		powcnt1 uint16
+		top     uint32
+		bottom  uint32
+	}{
+		// Engine A shows a red screen and engine B a blue one.
+		{power.LCDs | power.EngineA | power.EngineB, rgb(blue), rgb(red)},
+		{power.LCDs | power.EngineA | power.EngineB | power.DisplaySwap, rgb(red), rgb(blue)},
+		{power.LCDs | power.EngineB | power.DisplaySwap, 0, rgb(blue)},
+		{power.EngineA | power.EngineB, 0, 0},
+	}
+	for _, test := range tests {
+		mem := memory.NewInternalMemory()
+		pow := power.New(testHalter{})
+		pow.SetPOWCNT1(test.powcnt1)
+		gpu := New(mem, pow, &testVRAM{})
+		setColor(gpu.EngineA.palette, 0, red)
+		setColor(gpu.EngineB.palette, 0, blue)
+		write32(gpu.EngineA, displayControl, displayGraphics<<dispModeShift)
+		write32(gpu.EngineB, displayControl, displayGraphics<<dispModeShift)
+
+		gpu.RenderLine(10)
+		top, bottom := gpu.Top()[10*Width], gpu.Bottom()[10*Width]
+		if top != test.top || bottom != test.bottom {
+			t.Errorf("POWCNT1 %#x: top, bottom = %#06x, %#06x, want %#06x, %#06x",
+				test.powcnt1, top, bottom, test.top, test.bottom)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package gpu
+
+const objCount = 128
+
+// Bits of the OBJ attributes.
+const (
+	attrYMask        uint16 = 0xff
+	attrAffine       uint16 = 1 << 8
+	attrDoubleSize   uint16 = 1 << 9
+	attrHidden       uint16 = 1 << 9
+	attrModeShift           = 10
+	attrMosaic       uint16 = 1 << 12
+	attrColors256    uint16 = 1 << 13
+	attrShapeShift          = 14
+	attrXMask        uint16 = 0x1ff
+	attrParamShift          = 9
+	attrParamMask    uint16 = 0x1f
+	attrHFlip        uint16 = 1 << 12
+	attrVFlip        uint16 = 1 << 13
+	attrSizeShift           = 14
+	attrTileMask     uint32 = 0x3ff
+	attrPriority            = 10
+	attrPaletteShift        = 12
+)
+
+// OBJ modes.
+const (
+	objNormal = iota
+	objSemiTransparent
+	objWindow
+	objBitmap
+)
+
+// objSizes are the width and height of the sprites of each shape and size.
+// Shape 3 is prohibited.
+var objSizes = [3][4][2]int{
+	{{8, 8}, {16, 16}, {32, 32}, {64, 64}},
+	{{16, 8}, {32, 8}, {32, 16}, {64, 32}},
+	{{8, 16}, {8, 32}, {16, 32}, {32, 64}},
+}
+
+// objPixel is a dot of the sprite layer.
+type objPixel struct {
+	color           uint16
+	priority        uint8
+	semiTransparent bool
+	// alpha is the blend factor of a bitmap sprite, zero for the others.
+	alpha uint8
+}
+
+// renderSprites draws the sprites on a line into the sprite line buffer
+// and the OBJ window. Where sprites overlap, the one with the highest
+// priority wins, then the one earliest in OAM. Only the OBJ window is
+// drawn when the sprite layer is disabled.
+func (engine *Engine) renderSprites(line int, visible bool) {
+	for x := range engine.objLine {
+		engine.objLine[x] = objPixel{}
+		engine.objWindow[x] = false
+	}
+	control := engine.displayControl()
+	if !visible && control&dispOBJWin == 0 {
+		return
+	}
+	mosaicH, mosaicV := engine.mosaicSize(true)
+
+	for index := 0; index < objCount; index++ {
+		attr0 := engine.readOAM16(index * 8)
+		attr1 := engine.readOAM16(index*8 + 2)
+		attr2 := engine.readOAM16(index*8 + 4)
+		affine := attr0&attrAffine != 0
+		if !affine && attr0&attrHidden != 0 {
+			continue
+		}
+		mode := int(attr0 >> attrModeShift & 3)
+		shape := attr0 >> attrShapeShift
+		if shape == 3 || (mode == objWindow && control&dispOBJWin == 0) || (mode != objWindow && !visible) {
+			continue
+		}
+		size := objSizes[shape][attr1>>attrSizeShift]
+		width, height := size[0], size[1]
+		boundsWidth, boundsHeight := width, height
+		if affine && attr0&attrDoubleSize != 0 {
+			boundsWidth *= 2
+			boundsHeight *= 2
+		}
+		row := (line - int(attr0&attrYMask)) & 0xff
+		if row >= boundsHeight {
+			continue
+		}
+		left := int(attr1 & attrXMask)
+		if left >= Width {
+			left -= 512
+		}
+		mosaicX := 1
+		if attr0&attrMosaic != 0 {
+			row -= row % mosaicV
+			mosaicX = mosaicH
+		}
+
+		pa, pb, pc, pd := 0x100, 0, 0, 0x100
+		if affine {
+			params := int(attr1>>attrParamShift&attrParamMask) * 32
+			pa = int(int16(engine.readOAM16(params + 6)))
+			pb = int(int16(engine.readOAM16(params + 14)))
+			pc = int(int16(engine.readOAM16(params + 22)))
+			pd = int(int16(engine.readOAM16(params + 30)))
+		}
+		priority := uint8(attr2 >> attrPriority & 3)
+		alpha := uint8(0)
+		if mode == objBitmap {
+			alpha = uint8(attr2>>attrPaletteShift) + 1
+			if alpha == 1 {
+				continue
+			}
+		}
+
+		for column := 0; column < boundsWidth; column++ {
+			x := left + column
+			if x < 0 || x >= Width {
+				continue
+			}
+			sampled := column - x%mosaicX
+			if sampled < 0 {
+				sampled = 0
+			}
+			var tx, ty int
+			if affine {
+				dx := sampled - boundsWidth/2
+				dy := row - boundsHeight/2
+				tx = (pa*dx+pb*dy)>>8 + width/2
+				ty = (pc*dx+pd*dy)>>8 + height/2
+				if tx < 0 || tx >= width || ty < 0 || ty >= height {
+					continue
+				}
+			} else {
+				tx, ty = sampled, row
+				if attr1&attrHFlip != 0 {
+					tx = width - 1 - tx
+				}
+				if attr1&attrVFlip != 0 {
+					ty = height - 1 - ty
+				}
+			}
+
+			color := engine.objTexel(attr0, attr2, mode, tx, ty, width)
+			if color&opaque == 0 {
+				continue
+			}
+			if mode == objWindow {
+				engine.objWindow[x] = true
+				continue
+			}
+			pixel := &engine.objLine[x]
+			if pixel.color&opaque != 0 && pixel.priority <= priority {
+				continue
+			}
+			*pixel = objPixel{
+				color:           color,
+				priority:        priority,
+				semiTransparent: mode == objSemiTransparent,
+				alpha:           alpha,
+			}
+		}
+	}
+}
+
+// objTexel returns a dot of a sprite. Tiles are laid out one after the
+// other in 1D mapping and in rows of a 32 tile wide sheet in 2D mapping.
+func (engine *Engine) objTexel(attr0 uint16, attr2 uint16, mode int, x int, y int, width int) uint16 {
+	control := engine.displayControl()
+	tile := uint32(attr2) & attrTileMask
+	if mode == objBitmap {
+		var address uint32
+		switch {
+		case control&dispBitmapOBJ1D != 0:
+			boundary := uint32(128)
+			if control&dispBitmapOBJBound != 0 {
+				boundary = 256
+			}
+			address = tile*boundary + uint32(y*width+x)*2
+		case control&dispBitmapOBJ256 != 0:
+			address = (tile&0x1f)*0x10 + (tile&0x3e0)*0x80 + uint32(y*256+x)*2
+		default:
+			address = (tile&0xf)*0x10 + (tile&0x3f0)*0x80 + uint32(y*128+x)*2
+		}
+		color := engine.readOBJ16(address)
+		if color&opaque == 0 {
+			return 0
+		}
+		return color
+	}
+
+	colors256 := attr0&attrColors256 != 0
+	tileBytes := tileSize4
+	if colors256 {
+		tileBytes = tileSize8
+	}
+	var address uint32
+	if control&dispOBJ1D != 0 {
+		boundary := uint32(32) << (control >> dispOBJBoundShift & 3)
+		address = tile*boundary + uint32(((y/8)*(width/8)+x/8)*tileBytes)
+	} else {
+		address = tile*tileSize4 + uint32((y/8)*32*tileSize4+(x/8)*tileBytes)
+	}
+
+	palette := uint32(attr2 >> attrPaletteShift)
+	if colors256 {
+		index := uint32(engine.readOBJ8(address + uint32((y&7)*8+x&7)))
+		if index == 0 {
+			return 0
+		}
+		if control&dispOBJExtPalette != 0 {
+			return engine.objExtColor(palette*256 + index)
+		}
+		return engine.paletteColor(256+index) | opaque
+	}
+	index := uint32(engine.readOBJ8(address+uint32((y&7)*4+(x&7)/2)) >> (uint(x&1) * 4) & 0xf)
+	if index == 0 {
+		return 0
+	}
+	return engine.paletteColor(256+palette*16+index) | opaque
+}
+
+func (engine *Engine) readOAM16(offset int) uint16 {
+	return uint16(engine.oam[offset]) | uint16(engine.oam[offset+1])<<8
+}
+
+func (engine *Engine) readOBJ8(offset uint32) uint8 {
+	return engine.vram.ReadOBJ(engine.index, offset)
+}
+
+func (engine *Engine) readOBJ16(offset uint32) uint16 {
+	return uint16(engine.readOBJ8(offset)) | uint16(engine.readOBJ8(offset+1))<<8
+}
+
+// objExtColor returns a color of the sprites' extended palette.
+func (engine *Engine) objExtColor(index uint32) uint16 {
+	offset := index * 2
+	color := uint16(engine.vram.ReadOBJExtPalette(engine.index, offset)) |
+		uint16(engine.vram.ReadOBJExtPalette(engine.index, offset+1))<<8
+	return color&colorMask | opaque
+}
@@ -0,0 +1,21 @@
+package gpu
+
+// VRAM is the video memory as the VRAM bank controller maps it for the 2D
+// engines. Offsets are relative to the start of each area.
+type VRAM interface {
+	// ReadBG reads the background memory of an engine, at 0x06000000 for
+	// engine A and 0x06200000 for engine B.
+	ReadBG(engine int, offset uint32) uint8
+	// ReadOBJ reads the sprite memory of an engine, at 0x06400000 for
+	// engine A and 0x06600000 for engine B.
+	ReadOBJ(engine int, offset uint32) uint8
+	// ReadBGExtPalette reads the four 8KB background extended palette
+	// slots of an engine.
+	ReadBGExtPalette(engine int, offset uint32) uint8
+	// ReadOBJExtPalette reads the 8KB sprite extended palette of an
+	// engine.
+	ReadOBJExtPalette(engine int, offset uint32) uint8
+	// ReadLCDC reads a bank mapped for display by the CPU, as shown by
+	// the VRAM display mode of engine A.
+	ReadLCDC(bank int, offset uint32) uint8
+}
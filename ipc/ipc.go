@@ -0,0 +1,253 @@
+// Package ipc implements the registers the Arm7 and Arm9 use to
+// communicate, the IPCSYNC register and the pair of word FIFOs.
+package ipc
+
+import (
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+const (
+	syncAddress     = 0x04000180
+	fifoCntAddress  = 0x04000184
+	fifoSendAddress = 0x04000188
+	fifoRecvAddress = 0x04100000
+	fifoDepth       = 16
+)
+
+// Bits of IPCSYNC.
+const (
+	syncOutputShift        = 8
+	syncSendIRQ     uint16 = 1 << 13
+	syncIRQEnable   uint16 = 1 << 14
+)
+
+// Bits of IPCFIFOCNT.
+const (
+	fifoSendEmpty        uint16 = 1 << 0
+	fifoSendFull         uint16 = 1 << 1
+	fifoSendEmptyIRQ     uint16 = 1 << 2
+	fifoSendClear        uint16 = 1 << 3
+	fifoRecvEmpty        uint16 = 1 << 8
+	fifoRecvFull         uint16 = 1 << 9
+	fifoRecvNotEmptyIRQ  uint16 = 1 << 10
+	fifoError            uint16 = 1 << 14
+	fifoEnable           uint16 = 1 << 15
+	fifoControlWriteMask uint16 = fifoSendEmptyIRQ | fifoRecvNotEmptyIRQ | fifoEnable
+)
+
+// fifo is a queue of up to 16 words.
+type fifo struct {
+	words  [fifoDepth]uint32
+	head   int
+	length int
+}
+
+func (f *fifo) empty() bool {
+	return f.length == 0
+}
+
+func (f *fifo) full() bool {
+	return f.length == fifoDepth
+}
+
+func (f *fifo) push(word uint32) {
+	f.words[(f.head+f.length)%fifoDepth] = word
+	f.length++
+}
+
+func (f *fifo) pop() uint32 {
+	word := f.words[f.head]
+	f.head = (f.head + 1) % fifoDepth
+	f.length--
+	return word
+}
+
+func (f *fifo) front() uint32 {
+	return f.words[f.head]
+}
+
+func (f *fifo) clear() {
+	f.head = 0
+	f.length = 0
+}
+
+// endpoint is one CPU's side of the IPC registers. Its send FIFO is the
+// remote's receive FIFO.
+type endpoint struct {
+	remote     *endpoint
+	interrupts *irq.Controller
+
+	syncOutput    uint16
+	syncIRQEnable bool
+
+	control  uint16
+	send     fifo
+	lastRead uint32
+}
+
+// IPC connects the Arm7 and Arm9 IPC registers to each other.
+type IPC struct {
+	arm7 endpoint
+	arm9 endpoint
+}
+
+// New constructs the IPC registers with both FIFOs empty and disabled.
+func New(arm7Interrupts *irq.Controller, arm9Interrupts *irq.Controller) *IPC {
+	ipc := &IPC{}
+	ipc.arm7.interrupts = arm7Interrupts
+	ipc.arm7.remote = &ipc.arm9
+	ipc.arm9.interrupts = arm9Interrupts
+	ipc.arm9.remote = &ipc.arm7
+	return ipc
+}
+
+// MapArm7 registers the Arm7 side of the IPC registers with its I/O
+// registry.
+func (ipc *IPC) MapArm7(registry *mmio.Registry) {
+	ipc.arm7.mapRegisters(registry)
+}
+
+// MapArm9 registers the Arm9 side of the IPC registers with its I/O
+// registry.
+func (ipc *IPC) MapArm9(registry *mmio.Registry) {
+	ipc.arm9.mapRegisters(registry)
+}
+
+func (end *endpoint) mapRegisters(registry *mmio.Registry) {
+	registry.MapRegister(syncAddress, 4, &mmio.Register{
+		ReadMask:  0x4f0f,
+		WriteMask: 0x6f00,
+		OnRead: func() uint32 {
+			return uint32(end.readSync())
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			end.writeSync(uint16(value), uint16(mask))
+		},
+	})
+	registry.MapRegister(fifoCntAddress, 4, &mmio.Register{
+		ReadMask:  0xc70f,
+		WriteMask: 0xc40c,
+		OnRead: func() uint32 {
+			return uint32(end.readControl())
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			end.writeControl(uint16(value), uint16(mask))
+		},
+	})
+	registry.Map(fifoSendAddress, fifoSendAddress+3, &mmio.Handler{
+		Write32: end.sendWord,
+	})
+	// Narrower reads of the receive FIFO still take a whole word from it
+	// and return the part addressed.
+	registry.Map(fifoRecvAddress, fifoRecvAddress+3, &mmio.Handler{
+		Read8: func(address uint32) uint8 {
+			return uint8(end.receiveWord(address) >> ((address & 3) * 8))
+		},
+		Read16: func(address uint32) uint16 {
+			return uint16(end.receiveWord(address) >> ((address & 2) * 8))
+		},
+		Read32: end.receiveWord,
+	})
+}
+
+func (end *endpoint) readSync() uint16 {
+	value := end.remote.syncOutput >> syncOutputShift
+	value |= end.syncOutput
+	if end.syncIRQEnable {
+		value |= syncIRQEnable
+	}
+	return value
+}
+
+func (end *endpoint) writeSync(value uint16, mask uint16) {
+	if mask&0xff00 != 0 {
+		end.syncOutput = value & 0x0f00
+		end.syncIRQEnable = value&syncIRQEnable != 0
+	}
+	if mask&value&syncSendIRQ != 0 && end.remote.syncIRQEnable {
+		end.remote.interrupts.Request(irq.IPCSync)
+	}
+}
+
+func (end *endpoint) receive() *fifo {
+	return &end.remote.send
+}
+
+func (end *endpoint) readControl() uint16 {
+	value := end.control
+	if end.send.empty() {
+		value |= fifoSendEmpty
+	}
+	if end.send.full() {
+		value |= fifoSendFull
+	}
+	if end.receive().empty() {
+		value |= fifoRecvEmpty
+	}
+	if end.receive().full() {
+		value |= fifoRecvFull
+	}
+	return value
+}
+
+func (end *endpoint) writeControl(value uint16, mask uint16) {
+	previous := end.control
+	writable := mask & fifoControlWriteMask
+	end.control = (end.control &^ writable) | (value & writable)
+
+	// The error flag is acknowledged by writing a one to it.
+	if mask&value&fifoError != 0 {
+		end.control &^= fifoError
+	}
+	if mask&value&fifoSendClear != 0 {
+		end.send.clear()
+	}
+
+	// Enabling an interrupt whose condition already holds requests it.
+	if previous&fifoSendEmptyIRQ == 0 && end.control&fifoSendEmptyIRQ != 0 && end.send.empty() {
+		end.interrupts.Request(irq.IPCSendFIFOEmpty)
+	}
+	if previous&fifoRecvNotEmptyIRQ == 0 && end.control&fifoRecvNotEmptyIRQ != 0 && !end.receive().empty() {
+		end.interrupts.Request(irq.IPCRecvFIFONotEmpty)
+	}
+}
+
+func (end *endpoint) enabled() bool {
+	return end.control&fifoEnable != 0
+}
+
+func (end *endpoint) sendWord(address uint32, value uint32) {
+	if !end.enabled() {
+		return
+	}
+	if end.send.full() {
+		end.control |= fifoError
+		return
+	}
+	wasEmpty := end.send.empty()
+	end.send.push(value)
+	if wasEmpty && end.remote.control&fifoRecvNotEmptyIRQ != 0 {
+		end.remote.interrupts.Request(irq.IPCRecvFIFONotEmpty)
+	}
+}
+
+func (end *endpoint) receiveWord(address uint32) uint32 {
+	receive := end.receive()
+	// With the FIFOs disabled the oldest word is read without removing it.
+	if !end.enabled() {
+		if receive.empty() {
+			return end.lastRead
+		}
+		return receive.front()
+	}
+	if receive.empty() {
+		end.control |= fifoError
+		return end.lastRead
+	}
+	end.lastRead = receive.pop()
+	if receive.empty() && end.remote.control&fifoSendEmptyIRQ != 0 {
+		end.remote.interrupts.Request(irq.IPCSendFIFOEmpty)
+	}
+	return end.lastRead
+}
@@ -0,0 +1,149 @@
+package ipc
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+type ipcTest struct {
+	arm7, arm9     *mmio.Registry
+	arm7Interrupts *irq.Controller
+	arm9Interrupts *irq.Controller
+}
+
+func newIPCTest() *ipcTest {
+	test := &ipcTest{
+		arm7:           mmio.NewRegistry(),
+		arm9:           mmio.NewRegistry(),
+		arm7Interrupts: irq.NewController(),
+		arm9Interrupts: irq.NewController(),
+	}
+	ipc := New(test.arm7Interrupts, test.arm9Interrupts)
+	ipc.MapArm7(test.arm7)
+	ipc.MapArm9(test.arm9)
+	return test
+}
+
+func TestSync(t *testing.T) {
+	test := newIPCTest()
+	test.arm7.Write16(syncAddress, syncIRQEnable)
+	test.arm9.Write16(syncAddress, 0x0a00|syncSendIRQ)
+
+	if value := test.arm7.Read16(syncAddress); value != 0x000a|syncIRQEnable {
+		t.Errorf("Arm7 IPCSYNC = %#x, want %#x", value, 0x000a|syncIRQEnable)
+	}
+	if value := test.arm9.Read16(syncAddress); value != 0x0a00 {
+		t.Errorf("Arm9 IPCSYNC = %#x, want 0xa00", value)
+	}
+	if flags := test.arm7Interrupts.Flags(); flags != 1<<irq.IPCSync {
+		t.Errorf("Arm7 IF = %#x, want IPC sync", flags)
+	}
+
+	// The Arm9 has its interrupt disabled.
+	test.arm7.Write16(syncAddress, syncIRQEnable|syncSendIRQ)
+	if flags := test.arm9Interrupts.Flags(); flags != 0 {
+		t.Errorf("Arm9 IF = %#x, want none", flags)
+	}
+}
+
+func TestFIFO(t *testing.T) {
+	test := newIPCTest()
+	test.arm9.Write16(fifoCntAddress, fifoEnable)
+	test.arm7.Write16(fifoCntAddress, fifoEnable|fifoRecvNotEmptyIRQ)
+
+	for word := uint32(0); word < fifoDepth; word++ {
+		test.arm9.Write32(fifoSendAddress, word*0x11111111)
+	}
+	if flags := test.arm7Interrupts.Flags(); flags != 1<<irq.IPCRecvFIFONotEmpty {
+		t.Errorf("Arm7 IF = %#x, want receive not empty", flags)
+	}
+	tests := []struct {
+		registry *mmio.Registry
+		control  uint16
+	}{
+		{test.arm9, fifoEnable | fifoSendFull | fifoRecvEmpty},
+		{test.arm7, fifoEnable | fifoRecvNotEmptyIRQ | fifoSendEmpty | fifoRecvFull},
+	}
+	for index, want := range tests {
+		if control := want.registry.Read16(fifoCntAddress); control != want.control {
+			t.Errorf("IPCFIFOCNT %d = %#x, want %#x", index, control, want.control)
+		}
+	}
+
+	// Sending to a full FIFO sets the error flag and drops the word.
+	test.arm9.Write32(fifoSendAddress, 0xdeadbeef)
+	if control := test.arm9.Read16(fifoCntAddress); control&fifoError == 0 {
+		t.Errorf("IPCFIFOCNT = %#x, want the error flag", control)
+	}
+	for word := uint32(0); word < fifoDepth; word++ {
+		if value := test.arm7.Read32(fifoRecvAddress); value != word*0x11111111 {
+			t.Errorf("word %d = %#x, want %#x", word, value, word*0x11111111)
+		}
+	}
+
+	// Reading from an empty FIFO repeats the last word.
+	if value := test.arm7.Read32(fifoRecvAddress); value != 0xffffffff {
+		t.Errorf("empty FIFO read %#x, want the last word", value)
+	}
+	if control := test.arm7.Read16(fifoCntAddress); control&fifoError == 0 {
+		t.Errorf("IPCFIFOCNT = %#x, want the error flag", control)
+	}
+	test.arm7.Write16(fifoCntAddress, fifoEnable|fifoError)
+	if control := test.arm7.Read16(fifoCntAddress); control&fifoError != 0 {
+		t.Errorf("IPCFIFOCNT = %#x, want the error flag acknowledged", control)
+	}
+}
+
+func TestSendEmptyInterrupt(t *testing.T) {
+	test := newIPCTest()
+	test.arm7.Write16(fifoCntAddress, fifoEnable)
+	test.arm9.Write16(fifoCntAddress, fifoEnable|fifoSendEmptyIRQ)
+	// Enabling it while the FIFO is empty requests it straight away.
+	if flags := test.arm9Interrupts.Flags(); flags != 1<<irq.IPCSendFIFOEmpty {
+		t.Errorf("Arm9 IF = %#x, want send empty", flags)
+	}
+	test.arm9Interrupts.Acknowledge(0xffffffff)
+
+	test.arm9.Write32(fifoSendAddress, 1)
+	test.arm9.Write32(fifoSendAddress, 2)
+	test.arm7.Read32(fifoRecvAddress)
+	if flags := test.arm9Interrupts.Flags(); flags != 0 {
+		t.Errorf("Arm9 IF = %#x with a word left", flags)
+	}
+	test.arm7.Read32(fifoRecvAddress)
+	if flags := test.arm9Interrupts.Flags(); flags != 1<<irq.IPCSendFIFOEmpty {
+		t.Errorf("Arm9 IF = %#x, want send empty", flags)
+	}
+}
+
+func TestSendClear(t *testing.T) {
+	test := newIPCTest()
+	test.arm9.Write16(fifoCntAddress, fifoEnable)
+	test.arm9.Write32(fifoSendAddress, 1)
+	test.arm9.Write16(fifoCntAddress, fifoEnable|fifoSendClear)
+	if control := test.arm7.Read16(fifoCntAddress); control&fifoRecvEmpty == 0 {
+		t.Errorf("Arm7 IPCFIFOCNT = %#x, want the receive FIFO empty", control)
+	}
+}
+
+func TestNarrowReceive(t *testing.T) {
+	test := newIPCTest()
+	test.arm9.Write16(fifoCntAddress, fifoEnable)
+	test.arm7.Write16(fifoCntAddress, fifoEnable)
+	test.arm9.Write32(fifoSendAddress, 0x11223344)
+	test.arm9.Write32(fifoSendAddress, 0x55667788)
+
+	// Every read takes a word, whatever its width.
+	if value := test.arm7.Read16(fifoRecvAddress); value != 0x3344 {
+		t.Errorf("16 bit read = %#x, want 0x3344", value)
+	}
+	if value := test.arm7.Read8(fifoRecvAddress + 3); value != 0x55 {
+		t.Errorf("8 bit read = %#x, want 0x55", value)
+	}
+	test.arm7.Read16(fifoRecvAddress)
+	if control := test.arm7.Read16(fifoCntAddress); control&fifoError == 0 {
+		t.Errorf("IPCFIFOCNT = %#x, want the error flag", control)
+	}
+}
@@ -0,0 +1,118 @@
+// Package irq implements the interrupt controller each DS CPU has, made up
+// of the IME, IE and IF registers.
+package irq
+
+import "github.com/damilolarandolph/casper/mmio"
+
+// Source is an interrupt source, numbered by its bit in IE and IF.
+type Source uint32
+
+// Interrupt sources. Some are only connected to one of the CPUs.
+const (
+	VBlank Source = iota
+	HBlank
+	VCount
+	Timer0
+	Timer1
+	Timer2
+	Timer3
+	// RTC is the Arm7 SIO/RTC interrupt.
+	RTC
+	DMA0
+	DMA1
+	DMA2
+	DMA3
+	Keypad
+	GBASlot
+	_
+	_
+	IPCSync
+	IPCSendFIFOEmpty
+	IPCRecvFIFONotEmpty
+	CardTransferComplete
+	CardIREQ
+	// GXFIFO is the Arm9 geometry command FIFO interrupt.
+	GXFIFO
+	// Lid, SPI and Wifi are Arm7 only.
+	Lid
+	SPI
+	Wifi
+)
+
+const (
+	imeAddress = 0x04000208
+	ieAddress  = 0x04000210
+	ifAddress  = 0x04000214
+)
+
+// Controller latches interrupt requests and decides whether they are
+// signalled to its CPU.
+type Controller struct {
+	ime   mmio.Register
+	ie    mmio.Register
+	flags uint32
+}
+
+// NewController constructs an interrupt controller with every interrupt
+// disabled.
+func NewController() *Controller {
+	return &Controller{
+		ime: mmio.Register{ReadMask: 0x1, WriteMask: 0x1},
+		ie:  mmio.Register{ReadMask: 0xffffffff, WriteMask: 0xffffffff},
+	}
+}
+
+// Map registers IME, IE and IF with the I/O registry of the controller's
+// CPU.
+func (controller *Controller) Map(registry *mmio.Registry) {
+	registry.MapRegister(imeAddress, 4, &controller.ime)
+	registry.MapRegister(ieAddress, 4, &controller.ie)
+	// Writing a one to a bit of IF acknowledges that interrupt.
+	registry.Map(ifAddress, ifAddress+3, &mmio.Handler{
+		Read8: func(address uint32) uint8 {
+			return uint8(controller.flags >> ((address - ifAddress) * 8))
+		},
+		Read16: func(address uint32) uint16 {
+			return uint16(controller.flags >> ((address - ifAddress) * 8))
+		},
+		Read32: func(address uint32) uint32 {
+			return controller.flags
+		},
+		Write8: func(address uint32, value uint8) {
+			controller.Acknowledge(uint32(value) << ((address - ifAddress) * 8))
+		},
+		Write16: func(address uint32, value uint16) {
+			controller.Acknowledge(uint32(value) << ((address - ifAddress) * 8))
+		},
+		Write32: func(address uint32, value uint32) {
+			controller.Acknowledge(value)
+		},
+	})
+}
+
+// Request latches an interrupt request from source in IF.
+func (controller *Controller) Request(source Source) {
+	controller.flags |= 1 << source
+}
+
+// Flags returns IF, the interrupts requested and not yet acknowledged.
+func (controller *Controller) Flags() uint32 {
+	return controller.flags
+}
+
+// Acknowledge clears the IF bits set in mask.
+func (controller *Controller) Acknowledge(mask uint32) {
+	controller.flags &^= mask
+}
+
+// Asserted reports whether an enabled interrupt has been requested. This
+// wakes a halted CPU even when IME is clear.
+func (controller *Controller) Asserted() bool {
+	return controller.ie.Value&controller.flags != 0
+}
+
+// Pending reports whether the CPU should take an IRQ exception, provided
+// its CPSR doesn't mask them.
+func (controller *Controller) Pending() bool {
+	return controller.ime.Value&1 != 0 && controller.Asserted()
+}
@@ -0,0 +1,31 @@
+package irq
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+func TestInterruptFlagsByteAccess(t *testing.T) {
+	controller := NewController()
+	registry := mmio.NewRegistry()
+	controller.Map(registry)
+	controller.Request(VBlank)
+	controller.Request(IPCRecvFIFONotEmpty)
+
+	if value := registry.Read8(ifAddress); value != 1 {
+		t.Errorf("IF byte 0 = %#x, want 1", value)
+	}
+	if value := registry.Read16(ifAddress + 2); value != 1<<(IPCRecvFIFONotEmpty-16) {
+		t.Errorf("IF halfword 1 = %#x", value)
+	}
+
+	// Acknowledging through one byte leaves the other bytes alone.
+	registry.Write8(ifAddress+2, 0xff)
+	if value := registry.Read32(ifAddress); value != 1 {
+		t.Errorf("IF = %#x, want 1", value)
+	}
+	if flags := controller.Flags(); flags != 1 {
+		t.Errorf("Flags() = %#x, want 1", flags)
+	}
+}
@@ -0,0 +1,226 @@
+// Package keypad implements the button input registers of both CPUs and
+// the keypad interrupt.
+package keypad
+
+import (
+	"sync"
+
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const (
+	keyInputAddress    = 0x04000130
+	keyControlAddress  = 0x04000132
+	extKeyInputAddress = 0x04000136
+)
+
+// Button is a set of buttons.
+type Button uint16
+
+// The buttons in KEYINPUT order followed by the ones only the Arm7 sees
+// through EXTKEYIN.
+const (
+	A Button = 1 << iota
+	B
+	Select
+	Start
+	Right
+	Left
+	Up
+	Down
+	R
+	L
+	X
+	Y
+	Debug
+)
+
+const keyInputMask = 0x3ff
+
+// Bits of KEYCNT.
+const (
+	controlIRQ       uint16 = 1 << 14
+	controlAnd       uint16 = 1 << 15
+	controlWriteMask uint16 = 0xc3ff
+)
+
+// Bits of EXTKEYIN. Bits 2, 4 and 5 are unused and read as set.
+const (
+	extX      uint16 = 1 << 0
+	extY      uint16 = 1 << 1
+	extDebug  uint16 = 1 << 3
+	extPen    uint16 = 1 << 6
+	extHinge  uint16 = 1 << 7
+	extUnused uint16 = 0x34
+)
+
+// pollPeriod is how often the keypad interrupt condition is checked,
+// about a millisecond in master cycles.
+const pollPeriod = 67028
+
+// Pen reports whether the touchscreen is being touched.
+type Pen interface {
+	PenDown() bool
+}
+
+// Keypad holds the state of the buttons. Its setters may be called from
+// any goroutine, the emulated registers see the change the next time they
+// are read or the interrupt condition is polled.
+type Keypad struct {
+	mutex     sync.Mutex
+	pressed   Button
+	lidClosed bool
+
+	pen       Pen
+	scheduler *scheduler.Scheduler
+	controls  [2]control
+	wasClosed bool
+}
+
+// control is the KEYCNT of one CPU.
+type control struct {
+	value      uint16
+	interrupts *irq.Controller
+}
+
+// New constructs the keypad with no buttons pressed and the lid open. The
+// pen state in EXTKEYIN comes from pen.
+func New(sched *scheduler.Scheduler, arm7Interrupts *irq.Controller, arm9Interrupts *irq.Controller, pen Pen) *Keypad {
+	keypad := &Keypad{
+		pen:       pen,
+		scheduler: sched,
+	}
+	keypad.controls[0].interrupts = arm7Interrupts
+	keypad.controls[1].interrupts = arm9Interrupts
+	keypad.scheduler.Schedule(pollPeriod, keypad.poll)
+	return keypad
+}
+
+// SetButtons sets the buttons that are held down, releasing all others.
+func (keypad *Keypad) SetButtons(pressed Button) {
+	keypad.mutex.Lock()
+	defer keypad.mutex.Unlock()
+	keypad.pressed = pressed
+}
+
+// Buttons returns the buttons that are held down.
+func (keypad *Keypad) Buttons() Button {
+	keypad.mutex.Lock()
+	defer keypad.mutex.Unlock()
+	return keypad.pressed
+}
+
+// SetLidClosed sets whether the console is folded shut.
+func (keypad *Keypad) SetLidClosed(closed bool) {
+	keypad.mutex.Lock()
+	defer keypad.mutex.Unlock()
+	keypad.lidClosed = closed
+}
+
+// LidClosed reports whether the console is folded shut.
+func (keypad *Keypad) LidClosed() bool {
+	keypad.mutex.Lock()
+	defer keypad.mutex.Unlock()
+	return keypad.lidClosed
+}
+
+// MapArm7 registers KEYINPUT, KEYCNT and EXTKEYIN with the Arm7 I/O
+// registry.
+func (keypad *Keypad) MapArm7(registry *mmio.Registry) {
+	keypad.mapRegisters(registry, &keypad.controls[0])
+	registry.MapRegister(extKeyInputAddress, 2, &mmio.Register{
+		ReadMask: 0xff,
+		OnRead: func() uint32 {
+			return uint32(keypad.extKeyInput())
+		},
+	})
+}
+
+// MapArm9 registers KEYINPUT and KEYCNT with the Arm9 I/O registry.
+func (keypad *Keypad) MapArm9(registry *mmio.Registry) {
+	keypad.mapRegisters(registry, &keypad.controls[1])
+}
+
+func (keypad *Keypad) mapRegisters(registry *mmio.Registry, ctrl *control) {
+	registry.MapRegister(keyInputAddress, 2, &mmio.Register{
+		ReadMask: keyInputMask,
+		OnRead: func() uint32 {
+			return uint32(keypad.keyInput())
+		},
+	})
+	registry.MapRegister(keyControlAddress, 2, &mmio.Register{
+		ReadMask:  uint32(controlWriteMask),
+		WriteMask: uint32(controlWriteMask),
+		OnRead: func() uint32 {
+			return uint32(ctrl.value)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			ctrl.value = uint16(value)
+			keypad.check(ctrl, keypad.Buttons())
+		},
+	})
+}
+
+// keyInput returns KEYINPUT, where a cleared bit is a pressed button.
+func (keypad *Keypad) keyInput() uint16 {
+	return ^uint16(keypad.Buttons()) & keyInputMask
+}
+
+func (keypad *Keypad) extKeyInput() uint16 {
+	keypad.mutex.Lock()
+	pressed := keypad.pressed
+	lidClosed := keypad.lidClosed
+	keypad.mutex.Unlock()
+
+	value := extX | extY | extDebug | extPen | extUnused
+	if pressed&X != 0 {
+		value &^= extX
+	}
+	if pressed&Y != 0 {
+		value &^= extY
+	}
+	if pressed&Debug != 0 {
+		value &^= extDebug
+	}
+	if keypad.pen != nil && keypad.pen.PenDown() {
+		value &^= extPen
+	}
+	if lidClosed {
+		value |= extHinge
+	}
+	return value
+}
+
+// check requests the keypad interrupt while the condition in KEYCNT holds,
+// either any or all of the selected buttons being pressed.
+func (keypad *Keypad) check(ctrl *control, pressed Button) {
+	if ctrl.value&controlIRQ == 0 {
+		return
+	}
+	selected := Button(ctrl.value & keyInputMask)
+	held := pressed & selected
+	if ctrl.value&controlAnd != 0 {
+		if selected != 0 && held == selected {
+			ctrl.interrupts.Request(irq.Keypad)
+		}
+	} else if held != 0 {
+		ctrl.interrupts.Request(irq.Keypad)
+	}
+}
+
+// poll checks the interrupt conditions. Opening the lid requests the lid
+// interrupt on the Arm7, which wakes it from sleep mode.
+func (keypad *Keypad) poll() {
+	pressed := keypad.Buttons()
+	for index := range keypad.controls {
+		keypad.check(&keypad.controls[index], pressed)
+	}
+	closed := keypad.LidClosed()
+	if keypad.wasClosed && !closed {
+		keypad.controls[0].interrupts.Request(irq.Lid)
+	}
+	keypad.wasClosed = closed
+	keypad.scheduler.Schedule(pollPeriod, keypad.poll)
+}
@@ -0,0 +1,141 @@
+package keypad
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+type testPen bool
+
+func (pen *testPen) PenDown() bool {
+	return bool(*pen)
+}
+
+type keypadTest struct {
+	scheduler      *scheduler.Scheduler
+	arm7, arm9     *mmio.Registry
+	arm7Interrupts *irq.Controller
+	arm9Interrupts *irq.Controller
+	pen            testPen
+	keypad         *Keypad
+}
+
+func newKeypadTest() *keypadTest {
+	test := &keypadTest{
+		scheduler:      scheduler.New(),
+		arm7:           mmio.NewRegistry(),
+		arm9:           mmio.NewRegistry(),
+		arm7Interrupts: irq.NewController(),
+		arm9Interrupts: irq.NewController(),
+	}
+	test.keypad = New(test.scheduler, test.arm7Interrupts, test.arm9Interrupts, &test.pen)
+	test.keypad.MapArm7(test.arm7)
+	test.keypad.MapArm9(test.arm9)
+	return test
+}
+
+func TestKeyInput(t *testing.T) {
+	tests := []struct {
+		pressed Button
+		value   uint16
+	}{
+		{0, 0x3ff},
+		{A, 0x3fe},
+		{Start | Up, 0x3b7},
+		{L | R, 0x0ff},
+		// X, Y and debug are only in EXTKEYIN.
+		{X | Y | Debug, 0x3ff},
+	}
+	test := newKeypadTest()
+	for _, want := range tests {
+		test.keypad.SetButtons(want.pressed)
+		for _, registry := range []*mmio.Registry{test.arm7, test.arm9} {
+			if value := registry.Read16(keyInputAddress); value != want.value {
+				t.Errorf("buttons %#x: KEYINPUT = %#x, want %#x", want.pressed, value, want.value)
+			}
+		}
+	}
+}
+
+func TestExtKeyInput(t *testing.T) {
+	tests := []struct {
+		pressed Button
+		pen     bool
+		closed  bool
+		value   uint16
+	}{
+		{0, false, false, 0x7f},
+		{X, false, false, 0x7e},
+		{Y | Debug, false, false, 0x75},
+		{0, true, false, 0x3f},
+		{0, false, true, 0xff},
+		{A, false, false, 0x7f},
+	}
+	test := newKeypadTest()
+	for _, want := range tests {
+		test.keypad.SetButtons(want.pressed)
+		test.keypad.SetLidClosed(want.closed)
+		test.pen = testPen(want.pen)
+		if value := test.arm7.Read16(extKeyInputAddress); value != want.value {
+			t.Errorf("buttons %#x, pen %v, closed %v: EXTKEYIN = %#x, want %#x",
+				want.pressed, want.pen, want.closed, value, want.value)
+		}
+	}
+}
+
+func TestInterruptCondition(t *testing.T) {
+	tests := []struct {
+		control   uint16
+		pressed   Button
+		requested bool
+	}{
+		{controlIRQ | uint16(A|B), A, true},
+		{controlIRQ | uint16(A|B), Start, false},
+		{controlIRQ | controlAnd | uint16(A|B), A, false},
+		{controlIRQ | controlAnd | uint16(A|B), A | B | Start, true},
+		{controlIRQ | controlAnd, A, false},
+		{uint16(A | B), A, false},
+	}
+	for _, want := range tests {
+		test := newKeypadTest()
+		test.keypad.SetButtons(want.pressed)
+		test.arm9.Write16(keyControlAddress, want.control)
+		requested := test.arm9Interrupts.Flags()&(1<<irq.Keypad) != 0
+		if requested != want.requested {
+			t.Errorf("KEYCNT %#x, buttons %#x: requested = %v, want %v",
+				want.control, want.pressed, requested, want.requested)
+		}
+		if flags := test.arm7Interrupts.Flags(); flags != 0 {
+			t.Errorf("KEYCNT %#x on the Arm9: Arm7 IF = %#x", want.control, flags)
+		}
+	}
+}
+
+func TestPolledInterrupts(t *testing.T) {
+	test := newKeypadTest()
+	test.arm7.Write16(keyControlAddress, controlIRQ|uint16(Select))
+	test.scheduler.Advance(pollPeriod)
+	if flags := test.arm7Interrupts.Flags(); flags != 0 {
+		t.Errorf("IF = %#x with no buttons pressed", flags)
+	}
+
+	test.keypad.SetButtons(Select)
+	test.keypad.SetLidClosed(true)
+	test.scheduler.Advance(2 * pollPeriod)
+	if flags := test.arm7Interrupts.Flags(); flags != 1<<irq.Keypad {
+		t.Errorf("IF = %#x, want keypad", flags)
+	}
+
+	// Opening the lid requests the lid interrupt on the Arm7 only.
+	test.keypad.SetLidClosed(false)
+	test.scheduler.Advance(3 * pollPeriod)
+	if flags := test.arm7Interrupts.Flags(); flags&(1<<irq.Lid) == 0 {
+		t.Errorf("IF = %#x, want lid", flags)
+	}
+	if flags := test.arm9Interrupts.Flags(); flags != 0 {
+		t.Errorf("Arm9 IF = %#x, want none", flags)
+	}
+}
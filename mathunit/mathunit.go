@@ -0,0 +1,229 @@
+// Package mathunit implements the Arm9 hardware divider and square root
+// unit.
+package mathunit
+
+import (
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const (
+	divCntAddress       = 0x04000280
+	divNumerAddress     = 0x04000290
+	divDenomAddress     = 0x04000298
+	divResultAddress    = 0x040002a0
+	divRemResultAddress = 0x040002a8
+	sqrtCntAddress      = 0x040002b0
+	sqrtResultAddress   = 0x040002b4
+	sqrtParamAddress    = 0x040002b8
+)
+
+// Division modes of DIVCNT. Mode 3 behaves like 64/32.
+const (
+	div32By32 = 0
+	div64By32 = 1
+	div64By64 = 2
+)
+
+// Bits of DIVCNT and SQRTCNT.
+const (
+	controlDivByZero uint32 = 1 << 14
+	controlBusy      uint32 = 1 << 15
+)
+
+// Calculation times in master cycles, the units take 18 or 34 bus cycles
+// to divide and 13 to find a square root.
+const (
+	div32Cycles = 18 * 2
+	div64Cycles = 34 * 2
+	sqrtCycles  = 13 * 2
+)
+
+// MathUnit is the divider and square root unit. Results are calculated as
+// soon as an input changes, the busy flags stay set for as long as the
+// hardware takes.
+type MathUnit struct {
+	scheduler *scheduler.Scheduler
+
+	divControl  mmio.Register
+	numerator   [2]mmio.Register
+	denominator [2]mmio.Register
+	quotient    uint64
+	remainder   uint64
+	divBusy     *scheduler.Event
+	sqrtControl mmio.Register
+	sqrtParam   [2]mmio.Register
+	sqrtResult  uint32
+	sqrtBusy    *scheduler.Event
+}
+
+// New constructs the math unit.
+func New(scheduler *scheduler.Scheduler) *MathUnit {
+	unit := &MathUnit{scheduler: scheduler}
+	unit.divControl = mmio.Register{
+		ReadMask:  0xc003,
+		WriteMask: 0x0003,
+		OnRead:    unit.readDivControl,
+		OnWrite:   unit.startDivision,
+	}
+	unit.sqrtControl = mmio.Register{
+		ReadMask:  0x8001,
+		WriteMask: 0x0001,
+		OnRead:    unit.readSqrtControl,
+		OnWrite:   unit.startSqrt,
+	}
+	for index := 0; index < 2; index++ {
+		unit.numerator[index] = inputRegister(unit.startDivision)
+		unit.denominator[index] = inputRegister(unit.startDivision)
+		unit.sqrtParam[index] = inputRegister(unit.startSqrt)
+	}
+	return unit
+}
+
+func inputRegister(onWrite func(value uint32, mask uint32)) mmio.Register {
+	return mmio.Register{
+		ReadMask:  0xffffffff,
+		WriteMask: 0xffffffff,
+		OnWrite:   onWrite,
+	}
+}
+
+func resultRegister(read func() uint32) *mmio.Register {
+	return &mmio.Register{
+		ReadMask: 0xffffffff,
+		OnRead:   read,
+	}
+}
+
+// Map registers the divider and square root registers with the Arm9 I/O
+// registry.
+func (unit *MathUnit) Map(registry *mmio.Registry) {
+	registry.MapRegister(divCntAddress, 4, &unit.divControl)
+	registry.MapRegister(divNumerAddress, 4, &unit.numerator[0])
+	registry.MapRegister(divNumerAddress+4, 4, &unit.numerator[1])
+	registry.MapRegister(divDenomAddress, 4, &unit.denominator[0])
+	registry.MapRegister(divDenomAddress+4, 4, &unit.denominator[1])
+	registry.MapRegister(divResultAddress, 4, resultRegister(func() uint32 {
+		return uint32(unit.quotient)
+	}))
+	registry.MapRegister(divResultAddress+4, 4, resultRegister(func() uint32 {
+		return uint32(unit.quotient >> 32)
+	}))
+	registry.MapRegister(divRemResultAddress, 4, resultRegister(func() uint32 {
+		return uint32(unit.remainder)
+	}))
+	registry.MapRegister(divRemResultAddress+4, 4, resultRegister(func() uint32 {
+		return uint32(unit.remainder >> 32)
+	}))
+
+	registry.MapRegister(sqrtCntAddress, 4, &unit.sqrtControl)
+	registry.MapRegister(sqrtResultAddress, 4, resultRegister(func() uint32 {
+		return unit.sqrtResult
+	}))
+	registry.MapRegister(sqrtParamAddress, 4, &unit.sqrtParam[0])
+	registry.MapRegister(sqrtParamAddress+4, 4, &unit.sqrtParam[1])
+}
+
+func combine(words *[2]mmio.Register) uint64 {
+	return uint64(words[1].Value)<<32 | uint64(words[0].Value)
+}
+
+func (unit *MathUnit) readDivControl() uint32 {
+	value := unit.divControl.Value
+	// The flag is set whenever the whole 64 bit denominator is zero,
+	// whatever the mode.
+	if combine(&unit.denominator) == 0 {
+		value |= controlDivByZero
+	}
+	if unit.divBusy != nil {
+		value |= controlBusy
+	}
+	return value
+}
+
+func (unit *MathUnit) readSqrtControl() uint32 {
+	value := unit.sqrtControl.Value
+	if unit.sqrtBusy != nil {
+		value |= controlBusy
+	}
+	return value
+}
+
+// busy sets a busy flag that clears once cycles have passed, restarting
+// it if the unit was already busy.
+func (unit *MathUnit) busy(event **scheduler.Event, cycles uint64) {
+	unit.scheduler.Cancel(*event)
+	*event = unit.scheduler.Schedule(cycles, func() {
+		*event = nil
+	})
+}
+
+func (unit *MathUnit) startDivision(value uint32, mask uint32) {
+	mode := unit.divControl.Value & 0x3
+	numerator := int64(combine(&unit.numerator))
+	denominator := int64(combine(&unit.denominator))
+	cycles := uint64(div64Cycles)
+
+	switch mode {
+	case div32By32:
+		numerator = int64(int32(numerator))
+		denominator = int64(int32(denominator))
+		cycles = div32Cycles
+	case div64By64:
+	default:
+		denominator = int64(int32(denominator))
+	}
+
+	quotient, remainder := divide(numerator, denominator)
+	// Dividing by zero in 32 bit mode leaves the upper word of the
+	// quotient inverted.
+	if mode == div32By32 && denominator == 0 {
+		quotient ^= -1 << 32
+	}
+	unit.quotient = uint64(quotient)
+	unit.remainder = uint64(remainder)
+	unit.busy(&unit.divBusy, cycles)
+}
+
+// divide performs a signed division the way the hardware does, including
+// its results for division by zero and overflow.
+func divide(numerator int64, denominator int64) (int64, int64) {
+	if denominator == 0 {
+		if numerator < 0 {
+			return 1, numerator
+		}
+		return -1, numerator
+	}
+	if numerator == -1<<63 && denominator == -1 {
+		return numerator, 0
+	}
+	return numerator / denominator, numerator % denominator
+}
+
+func (unit *MathUnit) startSqrt(value uint32, mask uint32) {
+	param := combine(&unit.sqrtParam)
+	if unit.sqrtControl.Value&0x1 == 0 {
+		param &= 0xffffffff
+	}
+	unit.sqrtResult = sqrt(param)
+	unit.busy(&unit.sqrtBusy, sqrtCycles)
+}
+
+// sqrt returns the integer square root of value, rounded down.
+func sqrt(value uint64) uint32 {
+	var result uint64
+	bit := uint64(1) << 62
+	for bit > value {
+		bit >>= 2
+	}
+	for bit != 0 {
+		if value >= result+bit {
+			value -= result + bit
+			result = (result >> 1) + bit
+		} else {
+			result >>= 1
+		}
+		bit >>= 2
+	}
+	return uint32(result)
+}
@@ -0,0 +1,117 @@
+package mathunit
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+func TestDivide(t *testing.T) {
+	tests := []struct {
+		numerator, denominator int64
+		quotient, remainder    int64
+	}{
+		{7, 2, 3, 1},
+		{-7, 2, -3, -1},
+		{7, -2, -3, 1},
+		{5, 0, -1, 5},
+		{-5, 0, 1, -5},
+		{0, 0, -1, 0},
+		{-1 << 63, -1, -1 << 63, 0},
+	}
+	for _, test := range tests {
+		quotient, remainder := divide(test.numerator, test.denominator)
+		if quotient != test.quotient || remainder != test.remainder {
+			t.Errorf("divide(%d, %d) = %d, %d, want %d, %d", test.numerator, test.denominator,
+				quotient, remainder, test.quotient, test.remainder)
+		}
+	}
+}
+
+func TestSqrt(t *testing.T) {
+	tests := []struct {
+		value  uint64
+		result uint32
+	}{
+		{0, 0},
+		{1, 1},
+		{15, 3},
+		{16, 4},
+		{0xffffffff, 0xffff},
+		{0xffffffffffffffff, 0xffffffff},
+	}
+	for _, test := range tests {
+		if result := sqrt(test.value); result != test.result {
+			t.Errorf("sqrt(%#x) = %#x, want %#x", test.value, result, test.result)
+		}
+	}
+}
+
+func TestDivisionRegisters(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        uint32
+		numerator   uint64
+		denominator uint64
+		quotient    uint64
+		remainder   uint64
+		byZero      bool
+	}{
+		{"32 bit", div32By32, 100, 7, 14, 2, false},
+		{"32 bit negative", div32By32, 0xfffffff6, 3, 0xfffffffffffffffd, 0xffffffffffffffff, false},
+		{"32 bit by zero", div32By32, 5, 0, 0x00000000ffffffff, 5, true},
+		{"32 bit negative by zero", div32By32, 0xfffffffb, 0, 0xffffffff00000001, 0xfffffffffffffffb, true},
+		{"64 by 32 bit", div64By32, 1 << 40, 0xffffffff00000002, 1 << 39, 0, false},
+		{"64 bit by zero", div64By64, 5, 0, 0xffffffffffffffff, 5, true},
+		{"by zero flag ignores mode", div32By32, 5, 1 << 32, 0x00000000ffffffff, 5, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sched := scheduler.New()
+			unit := New(sched)
+			registry := mmio.NewRegistry()
+			unit.Map(registry)
+
+			registry.Write32(divCntAddress, test.mode)
+			registry.Write32(divNumerAddress, uint32(test.numerator))
+			registry.Write32(divNumerAddress+4, uint32(test.numerator>>32))
+			registry.Write32(divDenomAddress, uint32(test.denominator))
+			registry.Write32(divDenomAddress+4, uint32(test.denominator>>32))
+
+			control := registry.Read32(divCntAddress)
+			if control&controlBusy == 0 {
+				t.Error("divider not busy after a write")
+			}
+			if byZero := control&controlDivByZero != 0; byZero != test.byZero {
+				t.Errorf("division by zero flag = %v, want %v", byZero, test.byZero)
+			}
+			quotient := uint64(registry.Read32(divResultAddress)) | uint64(registry.Read32(divResultAddress+4))<<32
+			remainder := uint64(registry.Read32(divRemResultAddress)) | uint64(registry.Read32(divRemResultAddress+4))<<32
+			if quotient != test.quotient || remainder != test.remainder {
+				t.Errorf("result = %#x, %#x, want %#x, %#x", quotient, remainder, test.quotient, test.remainder)
+			}
+
+			sched.Advance(div64Cycles)
+			if registry.Read32(divCntAddress)&controlBusy != 0 {
+				t.Error("divider still busy")
+			}
+		})
+	}
+}
+
+func TestSqrtRegisters(t *testing.T) {
+	unit := New(scheduler.New())
+	registry := mmio.NewRegistry()
+	unit.Map(registry)
+
+	registry.Write32(sqrtParamAddress+4, 1)
+	registry.Write32(sqrtParamAddress, 0)
+	if result := registry.Read32(sqrtResultAddress); result != 0 {
+		t.Errorf("32 bit sqrt used the upper word, result = %#x", result)
+	}
+	registry.Write32(sqrtCntAddress, 1)
+	if result := registry.Read32(sqrtResultAddress); result != 0x10000 {
+		t.Errorf("64 bit sqrt = %#x, want 0x10000", result)
+	}
+}
@@ -1,24 +1,28 @@
 package memory
 
 const (
-	mainRAMSize   int = 0x400000 // 4MB
-	wramSize      int = 0x400    // 96KB
-	vramSize      int = 0xa4000  // 656KB
-	oamPalSize    int = 0x1000   // 4KB
-	_3DMemorySize int = 0x3e000  // 248KB
+	mainRAMSize    int = 0x400000 // 4MB
+	sharedWramSize int = 0x8000   // 32KB
+	arm7WramSize   int = 0x10000  // 64KB
+	vramSize       int = 0xa4000  // 656KB
+	oamPalSize     int = 0x1000   // 4KB
+	_3DMemorySize  int = 0x3e000  // 248KB
 	// Matrix stack size is unknown according to GBATEK.
 	// So I just set it to 4MB like main ram :-p
 	matrixStackSize   int = mainRAMSize
 	wifiRAMSize       int = oamPalSize * 2 // 8KB
 	firmwareFlashSize int = 0x40000        // 256KB
-	biosSize          int = 0x9000         // 36KB
+	biosSize          int = 0x4000         // 16KB
+	arm9BIOSSize      int = 0x1000         // 4KB
+	itcmSize          int = 0x8000         // 32KB
+	dtcmSize          int = 0x4000         // 16KB
 )
 
 type MemorySlot int
 
 const (
 	MainRAM MemorySlot = iota
-	WRAM
+	SharedWRAM
 	VRAM
 	OAMPAL
 	GFX
@@ -27,11 +31,15 @@ const (
 	Firmware
 	BIOS
 	ROM
+	Arm9BIOS
+	ITCM
+	DTCM
+	Arm7WRAM
 )
 
 type InternalMemory struct {
 	MainRAM     []uint8
-	Wram        []uint8
+	SharedWram  []uint8
 	Vram        []uint8
 	OamPal      []uint8
 	Gfx         []uint8
@@ -40,13 +48,18 @@ type InternalMemory struct {
 	Firmware    []uint8
 	BIOS        []uint8
 	ROM         []uint8
+	Arm9BIOS    []uint8
+	ITCM        []uint8
+	DTCM        []uint8
+	Arm7Wram    []uint8
+	wramControl uint8
 }
 
 func NewInternalMemory() *InternalMemory {
 
 	return &InternalMemory{
 		MainRAM:     make([]uint8, mainRAMSize),
-		Wram:        make([]uint8, wramSize),
+		SharedWram:  make([]uint8, sharedWramSize),
 		Vram:        make([]uint8, vramSize),
 		OamPal:      make([]uint8, oamPalSize),
 		Gfx:         make([]uint8, _3DMemorySize),
@@ -54,5 +67,55 @@ func NewInternalMemory() *InternalMemory {
 		WifiRAM:     make([]uint8, wifiRAMSize),
 		Firmware:    make([]uint8, firmwareFlashSize),
 		BIOS:        make([]uint8, biosSize),
+		Arm9BIOS:    make([]uint8, arm9BIOSSize),
+		ITCM:        make([]uint8, itcmSize),
+		DTCM:        make([]uint8, dtcmSize),
+		Arm7Wram:    make([]uint8, arm7WramSize),
 	}
 }
+
+// SetWramControl sets the WRAMCNT value which decides how the shared WRAM
+// is split between the CPUs:
+//
+//	0: all 32KB to the Arm9
+//	1: first 16KB to the Arm7, second 16KB to the Arm9
+//	2: first 16KB to the Arm9, second 16KB to the Arm7
+//	3: all 32KB to the Arm7
+func (mem *InternalMemory) SetWramControl(value uint8) {
+	mem.wramControl = value & 0x3
+}
+
+// WramControl returns the current WRAMCNT value.
+func (mem *InternalMemory) WramControl() uint8 {
+	return mem.wramControl
+}
+
+// Arm9SharedWram returns the part of the shared WRAM allocated to the Arm9,
+// which is empty if the Arm7 owns all of it.
+func (mem *InternalMemory) Arm9SharedWram() []uint8 {
+	half := sharedWramSize / 2
+	switch mem.wramControl {
+	case 0:
+		return mem.SharedWram
+	case 1:
+		return mem.SharedWram[half:]
+	case 2:
+		return mem.SharedWram[:half]
+	}
+	return nil
+}
+
+// Arm7SharedWram returns the part of the shared WRAM allocated to the Arm7.
+// When the Arm9 owns all of it the Arm7 sees its own WRAM instead.
+func (mem *InternalMemory) Arm7SharedWram() []uint8 {
+	half := sharedWramSize / 2
+	switch mem.wramControl {
+	case 1:
+		return mem.SharedWram[:half]
+	case 2:
+		return mem.SharedWram[half:]
+	case 3:
+		return mem.SharedWram
+	}
+	return mem.Arm7Wram
+}
@@ -0,0 +1,48 @@
+package memory
+
+import "testing"
+
+func TestSharedWramSplit(t *testing.T) {
+	const half = sharedWramSize / 2
+	tests := []struct {
+		control uint8
+		// The offsets in the shared WRAM each CPU sees and their sizes,
+		// an Arm7 size of -1 standing for its own WRAM.
+		arm9Start, arm9Size int
+		arm7Start, arm7Size int
+	}{
+		{0, 0, sharedWramSize, 0, -1},
+		{1, half, half, 0, half},
+		{2, 0, half, half, half},
+		{3, 0, 0, 0, sharedWramSize},
+		// Only the low two bits are used.
+		{7, 0, 0, 0, sharedWramSize},
+	}
+	for _, test := range tests {
+		mem := NewInternalMemory()
+		mem.SetWramControl(test.control)
+		if control := mem.WramControl(); control != test.control&3 {
+			t.Errorf("WRAMCNT %d read back as %d", test.control, control)
+		}
+
+		arm9 := mem.Arm9SharedWram()
+		if len(arm9) != test.arm9Size {
+			t.Errorf("WRAMCNT %d: Arm9 sees %#x bytes, want %#x", test.control, len(arm9), test.arm9Size)
+		} else if len(arm9) != 0 && &arm9[0] != &mem.SharedWram[test.arm9Start] {
+			t.Errorf("WRAMCNT %d: Arm9 part doesn't start at %#x", test.control, test.arm9Start)
+		}
+
+		arm7 := mem.Arm7SharedWram()
+		if test.arm7Size < 0 {
+			if &arm7[0] != &mem.Arm7Wram[0] {
+				t.Errorf("WRAMCNT %d: Arm7 doesn't see its own WRAM", test.control)
+			}
+			continue
+		}
+		if len(arm7) != test.arm7Size {
+			t.Errorf("WRAMCNT %d: Arm7 sees %#x bytes, want %#x", test.control, len(arm7), test.arm7Size)
+		} else if &arm7[0] != &mem.SharedWram[test.arm7Start] {
+			t.Errorf("WRAMCNT %d: Arm7 part doesn't start at %#x", test.control, test.arm7Start)
+		}
+	}
+}
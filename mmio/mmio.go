@@ -0,0 +1,128 @@
+// Package mmio implements the dispatch of memory mapped I/O register
+// accesses to the devices that own them.
+package mmio
+
+// Handler handles accesses to a range of I/O registers. Addresses are
+// absolute and aligned to the access width. Any callback may be left nil,
+// accesses of that width are then split into narrower ones. Accesses
+// narrower than any callback are dropped, reading as zero, since the
+// registers they would have to be merged with may have side effects such
+// as popping a FIFO. A handler without any read callback is write-only
+// and reads as zero, one without any write callback is read-only.
+type Handler struct {
+	Read8   func(address uint32) uint8
+	Read16  func(address uint32) uint16
+	Read32  func(address uint32) uint32
+	Write8  func(address uint32, value uint8)
+	Write16 func(address uint32, value uint16)
+	Write32 func(address uint32, value uint32)
+}
+
+// mapping is a range of addresses a handler is registered for. A handler
+// may be registered for several ranges.
+type mapping struct {
+	*Handler
+	start uint32
+	end   uint32
+}
+
+func (mapping *mapping) covers(address uint32, size uint32) bool {
+	return address >= mapping.start && address+size-1 <= mapping.end
+}
+
+// Registry maps I/O addresses to the handlers registered for them.
+// Unmapped addresses read as zero and ignore writes.
+type Registry struct {
+	handlers map[uint32]*mapping
+}
+
+// NewRegistry constructs an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[uint32]*mapping),
+	}
+}
+
+// Map registers handler for every byte from start to end inclusive,
+// replacing any handler previously registered there. The same handler may
+// be mapped over several ranges, wider accesses are only passed to it
+// when they fall within one.
+func (registry *Registry) Map(start uint32, end uint32, handler *Handler) {
+	mapped := &mapping{Handler: handler, start: start, end: end}
+	for address := start; address <= end; address++ {
+		registry.handlers[address] = mapped
+	}
+}
+
+// MapRegister registers a register of the given size in bytes at address.
+func (registry *Registry) MapRegister(address uint32, size uint32, register *Register) {
+	registry.Map(address, address+size-1, register.handler(address))
+}
+
+// Read8 performs an 8 bit read. Handlers without an 8 bit read callback
+// read as zero, a wider register is never read to extract the byte.
+func (registry *Registry) Read8(address uint32) uint8 {
+	handler := registry.handlers[address]
+	if handler == nil || handler.Read8 == nil {
+		return 0
+	}
+	return handler.Read8(address)
+}
+
+// Read16 performs a 16 bit read, split into byte reads for handlers
+// without a 16 bit read callback.
+func (registry *Registry) Read16(address uint32) uint16 {
+	address &^= 1
+	handler := registry.handlers[address]
+	if handler != nil && handler.Read16 != nil && handler.covers(address, 2) {
+		return handler.Read16(address)
+	}
+	return uint16(registry.Read8(address)) | uint16(registry.Read8(address+1))<<8
+}
+
+// Read32 performs a 32 bit read, split into halfword reads for handlers
+// without a 32 bit read callback.
+func (registry *Registry) Read32(address uint32) uint32 {
+	address &^= 3
+	handler := registry.handlers[address]
+	if handler != nil && handler.Read32 != nil && handler.covers(address, 4) {
+		return handler.Read32(address)
+	}
+	return uint32(registry.Read16(address)) | uint32(registry.Read16(address+2))<<16
+}
+
+// Write8 performs an 8 bit write. Handlers without an 8 bit write callback
+// ignore it, a wider register is never read back to merge the byte into.
+func (registry *Registry) Write8(address uint32, value uint8) {
+	handler := registry.handlers[address]
+	if handler == nil || handler.Write8 == nil {
+		return
+	}
+	handler.Write8(address, value)
+}
+
+// Write16 performs a 16 bit write, split into byte writes for handlers
+// without a 16 bit write callback.
+func (registry *Registry) Write16(address uint32, value uint16) {
+	address &^= 1
+	handler := registry.handlers[address]
+	if handler != nil && handler.Write16 != nil && handler.covers(address, 2) {
+		handler.Write16(address, value)
+		return
+	}
+	registry.Write8(address, uint8(value))
+	registry.Write8(address+1, uint8(value>>8))
+}
+
+// Write32 performs a 32 bit write, split into halfword writes for handlers
+// without a 32 bit write callback.
+func (registry *Registry) Write32(address uint32, value uint32) {
+	address &^= 3
+	handler := registry.handlers[address]
+	if handler != nil && handler.Write32 != nil && handler.covers(address, 4) {
+		handler.Write32(address, value)
+		return
+	}
+	registry.Write16(address, uint16(value))
+	registry.Write16(address+2, uint16(value>>16))
+}
@@ -0,0 +1,130 @@
+package mmio
+
+import "testing"
+
+func TestNarrowAccessesToWideHandlersAreDropped(t *testing.T) {
+	var reads, writes int
+	registry := NewRegistry()
+	registry.Map(0x100, 0x103, &Handler{
+		Read32: func(address uint32) uint32 {
+			reads++
+			return 0x12345678
+		},
+		Write32: func(address uint32, value uint32) {
+			writes++
+		},
+	})
+
+	if value := registry.Read8(0x101); value != 0 {
+		t.Errorf("Read8 = %#x, want 0", value)
+	}
+	if value := registry.Read16(0x102); value != 0 {
+		t.Errorf("Read16 = %#x, want 0", value)
+	}
+	registry.Write8(0x100, 0xff)
+	registry.Write16(0x102, 0xffff)
+	if reads != 0 || writes != 0 {
+		t.Errorf("narrow accesses made %d reads and %d writes, want none", reads, writes)
+	}
+
+	if value := registry.Read32(0x100); value != 0x12345678 {
+		t.Errorf("Read32 = %#x, want 0x12345678", value)
+	}
+	registry.Write32(0x100, 0)
+	if reads != 1 || writes != 1 {
+		t.Errorf("word accesses made %d reads and %d writes, want one each", reads, writes)
+	}
+}
+
+func TestWideAccessesAreSplit(t *testing.T) {
+	var bytes [4]uint8
+	var order []uint32
+	registry := NewRegistry()
+	registry.Map(0x200, 0x203, &Handler{
+		Read8: func(address uint32) uint8 {
+			return bytes[address-0x200]
+		},
+		Write8: func(address uint32, value uint8) {
+			order = append(order, address)
+			bytes[address-0x200] = value
+		},
+	})
+
+	registry.Write32(0x200, 0xaabbccdd)
+	if bytes != [4]uint8{0xdd, 0xcc, 0xbb, 0xaa} {
+		t.Errorf("bytes = %x", bytes)
+	}
+	for index, address := range order {
+		if address != 0x200+uint32(index) {
+			t.Errorf("write %d went to %#x", index, address)
+		}
+	}
+	if value := registry.Read32(0x200); value != 0xaabbccdd {
+		t.Errorf("Read32 = %#x, want 0xaabbccdd", value)
+	}
+	if value := registry.Read16(0x202); value != 0xaabb {
+		t.Errorf("Read16 = %#x, want 0xaabb", value)
+	}
+}
+
+func TestRegisterLanes(t *testing.T) {
+	var lastMask uint32
+	register := &Register{
+		ReadMask:  0x0000ffff,
+		WriteMask: 0x00ff00ff,
+		OnWrite: func(value uint32, mask uint32) {
+			lastMask = mask
+		},
+	}
+	registry := NewRegistry()
+	registry.MapRegister(0x300, 4, register)
+
+	registry.Write8(0x302, 0x12)
+	if register.Value != 0x00120000 || lastMask != 0x00ff0000 {
+		t.Errorf("Value = %#x, mask = %#x", register.Value, lastMask)
+	}
+	registry.Write16(0x300, 0xffff)
+	if register.Value != 0x001200ff || lastMask != 0x000000ff {
+		t.Errorf("Value = %#x, mask = %#x", register.Value, lastMask)
+	}
+	if value := registry.Read32(0x300); value != 0x000000ff {
+		t.Errorf("Read32 = %#x, want 0xff", value)
+	}
+	if value := registry.Read8(0x300); value != 0xff {
+		t.Errorf("Read8 = %#x, want 0xff", value)
+	}
+}
+
+func TestUnmappedAddresses(t *testing.T) {
+	registry := NewRegistry()
+	registry.Write32(0x400, 0xffffffff)
+	if value := registry.Read32(0x400); value != 0 {
+		t.Errorf("Read32 = %#x, want 0", value)
+	}
+}
+
+func TestHandlerMappedOverTwoRanges(t *testing.T) {
+	var reads []uint32
+	handler := &Handler{
+		Read16: func(address uint32) uint16 {
+			reads = append(reads, address)
+			return 0x1234
+		},
+	}
+	registry := NewRegistry()
+	registry.Map(0x300, 0x303, handler)
+	registry.Map(0x308, 0x30b, handler)
+
+	for _, address := range []uint32{0x300, 0x302, 0x308, 0x30a} {
+		reads = nil
+		if value := registry.Read16(address); value != 0x1234 || len(reads) != 1 || reads[0] != address {
+			t.Errorf("Read16(%#x) = %#x through %x, want one halfword read", address, value, reads)
+		}
+	}
+	// Word reads are split within each range.
+	reads = nil
+	registry.Read32(0x300)
+	if len(reads) != 2 || reads[0] != 0x300 || reads[1] != 0x302 {
+		t.Errorf("Read32 made reads %x, want 300 302", reads)
+	}
+}
@@ -0,0 +1,62 @@
+package mmio
+
+// Register is an I/O register backed by a stored value. Only the bits set
+// in ReadMask are visible to reads and only the bits set in WriteMask can be
+// changed by writes, which makes it easy to describe read-only and
+// write-only bits.
+type Register struct {
+	Value     uint32
+	ReadMask  uint32
+	WriteMask uint32
+
+	// OnRead, when set, supplies the value of the register for a read
+	// instead of Value.
+	OnRead func() uint32
+	// OnWrite, when set, is called after a write has updated Value with
+	// the bits that were written.
+	OnWrite func(value uint32, mask uint32)
+}
+
+// Read returns the readable bits of the register.
+func (register *Register) Read() uint32 {
+	value := register.Value
+	if register.OnRead != nil {
+		value = register.OnRead()
+	}
+	return value & register.ReadMask
+}
+
+// Write updates the writable bits of the register selected by mask.
+func (register *Register) Write(value uint32, mask uint32) {
+	mask &= register.WriteMask
+	register.Value = (register.Value &^ mask) | (value & mask)
+	if register.OnWrite != nil {
+		register.OnWrite(register.Value, mask)
+	}
+}
+
+// handler exposes the register at base to the registry.
+func (register *Register) handler(base uint32) *Handler {
+	return &Handler{
+		Read8: func(address uint32) uint8 {
+			return uint8(register.Read() >> ((address - base) * 8))
+		},
+		Read16: func(address uint32) uint16 {
+			return uint16(register.Read() >> ((address - base) * 8))
+		},
+		Read32: func(address uint32) uint32 {
+			return register.Read()
+		},
+		Write8: func(address uint32, value uint8) {
+			shift := (address - base) * 8
+			register.Write(uint32(value)<<shift, 0xff<<shift)
+		},
+		Write16: func(address uint32, value uint16) {
+			shift := (address - base) * 8
+			register.Write(uint32(value)<<shift, 0xffff<<shift)
+		},
+		Write32: func(address uint32, value uint32) {
+			register.Write(value, 0xffffffff)
+		},
+	}
+}
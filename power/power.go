@@ -0,0 +1,199 @@
+// Package power implements the power management chip on the Arm7's SPI
+// bus and the power control registers of both CPUs.
+package power
+
+import "github.com/damilolarandolph/casper/mmio"
+
+const (
+	haltControlAddress  = 0x04000301
+	powerControlAddress = 0x04000304
+)
+
+// Bits of POWCNT1 on the Arm9.
+const (
+	LCDs          uint16 = 1 << 0
+	EngineA       uint16 = 1 << 1
+	Render3D      uint16 = 1 << 2
+	Geometry3D    uint16 = 1 << 3
+	EngineB       uint16 = 1 << 9
+	DisplaySwap   uint16 = 1 << 15
+	powcnt1Mask   uint16 = 0x820f
+	powcnt2Mask   uint16 = 0x0003
+	powcnt2Sound  uint16 = 1 << 0
+	powcnt2Wifi   uint16 = 1 << 1
+	haltModeShift        = 6
+)
+
+// Modes of HALTCNT.
+const (
+	haltNone = iota
+	haltGBA
+	haltHalt
+	haltSleep
+)
+
+// Registers of the power management chip.
+const (
+	registerControl = iota
+	registerBattery
+	registerMicAmp
+	registerMicGain
+	registerCount
+)
+
+// Bits of the power management control register.
+const (
+	controlSoundAmp        uint8 = 1 << 0
+	controlBottomBacklight uint8 = 1 << 2
+	controlTopBacklight    uint8 = 1 << 3
+	controlLEDBlink        uint8 = 1 << 4
+	controlPowerOff        uint8 = 1 << 6
+	controlMask            uint8 = 0x7f
+)
+
+const (
+	indexRead  uint8 = 1 << 7
+	indexMask  uint8 = 0x7f
+	micGainMax uint8 = 0x03
+)
+
+// Halter is a CPU that can wait for an interrupt.
+type Halter interface {
+	Halt()
+}
+
+// Power holds the power state of the console.
+type Power struct {
+	arm7 Halter
+
+	registers [registerCount]uint8
+	index     uint8
+	position  int
+
+	powcnt1    uint16
+	powcnt2    uint16
+	poweredOff bool
+}
+
+// New constructs the power state of a console that has just been turned
+// on, with the sound amplifier and both backlights on.
+func New(arm7 Halter) *Power {
+	power := &Power{arm7: arm7}
+	power.registers[registerControl] = controlSoundAmp | controlBottomBacklight | controlTopBacklight
+	return power
+}
+
+// MapArm7 registers HALTCNT and POWCNT2 with the Arm7 I/O registry.
+func (power *Power) MapArm7(registry *mmio.Registry) {
+	registry.MapRegister(haltControlAddress, 1, &mmio.Register{
+		WriteMask: 0xc0,
+		OnWrite: func(value uint32, mask uint32) {
+			power.writeHaltControl(uint8(value))
+		},
+	})
+	registry.MapRegister(powerControlAddress, 2, &mmio.Register{
+		ReadMask:  uint32(powcnt2Mask),
+		WriteMask: uint32(powcnt2Mask),
+		OnRead: func() uint32 {
+			return uint32(power.powcnt2)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			power.powcnt2 = uint16(value) & powcnt2Mask
+		},
+	})
+}
+
+// MapArm9 registers POWCNT1 with the Arm9 I/O registry.
+func (power *Power) MapArm9(registry *mmio.Registry) {
+	registry.MapRegister(powerControlAddress, 2, &mmio.Register{
+		ReadMask:  uint32(powcnt1Mask),
+		WriteMask: uint32(powcnt1Mask),
+		OnRead: func() uint32 {
+			return uint32(power.powcnt1)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			power.powcnt1 = uint16(value) & powcnt1Mask
+		},
+	})
+}
+
+// writeHaltControl halts the Arm7 until an enabled interrupt arrives.
+// Sleep mode is only a halt of the Arm7 too, the game enables just the
+// interrupts that should wake the console, such as opening the lid, and
+// turns the screens and engines off through POWCNT1 before entering it.
+func (power *Power) writeHaltControl(value uint8) {
+	switch value >> haltModeShift {
+	case haltHalt, haltSleep:
+		power.arm7.Halt()
+	}
+}
+
+// Enabled reports whether all the given POWCNT1 bits are set.
+func (power *Power) Enabled(bits uint16) bool {
+	return power.powcnt1&bits == bits
+}
+
+// SetPOWCNT1 sets POWCNT1 directly, as the firmware leaves it.
+func (power *Power) SetPOWCNT1(value uint16) {
+	power.powcnt1 = value & powcnt1Mask
+}
+
+// SoundEnabled reports whether the speakers are powered.
+func (power *Power) SoundEnabled() bool {
+	return power.powcnt2&powcnt2Sound != 0 && power.registers[registerControl]&controlSoundAmp != 0
+}
+
+// Backlights reports whether the backlights of the top and bottom screens
+// are on.
+func (power *Power) Backlights() (top bool, bottom bool) {
+	control := power.registers[registerControl]
+	return control&controlTopBacklight != 0, control&controlBottomBacklight != 0
+}
+
+// PoweredOff reports whether the game has turned the console off.
+func (power *Power) PoweredOff() bool {
+	return power.poweredOff
+}
+
+// Transfer exchanges a byte with the power management chip. The first
+// byte selects a register and whether it is read, the second is the value.
+func (power *Power) Transfer(value uint8) uint8 {
+	position := power.position
+	power.position++
+	switch position {
+	case 0:
+		power.index = value
+		return 0
+	case 1:
+		register := power.index & indexMask
+		if register >= registerCount {
+			return 0
+		}
+		if power.index&indexRead != 0 {
+			return power.registers[register]
+		}
+		power.writeRegister(register, value)
+	}
+	return 0
+}
+
+func (power *Power) writeRegister(register uint8, value uint8) {
+	switch register {
+	case registerControl:
+		power.registers[register] = value & controlMask
+		if value&controlPowerOff != 0 {
+			power.poweredOff = true
+		}
+	// The battery status is read-only.
+	case registerBattery:
+	case registerMicAmp:
+		power.registers[register] = value & 1
+	case registerMicGain:
+		power.registers[register] = value & micGainMax
+	}
+}
+
+// Release deselects the chip.
+func (power *Power) Release() {
+	power.position = 0
+}
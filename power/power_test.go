@@ -0,0 +1,104 @@
+package power
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+type testHalter struct {
+	halted bool
+}
+
+func (halter *testHalter) Halt() {
+	halter.halted = true
+}
+
+func TestHaltControl(t *testing.T) {
+	tests := []struct {
+		value  uint8
+		halted bool
+	}{
+		{haltNone << haltModeShift, false},
+		{haltGBA << haltModeShift, false},
+		{haltHalt << haltModeShift, true},
+		{haltSleep << haltModeShift, true},
+	}
+	for _, test := range tests {
+		halter := &testHalter{}
+		registry := mmio.NewRegistry()
+		New(halter).MapArm7(registry)
+		registry.Write8(haltControlAddress, test.value)
+		if halter.halted != test.halted {
+			t.Errorf("HALTCNT = %#x: halted = %v, want %v", test.value, halter.halted, test.halted)
+		}
+	}
+}
+
+func TestPowerControl(t *testing.T) {
+	power := New(&testHalter{})
+	arm7, arm9 := mmio.NewRegistry(), mmio.NewRegistry()
+	power.MapArm7(arm7)
+	power.MapArm9(arm9)
+
+	arm9.Write16(powerControlAddress, 0xffff)
+	if value := arm9.Read16(powerControlAddress); value != powcnt1Mask {
+		t.Errorf("POWCNT1 = %#x, want %#x", value, powcnt1Mask)
+	}
+	arm9.Write16(powerControlAddress, LCDs|EngineA|EngineB|DisplaySwap)
+	if !power.Enabled(EngineA|EngineB) || power.Enabled(Render3D) {
+		t.Errorf("POWCNT1 = %#x", power.powcnt1)
+	}
+
+	arm7.Write16(powerControlAddress, 0xffff)
+	if value := arm7.Read16(powerControlAddress); value != powcnt2Mask {
+		t.Errorf("POWCNT2 = %#x, want %#x", value, powcnt2Mask)
+	}
+	if !power.SoundEnabled() {
+		t.Error("sound disabled")
+	}
+}
+
+// transfer selects a register of the power management chip and exchanges
+// its value.
+func transfer(power *Power, index uint8, value uint8) uint8 {
+	power.Transfer(index)
+	result := power.Transfer(value)
+	power.Release()
+	return result
+}
+
+func TestRegisters(t *testing.T) {
+	tests := []struct {
+		register uint8
+		write    uint8
+		read     uint8
+	}{
+		{registerControl, 0x3f, 0x3f},
+		{registerBattery, 0xff, 0x00},
+		{registerMicAmp, 0xff, 0x01},
+		{registerMicGain, 0xff, micGainMax},
+		{registerCount, 0xff, 0x00},
+	}
+	for _, test := range tests {
+		power := New(&testHalter{})
+		transfer(power, test.register, test.write)
+		if value := transfer(power, test.register|indexRead, 0); value != test.read {
+			t.Errorf("register %d = %#x, want %#x", test.register, value, test.read)
+		}
+	}
+}
+
+func TestPowerOff(t *testing.T) {
+	power := New(&testHalter{})
+	if value := transfer(power, registerControl|indexRead, 0); value != 0x0d {
+		t.Errorf("control = %#x, want 0xd", value)
+	}
+	transfer(power, registerControl, controlPowerOff)
+	if !power.PoweredOff() {
+		t.Error("not powered off")
+	}
+	if top, bottom := power.Backlights(); top || bottom {
+		t.Errorf("backlights = %v, %v, want off", top, bottom)
+	}
+}
@@ -0,0 +1,421 @@
+// Package rtc implements the Seiko S-35199A01 real time clock connected to
+// the Arm7 through a 3-wire serial interface.
+package rtc
+
+import (
+	"time"
+
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const registerAddress = 0x04000138
+
+// Bits of the RTC register. The direction bits select whether the
+// corresponding line is driven by the Arm7.
+const (
+	lineData      uint8 = 1 << 0
+	lineClock     uint8 = 1 << 1
+	lineSelect    uint8 = 1 << 2
+	directionData uint8 = 1 << 4
+	registerMask  uint8 = 0x77
+)
+
+// Commands in bits 4-6 of the command byte. Bit 7 selects a read and
+// bits 0-3 are the fixed code 0110.
+const (
+	commandStatus1 = iota
+	commandStatus2
+	commandDateTime
+	commandTime
+	commandAlarm1
+	commandAlarm2
+	commandClockAdjust
+	commandFree
+)
+
+const (
+	commandFixedMask = 0x0f
+	commandFixed     = 0x06
+	commandRead      = 0x80
+)
+
+// Bits of status register 1.
+const (
+	status1Reset    uint8 = 1 << 0
+	status1Hour24   uint8 = 1 << 1
+	status1Writable uint8 = 0x0e
+	status1Int1     uint8 = 1 << 4
+	status1Int2     uint8 = 1 << 5
+	status1PowerLow uint8 = 1 << 6
+	status1PowerOn  uint8 = 1 << 7
+)
+
+// Bits of status register 2.
+const (
+	status2Int1Mode   uint8 = 0x0f
+	status2Int2Enable uint8 = 1 << 6
+)
+
+// INT1 modes selected by status register 2.
+const (
+	int1Frequency       = 0x1
+	int1MinuteEdge      = 0x2
+	int1MinuteSteady    = 0x3
+	int1Alarm           = 0x4
+	int1Frequency2      = 0x5
+	int1MinuteEdge2     = 0x6
+	int1MinuteSteady2   = 0x7
+	alarmEnable         = 0x80
+	hourPM              = 0x40
+	parameterBufferSize = 7
+)
+
+// pollPeriod is how often the interrupt conditions are checked, a second
+// in master cycles.
+const pollPeriod = 67027964
+
+// Clock supplies the time the RTC counts from.
+type Clock func() time.Time
+
+// RTC is the real time clock. It follows a clock source, by default the
+// host's, offset by whatever time the game has set.
+type RTC struct {
+	scheduler  *scheduler.Scheduler
+	interrupts *irq.Controller
+	clock      Clock
+	offset     time.Duration
+
+	lines uint8
+
+	status1     uint8
+	status2     uint8
+	alarm1      [3]uint8
+	alarm2      [3]uint8
+	clockAdjust uint8
+	free        uint8
+
+	command   uint8
+	bit       int
+	shift     uint8
+	params    [parameterBufferSize]uint8
+	written   int
+	output    []uint8
+	outputPos int
+
+	lastMinute int
+}
+
+// New constructs the RTC counting from the host's time, as it is after the
+// battery has been inserted.
+func New(sched *scheduler.Scheduler, interrupts *irq.Controller) *RTC {
+	rtc := &RTC{
+		scheduler:  sched,
+		interrupts: interrupts,
+		clock:      time.Now,
+		status1:    status1PowerOn | status1Hour24,
+	}
+	rtc.lastMinute = rtc.now().Minute()
+	rtc.scheduler.Schedule(pollPeriod, rtc.poll)
+	return rtc
+}
+
+// SetClock replaces the clock source, which also discards any time set by
+// the game.
+func (rtc *RTC) SetClock(clock Clock) {
+	rtc.clock = clock
+	rtc.offset = 0
+	rtc.lastMinute = rtc.now().Minute()
+}
+
+// Now returns the time the RTC currently holds.
+func (rtc *RTC) Now() time.Time {
+	return rtc.now()
+}
+
+func (rtc *RTC) now() time.Time {
+	return rtc.clock().Add(rtc.offset)
+}
+
+// Map registers the RTC register with the Arm7 I/O registry.
+func (rtc *RTC) Map(registry *mmio.Registry) {
+	registry.MapRegister(registerAddress, 2, &mmio.Register{
+		ReadMask:  uint32(registerMask),
+		WriteMask: uint32(registerMask),
+		OnRead: func() uint32 {
+			return uint32(rtc.lines)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			if mask&0xff != 0 {
+				rtc.write(uint8(value))
+			}
+		},
+	})
+}
+
+// write drives the lines of the serial interface. A transfer lasts while
+// chip select is high, and a bit is exchanged, LSB first, on each rising
+// edge of the clock.
+func (rtc *RTC) write(value uint8) {
+	previous := rtc.lines
+	// The data line reads back what the RTC drives when it is an input.
+	rtc.lines = value&^lineData | previous&lineData
+	if value&directionData != 0 {
+		rtc.lines = value
+	}
+
+	selected := value&lineSelect != 0
+	if !selected {
+		if previous&lineSelect != 0 {
+			rtc.finish()
+		}
+		return
+	}
+	if previous&lineSelect == 0 {
+		rtc.begin()
+		return
+	}
+	if previous&lineClock != 0 || value&lineClock == 0 {
+		return
+	}
+
+	if value&directionData != 0 {
+		rtc.receiveBit(value & lineData)
+		return
+	}
+	rtc.lines &^= lineData
+	rtc.lines |= rtc.sendBit()
+}
+
+func (rtc *RTC) begin() {
+	rtc.command = 0
+	rtc.bit = 0
+	rtc.shift = 0
+	rtc.written = 0
+	rtc.output = nil
+	rtc.outputPos = 0
+}
+
+func (rtc *RTC) receiveBit(value uint8) {
+	rtc.shift |= value << uint(rtc.bit&7)
+	rtc.bit++
+	if rtc.bit&7 != 0 {
+		return
+	}
+	received := rtc.shift
+	rtc.shift = 0
+	if rtc.bit == 8 {
+		rtc.receiveCommand(received)
+		return
+	}
+	if rtc.command&commandRead == 0 && rtc.written < len(rtc.params) {
+		rtc.params[rtc.written] = received
+		rtc.written++
+	}
+}
+
+func (rtc *RTC) sendBit() uint8 {
+	if rtc.bit < 8 || rtc.outputPos >= len(rtc.output)*8 {
+		rtc.bit++
+		return 0
+	}
+	value := (rtc.output[rtc.outputPos/8] >> uint(rtc.outputPos&7)) & 1
+	rtc.outputPos++
+	rtc.bit++
+	return value
+}
+
+// reverse reverses the bits of a byte.
+func reverse(value uint8) uint8 {
+	var result uint8
+	for bit := 0; bit < 8; bit++ {
+		result = result<<1 | (value>>uint(bit))&1
+	}
+	return result
+}
+
+// receiveCommand decodes the command byte. It is sent MSB first, unlike
+// the parameters, so the fixed code ends up in the high bits when received
+// LSB first.
+func (rtc *RTC) receiveCommand(value uint8) {
+	if value&commandFixedMask != commandFixed {
+		value = reverse(value)
+	}
+	if value&commandFixedMask != commandFixed {
+		rtc.command = 0
+		return
+	}
+	rtc.command = value
+	if value&commandRead != 0 {
+		rtc.output = rtc.readRegister((value >> 4) & 7)
+	}
+}
+
+func (rtc *RTC) readRegister(register uint8) []uint8 {
+	switch register {
+	case commandStatus1:
+		value := rtc.status1
+		// The flags are cleared by reading them.
+		rtc.status1 &^= status1Int1 | status1Int2 | status1PowerLow | status1PowerOn
+		return []uint8{value}
+	case commandStatus2:
+		return []uint8{rtc.status2}
+	case commandDateTime:
+		now := rtc.now()
+		return []uint8{
+			toBCD(now.Year() % 100),
+			toBCD(int(now.Month())),
+			toBCD(now.Day()),
+			uint8(now.Weekday()),
+			rtc.encodeHour(now.Hour()),
+			toBCD(now.Minute()),
+			toBCD(now.Second()),
+		}
+	case commandTime:
+		now := rtc.now()
+		return []uint8{rtc.encodeHour(now.Hour()), toBCD(now.Minute()), toBCD(now.Second())}
+	case commandAlarm1:
+		if rtc.status2&status2Int1Mode == int1Alarm {
+			return rtc.alarm1[:]
+		}
+		// In the frequency modes only the first byte is used.
+		return rtc.alarm1[:1]
+	case commandAlarm2:
+		return rtc.alarm2[:]
+	case commandClockAdjust:
+		return []uint8{rtc.clockAdjust}
+	}
+	return []uint8{rtc.free}
+}
+
+// finish applies the parameters written once chip select goes low.
+func (rtc *RTC) finish() {
+	if rtc.command == 0 || rtc.command&commandRead != 0 || rtc.written == 0 {
+		return
+	}
+	params := rtc.params[:rtc.written]
+	switch (rtc.command >> 4) & 7 {
+	case commandStatus1:
+		if params[0]&status1Reset != 0 {
+			rtc.reset()
+			return
+		}
+		rtc.status1 = rtc.status1&^status1Writable | params[0]&status1Writable
+	case commandStatus2:
+		rtc.status2 = params[0]
+	case commandDateTime:
+		if len(params) == 7 {
+			rtc.setTime(params[0], params[1], params[2], params[4], params[5], params[6])
+		}
+	case commandTime:
+		if len(params) == 3 {
+			now := rtc.now()
+			rtc.setTime(toBCD(now.Year()%100), toBCD(int(now.Month())), toBCD(now.Day()), params[0], params[1], params[2])
+		}
+	case commandAlarm1:
+		copy(rtc.alarm1[:], params)
+	case commandAlarm2:
+		copy(rtc.alarm2[:], params)
+	case commandClockAdjust:
+		rtc.clockAdjust = params[0]
+	case commandFree:
+		rtc.free = params[0]
+	}
+}
+
+// reset clears the registers and the time back to 2000-01-01.
+func (rtc *RTC) reset() {
+	rtc.status1 = 0
+	rtc.status2 = 0
+	rtc.alarm1 = [3]uint8{}
+	rtc.alarm2 = [3]uint8{}
+	rtc.clockAdjust = 0
+	rtc.free = 0
+	rtc.setTime(0x00, 0x01, 0x01, 0x00, 0x00, 0x00)
+}
+
+func (rtc *RTC) setTime(year, month, day, hour, minute, second uint8) {
+	set := time.Date(
+		2000+fromBCD(year), time.Month(fromBCD(month&0x1f)), fromBCD(day&0x3f),
+		rtc.decodeHour(hour), fromBCD(minute&0x7f), fromBCD(second&0x7f), 0,
+		time.Local,
+	)
+	rtc.offset = set.Sub(rtc.clock())
+	rtc.lastMinute = set.Minute()
+}
+
+// encodeHour returns the hour in BCD in the selected 12 or 24 hour mode.
+// The PM flag is set in both modes.
+func (rtc *RTC) encodeHour(hour int) uint8 {
+	var pm uint8
+	if hour >= 12 {
+		pm = hourPM
+	}
+	if rtc.status1&status1Hour24 == 0 {
+		hour %= 12
+	}
+	return toBCD(hour) | pm
+}
+
+func (rtc *RTC) decodeHour(value uint8) int {
+	hour := fromBCD(value & 0x3f)
+	if rtc.status1&status1Hour24 == 0 && value&hourPM != 0 {
+		hour += 12
+	}
+	return hour
+}
+
+func toBCD(value int) uint8 {
+	return uint8(value/10<<4 | value%10)
+}
+
+func fromBCD(value uint8) int {
+	return int(value>>4)*10 + int(value&0xf)
+}
+
+// alarmMatches compares the enabled fields of an alarm, weekday, hour and
+// minute, against now.
+func (rtc *RTC) alarmMatches(alarm [3]uint8, now time.Time) bool {
+	if alarm[0]&alarmEnable == 0 && alarm[1]&alarmEnable == 0 && alarm[2]&alarmEnable == 0 {
+		return false
+	}
+	if alarm[0]&alarmEnable != 0 && int(alarm[0]&7) != int(now.Weekday()) {
+		return false
+	}
+	if alarm[1]&alarmEnable != 0 && rtc.decodeHour(alarm[1]&0x7f) != now.Hour() {
+		return false
+	}
+	if alarm[2]&alarmEnable != 0 && fromBCD(alarm[2]&0x7f) != now.Minute() {
+		return false
+	}
+	return true
+}
+
+// poll checks the interrupt conditions once a second. The frequency
+// interrupt fires once a second whatever frequency is selected and the
+// alarms fire on the minute they match.
+func (rtc *RTC) poll() {
+	rtc.scheduler.Schedule(pollPeriod, rtc.poll)
+	now := rtc.now()
+	newMinute := now.Minute() != rtc.lastMinute
+	rtc.lastMinute = now.Minute()
+
+	int1 := false
+	switch rtc.status2 & status2Int1Mode {
+	case int1Frequency, int1Frequency2:
+		int1 = true
+	case int1MinuteEdge, int1MinuteEdge2, int1MinuteSteady, int1MinuteSteady2:
+		int1 = newMinute
+	case int1Alarm:
+		int1 = newMinute && rtc.alarmMatches(rtc.alarm1, now)
+	}
+	if int1 {
+		rtc.status1 |= status1Int1
+		rtc.interrupts.Request(irq.RTC)
+	}
+	if rtc.status2&status2Int2Enable != 0 && newMinute && rtc.alarmMatches(rtc.alarm2, now) {
+		rtc.status1 |= status1Int2
+		rtc.interrupts.Request(irq.RTC)
+	}
+}
@@ -0,0 +1,201 @@
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+// pinned is the time the test clock is stuck at, a Sunday afternoon.
+var pinned = time.Date(2021, time.March, 14, 15, 9, 26, 0, time.Local)
+
+type rtcTest struct {
+	rtc        *RTC
+	registry   *mmio.Registry
+	scheduler  *scheduler.Scheduler
+	interrupts *irq.Controller
+	now        time.Time
+}
+
+func newRTCTest() *rtcTest {
+	test := &rtcTest{
+		registry:   mmio.NewRegistry(),
+		scheduler:  scheduler.New(),
+		interrupts: irq.NewController(),
+		now:        pinned,
+	}
+	test.rtc = New(test.scheduler, test.interrupts)
+	test.rtc.SetClock(func() time.Time { return test.now })
+	test.rtc.Map(test.registry)
+	return test
+}
+
+func (test *rtcTest) write(value uint8) {
+	test.registry.Write8(registerAddress, value)
+}
+
+// command selects the RTC and sends a command byte, MSB first.
+func (test *rtcTest) command(command uint8) {
+	test.write(0)
+	test.write(lineSelect | lineClock)
+	for bit := 7; bit >= 0; bit-- {
+		data := (command >> uint(bit)) & 1
+		test.write(lineSelect | directionData | data)
+		test.write(lineSelect | directionData | lineClock | data)
+	}
+}
+
+// send writes parameter bytes, LSB first, and deselects the RTC.
+func (test *rtcTest) send(params ...uint8) {
+	for _, param := range params {
+		for bit := 0; bit < 8; bit++ {
+			data := (param >> uint(bit)) & 1
+			test.write(lineSelect | directionData | data)
+			test.write(lineSelect | directionData | lineClock | data)
+		}
+	}
+	test.write(0)
+}
+
+// receive reads count bytes, LSB first, and deselects the RTC.
+func (test *rtcTest) receive(count int) []uint8 {
+	result := make([]uint8, count)
+	for i := range result {
+		for bit := 0; bit < 8; bit++ {
+			test.write(lineSelect)
+			test.write(lineSelect | lineClock)
+			result[i] |= (test.registry.Read8(registerAddress) & lineData) << uint(bit)
+		}
+	}
+	test.write(0)
+	return result
+}
+
+func (test *rtcTest) read(register uint8, count int) []uint8 {
+	test.command(commandFixed | register<<4 | commandRead)
+	return test.receive(count)
+}
+
+func (test *rtcTest) set(register uint8, params ...uint8) {
+	test.command(commandFixed | register<<4)
+	test.send(params...)
+}
+
+func equal(a, b []uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBCD(t *testing.T) {
+	tests := []struct {
+		value int
+		bcd   uint8
+	}{
+		{0, 0x00},
+		{9, 0x09},
+		{10, 0x10},
+		{21, 0x21},
+		{59, 0x59},
+		{99, 0x99},
+	}
+	for _, test := range tests {
+		if bcd := toBCD(test.value); bcd != test.bcd {
+			t.Errorf("toBCD(%d) = %#x, want %#x", test.value, bcd, test.bcd)
+		}
+		if value := fromBCD(test.bcd); value != test.value {
+			t.Errorf("fromBCD(%#x) = %d, want %d", test.bcd, value, test.value)
+		}
+	}
+}
+
+func TestHourEncoding(t *testing.T) {
+	tests := []struct {
+		hour   int
+		hour24 bool
+		value  uint8
+	}{
+		{0, true, 0x00},
+		{11, true, 0x11},
+		{12, true, 0x52},
+		{23, true, 0x63},
+		{0, false, 0x00},
+		{11, false, 0x11},
+		{12, false, 0x40},
+		{15, false, 0x43},
+		{23, false, 0x51},
+	}
+	for _, test := range tests {
+		rtc := &RTC{}
+		if test.hour24 {
+			rtc.status1 = status1Hour24
+		}
+		value := rtc.encodeHour(test.hour)
+		if value != test.value {
+			t.Errorf("encodeHour(%d), 24 hour %v = %#x, want %#x", test.hour, test.hour24, value, test.value)
+		}
+		if hour := rtc.decodeHour(value); hour != test.hour {
+			t.Errorf("decodeHour(%#x), 24 hour %v = %d, want %d", value, test.hour24, hour, test.hour)
+		}
+	}
+}
+
+func TestReadDateTime(t *testing.T) {
+	test := newRTCTest()
+	got := test.read(commandDateTime, 7)
+	want := []uint8{0x21, 0x03, 0x14, 0x00, 0x55, 0x09, 0x26}
+	if !equal(got, want) {
+		t.Errorf("date and time = %x, want %x", got, want)
+	}
+}
+
+func TestSetTime(t *testing.T) {
+	test := newRTCTest()
+	test.set(commandTime, 0x08, 0x30, 0x00)
+	if got, want := test.read(commandTime, 3), []uint8{0x08, 0x30, 0x00}; !equal(got, want) {
+		t.Errorf("time = %x, want %x", got, want)
+	}
+
+	// The time set keeps following the clock.
+	test.now = test.now.Add(90 * time.Second)
+	if got, want := test.read(commandDateTime, 7), []uint8{0x21, 0x03, 0x14, 0x00, 0x08, 0x31, 0x30}; !equal(got, want) {
+		t.Errorf("date and time = %x, want %x", got, want)
+	}
+}
+
+func TestPowerOnFlagClearedByRead(t *testing.T) {
+	test := newRTCTest()
+	if status := test.read(commandStatus1, 1)[0]; status&status1PowerOn == 0 {
+		t.Errorf("status 1 = %#x, want power on set", status)
+	}
+	if status := test.read(commandStatus1, 1)[0]; status&status1PowerOn != 0 {
+		t.Errorf("status 1 = %#x, want power on cleared", status)
+	}
+}
+
+func TestMinuteEdgeInterrupt(t *testing.T) {
+	test := newRTCTest()
+	test.interrupts.Map(test.registry)
+	test.registry.Write32(0x04000210, 1<<irq.RTC)
+	test.registry.Write32(0x04000208, 1)
+	test.set(commandStatus2, int1MinuteEdge)
+
+	test.scheduler.Advance(pollPeriod)
+	if test.interrupts.Pending() {
+		t.Fatal("interrupt requested within the minute")
+	}
+	test.now = test.now.Add(time.Minute)
+	test.scheduler.Advance(2 * pollPeriod)
+	if !test.interrupts.Pending() {
+		t.Error("no interrupt on the minute")
+	}
+}
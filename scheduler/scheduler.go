@@ -0,0 +1,130 @@
+// Package scheduler implements the event queue that drives the emulated
+// system. Time is counted in master clock cycles, which run at the Arm9
+// clock rate of about 67MHz.
+package scheduler
+
+import "container/heap"
+
+// Event is a callback scheduled to run at a given master cycle.
+type Event struct {
+	when    uint64
+	handler func()
+	index   int
+}
+
+// When returns the cycle the event is scheduled for.
+func (event *Event) When() uint64 {
+	return event.when
+}
+
+// Scheduled reports whether the event is waiting to fire.
+func (event *Event) Scheduled() bool {
+	return event.index >= 0
+}
+
+type eventQueue []*Event
+
+func (queue eventQueue) Len() int { return len(queue) }
+
+func (queue eventQueue) Less(i, j int) bool { return queue[i].when < queue[j].when }
+
+func (queue eventQueue) Swap(i, j int) {
+	queue[i], queue[j] = queue[j], queue[i]
+	queue[i].index = i
+	queue[j].index = j
+}
+
+func (queue *eventQueue) Push(x interface{}) {
+	event := x.(*Event)
+	event.index = len(*queue)
+	*queue = append(*queue, event)
+}
+
+func (queue *eventQueue) Pop() interface{} {
+	old := *queue
+	event := old[len(old)-1]
+	old[len(old)-1] = nil
+	event.index = -1
+	*queue = old[:len(old)-1]
+	return event
+}
+
+// Clock is a component that runs ahead of the scheduler and keeps its own
+// time in master cycles, such as a CPU.
+type Clock interface {
+	Timestamp() uint64
+}
+
+// Scheduler keeps the current master cycle and the events due after it.
+type Scheduler struct {
+	now    uint64
+	clock  Clock
+	events eventQueue
+}
+
+// New constructs a scheduler starting at cycle zero.
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// SetClock makes the scheduler follow clock while it runs ahead, so the
+// devices a CPU accesses in the middle of a slice see the cycle the CPU
+// has reached. A nil clock goes back to the time of the last event.
+func (scheduler *Scheduler) SetClock(clock Clock) {
+	scheduler.clock = clock
+}
+
+// Now returns the current master cycle. While an event fires this is the
+// cycle it was scheduled for, and while a clock is set it is the time of
+// the clock if that is later.
+func (scheduler *Scheduler) Now() uint64 {
+	if scheduler.clock != nil {
+		if now := scheduler.clock.Timestamp(); now > scheduler.now {
+			return now
+		}
+	}
+	return scheduler.now
+}
+
+// Schedule runs handler after delay master cycles and returns the event
+// so it can be cancelled.
+func (scheduler *Scheduler) Schedule(delay uint64, handler func()) *Event {
+	event := &Event{
+		when:    scheduler.Now() + delay,
+		handler: handler,
+	}
+	heap.Push(&scheduler.events, event)
+	return event
+}
+
+// Cancel removes event from the queue if it hasn't fired yet.
+func (scheduler *Scheduler) Cancel(event *Event) {
+	if event == nil || !event.Scheduled() {
+		return
+	}
+	heap.Remove(&scheduler.events, event.index)
+}
+
+// NextEvent returns the cycle of the earliest pending event and whether
+// there is one.
+func (scheduler *Scheduler) NextEvent() (uint64, bool) {
+	if len(scheduler.events) == 0 {
+		return 0, false
+	}
+	return scheduler.events[0].when, true
+}
+
+// Advance moves time forward to target, firing every event due up to
+// and including it in order.
+func (scheduler *Scheduler) Advance(target uint64) {
+	for len(scheduler.events) > 0 && scheduler.events[0].when <= target {
+		event := heap.Pop(&scheduler.events).(*Event)
+		if event.when > scheduler.now {
+			scheduler.now = event.when
+		}
+		event.handler()
+	}
+	if target > scheduler.now {
+		scheduler.now = target
+	}
+}
@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAdvanceOrder(t *testing.T) {
+	scheduler := New()
+	var fired []uint64
+	record := func() {
+		fired = append(fired, scheduler.Now())
+	}
+	for _, delay := range []uint64{30, 10, 20, 10, 50} {
+		scheduler.Schedule(delay, record)
+	}
+
+	scheduler.Advance(30)
+	if want := []uint64{10, 10, 20, 30}; !reflect.DeepEqual(fired, want) {
+		t.Errorf("fired at %v, want %v", fired, want)
+	}
+	if now := scheduler.Now(); now != 30 {
+		t.Errorf("now = %d, want 30", now)
+	}
+	if when, ok := scheduler.NextEvent(); !ok || when != 50 {
+		t.Errorf("next event = %d, %v, want 50, true", when, ok)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	scheduler := New()
+	fired := false
+	event := scheduler.Schedule(10, func() { fired = true })
+	other := scheduler.Schedule(20, func() {})
+	scheduler.Cancel(event)
+	scheduler.Cancel(event)
+
+	if event.Scheduled() || !other.Scheduled() {
+		t.Errorf("scheduled = %v, %v, want false, true", event.Scheduled(), other.Scheduled())
+	}
+	scheduler.Advance(100)
+	if fired {
+		t.Error("cancelled event fired")
+	}
+	if _, ok := scheduler.NextEvent(); ok {
+		t.Error("events left after advancing past all of them")
+	}
+	scheduler.Cancel(other)
+	scheduler.Cancel(nil)
+}
+
+func TestRescheduleFromHandler(t *testing.T) {
+	scheduler := New()
+	var fired []uint64
+	var tick func()
+	tick = func() {
+		fired = append(fired, scheduler.Now())
+		scheduler.Schedule(25, tick)
+	}
+	scheduler.Schedule(25, tick)
+
+	scheduler.Advance(110)
+	if want := []uint64{25, 50, 75, 100}; !reflect.DeepEqual(fired, want) {
+		t.Errorf("fired at %v, want %v", fired, want)
+	}
+	if when, _ := scheduler.NextEvent(); when != 125 {
+		t.Errorf("next event = %d, want 125", when)
+	}
+}
+
+func TestAdvanceBackwards(t *testing.T) {
+	scheduler := New()
+	scheduler.Advance(100)
+	scheduler.Advance(50)
+	if now := scheduler.Now(); now != 100 {
+		t.Errorf("now = %d, want 100", now)
+	}
+}
+
+type testClock uint64
+
+func (clock *testClock) Timestamp() uint64 {
+	return uint64(*clock)
+}
+
+func TestClock(t *testing.T) {
+	scheduler := New()
+	scheduler.Advance(100)
+	clock := testClock(140)
+	scheduler.SetClock(&clock)
+	if now := scheduler.Now(); now != 140 {
+		t.Errorf("now = %d, want the clock at 140", now)
+	}
+	event := scheduler.Schedule(10, func() {})
+	if when := event.When(); when != 150 {
+		t.Errorf("event scheduled for %d, want 150", when)
+	}
+
+	// A clock behind the scheduler doesn't move time back.
+	clock = 80
+	if now := scheduler.Now(); now != 100 {
+		t.Errorf("now = %d, want 100", now)
+	}
+	scheduler.SetClock(nil)
+	if now := scheduler.Now(); now != 100 {
+		t.Errorf("now = %d without a clock, want 100", now)
+	}
+}
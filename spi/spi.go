@@ -0,0 +1,128 @@
+// Package spi implements the Arm7's SPI bus, which connects it to the
+// power management chip, the firmware flash and the touchscreen.
+package spi
+
+import (
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const (
+	controlAddress = 0x040001c0
+	dataAddress    = 0x040001c2
+)
+
+// Bits of SPICNT.
+const (
+	controlBaudRate    uint16 = 0x3
+	controlBusy        uint16 = 1 << 7
+	controlDeviceShift        = 8
+	controlHold        uint16 = 1 << 11
+	controlIRQ         uint16 = 1 << 14
+	controlEnable      uint16 = 1 << 15
+	controlWriteMask   uint16 = 0xcf03
+)
+
+// Master cycles per byte at the fastest SPI clock of 4MHz. Each step of
+// the baud rate halves the clock.
+const bytePeriod = 64 * 2
+
+// DeviceSelect is the chip addressed by the device select bits of SPICNT.
+type DeviceSelect int
+
+// The devices on the bus.
+const (
+	PowerManagement DeviceSelect = iota
+	Firmware
+	Touchscreen
+)
+
+// Device is a chip on the bus. Transfer exchanges a byte with it while it
+// is selected, Release is called when it is deselected after a transfer.
+type Device interface {
+	Transfer(value uint8) uint8
+	Release()
+}
+
+// Bus is the Arm7's SPI controller.
+type Bus struct {
+	scheduler  *scheduler.Scheduler
+	interrupts *irq.Controller
+	devices    [4]Device
+
+	control uint16
+	data    uint8
+}
+
+// New constructs the SPI bus with no devices attached.
+func New(sched *scheduler.Scheduler, interrupts *irq.Controller) *Bus {
+	return &Bus{
+		scheduler:  sched,
+		interrupts: interrupts,
+	}
+}
+
+// Attach connects device to the bus at the given device select.
+func (bus *Bus) Attach(selected DeviceSelect, device Device) {
+	bus.devices[selected] = device
+}
+
+// Map registers SPICNT and SPIDATA with the Arm7 I/O registry.
+func (bus *Bus) Map(registry *mmio.Registry) {
+	registry.MapRegister(controlAddress, 2, &mmio.Register{
+		ReadMask:  0xcf83,
+		WriteMask: uint32(controlWriteMask),
+		OnRead: func() uint32 {
+			return uint32(bus.control)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			// The settings can't change in the middle of a transfer.
+			if bus.control&controlBusy == 0 {
+				bus.control = uint16(value) & controlWriteMask
+			}
+		},
+	})
+	registry.MapRegister(dataAddress, 2, &mmio.Register{
+		ReadMask:  0xff,
+		WriteMask: 0xff,
+		OnRead: func() uint32 {
+			return uint32(bus.data)
+		},
+		OnWrite: func(value uint32, mask uint32) {
+			if mask != 0 {
+				bus.write(uint8(value))
+			}
+		},
+	})
+}
+
+func (bus *Bus) selected() Device {
+	return bus.devices[(bus.control>>controlDeviceShift)&3]
+}
+
+// write sends a byte to the selected device. The device is deselected
+// after the byte unless the chip select is held.
+func (bus *Bus) write(value uint8) {
+	if bus.control&controlEnable == 0 || bus.control&controlBusy != 0 {
+		return
+	}
+	bus.data = 0
+	if device := bus.selected(); device != nil {
+		bus.data = device.Transfer(value)
+		if bus.control&controlHold == 0 {
+			device.Release()
+		}
+	}
+
+	bus.control |= controlBusy
+	period := uint64(bytePeriod) << (bus.control & controlBaudRate)
+	bus.scheduler.Schedule(period, bus.finish)
+}
+
+func (bus *Bus) finish() {
+	bus.control &^= controlBusy
+	if bus.control&controlIRQ != 0 {
+		bus.interrupts.Request(irq.SPI)
+	}
+}
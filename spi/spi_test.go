@@ -0,0 +1,117 @@
+package spi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+// echoDevice records the bytes it receives and returns each one inverted.
+type echoDevice struct {
+	received []uint8
+	releases int
+}
+
+func (device *echoDevice) Transfer(value uint8) uint8 {
+	device.received = append(device.received, value)
+	return ^value
+}
+
+func (device *echoDevice) Release() {
+	device.releases++
+}
+
+type spiTest struct {
+	scheduler  *scheduler.Scheduler
+	registry   *mmio.Registry
+	interrupts *irq.Controller
+	bus        *Bus
+}
+
+func newSPITest() *spiTest {
+	test := &spiTest{
+		scheduler:  scheduler.New(),
+		registry:   mmio.NewRegistry(),
+		interrupts: irq.NewController(),
+	}
+	test.bus = New(test.scheduler, test.interrupts)
+	test.bus.Map(test.registry)
+	return test
+}
+
+func TestTransfer(t *testing.T) {
+	test := newSPITest()
+	device := &echoDevice{}
+	test.bus.Attach(Firmware, device)
+	control := controlEnable | controlIRQ | uint16(Firmware)<<controlDeviceShift
+
+	test.registry.Write16(controlAddress, control|controlHold)
+	test.registry.Write16(dataAddress, 0x03)
+	if value := test.registry.Read16(dataAddress); value != 0xfc {
+		t.Errorf("SPIDATA = %#x, want 0xfc", value)
+	}
+	if value := test.registry.Read16(controlAddress); value&controlBusy == 0 {
+		t.Errorf("SPICNT = %#x, want busy", value)
+	}
+
+	// Writes while busy are ignored.
+	test.registry.Write16(dataAddress, 0x55)
+	test.registry.Write16(controlAddress, control)
+	test.scheduler.Advance(bytePeriod)
+	if value := test.registry.Read16(controlAddress); value != control|controlHold {
+		t.Errorf("SPICNT = %#x, want %#x", value, control|controlHold)
+	}
+	if flags := test.interrupts.Flags(); flags != 1<<irq.SPI {
+		t.Errorf("IF = %#x, want SPI", flags)
+	}
+	if device.releases != 0 {
+		t.Error("device released while chip select is held")
+	}
+
+	test.registry.Write16(controlAddress, control)
+	test.registry.Write16(dataAddress, 0x00)
+	test.scheduler.Advance(2 * bytePeriod)
+	if want := []uint8{0x03, 0x00}; !reflect.DeepEqual(device.received, want) {
+		t.Errorf("device received %x, want %x", device.received, want)
+	}
+	if device.releases != 1 {
+		t.Errorf("device released %d times, want once", device.releases)
+	}
+}
+
+func TestBaudRate(t *testing.T) {
+	for rate := uint16(0); rate < 4; rate++ {
+		test := newSPITest()
+		test.registry.Write16(controlAddress, controlEnable|rate)
+		test.registry.Write16(dataAddress, 0)
+
+		period := uint64(bytePeriod) << rate
+		test.scheduler.Advance(period - 1)
+		if value := test.registry.Read16(controlAddress); value&controlBusy == 0 {
+			t.Errorf("baud rate %d: done after %d cycles", rate, period-1)
+		}
+		test.scheduler.Advance(period)
+		if value := test.registry.Read16(controlAddress); value&controlBusy != 0 {
+			t.Errorf("baud rate %d: busy after %d cycles", rate, period)
+		}
+	}
+}
+
+func TestNoDevice(t *testing.T) {
+	test := newSPITest()
+	test.registry.Write16(controlAddress, controlEnable|uint16(Touchscreen)<<controlDeviceShift)
+	test.registry.Write16(dataAddress, 0xff)
+	if value := test.registry.Read16(dataAddress); value != 0 {
+		t.Errorf("SPIDATA = %#x, want 0", value)
+	}
+
+	test.scheduler.Advance(bytePeriod)
+	test.registry.Write16(controlAddress, 0)
+	test.registry.Write16(dataAddress, 0xff)
+	if value := test.registry.Read16(controlAddress); value&controlBusy != 0 {
+		t.Error("disabled bus started a transfer")
+	}
+}
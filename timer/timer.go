@@ -0,0 +1,196 @@
+// Package timer implements the four hardware timers each DS CPU has.
+package timer
+
+import (
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+const baseAddress = 0x04000100
+
+// Bits of TMxCNT_H.
+const (
+	controlPrescaler uint16 = 0x3
+	controlCountUp   uint16 = 1 << 2
+	controlIRQ       uint16 = 1 << 6
+	controlStart     uint16 = 1 << 7
+	controlMask      uint16 = controlPrescaler | controlCountUp | controlIRQ | controlStart
+)
+
+// Timers tick at the 33MHz bus clock divided by the prescaler, these are
+// the periods in master cycles.
+var prescalerPeriods = [4]uint64{2, 128, 512, 2048}
+
+// Timers are the four timers of one CPU. A timer overflowing can request
+// an interrupt and clock the next timer when it is in count-up mode.
+type Timers struct {
+	scheduler  *scheduler.Scheduler
+	interrupts *irq.Controller
+	timers     [4]timer
+}
+
+type timer struct {
+	timers  *Timers
+	index   int
+	reload  uint16
+	control uint16
+	counter uint16
+	// lastUpdate is the cycle counter was last brought up to date at.
+	lastUpdate uint64
+	overflow   *scheduler.Event
+}
+
+// New constructs the timers of a CPU, all stopped.
+func New(scheduler *scheduler.Scheduler, interrupts *irq.Controller) *Timers {
+	timers := &Timers{
+		scheduler:  scheduler,
+		interrupts: interrupts,
+	}
+	for index := range timers.timers {
+		timers.timers[index] = timer{
+			timers: timers,
+			index:  index,
+		}
+	}
+	return timers
+}
+
+// Map registers TMxCNT_L and TMxCNT_H of every timer with the I/O
+// registry of the timers' CPU.
+func (timers *Timers) Map(registry *mmio.Registry) {
+	for index := range timers.timers {
+		t := &timers.timers[index]
+		base := uint32(baseAddress + index*4)
+		registry.Map(base, base+3, &mmio.Handler{
+			Read8: func(address uint32) uint8 {
+				switch address - base {
+				case 0:
+					return uint8(t.readCounter())
+				case 1:
+					return uint8(t.readCounter() >> 8)
+				case 2:
+					return uint8(t.control)
+				}
+				return uint8(t.control >> 8)
+			},
+			Read16: func(address uint32) uint16 {
+				if address == base {
+					return t.readCounter()
+				}
+				return t.control
+			},
+			Write8: func(address uint32, value uint8) {
+				switch address - base {
+				case 0:
+					t.reload = (t.reload & 0xff00) | uint16(value)
+				case 1:
+					t.reload = (t.reload & 0x00ff) | uint16(value)<<8
+				case 2:
+					t.writeControl(uint16(value))
+				}
+			},
+			Write16: func(address uint32, value uint16) {
+				if address == base {
+					t.reload = value
+					return
+				}
+				t.writeControl(value)
+			},
+		})
+	}
+}
+
+func (t *timer) period() uint64 {
+	return prescalerPeriods[t.control&controlPrescaler]
+}
+
+// countsUp reports whether the timer is clocked by the previous timer's
+// overflows. Timer 0 has no previous timer.
+func (t *timer) countsUp() bool {
+	return t.index > 0 && t.control&controlCountUp != 0
+}
+
+// running reports whether the timer is clocked by the prescaler.
+func (t *timer) running() bool {
+	return t.control&controlStart != 0 && !t.countsUp()
+}
+
+// update brings counter up to date with the time elapsed since the last
+// update. Overflows are handled by the scheduled event instead.
+func (t *timer) update() {
+	if !t.running() {
+		return
+	}
+	period := t.period()
+	ticks := (t.timers.scheduler.Now() - t.lastUpdate) / period
+	t.counter += uint16(ticks)
+	t.lastUpdate += ticks * period
+}
+
+// schedule sets up the event for the timer's next overflow.
+func (t *timer) schedule() {
+	t.timers.scheduler.Cancel(t.overflow)
+	t.overflow = nil
+	if !t.running() {
+		return
+	}
+	when := t.lastUpdate + (0x10000-uint64(t.counter))*t.period()
+	var delay uint64
+	if now := t.timers.scheduler.Now(); when > now {
+		delay = when - now
+	}
+	t.overflow = t.timers.scheduler.Schedule(delay, t.onOverflow)
+}
+
+func (t *timer) readCounter() uint16 {
+	t.update()
+	return t.counter
+}
+
+func (t *timer) writeControl(value uint16) {
+	t.update()
+	wasStarted := t.control&controlStart != 0
+	wasRunning := t.running()
+	t.control = value & controlMask
+	if !wasStarted && t.control&controlStart != 0 {
+		t.counter = t.reload
+	}
+	// The prescaler counts from now whenever it starts clocking the timer,
+	// be it by the start bit or by leaving count-up mode.
+	if !wasRunning && t.running() {
+		t.lastUpdate = t.timers.scheduler.Now()
+	}
+	t.schedule()
+}
+
+func (t *timer) onOverflow() {
+	t.overflow = nil
+	t.counter = t.reload
+	t.lastUpdate = t.timers.scheduler.Now()
+	t.overflowed()
+	t.schedule()
+}
+
+// overflowed requests the timer's interrupt and clocks the next timer if
+// it counts up.
+func (t *timer) overflowed() {
+	if t.control&controlIRQ != 0 {
+		t.timers.interrupts.Request(irq.Timer0 + irq.Source(t.index))
+	}
+	if t.index == 3 {
+		return
+	}
+	next := &t.timers.timers[t.index+1]
+	if next.control&controlStart != 0 && next.countsUp() {
+		next.countUp()
+	}
+}
+
+func (t *timer) countUp() {
+	t.counter++
+	if t.counter == 0 {
+		t.counter = t.reload
+		t.overflowed()
+	}
+}
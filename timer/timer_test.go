@@ -0,0 +1,142 @@
+package timer
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/irq"
+	"github.com/damilolarandolph/casper/mmio"
+	"github.com/damilolarandolph/casper/scheduler"
+)
+
+type timerTest struct {
+	scheduler  *scheduler.Scheduler
+	registry   *mmio.Registry
+	interrupts *irq.Controller
+}
+
+func newTimerTest() *timerTest {
+	test := &timerTest{
+		scheduler:  scheduler.New(),
+		registry:   mmio.NewRegistry(),
+		interrupts: irq.NewController(),
+	}
+	New(test.scheduler, test.interrupts).Map(test.registry)
+	return test
+}
+
+func (test *timerTest) start(index int, reload uint16, control uint16) {
+	address := uint32(baseAddress + index*4)
+	test.registry.Write16(address, reload)
+	test.registry.Write16(address+2, control|controlStart)
+}
+
+func (test *timerTest) counter(index int) uint16 {
+	return test.registry.Read16(uint32(baseAddress + index*4))
+}
+
+func (test *timerTest) flags() uint32 {
+	return test.interrupts.Flags()
+}
+
+func TestPrescaler(t *testing.T) {
+	tests := []struct {
+		prescaler uint16
+		cycles    uint64
+		counter   uint16
+	}{
+		{0, 1, 0},
+		{0, 2, 1},
+		{0, 1000, 500},
+		{1, 127, 0},
+		{1, 128 * 3, 3},
+		{2, 512*7 + 511, 7},
+		{3, 2048 * 5, 5},
+	}
+	for _, test := range tests {
+		timers := newTimerTest()
+		timers.start(0, 0, test.prescaler)
+		timers.scheduler.Advance(test.cycles)
+		if counter := timers.counter(0); counter != test.counter {
+			t.Errorf("prescaler %d after %d cycles: counter = %d, want %d",
+				test.prescaler, test.cycles, counter, test.counter)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	test := newTimerTest()
+	test.start(1, 0xfff0, controlIRQ)
+
+	test.scheduler.Advance(0x10*2 - 1)
+	if flags := test.flags(); flags != 0 {
+		t.Errorf("IF = %#x before the overflow", flags)
+	}
+	test.scheduler.Advance(0x10 * 2)
+	if flags := test.flags(); flags != 1<<irq.Timer1 {
+		t.Errorf("IF = %#x, want timer 1", flags)
+	}
+	if counter := test.counter(1); counter != 0xfff0 {
+		t.Errorf("counter = %#x, want the reload value", counter)
+	}
+
+	// The timer keeps running from the reload value.
+	test.scheduler.Advance(0x10*2 + 6)
+	if counter := test.counter(1); counter != 0xfff3 {
+		t.Errorf("counter = %#x, want 0xfff3", counter)
+	}
+}
+
+func TestCountUp(t *testing.T) {
+	test := newTimerTest()
+	test.start(1, 0xfffe, controlCountUp|controlIRQ)
+	test.start(0, 0xff00, 0)
+
+	test.scheduler.Advance(0x100 * 2)
+	if counter := test.counter(1); counter != 0xffff {
+		t.Errorf("timer 1 = %#x after one overflow of timer 0, want 0xffff", counter)
+	}
+	if flags := test.flags(); flags != 0 {
+		t.Errorf("IF = %#x before timer 1 overflowed", flags)
+	}
+	test.scheduler.Advance(0x100 * 2 * 2)
+	if counter := test.counter(1); counter != 0xfffe {
+		t.Errorf("timer 1 = %#x, want the reload value", counter)
+	}
+	if flags := test.flags(); flags != 1<<irq.Timer1 {
+		t.Errorf("IF = %#x, want timer 1", flags)
+	}
+}
+
+func TestByteAccess(t *testing.T) {
+	test := newTimerTest()
+	test.registry.Write8(baseAddress, 0x34)
+	test.registry.Write8(baseAddress+1, 0x12)
+	test.registry.Write8(baseAddress+2, uint8(controlStart|2))
+
+	test.scheduler.Advance(512 * 3)
+	if value := test.registry.Read8(baseAddress); value != 0x37 {
+		t.Errorf("counter low = %#x, want 0x37", value)
+	}
+	if value := test.registry.Read8(baseAddress + 1); value != 0x12 {
+		t.Errorf("counter high = %#x, want 0x12", value)
+	}
+	if value := test.registry.Read8(baseAddress + 2); value != uint8(controlStart|2) {
+		t.Errorf("control = %#x, want %#x", value, controlStart|2)
+	}
+}
+
+func TestLeaveCountUp(t *testing.T) {
+	test := newTimerTest()
+	test.start(1, 0x10, controlCountUp)
+	test.scheduler.Advance(1000)
+
+	// Switching to the prescaler ticks from the switch, not from the start.
+	test.registry.Write16(baseAddress+4+2, controlStart)
+	if counter := test.counter(1); counter != 0x10 {
+		t.Errorf("counter = %#x right after the switch, want 0x10", counter)
+	}
+	test.scheduler.Advance(1000 + 2*5)
+	if counter := test.counter(1); counter != 0x15 {
+		t.Errorf("counter = %#x, want 0x15", counter)
+	}
+}
@@ -0,0 +1,184 @@
+// Package touchscreen implements the TSC2046 touchscreen controller on the
+// Arm7's SPI bus.
+package touchscreen
+
+import (
+	"sync"
+
+	"github.com/damilolarandolph/casper/firmware"
+)
+
+// Screen size in pixels.
+const (
+	Width  = 256
+	Height = 192
+)
+
+// Bits of the control byte.
+const (
+	controlStart        uint8 = 1 << 7
+	controlChannelShift       = 4
+	controlEightBit     uint8 = 1 << 3
+)
+
+// Input channels selected by the control byte.
+const (
+	channelTemperature0 = 0
+	channelY            = 1
+	channelBattery      = 2
+	channelZ1           = 3
+	channelZ2           = 4
+	channelX            = 5
+	channelAux          = 6
+	channelTemperature1 = 7
+)
+
+// Readings of the channels that don't depend on the pen, roughly those of
+// a console at room temperature.
+const (
+	temperature0 = 0x2c0
+	temperature1 = 0x350
+	battery      = 0x000
+	aux          = 0x000
+)
+
+// Pressure readings while the pen is down. Games only check that Z1 is
+// non-zero or derive a rough pressure from the ratio.
+const (
+	pressedZ1 = 0x200
+	pressedZ2 = 0x600
+)
+
+// Touchscreen is the touchscreen controller. The pointer is set by the
+// host in screen pixels and converted to ADC readings with the firmware's
+// calibration. Its methods may be called from any goroutine.
+type Touchscreen struct {
+	mutex       sync.Mutex
+	down        bool
+	x           int
+	y           int
+	calibration firmware.Calibration
+
+	control  uint8
+	position int
+	result   uint16
+}
+
+// New constructs a touchscreen with the pen up.
+func New(calibration firmware.Calibration) *Touchscreen {
+	return &Touchscreen{calibration: calibration}
+}
+
+// SetCalibration sets the calibration used to convert screen positions.
+func (ts *Touchscreen) SetCalibration(calibration firmware.Calibration) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.calibration = calibration
+}
+
+// SetPointer sets the position of the pen on the bottom screen in pixels
+// and whether it touches the screen. Positions outside the screen are
+// clamped to it.
+func (ts *Touchscreen) SetPointer(x int, y int, down bool) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.x = clamp(x, Width-1)
+	ts.y = clamp(y, Height-1)
+	ts.down = down
+}
+
+// PenDown reports whether the pen touches the screen.
+func (ts *Touchscreen) PenDown() bool {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	return ts.down
+}
+
+func clamp(value int, max int) int {
+	if value < 0 {
+		return 0
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// toADC converts a screen position to the reading the calibration maps to
+// it.
+func toADC(position int, screen1 uint8, screen2 uint8, adc1 uint16, adc2 uint16) uint16 {
+	if screen1 == screen2 {
+		return adc1
+	}
+	value := (position-int(screen1))*(int(adc2)-int(adc1))/(int(screen2)-int(screen1)) + int(adc1)
+	return uint16(clamp(value, 0xfff))
+}
+
+// convert samples the channel selected by the control byte as a 12 bit
+// value.
+func (ts *Touchscreen) convert() uint16 {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	cal := ts.calibration
+	switch (ts.control >> controlChannelShift) & 7 {
+	case channelTemperature0:
+		return temperature0
+	case channelTemperature1:
+		return temperature1
+	case channelBattery:
+		return battery
+	case channelAux:
+		return aux
+	case channelX:
+		if ts.down {
+			return toADC(ts.x, cal.ScreenX1, cal.ScreenX2, cal.ADCX1, cal.ADCX2)
+		}
+		return 0
+	case channelY:
+		if ts.down {
+			return toADC(ts.y, cal.ScreenY1, cal.ScreenY2, cal.ADCY1, cal.ADCY2)
+		}
+		return 0xfff
+	case channelZ1:
+		if ts.down {
+			return pressedZ1
+		}
+		return 0
+	case channelZ2:
+		if ts.down {
+			return pressedZ2
+		}
+		return 0xfff
+	}
+	return 0
+}
+
+// Transfer exchanges a byte with the controller. The result of a
+// conversion is shifted out in the two bytes after its control byte, and
+// a control byte sent during the second starts the next conversion.
+func (ts *Touchscreen) Transfer(value uint8) uint8 {
+	var out uint8
+	switch ts.position {
+	case 1:
+		out = uint8(ts.result >> 5)
+	case 2:
+		out = uint8(ts.result << 3)
+	}
+
+	if value&controlStart != 0 {
+		ts.control = value
+		ts.position = 1
+		ts.result = ts.convert()
+		if ts.control&controlEightBit != 0 {
+			ts.result &= 0xff0
+		}
+	} else if ts.position != 0 {
+		ts.position++
+	}
+	return out
+}
+
+// Release deselects the controller.
+func (ts *Touchscreen) Release() {
+	ts.position = 0
+}
@@ -0,0 +1,119 @@
+package touchscreen
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/firmware"
+)
+
+// calibration maps (16, 16) to ADC (0x100, 0x200) and (240, 176) to ADC
+// (0xf00, 0xd00), 16 ADC steps per pixel horizontally and 17.6 vertically.
+var calibration = firmware.Calibration{
+	ADCX1: 0x100, ADCY1: 0x200, ScreenX1: 16, ScreenY1: 16,
+	ADCX2: 0xf00, ADCY2: 0xd00, ScreenX2: 240, ScreenY2: 176,
+}
+
+// sample converts a channel and shifts the 12 bit result out of the next
+// two bytes.
+func sample(ts *Touchscreen, channel uint8, eightBit bool) uint16 {
+	control := controlStart | channel<<controlChannelShift
+	if eightBit {
+		control |= controlEightBit
+	}
+	ts.Transfer(control)
+	high := ts.Transfer(0)
+	low := ts.Transfer(0)
+	ts.Release()
+	return (uint16(high)<<8 | uint16(low)) >> 3
+}
+
+func TestToADC(t *testing.T) {
+	tests := []struct {
+		position int
+		adc      uint16
+	}{
+		{16, 0x100},
+		{240, 0xf00},
+		{128, 0x800},
+		{0, 0x000},
+		{255, 0xff0},
+		{300, 0xfff},
+	}
+	for _, test := range tests {
+		adc := toADC(test.position, calibration.ScreenX1, calibration.ScreenX2, calibration.ADCX1, calibration.ADCX2)
+		if adc != test.adc {
+			t.Errorf("toADC(%d) = %#x, want %#x", test.position, adc, test.adc)
+		}
+	}
+	if adc := toADC(50, 16, 16, 0x123, 0x456); adc != 0x123 {
+		t.Errorf("toADC with a degenerate calibration = %#x, want 0x123", adc)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	tests := []struct {
+		channel uint8
+		down    bool
+		value   uint16
+	}{
+		{channelX, true, 0x800},
+		{channelY, true, 0x780},
+		{channelZ1, true, pressedZ1},
+		{channelZ2, true, pressedZ2},
+		{channelX, false, 0},
+		{channelY, false, 0xfff},
+		{channelZ1, false, 0},
+		{channelZ2, false, 0xfff},
+		{channelTemperature0, false, temperature0},
+		{channelTemperature1, true, temperature1},
+		{channelBattery, false, battery},
+		{channelAux, false, aux},
+	}
+	ts := New(calibration)
+	for _, test := range tests {
+		ts.SetPointer(128, 96, test.down)
+		if value := sample(ts, test.channel, false); value != test.value {
+			t.Errorf("channel %d, pen down %v = %#x, want %#x", test.channel, test.down, value, test.value)
+		}
+	}
+}
+
+func TestEightBitConversion(t *testing.T) {
+	ts := New(calibration)
+	ts.SetPointer(128, 97, true)
+	if value := sample(ts, channelY, false); value != 0x791 {
+		t.Errorf("Y = %#x, want 0x791", value)
+	}
+	if value := sample(ts, channelY, true); value != 0x790 {
+		t.Errorf("8 bit Y = %#x, want 0x790", value)
+	}
+}
+
+func TestClampedPointer(t *testing.T) {
+	ts := New(calibration)
+	ts.SetPointer(-20, 500, true)
+	if x, y := sample(ts, channelX, false), sample(ts, channelY, false); x != 0 || y != 0xe08 {
+		t.Errorf("X, Y = %#x, %#x, want 0, 0xe08", x, y)
+	}
+	if !ts.PenDown() {
+		t.Error("pen up")
+	}
+}
+
+func TestContinuousConversion(t *testing.T) {
+	ts := New(calibration)
+	ts.SetPointer(128, 96, true)
+	// A control byte sent with the second result byte starts the next
+	// conversion without deselecting the controller.
+	ts.Transfer(controlStart | channelX<<controlChannelShift)
+	high := ts.Transfer(0)
+	low := ts.Transfer(controlStart | channelZ1<<controlChannelShift)
+	if x := (uint16(high)<<8 | uint16(low)) >> 3; x != 0x800 {
+		t.Errorf("X = %#x, want 0x800", x)
+	}
+	high = ts.Transfer(0)
+	low = ts.Transfer(0)
+	if z1 := (uint16(high)<<8 | uint16(low)) >> 3; z1 != pressedZ1 {
+		t.Errorf("Z1 = %#x, want %#x", z1, pressedZ1)
+	}
+}
@@ -0,0 +1,408 @@
+// Package vram implements the VRAM bank controller, which maps the nine
+// banks of video memory to the 2D engines, the 3D engine, the Arm7 or the
+// Arm9 through the VRAMCNT registers.
+package vram
+
+import "github.com/damilolarandolph/casper/mmio"
+
+// The banks in the order of their VRAMCNT registers.
+const (
+	bankA = iota
+	bankB
+	bankC
+	bankD
+	bankE
+	bankF
+	bankG
+	bankH
+	bankI
+	bankCount
+)
+
+const (
+	controlAddress = 0x04000240
+	// WRAMCNT sits between VRAMCNT_G and VRAMCNT_H.
+	controlHAddress = 0x04000248
+	statusAddress   = 0x04000240
+)
+
+// Bits of VRAMCNT.
+const (
+	controlOffsetShift       = 3
+	controlOffsetMask  uint8 = 0x3
+	controlEnable      uint8 = 1 << 7
+	controlWriteMask   uint8 = 0x9f
+)
+
+// Bits of VRAMSTAT.
+const (
+	statusC uint8 = 1 << 0
+	statusD uint8 = 1 << 1
+)
+
+// pageSize is the granularity of the mappings, the size of the smallest
+// banks.
+const pageSize = 0x4000
+
+// Sizes of the areas banks are mapped to, in pages.
+const (
+	bgAPages       = 32
+	bgBPages       = 8
+	objAPages      = 16
+	objBPages      = 8
+	lcdcPages      = 41
+	texturePages   = 32
+	texPalPages    = 6
+	extPalPages    = 2
+	objExtPalPages = 1
+	arm7Pages      = 16
+	slotPages      = 8
+)
+
+// Addresses of the Arm9 VRAM areas relative to 0x06000000, selected by
+// bits 21 to 23. The engine areas repeat within them.
+const (
+	areaShift = 21
+	areaBGA   = 0
+	areaBGB   = 1
+	areaOBJA  = 2
+	areaOBJB  = 3
+	lcdcBase  = 0x800000
+	lcdcSize  = 0x100000
+	areaMask  = 0xffffff
+)
+
+// The engines, in the order the 2D renderer numbers them.
+const (
+	engineA = iota
+	engineB
+)
+
+// bankSizes are the sizes of the banks, which are laid out one after the
+// other in memory.
+var bankSizes = [bankCount]int{
+	0x20000, 0x20000, 0x20000, 0x20000, 0x10000, 0x4000, 0x4000, 0x8000, 0x4000,
+}
+
+// mstMasks are the bits of the MST field of each bank's VRAMCNT.
+var mstMasks = [bankCount]uint8{3, 3, 7, 7, 7, 7, 7, 3, 3}
+
+// page holds the 16KB of every bank mapped to a page, more than one when
+// banks overlap.
+type page [][]uint8
+
+// table is the page table of one usage.
+type table []page
+
+// read8 reads a byte of the area, combining overlapping banks. Unmapped
+// pages read as zero.
+func (pages table) read8(offset uint32) uint8 {
+	index := int(offset / pageSize)
+	if index >= len(pages) {
+		return 0
+	}
+	var value uint8
+	for _, bank := range pages[index] {
+		value |= bank[offset%pageSize]
+	}
+	return value
+}
+
+// lookup returns the banks mapped at offset and the offset in them.
+func (pages table) lookup(offset uint32) ([][]uint8, uint32) {
+	index := int(offset / pageSize)
+	if index >= len(pages) {
+		return nil, 0
+	}
+	return pages[index], offset % pageSize
+}
+
+// mapBank maps the pages of a bank from the first page on, stopping at
+// the end of the area.
+func (pages table) mapBank(first int, memory []uint8) {
+	for index := 0; index*pageSize < len(memory) && first+index < len(pages); index++ {
+		pages[first+index] = append(pages[first+index], memory[index*pageSize:(index+1)*pageSize])
+	}
+}
+
+func (pages table) clear() {
+	for index := range pages {
+		pages[index] = pages[index][:0]
+	}
+}
+
+// Controller maps the VRAM banks. Its page tables are rebuilt whenever a
+// VRAMCNT register is written.
+type Controller struct {
+	banks   [bankCount][]uint8
+	control [bankCount]uint8
+
+	bgA     table
+	bgB     table
+	objA    table
+	objB    table
+	lcdc    table
+	texture table
+	texPal  table
+	bgExtA  table
+	bgExtB  table
+	objExtA table
+	objExtB table
+	arm7    table
+}
+
+// New constructs a controller over the VRAM memory with every bank
+// disabled.
+func New(memory []uint8) *Controller {
+	controller := &Controller{
+		bgA:     make(table, bgAPages),
+		bgB:     make(table, bgBPages),
+		objA:    make(table, objAPages),
+		objB:    make(table, objBPages),
+		lcdc:    make(table, lcdcPages),
+		texture: make(table, texturePages),
+		texPal:  make(table, texPalPages),
+		bgExtA:  make(table, extPalPages),
+		bgExtB:  make(table, extPalPages),
+		objExtA: make(table, objExtPalPages),
+		objExtB: make(table, objExtPalPages),
+		arm7:    make(table, arm7Pages),
+	}
+	start := 0
+	for bank, size := range bankSizes {
+		controller.banks[bank] = memory[start : start+size]
+		start += size
+	}
+	return controller
+}
+
+// MapArm9 registers VRAMCNT_A to VRAMCNT_I with the Arm9 I/O registry.
+func (controller *Controller) MapArm9(registry *mmio.Registry) {
+	for bank := 0; bank < bankCount; bank++ {
+		address := uint32(controlAddress + bank)
+		if bank >= bankH {
+			address = uint32(controlHAddress + bank - bankH)
+		}
+		bank := bank
+		registry.MapRegister(address, 1, &mmio.Register{
+			WriteMask: uint32(controlWriteMask),
+			OnWrite: func(value uint32, mask uint32) {
+				controller.SetControl(bank, uint8(value))
+			},
+		})
+	}
+}
+
+// MapArm7 registers VRAMSTAT with the Arm7 I/O registry.
+func (controller *Controller) MapArm7(registry *mmio.Registry) {
+	registry.MapRegister(statusAddress, 1, &mmio.Register{
+		ReadMask: uint32(statusC | statusD),
+		OnRead: func() uint32 {
+			return uint32(controller.status())
+		},
+	})
+}
+
+// SetControl writes the VRAMCNT register of a bank, numbered from zero
+// for bank A, and remaps VRAM.
+func (controller *Controller) SetControl(bank int, value uint8) {
+	controller.control[bank] = value & controlWriteMask
+	controller.rebuild()
+}
+
+// status returns VRAMSTAT, which tells whether banks C and D are mapped
+// to the Arm7.
+func (controller *Controller) status() uint8 {
+	var value uint8
+	if controller.mappedToArm7(bankC) {
+		value |= statusC
+	}
+	if controller.mappedToArm7(bankD) {
+		value |= statusD
+	}
+	return value
+}
+
+func (controller *Controller) mappedToArm7(bank int) bool {
+	control := controller.control[bank]
+	return control&controlEnable != 0 && control&mstMasks[bank] == 2
+}
+
+// rebuild recomputes every page table from the VRAMCNT registers.
+func (controller *Controller) rebuild() {
+	for _, pages := range controller.tables() {
+		pages.clear()
+	}
+	for bank, control := range controller.control {
+		if control&controlEnable == 0 {
+			continue
+		}
+		pages, first := controller.mapping(bank, control&mstMasks[bank], int(control>>controlOffsetShift&controlOffsetMask))
+		if pages != nil {
+			pages.mapBank(first, controller.banks[bank])
+		}
+	}
+}
+
+func (controller *Controller) tables() []table {
+	return []table{
+		controller.bgA, controller.bgB, controller.objA, controller.objB,
+		controller.lcdc, controller.texture, controller.texPal,
+		controller.bgExtA, controller.bgExtB, controller.objExtA, controller.objExtB,
+		controller.arm7,
+	}
+}
+
+// mapping returns the page table a bank is mapped into by its MST and
+// offset fields and the first page it takes up there, or nil for the
+// combinations that don't map the bank anywhere.
+func (controller *Controller) mapping(bank int, mst uint8, offset int) (table, int) {
+	// Every bank can be mapped for the CPU at its place in LCDC memory.
+	if mst == 0 {
+		start := 0
+		for _, size := range bankSizes[:bank] {
+			start += size
+		}
+		return controller.lcdc, start / pageSize
+	}
+
+	switch bank {
+	case bankA, bankB, bankC, bankD:
+		switch {
+		case mst == 1:
+			return controller.bgA, offset * slotPages
+		case mst == 2 && bank <= bankB:
+			return controller.objA, (offset & 1) * slotPages
+		case mst == 2:
+			return controller.arm7, (offset & 1) * slotPages
+		case mst == 3:
+			return controller.texture, offset * slotPages
+		case mst == 4 && bank == bankC:
+			return controller.bgB, 0
+		case mst == 4 && bank == bankD:
+			return controller.objB, 0
+		}
+	case bankE:
+		switch mst {
+		case 1:
+			return controller.bgA, 0
+		case 2:
+			return controller.objA, 0
+		case 3:
+			return controller.texPal, 0
+		case 4:
+			return controller.bgExtA, 0
+		}
+	case bankF, bankG:
+		// The 16KB banks are placed at 0, 16KB, 64KB or 80KB.
+		first := offset&1 + (offset>>1)*4
+		switch mst {
+		case 1:
+			return controller.bgA, first
+		case 2:
+			return controller.objA, first
+		case 3:
+			return controller.texPal, first
+		case 4:
+			return controller.bgExtA, offset & 1
+		case 5:
+			return controller.objExtA, 0
+		}
+	case bankH:
+		switch mst {
+		case 1:
+			return controller.bgB, 0
+		case 2:
+			return controller.bgExtB, 0
+		}
+	case bankI:
+		switch mst {
+		case 1:
+			return controller.bgB, 2
+		case 2:
+			return controller.objB, 0
+		case 3:
+			return controller.objExtB, 0
+		}
+	}
+	return nil, 0
+}
+
+// Arm9Page returns the banks mapped at an Arm9 VRAM address and the offset
+// of the address in them. The engine areas repeat across the 2MB they are
+// given, LCDC memory is not repeated.
+func (controller *Controller) Arm9Page(address uint32) ([][]uint8, uint32) {
+	offset := address & areaMask
+	switch offset >> areaShift {
+	case areaBGA:
+		return controller.bgA.lookup(offset % (bgAPages * pageSize))
+	case areaBGB:
+		return controller.bgB.lookup(offset % (bgBPages * pageSize))
+	case areaOBJA:
+		return controller.objA.lookup(offset % (objAPages * pageSize))
+	case areaOBJB:
+		return controller.objB.lookup(offset % (objBPages * pageSize))
+	}
+	return controller.lcdc.lookup((offset - lcdcBase) % lcdcSize)
+}
+
+// Arm7Page returns the banks mapped at an Arm7 VRAM address and the offset
+// of the address in them. The two 128KB slots repeat across the whole
+// VRAM area.
+func (controller *Controller) Arm7Page(address uint32) ([][]uint8, uint32) {
+	return controller.arm7.lookup(address % (arm7Pages * pageSize))
+}
+
+// ReadBG reads the background memory of a 2D engine.
+func (controller *Controller) ReadBG(engine int, offset uint32) uint8 {
+	if engine == engineA {
+		return controller.bgA.read8(offset % (bgAPages * pageSize))
+	}
+	return controller.bgB.read8(offset % (bgBPages * pageSize))
+}
+
+// ReadOBJ reads the sprite memory of a 2D engine.
+func (controller *Controller) ReadOBJ(engine int, offset uint32) uint8 {
+	if engine == engineA {
+		return controller.objA.read8(offset % (objAPages * pageSize))
+	}
+	return controller.objB.read8(offset % (objBPages * pageSize))
+}
+
+// ReadBGExtPalette reads the background extended palette slots of a 2D
+// engine.
+func (controller *Controller) ReadBGExtPalette(engine int, offset uint32) uint8 {
+	if engine == engineA {
+		return controller.bgExtA.read8(offset)
+	}
+	return controller.bgExtB.read8(offset)
+}
+
+// ReadOBJExtPalette reads the sprite extended palette of a 2D engine.
+func (controller *Controller) ReadOBJExtPalette(engine int, offset uint32) uint8 {
+	if engine == engineA {
+		return controller.objExtA.read8(offset)
+	}
+	return controller.objExtB.read8(offset)
+}
+
+// ReadLCDC reads a bank that is mapped to LCDC memory, as the VRAM display
+// mode does. Other banks read as zero.
+func (controller *Controller) ReadLCDC(bank int, offset uint32) uint8 {
+	control := controller.control[bank]
+	if control&controlEnable == 0 || control&mstMasks[bank] != 0 {
+		return 0
+	}
+	memory := controller.banks[bank]
+	return memory[offset%uint32(len(memory))]
+}
+
+// ReadTexture reads the texture slots of the 3D engine.
+func (controller *Controller) ReadTexture(offset uint32) uint8 {
+	return controller.texture.read8(offset)
+}
+
+// ReadTexturePalette reads the texture palette slots of the 3D engine.
+func (controller *Controller) ReadTexturePalette(offset uint32) uint8 {
+	return controller.texPal.read8(offset)
+}
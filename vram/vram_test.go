@@ -0,0 +1,165 @@
+package vram
+
+import (
+	"testing"
+
+	"github.com/damilolarandolph/casper/mmio"
+)
+
+// memorySize is the size of all the banks together.
+const memorySize = 0xa4000
+
+// tableName names a page table of the controller, or returns "none".
+func tableName(controller *Controller, pages table) string {
+	names := []string{
+		"bgA", "bgB", "objA", "objB", "lcdc", "texture", "texPal",
+		"bgExtA", "bgExtB", "objExtA", "objExtB", "arm7",
+	}
+	for index, candidate := range controller.tables() {
+		if len(pages) != 0 && &pages[0] == &candidate[0] {
+			return names[index]
+		}
+	}
+	return "none"
+}
+
+func TestMapping(t *testing.T) {
+	tests := []struct {
+		bank   int
+		mst    uint8
+		offset int
+		table  string
+		first  int
+	}{
+		{bankA, 0, 0, "lcdc", 0},
+		{bankD, 0, 0, "lcdc", 24},
+		{bankE, 0, 0, "lcdc", 32},
+		{bankH, 0, 0, "lcdc", 38},
+		{bankI, 0, 0, "lcdc", 40},
+		{bankA, 1, 3, "bgA", 24},
+		{bankB, 2, 1, "objA", 8},
+		{bankB, 2, 2, "objA", 0},
+		{bankC, 2, 1, "arm7", 8},
+		{bankD, 3, 2, "texture", 16},
+		{bankC, 4, 0, "bgB", 0},
+		{bankD, 4, 0, "objB", 0},
+		{bankA, 4, 0, "none", 0},
+		{bankE, 1, 0, "bgA", 0},
+		{bankE, 3, 0, "texPal", 0},
+		{bankE, 4, 0, "bgExtA", 0},
+		{bankE, 5, 0, "none", 0},
+		{bankF, 1, 0, "bgA", 0},
+		{bankF, 1, 1, "bgA", 1},
+		{bankF, 1, 2, "bgA", 4},
+		{bankG, 2, 3, "objA", 5},
+		{bankG, 3, 2, "texPal", 4},
+		{bankF, 4, 3, "bgExtA", 1},
+		{bankG, 5, 0, "objExtA", 0},
+		{bankH, 1, 0, "bgB", 0},
+		{bankH, 2, 0, "bgExtB", 0},
+		{bankI, 1, 0, "bgB", 2},
+		{bankI, 2, 0, "objB", 0},
+		{bankI, 3, 0, "objExtB", 0},
+	}
+	controller := New(make([]uint8, memorySize))
+	for _, test := range tests {
+		pages, first := controller.mapping(test.bank, test.mst, test.offset)
+		name := tableName(controller, pages)
+		if name != test.table || first != test.first {
+			t.Errorf("bank %d, MST %d, offset %d: mapped to %s page %d, want %s page %d",
+				test.bank, test.mst, test.offset, name, first, test.table, test.first)
+		}
+	}
+}
+
+func TestArm9Page(t *testing.T) {
+	memory := make([]uint8, memorySize)
+	controller := New(memory)
+	registry := mmio.NewRegistry()
+	controller.MapArm9(registry)
+
+	if banks, _ := controller.Arm9Page(0x06800000); len(banks) != 0 {
+		t.Errorf("disabled bank A mapped")
+	}
+
+	registry.Write8(controlAddress+bankA, 0x80)
+	registry.Write8(controlHAddress+bankI-bankH, 0x80)
+	registry.Write8(controlAddress+bankC, 0x81|2<<controlOffsetShift)
+	registry.Write8(controlAddress+bankD, 0x81|2<<controlOffsetShift)
+
+	tests := []struct {
+		address uint32
+		// backing are the offsets in memory of the mapped banks.
+		backing []int
+	}{
+		{0x06800010, []int{0x10}},
+		{0x0681c004, []int{0x1c004}},
+		{0x06820000, nil},
+		{0x068a0002, []int{0xa0002}},
+		// LCDC memory is not repeated.
+		{0x06900010, []int{0x10}},
+		// Banks C and D overlap in engine A's background memory, which
+		// repeats every 512KB.
+		{0x06040005, []int{0x40005, 0x60005}},
+		{0x06140005, []int{0x40005, 0x60005}},
+		{0x06000000, nil},
+	}
+	for _, test := range tests {
+		banks, offset := controller.Arm9Page(test.address)
+		if len(banks) != len(test.backing) {
+			t.Errorf("%#x: %d banks mapped, want %d", test.address, len(banks), len(test.backing))
+			continue
+		}
+		for index, backing := range test.backing {
+			if &banks[index][offset] != &memory[backing] {
+				t.Errorf("%#x: bank %d is not backed by %#x", test.address, index, backing)
+			}
+		}
+	}
+
+	memory[0x40005] = 0x0f
+	memory[0x60005] = 0xf0
+	if value := controller.ReadBG(engineA, 0x40005); value != 0xff {
+		t.Errorf("overlapping banks read %#x, want 0xff", value)
+	}
+}
+
+func TestArm7Page(t *testing.T) {
+	memory := make([]uint8, memorySize)
+	controller := New(memory)
+	arm9, arm7 := mmio.NewRegistry(), mmio.NewRegistry()
+	controller.MapArm9(arm9)
+	controller.MapArm7(arm7)
+
+	tests := []struct {
+		bank    int
+		control uint8
+		status  uint8
+		address uint32
+		backing int
+	}{
+		{bankC, 0x82, statusC, 0x06000007, 0x40007},
+		{bankC, 0x82 | 1<<controlOffsetShift, statusC, 0x06020007, 0x40007},
+		{bankD, 0x82 | 1<<controlOffsetShift, statusD, 0x06060007, 0x60007},
+		{bankC, 0x02, 0, 0x06000007, -1},
+		{bankC, 0x81, 0, 0x06000007, -1},
+	}
+	for _, test := range tests {
+		arm9.Write8(controlAddress+bankC, 0)
+		arm9.Write8(controlAddress+bankD, 0)
+		arm9.Write8(controlAddress+uint32(test.bank), test.control)
+		if status := arm7.Read8(statusAddress); status != test.status {
+			t.Errorf("bank %d, VRAMCNT %#x: VRAMSTAT = %#x, want %#x", test.bank, test.control, status, test.status)
+		}
+		banks, offset := controller.Arm7Page(test.address)
+		if test.backing < 0 {
+			if len(banks) != 0 {
+				t.Errorf("bank %d, VRAMCNT %#x: mapped to the Arm7", test.bank, test.control)
+			}
+			continue
+		}
+		if len(banks) != 1 || &banks[0][offset] != &memory[test.backing] {
+			t.Errorf("bank %d, VRAMCNT %#x: %#x is not backed by %#x", test.bank, test.control, test.address, test.backing)
+		}
+	}
+}